@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,17 +16,75 @@ import (
 
 	"github.com/leeaandrob/claudex/internal/api"
 	"github.com/leeaandrob/claudex/internal/claude"
+	"github.com/leeaandrob/claudex/internal/converter"
 	"github.com/leeaandrob/claudex/internal/mcp"
 	"github.com/leeaandrob/claudex/internal/observability"
 )
 
+// newFiberConfig returns the Fiber server configuration.
+func newFiberConfig(serviceName string, readTimeout, writeTimeout time.Duration) fiber.Config {
+	return fiber.Config{
+		AppName:               serviceName,
+		DisableStartupMessage: true,
+		ReadTimeout:           readTimeout,
+		WriteTimeout:          writeTimeout,
+	}
+}
+
+// shutdown stops accepting requests and lets in-flight ones finish, then
+// drains in-flight MCP tool calls and stops the MCP servers and the app.
+// Requests get up to half of timeout, so that slow ones cannot use up the
+// drain's time, and the drain gets the rest. It returns the app's shutdown
+// error.
+func shutdown(app *fiber.App, mcpManager *mcp.Manager, timeout time.Duration, logger *observability.Logger) error {
+	deadline := time.Now().Add(timeout)
+
+	// In-flight requests, including streamed MCP continuations, may still
+	// call tools, so the MCP servers stay up until they finish
+	httpCtx, cancelHTTP := context.WithTimeout(context.Background(), timeout/2)
+	defer cancelHTTP()
+	err := app.ShutdownWithContext(httpCtx)
+	if err != nil {
+		logger.Error("error during shutdown", "error", err.Error())
+	}
+
+	mcpCtx, cancelMCP := context.WithDeadline(context.Background(), deadline)
+	defer cancelMCP()
+	if err := mcpManager.Shutdown(mcpCtx); err != nil {
+		logger.Error("error stopping MCP servers", "error", err.Error())
+	}
+	return err
+}
+
 func main() {
 	// Configuration from flags / environment
-	var port, logLevel, otlpEndpoint, serviceName string
+	var port, logLevel, otlpEndpoint, serviceName, idPrefix, idFormat, cliOutputFormat, assistantHistory, assistantToolCalls, argumentCoercion, warmUp, durationBuckets, metricsModels string
+	var selfTest, cliTrace bool
+	var maxImageURLLength, cliTraceMaxFiles int
+	var cliTraceDir string
+	var idleTimeout, readTimeout, writeTimeout, shutdownTimeout time.Duration
 	flag.StringVar(&port, "port", "8080", "server listen port")
 	flag.StringVar(&logLevel, "log_level", "info", "log level")
 	flag.StringVar(&otlpEndpoint, "otel_exporter_otlp_endpoint", "", "OTLP exporter endpoint")
 	flag.StringVar(&serviceName, "service_name", "openai-claude-proxy", "service name")
+	flag.BoolVar(&selfTest, "selftest", false, "send a canned request through the pipeline, report the result, and exit")
+	flag.DurationVar(&idleTimeout, "claudex_idle_timeout", 0, "kill a streaming claude CLI process after this long without output (0 disables)")
+	flag.StringVar(&idPrefix, "claudex_id_prefix", converter.DefaultCompletionIDPrefix, "prefix for completion IDs")
+	flag.StringVar(&idFormat, "claudex_id_format", converter.CompletionIDUUID, "completion ID format: uuid, hex, or short")
+	flag.StringVar(&argumentCoercion, "claudex_tool_arguments", converter.ArgumentCoercionPassthrough, "handling of tool call arguments that are not a JSON object: passthrough (log and forward) or reject (fail with 502)")
+	flag.StringVar(&cliOutputFormat, "claudex_cli_output_format", claude.OutputFormatJSON, "claude CLI output format for plain text prompts: json or text (text skips parsing but reports no usage)")
+	flag.StringVar(&assistantHistory, "claudex_assistant_history", claude.AssistantHistoryMessages, "how assistant turns are sent in stream-json input: assistant (assistant messages) or transcript (labeled user messages)")
+	flag.IntVar(&maxImageURLLength, "claudex_max_image_url_bytes", claude.DefaultMaxImageURLLength, "max length in bytes of an image data URL; longer images are rejected with a 400 (0 disables)")
+	flag.BoolVar(&cliTrace, "claudex_cli_trace", false, "debug: record each claude CLI invocation's args, stdin, stdout, and stderr (image data redacted) in claudex_cli_trace_dir")
+	flag.StringVar(&cliTraceDir, "claudex_cli_trace_dir", "claudex-cli-traces", "directory for claude CLI invocation traces")
+	flag.IntVar(&cliTraceMaxFiles, "claudex_cli_trace_max_files", claude.DefaultTraceMaxFiles, "number of claude CLI invocation traces kept; older ones are deleted")
+	flag.StringVar(&warmUp, "claudex_warmup", claude.WarmUpOff, "claude CLI warm-up at startup: off, version (run claude --version), or prompt (send a one-word prompt; checks auth but uses tokens)")
+	flag.StringVar(&assistantToolCalls, "claudex_assistant_tool_calls", claude.AssistantToolCallsJSON, "how tool_calls on assistant messages are sent to the CLI: json (a tool_calls block after the text) or omit")
+	flag.StringVar(&durationBuckets, "claudex_duration_buckets", "", "comma-separated upper bounds in seconds for the request, CLI, and time-to-first-token duration histograms (default 0.5 to 600)")
+	flag.StringVar(&metricsModels, "claudex_metrics_models", "", "comma-separated models recorded by name in the request metrics' model label; others are recorded as other (default: by family, opus, sonnet, or haiku)")
+	flag.DurationVar(&readTimeout, "claudex_read_timeout", 10*time.Minute, "HTTP server read timeout")
+	flag.DurationVar(&writeTimeout, "claudex_write_timeout", 10*time.Minute, "HTTP server write timeout; must exceed the longest expected stream")
+	flag.DurationVar(&shutdownTimeout, "claudex_shutdown_timeout", 30*time.Second, "time in-flight requests and MCP tool calls are given to finish on shutdown")
 	flag.Parse()
 
 	// Initialize logger
@@ -37,6 +96,7 @@ func main() {
 	)
 
 	// Initialize tracing (if endpoint configured)
+	tracing := false
 	if otlpEndpoint != "" {
 		tp, err := observability.InitTracer(context.Background(), serviceName, otlpEndpoint)
 		if err != nil {
@@ -49,22 +109,76 @@ func main() {
 					logger.Error("failed to shutdown tracer", "error", err.Error())
 				}
 			}()
+			tracing = true
 			logger.Info("tracer initialized", "endpoint", otlpEndpoint)
 		}
 	}
 
+	// Configure completion IDs and tool call argument handling
+	conv := converter.NewConverter()
+	if err := conv.SetCompletionIDFormat(idPrefix, idFormat); err != nil {
+		logger.Warn("invalid completion ID format, using default", "error", err.Error())
+	}
+	if err := conv.SetArgumentCoercion(argumentCoercion); err != nil {
+		logger.Warn("invalid tool argument coercion mode, using passthrough", "error", err.Error())
+	}
+
 	// Initialize metrics
-	metrics := observability.InitMetrics()
+	buckets, err := observability.ParseBuckets(durationBuckets)
+	if err != nil {
+		logger.Warn("invalid duration buckets, using defaults", "error", err.Error())
+		buckets = observability.DefaultDurationBuckets
+	}
+	metrics := observability.InitMetricsWithBuckets(buckets)
+	if metricsModels != "" {
+		metrics.SetModelAllowlist(strings.Split(metricsModels, ","))
+	}
 	logger.Info("metrics initialized")
 
 	// Initialize Claude executor
 	executor := claude.NewExecutor()
+	executor.SetIdleTimeout(idleTimeout)
+	executor.SetMaxImageURLLength(maxImageURLLength)
+	if err := executor.SetOutputFormat(cliOutputFormat); err != nil {
+		logger.Warn("invalid CLI output format, using json", "error", err.Error())
+	}
+	if err := executor.SetAssistantHistory(assistantHistory); err != nil {
+		logger.Warn("invalid assistant history mode, using assistant", "error", err.Error())
+	}
+	if err := executor.SetAssistantToolCalls(assistantToolCalls); err != nil {
+		logger.Warn("invalid assistant tool calls mode, using json", "error", err.Error())
+	}
+	if cliTrace {
+		tracer, err := claude.NewInvocationTracer(cliTraceDir, cliTraceMaxFiles, logger.Logger)
+		if err != nil {
+			logger.Warn("CLI invocation tracing disabled", "error", err.Error())
+		} else {
+			executor.SetTracer(tracer)
+			logger.Warn("tracing claude CLI invocations; traces contain full prompts", "dir", cliTraceDir)
+		}
+	}
 	if !executor.IsAvailable() {
 		logger.Warn("claude CLI is not available, some features may not work")
 	} else {
 		logger.Info("claude CLI is available")
 	}
 
+	// Pay the CLI's cold-start cost before the first request, without delaying startup
+	if warmUp != claude.WarmUpOff {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+			start := time.Now()
+			err := executor.WarmUp(ctx, warmUp)
+			metrics.RecordWarmUp(err == nil)
+			if err != nil {
+				logger.Warn("claude CLI warm-up failed", "mode", warmUp, "error", err.Error())
+				return
+			}
+			logger.Info("claude CLI warmed up", "mode", warmUp, "duration", time.Since(start).String())
+		}()
+	}
+
 	// Initialize MCP manager
 	mcpManager := mcp.NewManager()
 	if err := mcpManager.LoadConfigFromEnv(); err != nil {
@@ -83,18 +197,31 @@ func main() {
 	mcpCancel()
 
 	// Create Fiber app
-	app := fiber.New(fiber.Config{
-		AppName:               serviceName,
-		DisableStartupMessage: true,
-		ReadTimeout:           10 * time.Minute,
-		WriteTimeout:          10 * time.Minute,
-	})
+	app := fiber.New(newFiberConfig(serviceName, readTimeout, writeTimeout))
 
 	// Add recover middleware
 	app.Use(recover.New())
 
 	// Register routes
-	api.RegisterRoutes(app, logger, metrics, executor, mcpManager)
+	api.RegisterRoutes(app, logger, metrics, executor, conv, mcpManager, tracing)
+
+	// Self-test mode: exercise the full pipeline once and exit
+	if selfTest {
+		err := runSelfTest(app, 2*time.Minute)
+		if stopErr := mcpManager.StopAll(); stopErr != nil {
+			logger.Error("error stopping MCP servers", "error", stopErr.Error())
+		}
+		if err != nil {
+			logger.Error("self-test failed", "error", err.Error())
+			os.Exit(1)
+		}
+		logger.Info("self-test passed")
+		os.Exit(0)
+	}
+
+	// Probe MCP servers for hangs, if enabled, until shutdown
+	probeCtx, probeCancel := context.WithCancel(context.Background())
+	mcpManager.StartProbes(probeCtx)
 
 	// Graceful shutdown
 	go func() {
@@ -104,18 +231,8 @@ func main() {
 
 		logger.Info("received shutdown signal", "signal", sig.String())
 
-		// Stop MCP servers
-		if err := mcpManager.StopAll(); err != nil {
-			logger.Error("error stopping MCP servers", "error", err.Error())
-		}
-
-		// Give in-flight requests time to complete
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		if err := app.ShutdownWithContext(ctx); err != nil {
-			logger.Error("error during shutdown", "error", err.Error())
-		}
+		probeCancel()
+		shutdown(app, mcpManager, shutdownTimeout, logger)
 	}()
 
 	// Start server
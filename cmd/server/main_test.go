@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/mcp"
+	"github.com/leeaandrob/claudex/internal/observability"
+)
+
+func TestNewFiberConfig_Timeouts(t *testing.T) {
+	app := fiber.New(newFiberConfig("test", 30*time.Second, 15*time.Minute))
+	cfg := app.Config()
+
+	if cfg.ReadTimeout != 30*time.Second {
+		t.Errorf("got read timeout %v, want 30s", cfg.ReadTimeout)
+	}
+	if cfg.WriteTimeout != 15*time.Minute {
+		t.Errorf("got write timeout %v, want 15m", cfg.WriteTimeout)
+	}
+	if cfg.AppName != "test" {
+		t.Errorf("got app name %q, want %q", cfg.AppName, "test")
+	}
+}
+
+func TestShutdown_Timeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		timeout     time.Duration
+		wantDrained bool
+	}{
+		{name: "drains within timeout", timeout: 5 * time.Second, wantDrained: true},
+		// Requests get half the timeout; the rest is kept for the MCP drain
+		{name: "gives up at half the timeout", timeout: 400 * time.Millisecond, wantDrained: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New(newFiberConfig("test", time.Minute, time.Minute))
+			started := make(chan struct{})
+			app.Get("/slow", func(c *fiber.Ctx) error {
+				close(started)
+				time.Sleep(500 * time.Millisecond)
+				return c.SendString("ok")
+			})
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listen: %v", err)
+			}
+			go app.Listener(ln)
+			go http.Get("http://" + ln.Addr().String() + "/slow")
+			<-started
+
+			start := time.Now()
+			err = shutdown(app, mcp.NewManager(), tt.timeout, observability.NewLogger("error"))
+			elapsed := time.Since(start)
+
+			if tt.wantDrained {
+				if err != nil || elapsed < 400*time.Millisecond {
+					t.Errorf("got err %v after %v, want the in-flight request drained", err, elapsed)
+				}
+				return
+			}
+			if err == nil || elapsed > 300*time.Millisecond {
+				t.Errorf("got err %v after %v, want a timeout after %v", err, elapsed, tt.timeout/2)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// selfTestRequest is the canned request sent through the pipeline by --selftest.
+const selfTestRequest = `{"model":"claude-sonnet","messages":[{"role":"user","content":"Reply with the single word: ok"}]}`
+
+// runSelfTest sends a canned non-streaming chat completion through the
+// registered routes (executor + parser + converter) and returns an error if
+// the pipeline does not produce a non-empty assistant response.
+func runSelfTest(app *fiber.App, timeout time.Duration) error {
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(selfTestRequest))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, int(timeout.Milliseconds()))
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != fiber.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+
+	var completion models.ChatCompletionResponse
+	if err := json.Unmarshal(body, &completion); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if len(completion.Choices) == 0 {
+		return fmt.Errorf("response has no choices")
+	}
+	if strings.TrimSpace(completion.Choices[0].Message.GetTextContent()) == "" {
+		return fmt.Errorf("response content is empty")
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/leeaandrob/claudex/internal/api/handlers"
+	"github.com/leeaandrob/claudex/internal/claude"
+	"github.com/leeaandrob/claudex/internal/converter"
+	"github.com/leeaandrob/claudex/internal/models"
+	"github.com/leeaandrob/claudex/internal/observability"
+)
+
+var testMetrics = observability.InitMetrics()
+
+// fakeExecutor returns a fixed CLI output or error.
+type fakeExecutor struct {
+	output string
+	err    error
+}
+
+func (f *fakeExecutor) ExecuteWithMessages(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+	return f.output, f.err
+}
+
+func (f *fakeExecutor) ExecuteStreamingWithMessages(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func newSelfTestApp(exec handlers.Executor) *fiber.App {
+	h := handlers.NewChatCompletionsHandler(exec, claude.NewParser(), converter.NewConverter(), nil, testMetrics, observability.NewLogger("error"))
+	app := fiber.New()
+	app.Post("/v1/chat/completions", h.Handle)
+	return app
+}
+
+func TestRunSelfTest(t *testing.T) {
+	okOutput, _ := json.Marshal(models.ClaudeJSONResponse{Type: "result", Result: "ok"})
+	emptyOutput, _ := json.Marshal(models.ClaudeJSONResponse{Type: "result"})
+
+	tests := []struct {
+		name    string
+		exec    *fakeExecutor
+		wantErr string
+	}{
+		{name: "success", exec: &fakeExecutor{output: string(okOutput)}},
+		{name: "cli failure", exec: &fakeExecutor{err: errors.New("claude: command not found")}, wantErr: "unexpected status 500"},
+		{name: "unparseable output", exec: &fakeExecutor{output: "not json"}, wantErr: "unexpected status 500"},
+		{name: "empty content", exec: &fakeExecutor{output: string(emptyOutput)}, wantErr: "response content is empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runSelfTest(newSelfTestApp(tt.exec), 5*time.Second)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("got error %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/api/middleware"
+	"github.com/leeaandrob/claudex/internal/models"
+	"github.com/leeaandrob/claudex/internal/observability"
+)
+
+// caller identifies who made a request, for audit records.
+type caller struct {
+	requestID string
+	user      string
+	safetyID  string
+	apiKey    string
+}
+
+// maxSafetyIdentifierLen bounds the length of logged safety identifiers.
+const maxSafetyIdentifierLen = 64
+
+type callerKey struct{}
+
+// callerFrom builds the caller of a request from its headers and body.
+func callerFrom(c *fiber.Ctx, req *models.ChatCompletionRequest) caller {
+	return caller{
+		requestID: middleware.GetRequestID(c),
+		user:      req.User,
+		safetyID:  boundSafetyIdentifier(req.SafetyIdentifier),
+		apiKey:    maskAPIKey(strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")),
+	}
+}
+
+// withCaller returns a context carrying the request's caller.
+func withCaller(ctx context.Context, cl caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, cl)
+}
+
+// callerFromContext returns the caller stored by withCaller, if any.
+func callerFromContext(ctx context.Context) caller {
+	cl, _ := ctx.Value(callerKey{}).(caller)
+	return cl
+}
+
+// boundSafetyIdentifier truncates a client safety identifier so arbitrary
+// values cannot bloat log records.
+func boundSafetyIdentifier(id string) string {
+	if len(id) <= maxSafetyIdentifierLen {
+		return id
+	}
+	id = id[:maxSafetyIdentifierLen]
+	for !utf8.ValidString(id) {
+		id = id[:len(id)-1]
+	}
+	return id
+}
+
+// maskAPIKey keeps only the last four characters of a key.
+func maskAPIKey(key string) string {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return ""
+	}
+	if len(key) <= 8 {
+		return "***"
+	}
+	return "***" + key[len(key)-4:]
+}
+
+// SetAuditLogger replaces the tool execution audit logger. Nil disables auditing.
+func (h *ChatCompletionsHandler) SetAuditLogger(audit *observability.AuditLogger) {
+	h.audit = audit
+}
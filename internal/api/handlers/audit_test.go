@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/api/middleware"
+	"github.com/leeaandrob/claudex/internal/mcp"
+	"github.com/leeaandrob/claudex/internal/models"
+	"github.com/leeaandrob/claudex/internal/observability"
+)
+
+func TestExecuteMCPToolCalls_AuditEntry(t *testing.T) {
+	manager := mcp.NewManager()
+	err := manager.RegisterLocalTool("lookup", nil, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		return &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: "found"}}}, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	calls := 0
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			calls++
+			if calls == 1 {
+				return resultJSON(`{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"lookup","arguments":"{\"q\":\"secret\"}"}}]}`), nil
+			}
+			return resultJSON("done"), nil
+		},
+	}
+
+	tests := []struct {
+		name     string
+		redact   bool
+		wantArgs string
+	}{
+		{name: "plain", redact: false, wantArgs: `{"q":"secret"}`},
+		{name: "redacted", redact: true, wantArgs: "[redacted]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			var buf bytes.Buffer
+			h := newTestHandler(exec)
+			h.mcpManager = manager
+			h.SetAuditLogger(observability.NewAuditLogger(&buf, tt.redact))
+
+			app := fiber.New()
+			app.Use(middleware.RequestID())
+			app.Post("/v1/chat/completions", h.Handle)
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"claude-sonnet","user":"alice","safety_identifier":"hashed-alice","prompt_cache_key":"k1","messages":[{"role":"user","content":"look it up"}]}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer sk-test-1234567890")
+			req.Header.Set(middleware.RequestIDHeader, "req-42")
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("app.Test: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != 200 {
+				t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+			}
+
+			var entry map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("unmarshal audit entry %q: %v", buf.String(), err)
+			}
+
+			want := map[string]string{
+				"request_id":        "req-42",
+				"user":              "alice",
+				"safety_identifier": "hashed-alice",
+				"api_key":           "***7890",
+				"tool":              "lookup",
+				"arguments":         tt.wantArgs,
+				"status":            "success",
+			}
+			for key, val := range want {
+				if entry[key] != val {
+					t.Errorf("got %s %v, want %q", key, entry[key], val)
+				}
+			}
+			if _, ok := entry["duration_ms"]; !ok {
+				t.Error("audit entry has no duration_ms")
+			}
+		})
+	}
+}
+
+func TestBoundSafetyIdentifier(t *testing.T) {
+	long := strings.Repeat("a", maxSafetyIdentifierLen-1) + "é"
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{id: "", want: ""},
+		{id: "user-1", want: "user-1"},
+		{id: strings.Repeat("x", 100), want: strings.Repeat("x", maxSafetyIdentifierLen)},
+		{id: long, want: strings.Repeat("a", maxSafetyIdentifierLen-1)},
+	}
+	for _, tt := range tests {
+		if got := boundSafetyIdentifier(tt.id); got != tt.want {
+			t.Errorf("boundSafetyIdentifier(%q): got %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// HandleBatch processes a non-standard batch of independent chat completion
+// requests. Items run concurrently (bounded by CLAUDEX_BATCH_CONCURRENCY) and
+// each item reports its own status, so one failure doesn't fail the batch.
+// Each item takes a slot from the global concurrency limiter like a single
+// request; items it refuses report its error and the batch response carries
+// Retry-After.
+func (h *ChatCompletionsHandler) HandleBatch(c *fiber.Ctx) error {
+	var batch models.BatchCompletionRequest
+	if err := c.BodyParser(&batch); err != nil {
+		h.metrics.RecordError("parse_error")
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: models.ErrorDetail{
+				Message: "Invalid request body: " + err.Error(),
+				Type:    "invalid_request_error",
+				Code:    "invalid_json",
+			},
+		})
+	}
+
+	if len(batch.Requests) == 0 {
+		h.metrics.RecordError("validation_error")
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: models.ErrorDetail{
+				Message: "Requests array is required and cannot be empty",
+				Type:    "invalid_request_error",
+				Param:   "requests",
+				Code:    "invalid_batch",
+			},
+		})
+	}
+
+	if maxItems := getBatchMaxItems(); len(batch.Requests) > maxItems {
+		h.metrics.RecordError("validation_error")
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
+			Error: models.ErrorDetail{
+				Message: fmt.Sprintf("Batch has %d requests, maximum is %d", len(batch.Requests), maxItems),
+				Type:    "invalid_request_error",
+				Param:   "requests",
+				Code:    "invalid_batch",
+			},
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), h.requestTimeout(c))
+	defer cancel()
+
+	results := make([]models.BatchCompletionItem, len(batch.Requests))
+	sem := make(chan struct{}, getBatchConcurrency())
+	var wg sync.WaitGroup
+
+	disableMCP := c.Get(DisableMCPHeader) == "1"
+	policy := h.keyPolicy(c)
+	for i := range batch.Requests {
+		batch.Requests[i].DisableMCP = disableMCP
+		h.overrideModel(c, &batch.Requests[i])
+		if policy != nil {
+			batch.Requests[i].AllowedMCPTools = policy.allowedTools()
+			batch.Requests[i].AllowedModels = policy.Models
+		}
+		itemCtx := withCaller(ctx, callerFrom(c, &batch.Requests[i]))
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[index] = h.completeBatchItem(itemCtx, index, &batch.Requests[index], policy)
+		}(i)
+	}
+	wg.Wait()
+
+	// Items refused by the concurrency limiter share one retry estimate
+	for _, item := range results {
+		if item.Error != nil && item.Error.Code == "concurrency_limit" {
+			c.Set("Retry-After", strconv.Itoa(h.limiter.retryAfter()))
+			break
+		}
+	}
+
+	return c.JSON(models.BatchCompletionResponse{
+		Object: "batch.completion",
+		Data:   results,
+	})
+}
+
+// completeBatchItem validates and executes a single batch item, refusing
+// models the API key's policy does not allow and holding a concurrency slot
+// while it runs.
+func (h *ChatCompletionsHandler) completeBatchItem(ctx context.Context, index int, req *models.ChatCompletionRequest, policy *KeyPolicy) models.BatchCompletionItem {
+	start := time.Now()
+	h.metrics.IncrementActive()
+	defer h.metrics.DecrementActive()
+
+	item := models.BatchCompletionItem{Index: index}
+
+	if req.Stream {
+		h.metrics.RecordError("validation_error")
+		item.Status = fiber.StatusBadRequest
+		item.Error = invalidRequest("stream", "unsupported_parameter", "Streaming is not supported for batch items")
+		return item
+	}
+
+	if detail := h.prepareRequest(req); detail != nil {
+		h.metrics.RecordError("validation_error")
+		item.Status = fiber.StatusBadRequest
+		item.Error = detail
+		return item
+	}
+	if policy != nil && !policy.allowsModel(req.Model) {
+		h.metrics.RecordError("permission_error")
+		item.Status = fiber.StatusForbidden
+		item.Error = modelNotAllowed(req.Model)
+		return item
+	}
+
+	cerr := h.moderate(ctx, req)
+	var resp *models.ChatCompletionResponse
+	if cerr == nil {
+		var release func()
+		if release, cerr = h.acquireSlot(ctx); cerr == nil {
+			resp, cerr = h.complete(ctx, req)
+			release()
+		}
+	}
+	if cerr != nil {
+		h.metrics.RecordError(cerr.metric)
+		h.metrics.RecordRequest("error", req.Model, false, time.Since(start).Seconds())
+		item.Status = cerr.status
+		item.Error = &cerr.detail
+		return item
+	}
+
+	h.metrics.RecordRequest("success", resp.Model, false, time.Since(start).Seconds())
+	item.Status = fiber.StatusOK
+	item.Response = resp
+	return item
+}
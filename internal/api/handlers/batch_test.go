@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestHandleBatch_MixedResults(t *testing.T) {
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			if req.Messages[0].GetTextContent() == "fail" {
+				return "", errors.New("boom")
+			}
+			return resultJSON("echo: " + req.Messages[0].GetTextContent()), nil
+		},
+	}
+
+	resp, body := postJSON(t, newTestApp(exec), "/v1/batch/completions", `{"requests":[
+		{"model":"m","messages":[{"role":"user","content":"first"}]},
+		{"model":"m","messages":[{"role":"user","content":"fail"}]},
+		{"model":"m","messages":[]},
+		{"model":"m","stream":true,"messages":[{"role":"user","content":"hi"}]},
+		{"model":"m","messages":[{"role":"user","content":"last"}]}
+	]}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+	}
+
+	var out models.BatchCompletionResponse
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(out.Data) != 5 {
+		t.Fatalf("got %d items, want 5", len(out.Data))
+	}
+
+	want := []struct {
+		status  int
+		content string
+		code    string
+	}{
+		{status: 200, content: "echo: first"},
+		{status: 500, code: "claude_error"},
+		{status: 400, code: "invalid_messages"},
+		{status: 400, code: "unsupported_parameter"},
+		{status: 200, content: "echo: last"},
+	}
+
+	for i, w := range want {
+		item := out.Data[i]
+		if item.Index != i {
+			t.Errorf("item %d: got index %d", i, item.Index)
+		}
+		if item.Status != w.status {
+			t.Errorf("item %d: got status %d, want %d", i, item.Status, w.status)
+		}
+		if w.code != "" {
+			if item.Error == nil || item.Error.Code != w.code {
+				t.Errorf("item %d: got error %+v, want code %q", i, item.Error, w.code)
+			}
+			continue
+		}
+		if item.Response == nil {
+			t.Fatalf("item %d: missing response", i)
+		}
+		if got := item.Response.Choices[0].Message.GetTextContent(); got != w.content {
+			t.Errorf("item %d: got content %q, want %q", i, got, w.content)
+		}
+	}
+}
+
+func TestHandleBatch_ConcurrencyLimit(t *testing.T) {
+	t.Setenv("CLAUDEX_BATCH_CONCURRENCY", "2")
+
+	var running, peak int32
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return resultJSON("ok"), nil
+		},
+	}
+
+	item := `{"model":"m","messages":[{"role":"user","content":"hi"}]}`
+	postJSON(t, newTestApp(exec), "/v1/batch/completions", `{"requests":[`+item+`,`+item+`,`+item+`,`+item+`,`+item+`]}`)
+
+	if peak > 2 {
+		t.Errorf("got peak concurrency %d, want <= 2", peak)
+	}
+}
+
+func TestHandleBatch_Saturated(t *testing.T) {
+	t.Setenv("CLAUDEX_BATCH_CONCURRENCY", "2")
+	t.Setenv("CLAUDEX_MAX_CONCURRENT", "1")
+	t.Setenv("CLAUDEX_MAX_QUEUE", "0")
+
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			time.Sleep(100 * time.Millisecond)
+			return resultJSON("ok"), nil
+		},
+	}
+
+	item := `{"model":"m","messages":[{"role":"user","content":"hi"}]}`
+	resp, body := postJSON(t, newTestApp(exec), "/v1/batch/completions", `{"requests":[`+item+`,`+item+`]}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+	}
+
+	var out models.BatchCompletionResponse
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatalf("unmarshal response %s: %v", body, err)
+	}
+	statuses := map[int]int{}
+	for _, item := range out.Data {
+		statuses[item.Status]++
+		if item.Status == 503 && item.Error.Code != "concurrency_limit" {
+			t.Errorf("got error code %q, want concurrency_limit", item.Error.Code)
+		}
+	}
+	if statuses[200] != 1 || statuses[503] != 1 {
+		t.Errorf("got item statuses %v, want one 200 and one 503", statuses)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("got no Retry-After header for a batch with refused items")
+	}
+}
+
+func TestHandleBatch_Empty(t *testing.T) {
+	resp, _ := postJSON(t, newTestApp(&fakeExecutor{}), "/v1/batch/completions", `{"requests":[]}`)
+	if resp.StatusCode != 400 {
+		t.Errorf("got status %d, want 400", resp.StatusCode)
+	}
+}
@@ -4,10 +4,15 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/leeaandrob/claudex/internal/claude"
@@ -28,19 +33,107 @@ func getRequestTimeout() time.Duration {
 	return 10 * time.Minute
 }
 
+// requestTimeout returns the timeout for a request: the one asked for with
+// RequestTimeoutHeader, clamped to the ceiling, or REQUEST_TIMEOUT.
+func (h *ChatCompletionsHandler) requestTimeout(c *fiber.Ctx) time.Duration {
+	val := c.Get(RequestTimeoutHeader)
+	if val == "" {
+		return getRequestTimeout()
+	}
+	seconds, err := strconv.Atoi(val)
+	if err != nil || seconds <= 0 {
+		h.logger.Warn("ignoring invalid request timeout header", "value", val)
+		return getRequestTimeout()
+	}
+
+	timeout := time.Duration(seconds) * time.Second
+	if ceiling := getMaxRequestTimeout(); timeout > ceiling {
+		h.logger.Warn("clamping requested timeout", "requested", timeout, "ceiling", ceiling)
+		return ceiling
+	}
+	return timeout
+}
+
+// Executor runs Claude CLI requests. It is implemented by *claude.Executor.
+type Executor interface {
+	ExecuteWithMessages(ctx context.Context, req *models.ChatCompletionRequest) (string, error)
+	ExecuteStreamingWithMessages(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error)
+}
+
+// DebugPromptHeader asks for the assembled prompt and the tools advertised to
+// the model to be returned in the non-streaming response's claudex_debug
+// field.
+const DebugPromptHeader = "X-Claudex-Debug-Prompt"
+
+// DisableMCPHeader leaves the server's MCP tools out of a request when set
+// to "1". Client-supplied tools are unaffected.
+const DisableMCPHeader = "X-Claudex-Disable-MCP"
+
+// RequestTimeoutHeader overrides REQUEST_TIMEOUT for a request, in seconds.
+// Values above CLAUDEX_MAX_REQUEST_TIMEOUT are clamped to it.
+const RequestTimeoutHeader = "X-Claudex-Request-Timeout"
+
+// ModelHeader overrides the request body's model, for gateways that route
+// by header. The overriding model is validated and echoed like a body model.
+const ModelHeader = "X-Claude-Model"
+
+// overrideModel replaces req.Model with the ModelHeader value, if set.
+func (h *ChatCompletionsHandler) overrideModel(c *fiber.Ctx, req *models.ChatCompletionRequest) {
+	model := strings.TrimSpace(c.Get(ModelHeader))
+	if model == "" || model == req.Model {
+		return
+	}
+	h.logger.Debug("model overridden by header", "body_model", req.Model, "model", model)
+	req.Model = model
+}
+
+// promptAssembler is implemented by executors that can report the prompt
+// they would pass to the CLI.
+type promptAssembler interface {
+	AssemblePrompt(req *models.ChatCompletionRequest) (string, string, error)
+}
+
+// streamingChecker is implemented by executors that cannot stream every
+// model. Executors without it are assumed to stream all models.
+type streamingChecker interface {
+	SupportsStreaming(model string) bool
+}
+
+// imageURLChecker is implemented by executors that refuse some image URLs,
+// such as data URLs over a length cap, so they are rejected up front.
+type imageURLChecker interface {
+	CheckImageURL(url string) error
+}
+
+// outputFormatter is implemented by executors that can be asked for output
+// other than JSON. Executors without it always produce JSON.
+type outputFormatter interface {
+	OutputFormat(req *models.ChatCompletionRequest) string
+}
+
 // ChatCompletionsHandler handles chat completion requests.
 type ChatCompletionsHandler struct {
-	executor   *claude.Executor
+	executor   Executor
 	parser     *claude.Parser
 	converter  *converter.Converter
 	mcpManager *mcp.Manager
 	metrics    *observability.Metrics
 	logger     *observability.Logger
+	moderator  Moderator
+	audit      *observability.AuditLogger
+	store      CompletionStore
+	output     OutputProcessor
+	sanitizer  *ControlCharSanitizer
+	limiter    *concurrencyLimiter
+	toolSlots  toolSlots
+
+	keyPolicies   KeyPolicies
+	conversations *conversationCache
 }
 
 // NewChatCompletionsHandler creates a new chat completions handler.
 func NewChatCompletionsHandler(
-	executor *claude.Executor,
+	executor Executor,
 	parser *claude.Parser,
 	conv *converter.Converter,
 	mcpManager *mcp.Manager,
@@ -48,12 +141,20 @@ func NewChatCompletionsHandler(
 	logger *observability.Logger,
 ) *ChatCompletionsHandler {
 	return &ChatCompletionsHandler{
-		executor:   executor,
-		parser:     parser,
-		converter:  conv,
-		mcpManager: mcpManager,
-		metrics:    metrics,
-		logger:     logger,
+		executor:      executor,
+		parser:        parser,
+		converter:     conv,
+		mcpManager:    mcpManager,
+		metrics:       metrics,
+		logger:        logger,
+		moderator:     moderatorFromEnv(),
+		audit:         auditLoggerFromEnv(),
+		store:         completionStoreFromEnv(),
+		output:        outputProcessorFromEnv(),
+		sanitizer:     NewControlCharSanitizer(getControlCharsMode()),
+		limiter:       limiterFromEnv(),
+		keyPolicies:   keyPoliciesFromEnv(),
+		conversations: conversationCacheFromEnv(),
 	}
 }
 
@@ -76,104 +177,719 @@ func (h *ChatCompletionsHandler) Handle(c *fiber.Ctx) error {
 		})
 	}
 
-	// Validate messages
-	if len(req.Messages) == 0 {
+	// Fiber's body parser drops unknown fields; strict mode reports them
+	if getUnknownFieldsMode() == UnknownFieldsReject {
+		if detail := validateKnownFields(c.Body()); detail != nil {
+			h.metrics.RecordError("validation_error")
+			return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: *detail})
+		}
+	}
+
+	req.DisableMCP = c.Get(DisableMCPHeader) == "1"
+	h.overrideModel(c, &req)
+	policy := h.keyPolicy(c)
+	if policy != nil {
+		req.AllowedMCPTools = policy.allowedTools()
+		req.AllowedModels = policy.Models
+	}
+	if detail := h.prepareRequest(&req); detail != nil {
 		h.metrics.RecordError("validation_error")
-		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{
-			Error: models.ErrorDetail{
-				Message: "Messages array is required and cannot be empty",
-				Type:    "invalid_request_error",
-				Code:    "invalid_messages",
-			},
-		})
+		return c.Status(fiber.StatusBadRequest).JSON(models.ErrorResponse{Error: *detail})
+	}
+	if policy != nil && !policy.allowsModel(req.Model) {
+		h.metrics.RecordError("permission_error")
+		return c.Status(fiber.StatusForbidden).JSON(models.ErrorResponse{Error: *modelNotAllowed(req.Model)})
+	}
+	setRequestAttributes(c.UserContext(), &req)
+
+	// A retried request gets the conversation's cached final turn
+	cached, turn := h.lookupConversation(c, &req)
+	if cached != nil {
+		h.metrics.RecordRequest("success", req.Model, false, time.Since(start).Seconds())
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(cached)
 	}
 
-	// Add MCP tools to the request if available
-	if h.mcpManager != nil && h.mcpManager.HasTools() {
-		mcpTools := h.mcpManager.GetToolsAsOpenAI()
-		req.Tools = append(req.Tools, mcpTools...)
+	if cerr := h.moderate(c.Context(), &req); cerr != nil {
+		return h.writeCompletionError(c, &req, cerr, start)
+	}
+
+	release, cerr := h.acquireSlot(c.Context())
+	if cerr != nil {
+		return h.writeCompletionError(c, &req, cerr, start)
 	}
 
 	// Use CLI for all requests (Anthropic API deprecated)
 	if req.Stream {
-		return h.handleStreamingCLI(c, &req, start)
+		// The slot is held until the stream finishes writing
+		return h.handleStreamingCLI(c, &req, start, release)
 	}
-	return h.handleNonStreamingCLI(c, &req, start)
+	defer release()
+	return h.handleNonStreamingCLI(c, &req, start, turn)
 }
 
-// handleNonStreamingCLI handles non-streaming requests using CLI.
-func (h *ChatCompletionsHandler) handleNonStreamingCLI(c *fiber.Ctx, req *models.ChatCompletionRequest, start time.Time) error {
-	ctx, cancel := context.WithTimeout(c.Context(), getRequestTimeout())
+// prepareRequest validates a parsed request, fills in a missing model, and
+// adds MCP tools to it.
+// Returns the validation error, if any.
+func (h *ChatCompletionsHandler) prepareRequest(req *models.ChatCompletionRequest) *models.ErrorDetail {
+	// Fill in or refuse a missing model
+	if strings.TrimSpace(req.Model) == "" {
+		if getMissingModelMode() == MissingModelReject {
+			return invalidRequest("model", "invalid_request", "model is required")
+		}
+		req.Model = getDefaultModel()
+	}
+
+	// Validate messages
+	if len(req.Messages) == 0 {
+		return &models.ErrorDetail{
+			Message: "Messages array is required and cannot be empty",
+			Type:    "invalid_request_error",
+			Code:    "invalid_messages",
+		}
+	}
+
+	// Validate roles, tool linkage, and content shapes
+	if detail := validateRequest(req); detail != nil {
+		return detail
+	}
+	if checker, ok := h.executor.(imageURLChecker); ok {
+		if detail := validateImageURLs(req, checker.CheckImageURL); detail != nil {
+			return detail
+		}
+	}
+
+	// Refuse rather than silently answer a streaming request without streaming
+	if req.Stream && !h.supportsStreaming(req.Model) {
+		return invalidRequest("stream", "streaming_not_supported",
+			fmt.Sprintf("model %s does not support streaming", req.Model))
+	}
+
+	// Strict schema validation needs the complete response
+	if req.Stream && strictSchema(req) != nil && getStreamingStrictSchemaMode() == StreamingStrictSchemaReject {
+		return invalidRequest("stream", "incompatible_parameters",
+			"stream cannot be combined with a strict json_schema response_format; disable stream or set json_schema.strict to false")
+	}
+
+	// Each streamed choice runs its own CLI process
+	if maxChoices := getMaxChoices(); req.N > maxChoices {
+		return invalidRequest("n", "invalid_parameter",
+			fmt.Sprintf("n must be at most %d, got %d", maxChoices, req.N))
+	}
+
+	// Keep sampling parameters within the ranges OpenAI accepts
+	clamped, detail := checkParamRanges(req, getParamRangeMode() == ParamRangeClamp)
+	if detail != nil {
+		return detail
+	}
+	if len(clamped) > 0 {
+		h.logger.Debug("clamped out-of-range parameters", "params", clamped)
+	}
+
+	// The CLI has no equivalent of logit_bias
+	if len(req.LogitBias) > 0 {
+		if getLogitBiasMode() == UnsupportedParamReject {
+			return invalidRequest("logit_bias", "unsupported_parameter", "logit_bias is not supported")
+		}
+		h.logger.Debug("ignoring unsupported parameter", "param", "logit_bias")
+	}
+
+	// Some clients split one turn across several messages
+	if getMergeConsecutiveMessages() {
+		if messages, merged := mergeConsecutiveMessages(req.Messages); merged > 0 {
+			h.logger.Debug("merged consecutive messages", "merged", merged, "kept", len(messages))
+			req.Messages = messages
+		}
+	}
+
+	// Bound the conversation history passed to the CLI
+	if kept, dropped := truncateHistory(req.Messages, getMaxHistoryMessages(), getMaxHistoryTokens()); dropped > 0 {
+		h.logger.Info("truncated conversation history", "dropped", dropped, "kept", len(kept))
+		req.Messages = kept
+	}
+
+	// Add MCP tools to the request if available and not disabled by the client
+	if h.mcpManager != nil && h.mcpManager.HasTools() && !req.DisableMCP {
+		if detail := h.mergeMCPTools(req); detail != nil {
+			return detail
+		}
+	}
+
+	// The model can't tell same-named tools apart
+	tools, duplicates := dedupeTools(req.Tools)
+	if len(duplicates) > 0 {
+		h.logger.Warn("duplicate tool names in request", "tools", duplicates)
+		if getDuplicateToolsMode() == DuplicateToolsReject {
+			return invalidRequest("tools", "duplicate_tool_name",
+				fmt.Sprintf("tool names must be unique, duplicated: %s", strings.Join(duplicates, ", ")))
+		}
+		req.Tools = tools
+	}
+
+	// The request's tool_choice always wins over the configured default
+	if len(req.Tools) > 0 && req.ToolChoice == nil {
+		if choice := getDefaultToolChoice(); choice != "" {
+			req.ToolChoice = choice
+		}
+	}
+
+	return nil
+}
+
+// mergeMCPTools adds MCP tools to the request, resolving name collisions with
+// client tools according to CLAUDEX_TOOL_PRECEDENCE, and records which tools
+// are executed via MCP.
+func (h *ChatCompletionsHandler) mergeMCPTools(req *models.ChatCompletionRequest) *models.ErrorDetail {
+	mcpTools := h.mcpManager.GetToolsAsOpenAI()
+	if req.AllowedMCPTools != nil {
+		// The API key's policy hides the other MCP tools
+		allowed := mcpTools[:0:0]
+		for _, tool := range mcpTools {
+			if req.AllowedMCPTools[tool.Function.Name] {
+				allowed = append(allowed, tool)
+			}
+		}
+		mcpTools = allowed
+	}
+	mcpNames := make(map[string]bool, len(mcpTools))
+	for _, tool := range mcpTools {
+		mcpNames[tool.Function.Name] = true
+	}
+
+	clientNames := make(map[string]bool, len(req.Tools))
+	var collisions []string
+	for _, tool := range req.Tools {
+		name := tool.Function.Name
+		if mcpNames[name] && !clientNames[name] {
+			collisions = append(collisions, name)
+		}
+		clientNames[name] = true
+	}
+
+	precedence := getToolPrecedence()
+	if len(collisions) > 0 {
+		h.logger.Warn("client tools collide with MCP tools", "tools", collisions, "precedence", precedence)
+		if precedence == ToolPrecedenceError {
+			return invalidRequest("tools", "tool_name_conflict",
+				fmt.Sprintf("tools conflict with server-provided MCP tools: %s", strings.Join(collisions, ", ")))
+		}
+	}
+
+	merged := make([]models.Tool, 0, len(req.Tools)+len(mcpTools))
+	for _, tool := range req.Tools {
+		if precedence == ToolPrecedenceMCP && mcpNames[tool.Function.Name] {
+			continue
+		}
+		merged = append(merged, tool)
+	}
+
+	req.MCPTools = make(map[string]bool, len(mcpTools))
+	for _, tool := range mcpTools {
+		if precedence == ToolPrecedenceClient && clientNames[tool.Function.Name] {
+			continue
+		}
+		merged = append(merged, tool)
+		req.MCPTools[tool.Function.Name] = true
+	}
+
+	req.Tools = merged
+	return nil
+}
+
+// handleNonStreamingCLI handles non-streaming requests using CLI. A
+// successful response becomes turn's cached final turn, if turn is set.
+func (h *ChatCompletionsHandler) handleNonStreamingCLI(c *fiber.Ctx, req *models.ChatCompletionRequest, start time.Time, turn *cachedConversation) error {
+	ctx, cancel := context.WithTimeout(c.Context(), h.requestTimeout(c))
 	defer cancel()
+	ctx = withCaller(ctx, callerFrom(c, req))
+
+	openaiResp, cerr := h.complete(ctx, req)
+	if cerr != nil {
+		return h.writeCompletionError(c, req, cerr, start)
+	}
+
+	h.metrics.RecordRequest("success", openaiResp.Model, false, time.Since(start).Seconds())
+	h.storeCompletion(ctx, req, openaiResp)
+	h.cacheConversationTurn(turn, openaiResp)
+
+	if c.Get(DebugPromptHeader) == "1" {
+		openaiResp.Debug = h.promptDebug(req)
+	}
+	return c.JSON(openaiResp)
+}
+
+// supportsStreaming reports whether the executor can stream model.
+func (h *ChatCompletionsHandler) supportsStreaming(model string) bool {
+	checker, ok := h.executor.(streamingChecker)
+	return !ok || checker.SupportsStreaming(model)
+}
+
+// outputFormat returns the CLI output format the executor produces for req.
+func (h *ChatCompletionsHandler) outputFormat(req *models.ChatCompletionRequest) string {
+	formatter, ok := h.executor.(outputFormatter)
+	if !ok {
+		return claude.OutputFormatJSON
+	}
+	return formatter.OutputFormat(req)
+}
+
+// promptDebug returns the tools advertised to the model for req and, if the
+// executor can report them, the prompts it assembled.
+func (h *ChatCompletionsHandler) promptDebug(req *models.ChatCompletionRequest) *models.PromptDebug {
+	debug := &models.PromptDebug{Tools: advertisedTools(req)}
+	assembler, ok := h.executor.(promptAssembler)
+	if !ok {
+		return debug
+	}
+	systemPrompt, prompt, err := assembler.AssemblePrompt(req)
+	if err != nil {
+		h.logger.Warn("failed to assemble debug prompt", "error", err)
+		return debug
+	}
+	debug.SystemPrompt, debug.Prompt = systemPrompt, prompt
+	return debug
+}
+
+// advertisedTools returns the tools described to the model for req: the
+// merged client and MCP function tools.
+func advertisedTools(req *models.ChatCompletionRequest) []models.Tool {
+	tools := []models.Tool{}
+	for _, tool := range req.Tools {
+		if tool.Type == "function" {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
+
+// complete produces the final non-streaming response for a prepared request,
+// including empty response handling, response_format enforcement, MCP tool
+// execution, and output post-processing.
+func (h *ChatCompletionsHandler) complete(ctx context.Context, req *models.ChatCompletionRequest) (*models.ChatCompletionResponse, *completionError) {
+	openaiResp, cerr := h.runCompletion(ctx, req)
+	if cerr == nil && isEmptyResponse(openaiResp) {
+		openaiResp, cerr = h.handleEmptyResponse(ctx, req, openaiResp)
+	}
+	if cerr == nil {
+		cerr = h.limitToolCalls(req, openaiResp)
+	}
+	if cerr == nil {
+		cerr = h.checkToolCallArguments(req, openaiResp)
+	}
+	if cerr == nil && requiresToolCall(req) && !hasToolCalls(openaiResp) {
+		openaiResp, cerr = h.handleMissingToolCall(ctx, req, openaiResp)
+	}
+	if cerr == nil && wantsJSON(req) {
+		normalizeJSONResponse(openaiResp)
+		if schema := strictSchema(req); schema != nil && !hasToolCalls(openaiResp) {
+			openaiResp, cerr = h.enforceResponseSchema(ctx, req, schema, openaiResp)
+		}
+	}
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	// Execute MCP tools if there are tool calls and MCP manager is available
+	if len(openaiResp.Choices) > 0 && len(openaiResp.Choices[0].Message.ToolCalls) > 0 && h.mcpManager != nil {
+		if openaiResp, cerr = h.executeMCPToolCalls(ctx, openaiResp, req); cerr != nil {
+			return nil, cerr
+		}
+	}
+
+	applyStop(req, openaiResp)
+	h.handleMalformedToolCalls(req, openaiResp)
+	h.processOutput(openaiResp)
+	h.estimateUsage(req, openaiResp)
+	return openaiResp, nil
+}
+
+// handleMalformedToolCalls logs, and with CLAUDEX_MALFORMED_TOOL_CALLS_MODE
+// "strip" removes, tool_calls blocks left in the text of choices without tool
+// calls because they could not be parsed.
+func (h *ChatCompletionsHandler) handleMalformedToolCalls(req *models.ChatCompletionRequest, resp *models.ChatCompletionResponse) {
+	mode := getMalformedToolCallsMode()
+	if mode == MalformedToolCallsKeep {
+		return
+	}
+	for i := range resp.Choices {
+		msg := &resp.Choices[i].Message
+		text, ok := msg.Content.(string)
+		if !ok || len(msg.ToolCalls) > 0 {
+			continue
+		}
+		stripped, block := h.converter.StripMalformedToolCalls(text)
+		if block == "" {
+			continue
+		}
+		h.logger.Warn("response contains a malformed tool_calls block", "model", req.Model, "mode", mode, "block", block)
+		if mode == MalformedToolCallsStrip {
+			msg.Content = stripped
+		}
+	}
+}
+
+// limitToolCalls enforces CLAUDEX_MAX_TOOL_CALLS on each choice, truncating
+// the extra tool calls or failing the request per CLAUDEX_TOOL_CALL_LIMIT_MODE.
+func (h *ChatCompletionsHandler) limitToolCalls(req *models.ChatCompletionRequest, resp *models.ChatCompletionResponse) *completionError {
+	limit := getMaxToolCalls()
+	if limit == 0 {
+		return nil
+	}
+	for i := range resp.Choices {
+		calls := resp.Choices[i].Message.ToolCalls
+		if len(calls) <= limit {
+			continue
+		}
+		if getToolCallLimitMode() == ToolCallLimitReject {
+			h.logger.Warn("rejecting response over the tool call limit", "model", req.Model, "tool_calls", len(calls), "limit", limit)
+			return &completionError{
+				status: fiber.StatusBadGateway,
+				metric: "too_many_tool_calls",
+				detail: models.ErrorDetail{
+					Message: fmt.Sprintf("model returned %d tool calls, more than the limit of %d", len(calls), limit),
+					Type:    "server_error",
+					Code:    "too_many_tool_calls",
+				},
+			}
+		}
+		h.logger.Warn("truncating tool calls over the limit", "model", req.Model, "tool_calls", len(calls), "limit", limit)
+		resp.Choices[i].Message.ToolCalls = calls[:limit]
+	}
+	return nil
+}
+
+// checkToolCallArguments logs tool calls whose arguments are not a JSON
+// object and, with CLAUDEX_TOOL_ARGUMENTS=reject, fails the request.
+func (h *ChatCompletionsHandler) checkToolCallArguments(req *models.ChatCompletionRequest, resp *models.ChatCompletionResponse) *completionError {
+	mode := h.converter.ArgumentCoercion()
+	for i := range resp.Choices {
+		for _, tc := range resp.Choices[i].Message.ToolCalls {
+			err := converter.CheckToolCallArguments(tc)
+			if err == nil {
+				continue
+			}
+			h.logger.Warn("invalid tool call arguments", "model", req.Model, "tool", tc.Function.Name, "mode", mode, "error", err.Error())
+			if mode == converter.ArgumentCoercionReject {
+				return &completionError{
+					status: fiber.StatusBadGateway,
+					metric: "invalid_tool_arguments",
+					detail: models.ErrorDetail{
+						Message: "model returned invalid tool call arguments: " + err.Error(),
+						Type:    "server_error",
+						Code:    "invalid_tool_arguments",
+					},
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// completionError describes a failed completion and how to report it.
+type completionError struct {
+	status int
+	metric string
+	detail models.ErrorDetail
 
+	// retryAfter is sent as the Retry-After header when set
+	retryAfter time.Duration
+}
+
+// runCompletionOnce executes Claude CLI, parses its output, and converts it to
+// OpenAI format.
+func (h *ChatCompletionsHandler) runCompletionOnce(ctx context.Context, req *models.ChatCompletionRequest) (*models.ChatCompletionResponse, *completionError) {
 	claudeStart := time.Now()
 
+	// The CLI gets its own budget inside the HTTP request timeout
+	execCtx, cancel := context.WithTimeout(ctx, getExecTimeout(ctx))
+	defer cancel()
+
 	// Execute Claude CLI with messages (supports images and tools via stream-json)
-	output, err := h.executor.ExecuteWithMessages(ctx, req)
+	output, err := h.executor.ExecuteWithMessages(execCtx, req)
+	var cliErr *claude.CLIError
+	if errors.As(err, &cliErr) {
+		return nil, cliCompletionError(cliErr)
+	}
 	if err != nil {
-		h.metrics.RecordError("claude_error")
-		h.metrics.RecordRequest("error", false, time.Since(start).Seconds())
-		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
-			Error: models.ErrorDetail{
+		return nil, &completionError{
+			status: fiber.StatusInternalServerError,
+			metric: "claude_error",
+			detail: models.ErrorDetail{
 				Message: "Failed to execute Claude: " + err.Error(),
 				Type:    "server_error",
 				Code:    "claude_error",
 			},
-		})
+		}
 	}
 
 	h.metrics.RecordClaudeDuration(time.Since(claudeStart).Seconds())
 
 	// Parse Claude response
-	claudeResp, err := h.parser.ParseJSONResponse(output)
+	claudeResp, err := h.parser.ParseResponse(output, h.outputFormat(req))
+	if errors.As(err, &cliErr) {
+		return nil, cliCompletionError(cliErr)
+	}
 	if err != nil {
-		h.metrics.RecordError("parse_error")
-		h.metrics.RecordRequest("error", false, time.Since(start).Seconds())
-		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
-			Error: models.ErrorDetail{
+		return nil, &completionError{
+			status: fiber.StatusInternalServerError,
+			metric: "parse_error",
+			detail: models.ErrorDetail{
 				Message: "Failed to parse Claude response: " + err.Error(),
 				Type:    "server_error",
 				Code:    "parse_error",
 			},
-		})
+		}
 	}
 
 	// Convert to OpenAI format (handles tool calls in response)
-	openaiResp := h.converter.ClaudeToOpenAIResponse(claudeResp, req.Model)
+	return h.converter.ClaudeToOpenAIResponse(claudeResp, req.Model), nil
+}
 
-	// Execute MCP tools if there are tool calls and MCP manager is available
-	if len(openaiResp.Choices) > 0 && len(openaiResp.Choices[0].Message.ToolCalls) > 0 && h.mcpManager != nil {
-		openaiResp = h.executeMCPToolCalls(ctx, openaiResp, req)
+// cliCompletionError maps an error reported by the Claude CLI to an OpenAI-style
+// error, keeping the CLI's message and code.
+func cliCompletionError(cliErr *claude.CLIError) *completionError {
+	status, errType := fiber.StatusBadGateway, "server_error"
+	switch cliErr.Code {
+	case "invalid_request_error":
+		status, errType = fiber.StatusBadRequest, "invalid_request_error"
+	case claude.RateLimitErrorCode:
+		status, errType = fiber.StatusTooManyRequests, "rate_limit_error"
+	case "overloaded_error":
+		status = fiber.StatusServiceUnavailable
 	}
 
-	h.metrics.RecordRequest("success", false, time.Since(start).Seconds())
+	code := cliErr.Code
+	if code == "" {
+		code = "claude_error"
+	}
 
-	return c.JSON(openaiResp)
+	return &completionError{
+		status: status,
+		metric: "claude_error",
+		detail: models.ErrorDetail{
+			Message: cliErr.Message,
+			Type:    errType,
+			Code:    code,
+		},
+		retryAfter: cliErr.RetryAfter,
+	}
 }
 
-// executeMCPToolCalls executes tool calls via MCP and returns the results.
-func (h *ChatCompletionsHandler) executeMCPToolCalls(ctx context.Context, resp *models.ChatCompletionResponse, req *models.ChatCompletionRequest) *models.ChatCompletionResponse {
+// writeCompletionError records metrics for a failed completion and writes the error response.
+func (h *ChatCompletionsHandler) writeCompletionError(c *fiber.Ctx, req *models.ChatCompletionRequest, cerr *completionError, start time.Time) error {
+	h.metrics.RecordError(cerr.metric)
+	h.metrics.RecordRequest("error", req.Model, false, time.Since(start).Seconds())
+	if cerr.retryAfter > 0 {
+		c.Set("Retry-After", strconv.Itoa(int(math.Ceil(cerr.retryAfter.Seconds()))))
+	}
+	return c.Status(cerr.status).JSON(models.ErrorResponse{Error: cerr.detail})
+}
+
+// isEmptyResponse reports whether the first choice has neither content nor tool calls.
+func isEmptyResponse(resp *models.ChatCompletionResponse) bool {
+	if len(resp.Choices) == 0 {
+		return true
+	}
+	msg := resp.Choices[0].Message
+	return len(msg.ToolCalls) == 0 && strings.TrimSpace(msg.GetTextContent()) == ""
+}
+
+// handleEmptyResponse applies the configured empty response mode.
+func (h *ChatCompletionsHandler) handleEmptyResponse(ctx context.Context, req *models.ChatCompletionRequest, resp *models.ChatCompletionResponse) (*models.ChatCompletionResponse, *completionError) {
+	mode := getEmptyResponseMode()
+	h.logger.Warn("claude returned an empty response", "model", req.Model, "mode", mode)
+
+	switch mode {
+	case EmptyResponseError:
+		return nil, &completionError{
+			status: fiber.StatusBadGateway,
+			metric: "empty_response",
+			detail: models.ErrorDetail{
+				Message: "Claude returned an empty response",
+				Type:    "server_error",
+				Code:    "empty_response",
+			},
+		}
+	case EmptyResponseRetry:
+		retryResp, cerr := h.runCompletion(ctx, req)
+		if cerr != nil {
+			return nil, cerr
+		}
+		if isEmptyResponse(retryResp) {
+			h.logger.Warn("claude returned an empty response after retry", "model", req.Model)
+		}
+		return retryResp, nil
+	case EmptyResponseSentinel:
+		if len(resp.Choices) > 0 {
+			resp.Choices[0].Message.Content = getEmptyResponseSentinel()
+		}
+		return resp, nil
+	}
+
+	return resp, nil
+}
+
+// toolCallRequiredInstruction is added as a system message when retrying a
+// response that ignored tool_choice.
+const toolCallRequiredInstruction = "You MUST respond by calling one of the provided tools. Do not reply with plain text."
+
+// requiresToolCall reports whether tool_choice forces the model to call a tool.
+func requiresToolCall(req *models.ChatCompletionRequest) bool {
+	if len(req.Tools) == 0 {
+		return false
+	}
+	switch choice := req.ToolChoice.(type) {
+	case string:
+		return choice == "required"
+	case map[string]any:
+		return choice["type"] == "function"
+	}
+	return false
+}
+
+// hasToolCalls reports whether the response's first choice calls a tool.
+func hasToolCalls(resp *models.ChatCompletionResponse) bool {
+	return len(resp.Choices) > 0 && len(resp.Choices[0].Message.ToolCalls) > 0
+}
+
+// handleMissingToolCall applies the configured tool_choice "required" mode to
+// a response that has no tool call.
+func (h *ChatCompletionsHandler) handleMissingToolCall(ctx context.Context, req *models.ChatCompletionRequest, resp *models.ChatCompletionResponse) (*models.ChatCompletionResponse, *completionError) {
+	mode := getToolChoiceRequiredMode()
+	h.logger.Warn("claude ignored required tool_choice", "model", req.Model, "mode", mode)
+
+	switch mode {
+	case ToolChoiceRequiredPassthrough:
+		return resp, nil
+	case ToolChoiceRequiredRetry:
+		retryReq := *req
+		retryReq.Messages = append(append([]models.Message{}, req.Messages...), models.Message{
+			Role:    "system",
+			Content: toolCallRequiredInstruction,
+		})
+		retryResp, cerr := h.runCompletion(ctx, &retryReq)
+		if cerr != nil {
+			return nil, cerr
+		}
+		if hasToolCalls(retryResp) {
+			return retryResp, nil
+		}
+		h.logger.Warn("claude ignored required tool_choice after retry", "model", req.Model)
+	}
+
+	return nil, &completionError{
+		status: fiber.StatusBadGateway,
+		metric: "tool_choice_violation",
+		detail: models.ErrorDetail{
+			Message: "tool_choice requires a tool call but Claude responded with text",
+			Type:    "server_error",
+			Code:    "tool_choice_violation",
+		},
+	}
+}
+
+// executeMCPToolCalls executes the response's MCP tool calls and returns
+// Claude's response to their results. Tool errors are fed back to Claude
+// unless CLAUDEX_MCP_TOOL_ERROR_MODE is "fail", in which case the request
+// fails.
+func (h *ChatCompletionsHandler) executeMCPToolCalls(ctx context.Context, resp *models.ChatCompletionResponse, req *models.ChatCompletionRequest) (*models.ChatCompletionResponse, *completionError) {
 	if len(resp.Choices) == 0 || len(resp.Choices[0].Message.ToolCalls) == 0 {
-		return resp
+		return resp, nil
 	}
 
-	toolCalls := resp.Choices[0].Message.ToolCalls
-	var toolResults []models.Message
+	toolResults, cerr := h.runMCPTools(ctx, req, resp.Choices[0].Message.ToolCalls)
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	// If we executed any MCP tools, we need to continue the conversation
+	if len(toolResults) > 0 {
+		newReq := continuationRequest(req, toolResults)
+
+		// Execute again to get Claude's response to the tool results
+		newCtx, cancel := context.WithTimeout(ctx, getExecTimeout(ctx))
+		defer cancel()
+
+		output, err := h.executor.ExecuteWithMessages(newCtx, newReq)
+		if err != nil {
+			h.logger.Error("failed to execute continuation after tool calls", "error", err.Error())
+			return resp, nil
+		}
 
+		claudeResp, err := h.parser.ParseResponse(output, h.outputFormat(newReq))
+		if err != nil {
+			h.logger.Error("failed to parse continuation response", "error", err.Error())
+			return resp, nil
+		}
+
+		return h.converter.ClaudeToOpenAIResponse(claudeResp, req.Model), nil
+	}
+
+	return resp, nil
+}
+
+// runMCPTools executes the tool calls that go to MCP and returns their
+// results as tool messages, in call order. Calls to client tools are skipped.
+// Calls run in parallel, up to CLAUDEX_MCP_MAX_CONCURRENT_CALLS for the
+// request and CLAUDEX_MCP_MAX_CONCURRENT_CALLS_PER_SERVER for each server;
+// the rest wait for a slot. A failed call becomes an error result unless
+// CLAUDEX_MCP_TOOL_ERROR_MODE is "fail".
+func (h *ChatCompletionsHandler) runMCPTools(ctx context.Context, req *models.ChatCompletionRequest, toolCalls []models.ToolCall) ([]models.Message, *completionError) {
+	if limit := getMaxToolCalls(); limit > 0 && len(toolCalls) > limit {
+		toolCalls = toolCalls[:limit]
+	}
+
+	var calls []models.ToolCall
 	for _, tc := range toolCalls {
 		h.logger.Info("checking MCP tool availability", "tool_name", tc.Function.Name)
 
-		// Check if this is an MCP tool
-		if !h.mcpManager.IsToolAvailable(tc.Function.Name) {
+		// Check if this is an MCP tool (client tools may take precedence)
+		if !h.isMCPTool(req, tc.Function.Name) {
 			// Not an MCP tool, skip (caller handles non-MCP tools)
 			h.logger.Info("tool not available via MCP, skipping", "tool_name", tc.Function.Name)
 			continue
 		}
+		calls = append(calls, tc)
+	}
 
-		h.logger.Info("executing MCP tool", "tool_name", tc.Function.Name, "arguments", tc.Function.Arguments)
+	results := make([]*models.MCPToolResult, len(calls))
+	errs := make([]error, len(calls))
+	requestSlots := make(chan struct{}, getMaxConcurrentToolCalls())
+	var wg sync.WaitGroup
+	for i, tc := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := h.acquireToolSlot(ctx, requestSlots, tc.Function.Name)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer release()
+			results[i], errs[i] = h.callMCPTool(ctx, tc)
+		}()
+	}
+	wg.Wait()
 
-		// Execute the tool via MCP
-		result, err := h.mcpManager.CallTool(ctx, tc.Function.Name, json.RawMessage(tc.Function.Arguments))
+	var toolResults []models.Message
+	for i, tc := range calls {
+		result, err := results[i], errs[i]
+		if getMCPToolErrorMode() == MCPToolErrorFail {
+			if err == nil && result.IsError {
+				err = errors.New(result.GetTextContent())
+			}
+			if err != nil {
+				return nil, &completionError{
+					status: fiber.StatusBadGateway,
+					metric: "tool_error",
+					detail: models.ErrorDetail{
+						Message: fmt.Sprintf("MCP tool %s failed: %v", tc.Function.Name, err),
+						Type:    "server_error",
+						Code:    "tool_error",
+					},
+				}
+			}
+		}
 		if err != nil {
 			// Return error as tool result
 			toolResults = append(toolResults, models.Message{
@@ -184,55 +900,104 @@ func (h *ChatCompletionsHandler) executeMCPToolCalls(ctx context.Context, resp *
 			continue
 		}
 
-		// Format the tool result
-		resultContent := result.GetTextContent()
-		h.logger.Info("MCP tool executed successfully", "tool_name", tc.Function.Name, "result_length", len(resultContent))
+		// Format the tool result, forwarding images and mime-typed resources
+		resultContent, images := toolResultParts(result)
+		h.logger.Info("MCP tool executed successfully", "tool_name", tc.Function.Name, "result_length", len(resultContent), "images", len(images))
+		if limit := getMaxToolResultBytes(); limit > 0 && len(resultContent) > limit {
+			h.logger.Warn("truncating MCP tool result", "tool_name", tc.Function.Name, "result_length", len(resultContent), "limit", limit)
+			resultContent = truncateToolResult(resultContent, limit)
+		}
 		toolResults = append(toolResults, models.Message{
 			Role:       "tool",
 			ToolCallID: tc.ID,
-			Content:    resultContent,
+			Content:    toolResultMessageContent(resultContent, images),
 		})
 	}
+	return toolResults, nil
+}
 
-	// If we executed any MCP tools, we need to continue the conversation
-	if len(toolResults) > 0 {
-		// Build new messages array with original messages + tool results
-		// Note: Claude CLI stream-json doesn't accept assistant messages in input,
-		// so we include only user messages and tool results
-		messages := append([]models.Message{}, req.Messages...)
-		messages = append(messages, toolResults...)
+// callMCPTool executes one tool call via MCP, unless its arguments are
+// malformed, and audits it.
+func (h *ChatCompletionsHandler) callMCPTool(ctx context.Context, tc models.ToolCall) (*models.MCPToolResult, error) {
+	h.logger.Info("executing MCP tool", "tool_name", tc.Function.Name, "arguments", tc.Function.Arguments)
 
-		// Create a new request with the tool results
-		newReq := &models.ChatCompletionRequest{
-			Model:    req.Model,
-			Messages: messages,
-			Tools:    req.Tools,
-		}
+	toolStart := time.Now()
+	var result *models.MCPToolResult
+	err := h.validateToolArguments(tc)
+	if err == nil {
+		result, err = h.mcpManager.CallTool(ctx, tc.Function.Name, json.RawMessage(tc.Function.Arguments))
+	}
+	h.auditToolCall(ctx, tc, result, err, time.Since(toolStart))
+	return result, err
+}
 
-		// Execute again to get Claude's response to the tool results
-		newCtx, cancel := context.WithTimeout(ctx, getRequestTimeout())
-		defer cancel()
+// isMCPTool reports whether calls to the named tool are executed via MCP.
+func (h *ChatCompletionsHandler) isMCPTool(req *models.ChatCompletionRequest, name string) bool {
+	return h.mcpManager != nil && req.MCPTools[name] && h.mcpManager.IsToolAvailable(name)
+}
 
-		output, err := h.executor.ExecuteWithMessages(newCtx, newReq)
-		if err != nil {
-			h.logger.Error("failed to execute continuation after tool calls", "error", err.Error())
-			return resp
-		}
+// continuationRequest builds the request that gives Claude the results of
+// its MCP tool calls. The assistant turn that made the calls is not resent;
+// each tool result names its call ID. Earlier assistant turns are sent as
+// CLAUDEX_ASSISTANT_HISTORY selects.
+func continuationRequest(req *models.ChatCompletionRequest, toolResults []models.Message) *models.ChatCompletionRequest {
+	messages := append([]models.Message{}, req.Messages...)
+	messages = append(messages, toolResults...)
+	return &models.ChatCompletionRequest{
+		Model:    req.Model,
+		Messages: messages,
+		Tools:    req.Tools,
+		Stream:   req.Stream,
+	}
+}
 
-		claudeResp, err := h.parser.ParseJSONResponse(output)
-		if err != nil {
-			h.logger.Error("failed to parse continuation response", "error", err.Error())
-			return resp
-		}
+// auditToolCall writes the audit record for one MCP tool execution.
+func (h *ChatCompletionsHandler) auditToolCall(ctx context.Context, tc models.ToolCall, result *models.MCPToolResult, err error, duration time.Duration) {
+	if h.audit == nil {
+		return
+	}
 
-		return h.converter.ClaudeToOpenAIResponse(claudeResp, req.Model)
+	cl := callerFromContext(ctx)
+	entry := observability.ToolAuditEntry{
+		RequestID: cl.requestID,
+		User:      cl.user,
+		SafetyID:  cl.safetyID,
+		APIKey:    cl.apiKey,
+		Tool:      tc.Function.Name,
+		Arguments: tc.Function.Arguments,
+		Status:    "success",
+		Duration:  duration,
+	}
+	switch {
+	case err != nil:
+		entry.Status = "error"
+		entry.Error = err.Error()
+	case result.IsError:
+		entry.Status = "tool_error"
+	}
+	h.audit.LogToolCall(entry)
+}
+
+// truncateToolResult cuts content to at most limit bytes (on a UTF-8 boundary)
+// and appends a marker noting how many bytes were dropped.
+func truncateToolResult(content string, limit int) string {
+	if limit <= 0 || len(content) <= limit {
+		return content
 	}
 
-	return resp
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(content[cut]) {
+		cut--
+	}
+
+	return fmt.Sprintf("%s\n[truncated %d bytes]", content[:cut], len(content)-cut)
 }
 
-// handleStreamingCLI handles streaming requests using CLI.
-func (h *ChatCompletionsHandler) handleStreamingCLI(c *fiber.Ctx, req *models.ChatCompletionRequest, start time.Time) error {
+// handleStreamingCLI handles streaming requests using CLI. done is called
+// once the stream has been written. When n > 1, n executor streams run
+// concurrently and their chunks are interleaved on the same SSE response,
+// each tagged with its choice index.
+func (h *ChatCompletionsHandler) handleStreamingCLI(c *fiber.Ctx, req *models.ChatCompletionRequest, start time.Time, done func()) error {
 	// Set SSE headers
 	c.Set("Content-Type", "text/event-stream")
 	c.Set("Cache-Control", "no-cache")
@@ -240,83 +1005,549 @@ func (h *ChatCompletionsHandler) handleStreamingCLI(c *fiber.Ctx, req *models.Ch
 	c.Set("Transfer-Encoding", "chunked")
 	c.Set("X-Accel-Buffering", "no")
 
-	completionID := converter.GenerateCompletionID()
+	completionID := h.converter.GenerateCompletionID()
+
+	// The stream outlives the handler, so caller details are captured now.
+	// Without RequestTimeoutHeader only each CLI run is limited, as before.
+	ctx := withCaller(context.Background(), callerFrom(c, req))
+	cancel := context.CancelFunc(func() {})
+	if c.Get(RequestTimeoutHeader) != "" {
+		ctx, cancel = context.WithTimeout(ctx, h.requestTimeout(c))
+	}
 
 	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
-		defer func() {
-			h.metrics.RecordRequest("success", true, time.Since(start).Seconds())
-		}()
+		defer done()
+		defer cancel()
+		summary := h.writeStream(ctx, w, req, completionID, start)
+		h.metrics.RecordRequest(summary.status, req.Model, true, time.Since(start).Seconds())
+		if getStreamSummaryLog() {
+			h.logStreamSummary(ctx, req, completionID, summary, time.Since(start))
+		}
+	}))
+
+	return nil
+}
 
-		claudeStart := time.Now()
+// writeStream runs the request's choices and writes their chunks to w as SSE
+// events, recording the time from start to the first content delta. It returns
+// a summary of what was sent, whose status is the request status to record:
+// "client_disconnect" when a write fails because the client went away,
+// otherwise "success".
+func (h *ChatCompletionsHandler) writeStream(ctx context.Context, w *bufio.Writer, req *models.ChatCompletionRequest, completionID string, start time.Time) streamSummary {
+	n := req.N
+	if n < 1 {
+		n = 1
+	}
 
-		// Start streaming from Claude CLI (supports images and tools via stream-json)
-		chunks, errChan, err := h.executor.ExecuteStreamingWithMessages(context.Background(), req)
-		if err != nil {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Fan each choice's chunks into a single channel so only this
+	// goroutine writes to w. Per-choice ordering is preserved because
+	// each choice sends sequentially.
+	events := make(chan streamEvent)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			h.streamChoice(ctx, req, completionID, index, events)
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	// abort stops the remaining choices and drains so their goroutines can exit
+	abort := func() {
+		cancel()
+		for range events {
+		}
+	}
+
+	summary := streamSummary{status: "success"}
+	for ev := range events {
+		if ev.errorMsg != "" {
+			cancel()
 			h.metrics.RecordError("claude_error")
-			h.writeSSEError(w, "Failed to start Claude: "+err.Error())
+			summary.bytes += h.writeSSEError(w, ev.errorMsg)
+			summary.finishReason = "error"
+			abort()
+			return summary
+		}
+
+		if ev.keepAlive {
+			// A comment line, which SSE clients ignore
+			n, _ := w.WriteString(": keep-alive\n\n")
+			summary.bytes += n
+		} else if ev.toolResult != nil {
+			// Always named, so clients can tell it from content chunks
+			data, _ := json.Marshal(ev.toolResult)
+			n, _ := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", sseEventToolResult, data)
+			summary.bytes += n
+		} else {
+			data, _ := json.Marshal(ev.chunk)
+			summary.bytes += writeSSEEvent(w, sseEventMessage, string(data))
+		}
+		summary.record(ev)
+		if err := w.Flush(); err != nil {
+			h.clientDisconnected(err)
+			abort()
+			summary.status = "client_disconnect"
+			return summary
+		}
+		if summary.ttft == 0 && ev.chunk != nil && hasContentDelta(ev.chunk) {
+			summary.ttft = time.Since(start)
+			h.metrics.RecordTTFT(summary.ttft.Seconds())
+		}
+	}
+
+	// Choices stopped by the request timeout could not send their errors
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		h.metrics.RecordError("claude_error")
+		summary.bytes += h.writeSSEError(w, "request timed out")
+		summary.finishReason = "error"
+		return summary
+	}
+
+	// Send [DONE] marker once every choice has finished
+	summary.bytes += writeSSEEvent(w, sseEventDone, "[DONE]")
+	if err := w.Flush(); err != nil {
+		h.clientDisconnected(err)
+		summary.status = "client_disconnect"
+	}
+	return summary
+}
+
+// extractedToolCallEvents extracts tool calls from a complete response text
+// as non-streaming responses do, returning the remaining text followed by one
+// event per call.
+func extractedToolCallEvents(conv *converter.Converter, text string) []converter.ToolCallStreamEvent {
+	content, toolCalls := conv.ExtractToolCalls(text)
+	events := []converter.ToolCallStreamEvent{{Text: content}}
+	for i, tc := range toolCalls {
+		events = append(events, converter.ToolCallStreamEvent{ToolCall: &models.ToolCallDelta{
+			Index:    i,
+			ID:       tc.ID,
+			Type:     tc.Type,
+			Function: &models.FunctionCallDelta{Name: tc.Function.Name, Arguments: tc.Function.Arguments},
+		}})
+	}
+	return events
+}
+
+// hasContentDelta reports whether chunk carries text or tool call deltas,
+// rather than only a role or finish reason.
+func hasContentDelta(chunk *models.ChatCompletionChunk) bool {
+	for _, choice := range chunk.Choices {
+		if choice.Delta.Content != "" || len(choice.Delta.ToolCalls) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// clientDisconnected records a stream the client abandoned.
+func (h *ChatCompletionsHandler) clientDisconnected(err error) {
+	h.logger.Info("client disconnected mid-stream", "error", err.Error())
+	h.metrics.RecordClientDisconnect()
+}
+
+// streamEvent is a chunk (or terminal error) produced by a single choice's stream.
+type streamEvent struct {
+	chunk      *models.ChatCompletionChunk
+	toolResult *models.StreamToolResult
+	errorMsg   string
+	keepAlive  bool // an SSE comment showing the stream is alive while tools run
+}
+
+// streamChoice runs one executor stream and sends its chunks, tagged with
+// index, to events. The role chunk precedes the first content delta and the
+// final chunk carries finish_reason. When streamed tool calls go to MCP, they
+// are executed instead of sent, and Claude's response to their results is
+// streamed as a continuation on the same choice.
+func (h *ChatCompletionsHandler) streamChoice(ctx context.Context, req *models.ChatCompletionRequest, completionID string, index int, events chan<- streamEvent) {
+	cs := &choiceStream{
+		h:            h,
+		ctx:          ctx,
+		req:          req,
+		completionID: completionID,
+		index:        index,
+		events:       events,
+		output:       h.outputStream(),
+	}
+	// Stop sequences are cut from the model's text before post-processing
+	if len(req.Stop) > 0 {
+		cs.stop = newStopStream(req.Stop)
+		cs.output = &chainedStream{first: cs.stop, second: cs.output}
+	}
+
+	var usage models.Usage
+	phaseReq := req
+	for phase := 0; ; phase++ {
+		// Only the first phase's MCP tool calls are executed, as for
+		// non-streaming requests
+		result, ok := cs.runPhase(phaseReq, phase == 0 && len(req.MCPTools) > 0)
+		if !ok {
 			return
 		}
+		usage.PromptTokens += result.usage.PromptTokens
+		usage.CompletionTokens += result.usage.CompletionTokens
+		usage.TotalTokens += result.usage.TotalTokens
 
-		h.metrics.RecordClaudeDuration(time.Since(claudeStart).Seconds())
+		if len(result.mcpCalls) == 0 {
+			// Send final chunk with finish_reason
+			final := h.converter.CreateFinalChunk(completionID, req.Model)
+			if result.sentToolCalls {
+				final = h.converter.CreateToolCallFinalChunk(completionID, req.Model)
+			}
+			if getAlwaysStreamUsage() {
+				if usage.TotalTokens == 0 && getEstimateUsage() {
+					usage = estimatedUsage(req, cs.sentChars)
+				}
+				final.Usage = &usage
+			}
+			cs.send(streamEvent{chunk: final})
+			return
+		}
+
+		stopKeepAlive := cs.keepAlive(getToolKeepAlive())
+		toolResults, cerr := h.runMCPTools(ctx, req, result.mcpCalls)
+		stopKeepAlive()
+		if cerr != nil {
+			h.metrics.RecordError(cerr.metric)
+			cs.send(streamEvent{errorMsg: cerr.detail.Message})
+			return
+		}
+		h.logger.Info("streaming continuation after MCP tool calls", "model", req.Model, "tool_results", len(toolResults))
+		if getStreamToolResults() && !cs.sendToolResults(result.mcpCalls, toolResults) {
+			return
+		}
+		phaseReq = continuationRequest(req, toolResults)
+		cs.separate = cs.sentText
+	}
+}
 
-		isFirst := true
+// choiceStream is the state of one streamed choice, shared by the executor
+// streams (phases) that produce it.
+type choiceStream struct {
+	h            *ChatCompletionsHandler
+	ctx          context.Context
+	req          *models.ChatCompletionRequest
+	completionID string
+	index        int
+	events       chan<- streamEvent
+	output       OutputStream
+	stop         *stopStream // cuts the text at a stop sequence, if the request sets any
 
-		for line := range chunks {
-			msg, err := h.parser.ParseStreamLine(line)
-			if err != nil {
-				continue
+	started bool // the role chunk was sent
+
+	// sentText and separate put a paragraph break between text sent before
+	// MCP tool calls and the continuation's text
+	sentText, separate bool
+
+	// sentChars counts the text and tool call characters sent, for usage
+	// estimates
+	sentChars int
+}
+
+// stopped reports whether the choice's text reached a stop sequence.
+func (cs *choiceStream) stopped() bool {
+	return cs.stop != nil && cs.stop.matched
+}
+
+// streamPhaseResult is what one executor stream of a choice produced.
+type streamPhaseResult struct {
+	usage         models.Usage
+	sentToolCalls bool              // tool call deltas were sent to the client
+	mcpCalls      []models.ToolCall // MCP tool calls held back for execution
+}
+
+// send tags a chunk with the choice index and sends it, reporting false if
+// the stream was cancelled.
+func (cs *choiceStream) send(ev streamEvent) bool {
+	if ev.chunk != nil {
+		ev.chunk.Choices[0].Index = cs.index
+	}
+	select {
+	case cs.events <- ev:
+		return true
+	case <-cs.ctx.Done():
+		return false
+	}
+}
+
+// keepAlive sends a keep-alive event every interval until the returned
+// function is called, so the client sees activity while MCP tools run. A
+// zero interval sends none.
+func (cs *choiceStream) keepAlive(interval time.Duration) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			select {
+			case cs.events <- streamEvent{keepAlive: true}:
+			case <-done:
+				return
+			case <-cs.ctx.Done():
+				return
 			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
 
-			// Handle stream_event messages with content deltas
-			if msg.Type == "stream_event" {
-				deltaText := msg.GetDeltaText()
-				if deltaText == "" {
-					continue
-				}
+// sendToolResults sends the results of the MCP tool calls as tool result
+// events.
+func (cs *choiceStream) sendToolResults(calls []models.ToolCall, results []models.Message) bool {
+	names := make(map[string]string, len(calls))
+	for _, tc := range calls {
+		names[tc.ID] = tc.Function.Name
+	}
+	for _, msg := range results {
+		ev := streamEvent{toolResult: &models.StreamToolResult{
+			ID:         cs.completionID,
+			Object:     "claudex.tool_result",
+			Index:      cs.index,
+			ToolCallID: msg.ToolCallID,
+			Name:       names[msg.ToolCallID],
+			Content:    msg.GetTextContent(),
+		}}
+		if !cs.send(ev) {
+			return false
+		}
+	}
+	return true
+}
+
+// start sends the role chunk before the choice's first delta.
+func (cs *choiceStream) start() bool {
+	if cs.started {
+		return true
+	}
+	cs.started = true
+	return cs.send(streamEvent{chunk: cs.h.converter.CreateRoleChunk(cs.completionID, cs.req.Model)})
+}
+
+// emit sends post-processed text, preceded by the role chunk the first time.
+func (cs *choiceStream) emit(text string) bool {
+	if text == "" {
+		return true
+	}
+	if !cs.start() {
+		return false
+	}
+	if cs.separate {
+		text = "\n\n" + text
+		cs.separate = false
+	}
+	cs.sentText = true
+	cs.sentChars += len(text)
+	return cs.send(streamEvent{chunk: cs.h.converter.CreateContentChunk(cs.completionID, cs.req.Model, text)})
+}
+
+// runPhase runs one executor stream for req and sends its text and tool call
+// deltas. With holdMCP, tool calls to MCP tools are collected for execution
+// instead of sent. It returns false once the response has ended, with an
+// error event or because the client went away.
+func (cs *choiceStream) runPhase(req *models.ChatCompletionRequest, holdMCP bool) (streamPhaseResult, bool) {
+	h := cs.h
+	var result streamPhaseResult
+	claudeStart := time.Now()
+
+	execCtx, cancel := context.WithTimeout(cs.ctx, getExecTimeout(cs.ctx))
+	defer cancel()
+
+	// Start streaming from Claude CLI (supports images and tools via stream-json)
+	chunks, errChan, err := h.executor.ExecuteStreamingWithMessages(execCtx, req)
+	if err != nil {
+		cs.send(streamEvent{errorMsg: "Failed to start Claude: " + err.Error()})
+		return result, false
+	}
+
+	// However the phase ends, the CLI is stopped and its remaining output
+	// discarded, so the executor's goroutine is never left blocked on a send
+	defer func() {
+		cancel()
+		for range chunks {
+		}
+	}()
+
+	h.metrics.RecordClaudeDuration(time.Since(claudeStart).Seconds())
 
-				// Send role-only chunk first
-				if isFirst {
-					roleChunk := h.converter.CreateRoleChunk(completionID, req.Model)
-					data, _ := json.Marshal(roleChunk)
-					fmt.Fprintf(w, "data: %s\n\n", data)
-					w.Flush()
-					isFirst = false
+	// With tools, a tool_calls JSON block in the text becomes tool call
+	// deltas, either as it is written or once the whole text is buffered
+	var toolStream *converter.ToolCallStream
+	var buffered *strings.Builder
+	if len(req.Tools) > 0 {
+		switch getStreamToolCallsMode() {
+		case StreamToolCallsIncremental:
+			toolStream = converter.NewToolCallStream()
+		case StreamToolCallsBuffered:
+			buffered = &strings.Builder{}
+		}
+	}
+	toolCallLimit, truncated := getMaxToolCalls(), false
+
+	// Partial events and complete messages can carry the same text
+	text := converter.NewTextStream(getStreamTextSource() == StreamTextMerged)
+
+	// Held-back MCP calls by stream index. Calls sent to the client are
+	// renumbered so their indexes stay consecutive.
+	mcpCalls := make(map[int]*models.ToolCall)
+	var mcpOrder []int
+	clientIndex := make(map[int]int)
+
+	emitEvents := func(evs []converter.ToolCallStreamEvent) bool {
+		for _, ev := range evs {
+			// Nothing after a stop sequence is sent, tool calls included
+			if cs.stopped() {
+				return true
+			}
+			if ev.ToolCall == nil {
+				if !cs.emit(cs.output.Write(ev.Text)) {
+					return false
+				}
+				continue
+			}
+			// Headers are already sent, so extra calls are always dropped
+			if toolCallLimit > 0 && ev.ToolCall.Index >= toolCallLimit {
+				if !truncated {
+					h.logger.Warn("truncating streamed tool calls over the limit", "model", req.Model, "limit", toolCallLimit)
+					truncated = true
 				}
+				continue
+			}
+			tc := ev.ToolCall
+			var name, args string
+			if tc.Function != nil {
+				name, args = tc.Function.Name, tc.Function.Arguments
+			}
+
+			// The header names the tool; later deltas only carry arguments
+			if tc.ID != "" && holdMCP && h.isMCPTool(req, name) {
+				mcpCalls[tc.Index] = &models.ToolCall{ID: tc.ID, Type: "function", Function: models.FunctionCall{Name: name}}
+				mcpOrder = append(mcpOrder, tc.Index)
+			}
+			if call, ok := mcpCalls[tc.Index]; ok {
+				call.Function.Arguments += args
+				continue
+			}
 
-				// Create chunk with delta text
-				chunk := h.converter.CreateContentChunk(completionID, req.Model, deltaText)
-				data, _ := json.Marshal(chunk)
-				fmt.Fprintf(w, "data: %s\n\n", data)
-				w.Flush()
+			if _, ok := clientIndex[tc.Index]; !ok {
+				clientIndex[tc.Index] = len(clientIndex)
+			}
+			if !cs.start() {
+				return false
+			}
+			if !cs.send(streamEvent{chunk: h.converter.CreateToolCallChunk(cs.completionID, cs.req.Model, clientIndex[tc.Index], tc.ID, name, args)}) {
+				return false
 			}
+			cs.sentChars += len(name) + len(args)
+			result.sentToolCalls = true
 		}
+		return true
+	}
 
-		// Check for errors
-		select {
-		case err := <-errChan:
-			if err != nil {
-				h.metrics.RecordError("claude_error")
-				h.writeSSEError(w, err.Error())
-				return
+	for line := range chunks {
+		msg, err := h.parser.ParseStreamLine(line)
+		if err != nil {
+			continue
+		}
+
+		if msg.Type == "result" && msg.Usage != nil {
+			result.usage = msg.Usage.ToOpenAIUsage()
+		}
+
+		// Text not yet sent, from partial events or complete messages
+		deltaText := text.Write(msg)
+		if deltaText == "" {
+			continue
+		}
+
+		switch {
+		case toolStream != nil:
+			if !emitEvents(toolStream.Write(deltaText)) {
+				return result, false
 			}
+		case buffered != nil:
+			buffered.WriteString(deltaText)
 		default:
+			if !cs.emit(cs.output.Write(deltaText)) {
+				return result, false
+			}
 		}
 
-		// Send final chunk with finish_reason
-		finalChunk := h.converter.CreateFinalChunk(completionID, req.Model)
-		data, _ := json.Marshal(finalChunk)
-		fmt.Fprintf(w, "data: %s\n\n", data)
+		if cs.stopped() {
+			break
+		}
+	}
 
-		// Send [DONE] marker
-		fmt.Fprintf(w, "data: [DONE]\n\n")
-		w.Flush()
-	}))
+	// The rest of the response is not wanted after a stop sequence, so the
+	// CLI is stopped and its remaining output discarded
+	if cs.stopped() {
+		cancel()
+		for range chunks {
+		}
+		if !cs.emit(cs.output.Flush()) {
+			return result, false
+		}
+		return result, true
+	}
 
-	return nil
+	// Send text the tool call parser and output processor held back
+	if toolStream != nil && !emitEvents(toolStream.Flush()) {
+		return result, false
+	}
+	if buffered != nil && !emitEvents(extractedToolCallEvents(h.converter, buffered.String())) {
+		return result, false
+	}
+	if !cs.emit(cs.output.Flush()) {
+		return result, false
+	}
+
+	// Check for errors
+	select {
+	case err := <-errChan:
+		if err != nil {
+			// Close out content the client already has so the stream stays well-formed
+			if reason := getStreamErrorFinishReason(); reason != "" && cs.started {
+				final := h.converter.CreateFinalChunk(cs.completionID, cs.req.Model)
+				final.Choices[0].FinishReason = reason
+				if !cs.send(streamEvent{chunk: final}) {
+					return result, false
+				}
+			}
+			cs.send(streamEvent{errorMsg: err.Error()})
+			return result, false
+		}
+	default:
+	}
+
+	for _, i := range mcpOrder {
+		result.mcpCalls = append(result.mcpCalls, *mcpCalls[i])
+	}
+	return result, true
 }
 
-// writeSSEError writes an error as an SSE event.
-func (h *ChatCompletionsHandler) writeSSEError(w *bufio.Writer, message string) {
+// writeSSEError writes an error as an SSE event, returning the bytes written.
+func (h *ChatCompletionsHandler) writeSSEError(w *bufio.Writer, message string) int {
 	errResp := models.ErrorResponse{
 		Error: models.ErrorDetail{
 			Message: message,
@@ -325,9 +1556,34 @@ func (h *ChatCompletionsHandler) writeSSEError(w *bufio.Writer, message string)
 		},
 	}
 	data, _ := json.Marshal(errResp)
-	fmt.Fprintf(w, "data: %s\n\n", data)
-	fmt.Fprintf(w, "data: [DONE]\n\n")
+	n := writeSSEEvent(w, sseEventError, string(data))
+	n += writeSSEEvent(w, sseEventDone, "[DONE]")
 	w.Flush()
+	return n
+}
+
+// SSE event names used when CLAUDEX_SSE_EVENT_NAMES is enabled.
+const (
+	sseEventMessage = "message"
+	sseEventError   = "error"
+	sseEventDone    = "done"
+)
+
+// sseEventToolResult names the tool result events sent when
+// CLAUDEX_STREAM_TOOL_RESULTS is enabled, whether or not other events are
+// named.
+const sseEventToolResult = "tool_result"
+
+// writeSSEEvent writes one SSE event, returning the bytes written. Events
+// are bare data lines unless named events are enabled, for clients that
+// require an event field.
+func writeSSEEvent(w *bufio.Writer, event, data string) int {
+	var n int
+	if getSSEEventNames() {
+		n, _ = fmt.Fprintf(w, "event: %s\n", event)
+	}
+	m, _ := fmt.Fprintf(w, "data: %s\n\n", data)
+	return n + m
 }
 
 // NOTE: Anthropic API handlers removed as part of deprecation (PRP-002).
@@ -0,0 +1,1792 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/claude"
+	"github.com/leeaandrob/claudex/internal/converter"
+	"github.com/leeaandrob/claudex/internal/mcp"
+	"github.com/leeaandrob/claudex/internal/models"
+	"github.com/leeaandrob/claudex/internal/observability"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// testMetrics is shared because Prometheus metrics can only be registered once.
+var testMetrics = observability.InitMetrics()
+
+// fakeExecutor stubs the Claude CLI for handler tests.
+type fakeExecutor struct {
+	execute func(ctx context.Context, req *models.ChatCompletionRequest) (string, error)
+	stream  func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error)
+}
+
+func (f *fakeExecutor) ExecuteWithMessages(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+	return f.execute(ctx, req)
+}
+
+func (f *fakeExecutor) ExecuteStreamingWithMessages(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+	return f.stream(ctx, req)
+}
+
+// resultJSON returns CLI JSON output with the given result text.
+func resultJSON(text string) string {
+	data, _ := json.Marshal(models.ClaudeJSONResponse{Type: "result", Result: text})
+	return string(data)
+}
+
+// deltaLine returns a stream-json text delta line.
+func deltaLine(text string) string {
+	data, _ := json.Marshal(map[string]any{
+		"type": "stream_event",
+		"event": map[string]any{
+			"type":  "content_block_delta",
+			"delta": map[string]any{"type": "text_delta", "text": text},
+		},
+	})
+	return string(data)
+}
+
+// streamOf returns channels that yield lines and then err.
+func streamOf(lines []string, err error) (<-chan string, <-chan error) {
+	chunks := make(chan string, len(lines))
+	errChan := make(chan error, 1)
+	for _, line := range lines {
+		chunks <- line
+	}
+	if err != nil {
+		errChan <- err
+	}
+	close(chunks)
+	close(errChan)
+	return chunks, errChan
+}
+
+// newTestHandler returns a handler using exec and no MCP manager.
+func newTestHandler(exec Executor) *ChatCompletionsHandler {
+	return NewChatCompletionsHandler(exec, claude.NewParser(), converter.NewConverter(), nil, testMetrics, observability.NewLogger("error"))
+}
+
+// newTestApp returns a Fiber app serving a new handler's routes.
+func newTestApp(exec Executor) *fiber.App {
+	return appFor(newTestHandler(exec))
+}
+
+// appFor returns a Fiber app serving h's routes.
+func appFor(h *ChatCompletionsHandler) *fiber.App {
+	app := fiber.New()
+	app.Post("/v1/chat/completions", h.Handle)
+	app.Get("/v1/chat/completions/:id", h.HandleGetCompletion)
+	app.Post("/v1/batch/completions", h.HandleBatch)
+	return app
+}
+
+// postChat sends a chat completion request and returns the response and body.
+func postChat(t *testing.T, app *fiber.App, body string) (*http.Response, string) {
+	t.Helper()
+	return postJSON(t, app, "/v1/chat/completions", body)
+}
+
+// postJSON sends a JSON POST request and returns the response and body.
+func postJSON(t *testing.T, app *fiber.App, path, body string) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("app.Test: %v", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return resp, string(data)
+}
+
+// sseChunks decodes the data payloads of an SSE body, excluding [DONE].
+func sseChunks(t *testing.T, body string) []models.ChatCompletionChunk {
+	t.Helper()
+	var chunks []models.ChatCompletionChunk
+	for _, line := range strings.Split(body, "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk models.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func TestTruncateToolResult(t *testing.T) {
+	result := &models.MCPToolResult{
+		Content: []models.MCPContent{{Type: "text", Text: strings.Repeat("a", 5000)}},
+	}
+
+	got := truncateToolResult(result.GetTextContent(), 1000)
+
+	if !strings.HasPrefix(got, strings.Repeat("a", 1000)+"\n") {
+		t.Errorf("truncated content does not keep the first 1000 bytes")
+	}
+	if !strings.HasSuffix(got, "[truncated 4000 bytes]") {
+		t.Errorf("got suffix %q, want truncation marker", got[len(got)-30:])
+	}
+}
+
+func TestTruncateToolResult_NoOp(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		limit   int
+	}{
+		{name: "under limit", content: "short", limit: 100},
+		{name: "exact limit", content: "12345", limit: 5},
+		{name: "disabled", content: strings.Repeat("x", 100), limit: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateToolResult(tt.content, tt.limit); got != tt.content {
+				t.Errorf("got %q, want unchanged %q", got, tt.content)
+			}
+		})
+	}
+}
+
+func TestTruncateToolResult_RuneBoundary(t *testing.T) {
+	// "é" is two bytes; a limit of 3 would split the second rune.
+	got := truncateToolResult("ééé", 3)
+
+	if !strings.HasPrefix(got, "é\n") {
+		t.Errorf("got %q, want cut on rune boundary", got)
+	}
+	if !strings.HasSuffix(got, "[truncated 4 bytes]") {
+		t.Errorf("got %q, want 4 truncated bytes", got)
+	}
+}
+
+func TestHandleStreaming_MultipleChoices(t *testing.T) {
+	exec := &fakeExecutor{
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			chunks, errChan := streamOf([]string{deltaLine("Hello"), deltaLine(" there")}, nil)
+			return chunks, errChan, nil
+		},
+	}
+	app := newTestApp(exec)
+
+	_, body := postChat(t, app, `{"model":"claude-sonnet","stream":true,"n":2,"messages":[{"role":"user","content":"hi"}]}`)
+
+	roles := map[int]int{}
+	content := map[int]string{}
+	finished := map[int]int{}
+	for _, chunk := range sseChunks(t, body) {
+		choice := chunk.Choices[0]
+		if choice.Delta.Role != "" {
+			roles[choice.Index]++
+		}
+		content[choice.Index] += choice.Delta.Content
+		if choice.FinishReason != "" {
+			finished[choice.Index]++
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if roles[i] != 1 {
+			t.Errorf("choice %d: got %d role chunks, want 1", i, roles[i])
+		}
+		if content[i] != "Hello there" {
+			t.Errorf("choice %d: got content %q, want %q", i, content[i], "Hello there")
+		}
+		if finished[i] != 1 {
+			t.Errorf("choice %d: got %d final chunks, want 1", i, finished[i])
+		}
+	}
+	if got := strings.Count(body, "data: [DONE]"); got != 1 {
+		t.Errorf("got %d [DONE] markers, want 1", got)
+	}
+
+	// Each choice runs a CLI process, so n is capped
+	t.Setenv("CLAUDEX_MAX_CHOICES", "2")
+	resp, body := postChat(t, app, `{"model":"claude-sonnet","stream":true,"n":3,"messages":[{"role":"user","content":"hi"}]}`)
+	if resp.StatusCode != 400 || !strings.Contains(body, `"invalid_parameter"`) {
+		t.Errorf("n over the limit: got status %d (body=%s), want 400 invalid_parameter", resp.StatusCode, body)
+	}
+}
+
+func TestHandleNonStreaming_EmptyResponseModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		outputs     []string
+		wantStatus  int
+		wantContent string
+		wantCalls   int
+	}{
+		{name: "passthrough", mode: "", outputs: []string{""}, wantStatus: 200, wantContent: "", wantCalls: 1},
+		{name: "error", mode: "error", outputs: []string{""}, wantStatus: 502, wantCalls: 1},
+		{name: "retry", mode: "retry", outputs: []string{"", "second try"}, wantStatus: 200, wantContent: "second try", wantCalls: 2},
+		{name: "sentinel", mode: "sentinel", outputs: []string{"  "}, wantStatus: 200, wantContent: DefaultEmptyResponseSentinel, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_EMPTY_RESPONSE_MODE", tt.mode)
+
+			calls := 0
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					out := tt.outputs[min(calls, len(tt.outputs)-1)]
+					calls++
+					return resultJSON(out), nil
+				},
+			}
+
+			resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("got %d executor calls, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+
+			var out models.ChatCompletionResponse
+			if err := json.Unmarshal([]byte(body), &out); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			if got := out.Choices[0].Message.GetTextContent(); got != tt.wantContent {
+				t.Errorf("got content %q, want %q", got, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestHandle_LogitBias(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		wantStatus int
+		wantCalls  int
+	}{
+		{name: "ignore by default", mode: "", wantStatus: 200, wantCalls: 1},
+		{name: "reject", mode: "reject", wantStatus: 400, wantCalls: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_LOGIT_BIAS_MODE", tt.mode)
+
+			calls := 0
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					calls++
+					return resultJSON("ok"), nil
+				},
+			}
+
+			resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}],"logit_bias":{"50256":-100}}`)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("got %d executor calls, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantStatus == 400 && !strings.Contains(body, "unsupported_parameter") {
+				t.Errorf("got body %s, want unsupported_parameter", body)
+			}
+		})
+	}
+}
+
+func TestHandleNonStreaming_CLIError(t *testing.T) {
+	tests := []struct {
+		name           string
+		output         string
+		err            error
+		wantStatus     int
+		wantCode       string
+		wantRetryAfter string
+	}{
+		{
+			name:       "rate limit",
+			output:     `{"type":"error","error":{"type":"rate_limit_error","message":"Rate limited"}}`,
+			wantStatus: 429,
+			wantCode:   "rate_limit_error",
+		},
+		{
+			name:           "rate limit with retry hint",
+			output:         `{"type":"error","error":{"type":"rate_limit_error","message":"Rate limited, try again in 2 minutes"}}`,
+			wantStatus:     429,
+			wantCode:       "rate_limit_error",
+			wantRetryAfter: "120",
+		},
+		{
+			name:           "rate limit on stderr",
+			err:            claude.ParseRateLimit("API Error: 429 Too Many Requests. Retry after 30 seconds."),
+			wantStatus:     429,
+			wantCode:       "rate_limit_error",
+			wantRetryAfter: "30",
+		},
+		{
+			name:       "max turns",
+			output:     `{"type":"result","subtype":"error_max_turns","is_error":true,"result":"Reached max turns"}`,
+			wantStatus: 502,
+			wantCode:   "error_max_turns",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					return tt.output, tt.err
+				},
+			}
+
+			resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if got := resp.Header.Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("got Retry-After %q, want %q", got, tt.wantRetryAfter)
+			}
+
+			var out models.ErrorResponse
+			if err := json.Unmarshal([]byte(body), &out); err != nil {
+				t.Fatalf("unmarshal error: %v", err)
+			}
+			if out.Error.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", out.Error.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHandleStreaming_ErrorAfterContent(t *testing.T) {
+	tests := []struct {
+		name       string
+		reason     string
+		wantFinish string
+	}{
+		{name: "disabled", reason: "", wantFinish: ""},
+		{name: "stop", reason: "stop", wantFinish: "stop"},
+		{name: "error", reason: "error", wantFinish: "error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_STREAM_ERROR_FINISH_REASON", tt.reason)
+
+			exec := &fakeExecutor{
+				stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+					chunks, errChan := streamOf([]string{deltaLine("partial")}, errors.New("cli crashed"))
+					return chunks, errChan, nil
+				},
+			}
+
+			_, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+
+			var finish string
+			for _, chunk := range sseChunks(t, body) {
+				if len(chunk.Choices) > 0 && chunk.Choices[0].FinishReason != "" {
+					finish = chunk.Choices[0].FinishReason
+				}
+			}
+			if finish != tt.wantFinish {
+				t.Errorf("got finish_reason %q, want %q", finish, tt.wantFinish)
+			}
+
+			errAt := strings.Index(body, "cli crashed")
+			if errAt < 0 {
+				t.Fatalf("got body %s, want error event", body)
+			}
+			if tt.wantFinish != "" && strings.Index(body, `"finish_reason":"`+tt.wantFinish+`"`) > errAt {
+				t.Errorf("final chunk sent after error event")
+			}
+			if got := strings.Count(body, "[DONE]"); got != 1 {
+				t.Errorf("got %d [DONE] markers, want 1", got)
+			}
+		})
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestTimeout string
+		execTimeout    string
+		want           time.Duration
+	}{
+		{name: "defaults to request timeout", requestTimeout: "120", want: 120 * time.Second},
+		{name: "exec timeout inside request timeout", requestTimeout: "600", execTimeout: "540", want: 540 * time.Second},
+		{name: "clamped to request timeout", requestTimeout: "60", execTimeout: "300", want: 60 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("REQUEST_TIMEOUT", tt.requestTimeout)
+			t.Setenv("CLAUDE_EXEC_TIMEOUT", tt.execTimeout)
+
+			var deadline time.Time
+			var hasDeadline bool
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					deadline, hasDeadline = ctx.Deadline()
+					return resultJSON("ok"), nil
+				},
+			}
+
+			start := time.Now()
+			resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`)
+			if resp.StatusCode != 200 {
+				t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+			}
+
+			if !hasDeadline {
+				t.Fatal("executor context has no deadline")
+			}
+			if got := deadline.Sub(start); got > tt.want+time.Second || got < tt.want-time.Second {
+				t.Errorf("got executor deadline %v after start, want about %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleStreaming_AlwaysUsage(t *testing.T) {
+	tests := []struct {
+		name      string
+		flag      string
+		wantUsage *models.Usage
+	}{
+		{name: "off by default", flag: "", wantUsage: nil},
+		{name: "enabled", flag: "true", wantUsage: &models.Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_STREAM_ALWAYS_USAGE", tt.flag)
+
+			exec := &fakeExecutor{
+				stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+					chunks, errChan := streamOf([]string{
+						deltaLine("hello"),
+						`{"type":"result","result":"hello","usage":{"input_tokens":12,"output_tokens":3}}`,
+					}, nil)
+					return chunks, errChan, nil
+				},
+			}
+
+			_, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+
+			chunks := sseChunks(t, body)
+			if len(chunks) == 0 {
+				t.Fatalf("got no chunks (body=%s)", body)
+			}
+			for _, chunk := range chunks[:len(chunks)-1] {
+				if chunk.Usage != nil {
+					t.Errorf("got usage on non-final chunk %+v", chunk)
+				}
+			}
+
+			final := chunks[len(chunks)-1]
+			if final.Choices[0].FinishReason != "stop" {
+				t.Fatalf("got last chunk %+v, want final chunk", final)
+			}
+			switch {
+			case tt.wantUsage == nil && final.Usage != nil:
+				t.Errorf("got usage %+v, want none", final.Usage)
+			case tt.wantUsage != nil && (final.Usage == nil || *final.Usage != *tt.wantUsage):
+				t.Errorf("got usage %+v, want %+v", final.Usage, tt.wantUsage)
+			}
+		})
+	}
+}
+
+func TestHandleStreaming_TextSource(t *testing.T) {
+	// The CLI sends the text as partial events, then as a complete message
+	// that also holds text the partial events missed
+	lines := []string{
+		deltaLine("Hello "),
+		deltaLine("world"),
+		`{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Hello world, again"}]}}`,
+		`{"type":"result","result":"Hello world, again"}`,
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{name: "partial by default", source: "", want: "Hello world"},
+		{name: "merged", source: StreamTextMerged, want: "Hello world, again"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_STREAM_TEXT_SOURCE", tt.source)
+
+			exec := &fakeExecutor{
+				stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+					chunks, errChan := streamOf(lines, nil)
+					return chunks, errChan, nil
+				},
+			}
+
+			_, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+
+			var content string
+			for _, chunk := range sseChunks(t, body) {
+				content += chunk.Choices[0].Delta.Content
+			}
+			if content != tt.want {
+				t.Errorf("got content %q, want %q", content, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleStreaming_SSEEventNames(t *testing.T) {
+	tests := []struct {
+		name string
+		flag string
+		fail bool
+		want []string
+	}{
+		{name: "bare data by default", want: []string{"data: {", "data: {", "data: {", "data: [DONE]"}},
+		{name: "named", flag: "true", want: []string{"event: message", "data: {", "event: message", "data: {", "event: message", "data: {", "event: done", "data: [DONE]"}},
+		{name: "named error", flag: "true", fail: true, want: []string{"event: message", "data: {", "event: message", "data: {", "event: error", "data: {", "event: done", "data: [DONE]"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_SSE_EVENT_NAMES", tt.flag)
+
+			exec := &fakeExecutor{
+				stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+					var err error
+					if tt.fail {
+						err = errors.New("cli crashed")
+					}
+					chunks, errChan := streamOf([]string{deltaLine("hello")}, err)
+					return chunks, errChan, nil
+				},
+			}
+
+			_, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+
+			var got []string
+			for _, line := range strings.Split(body, "\n") {
+				if line == "" {
+					continue
+				}
+				if strings.HasPrefix(line, "data: {") {
+					line = "data: {"
+				}
+				got = append(got, line)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got lines %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleStreaming_ToolCallDeltas(t *testing.T) {
+	t.Setenv("CLAUDEX_STREAM_TOOL_CALLS", "true")
+
+	exec := &fakeExecutor{
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			chunks, errChan := streamOf([]string{
+				deltaLine("Let me check. {\"tool_calls\": [{\"id\": \"call_1\", \"type\": \"function\", "),
+				deltaLine("\"function\": {\"name\": \"get_weather\", \"arguments\": \"{\\\"city\\\": "),
+				deltaLine("\\\"Paris\\\"}\"}}]}"),
+			}, nil)
+			return chunks, errChan, nil
+		},
+	}
+	body := `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"weather?"}],` +
+		`"tools":[{"type":"function","function":{"name":"get_weather","parameters":{"type":"object"}}}]}`
+	_, respBody := postChat(t, newTestApp(exec), body)
+
+	var content, name, args string
+	var argDeltas int
+	chunks := sseChunks(t, respBody)
+	for _, chunk := range chunks {
+		delta := chunk.Choices[0].Delta
+		content += delta.Content
+		for _, tc := range delta.ToolCalls {
+			if tc.Function == nil {
+				continue
+			}
+			name += tc.Function.Name
+			if tc.Function.Arguments != "" {
+				args += tc.Function.Arguments
+				argDeltas++
+			}
+		}
+	}
+
+	if content != "Let me check. " {
+		t.Errorf("got content %q, want the text before the block", content)
+	}
+	if name != "get_weather" || args != `{"city": "Paris"}` {
+		t.Errorf("got tool call %s(%s), want get_weather({\"city\": \"Paris\"})", name, args)
+	}
+	if argDeltas < 2 {
+		t.Errorf("got %d argument deltas, want arguments streamed in pieces", argDeltas)
+	}
+	if reason := chunks[len(chunks)-1].Choices[0].FinishReason; reason != "tool_calls" {
+		t.Errorf("got finish_reason %q, want tool_calls", reason)
+	}
+}
+
+func TestHandleStreaming_MCPContinuation(t *testing.T) {
+	t.Setenv("CLAUDEX_STREAM_TOOL_CALLS", "true")
+
+	var gotArgs string
+	manager := mcp.NewManager()
+	err := manager.RegisterLocalTool("lookup", nil, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		gotArgs = string(arguments)
+		return &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: "42"}}}, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	var requests []*models.ChatCompletionRequest
+	exec := &fakeExecutor{
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			requests = append(requests, req)
+			lines := []string{deltaLine("It is "), deltaLine("42.")}
+			if len(requests) == 1 {
+				lines = []string{
+					deltaLine("Checking. {\"tool_calls\": [{\"id\": \"call_1\", \"type\": \"function\", "),
+					deltaLine("\"function\": {\"name\": \"lookup\", \"arguments\": \"{\\\"q\\\": \\\"answer\\\"}\"}}]}"),
+				}
+			}
+			chunks, errChan := streamOf(lines, nil)
+			return chunks, errChan, nil
+		},
+	}
+	h := newTestHandler(exec)
+	h.mcpManager = manager
+
+	_, body := postChat(t, appFor(h), `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"what is the answer?"}]}`)
+
+	if len(requests) != 2 {
+		t.Fatalf("got %d executor streams, want 2 (body=%s)", len(requests), body)
+	}
+	if gotArgs != `{"q": "answer"}` {
+		t.Errorf("got tool arguments %q, want %q", gotArgs, `{"q": "answer"}`)
+	}
+	last := requests[1].Messages[len(requests[1].Messages)-1]
+	if last.Role != "tool" || last.ToolCallID != "call_1" || last.GetTextContent() != "42" {
+		t.Errorf("got continuation last message %+v, want the tool result", last)
+	}
+
+	var deltas []string
+	chunks := sseChunks(t, body)
+	for _, chunk := range chunks {
+		delta := chunk.Choices[0].Delta
+		if len(delta.ToolCalls) > 0 {
+			t.Errorf("got tool call delta %+v, want MCP calls executed, not sent", delta.ToolCalls)
+		}
+		if delta.Content != "" {
+			deltas = append(deltas, delta.Content)
+		}
+	}
+	want := []string{"Checking. ", "\n\nIt is ", "42."}
+	if strings.Join(deltas, "|") != strings.Join(want, "|") {
+		t.Errorf("got content deltas %q, want %q", deltas, want)
+	}
+	if reason := chunks[len(chunks)-1].Choices[0].FinishReason; reason != "stop" {
+		t.Errorf("got finish_reason %q, want stop", reason)
+	}
+}
+
+func TestHandleStreaming_ToolKeepAlive(t *testing.T) {
+	t.Setenv("CLAUDEX_STREAM_TOOL_CALLS", "true")
+
+	manager := mcp.NewManager()
+	err := manager.RegisterLocalTool("lookup", nil, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		time.Sleep(200 * time.Millisecond)
+		return &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: "42"}}}, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	streams := 0
+	exec := &fakeExecutor{
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			streams++
+			lines := []string{deltaLine("It is 42.")}
+			if streams == 1 {
+				lines = []string{deltaLine(`{"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "lookup", "arguments": "{}"}}]}`)}
+			}
+			chunks, errChan := streamOf(lines, nil)
+			return chunks, errChan, nil
+		},
+	}
+	h := newTestHandler(exec)
+	h.mcpManager = manager
+	app := appFor(h)
+	body := `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"what is the answer?"}]}`
+
+	tests := []struct {
+		name     string
+		interval string
+		want     bool
+	}{
+		{name: "comments during a slow tool", interval: "40", want: true},
+		{name: "disabled", interval: "0", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_TOOL_KEEPALIVE_MS", tt.interval)
+			streams = 0
+
+			_, respBody := postChat(t, app, body)
+			comments := strings.Count(respBody, ": keep-alive\n\n")
+			if !tt.want {
+				if comments != 0 {
+					t.Errorf("got %d keep-alive comments, want none", comments)
+				}
+				return
+			}
+			if comments < 2 {
+				t.Errorf("got %d keep-alive comments, want at least 2 during a 200ms tool call (body=%s)", comments, respBody)
+			}
+			if last, content := strings.LastIndex(respBody, ": keep-alive"), strings.Index(respBody, "It is 42."); last > content {
+				t.Errorf("got a keep-alive comment after the continuation, want them only while the tool runs")
+			}
+			if chunks := sseChunks(t, respBody); chunks[len(chunks)-1].Choices[0].FinishReason != "stop" {
+				t.Errorf("got final chunk %+v, want finish_reason stop", chunks[len(chunks)-1])
+			}
+		})
+	}
+}
+
+func TestHandleStreaming_ToolResultEvents(t *testing.T) {
+	t.Setenv("CLAUDEX_STREAM_TOOL_CALLS", "true")
+	t.Setenv("CLAUDEX_STREAM_TOOL_RESULTS", "true")
+
+	manager := mcp.NewManager()
+	err := manager.RegisterLocalTool("lookup", nil, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		return &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: "42"}}}, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	streams := 0
+	exec := &fakeExecutor{
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			streams++
+			lines := []string{deltaLine("It is 42.")}
+			if streams == 1 {
+				lines = []string{deltaLine(`{"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "lookup", "arguments": "{}"}}]}`)}
+			}
+			chunks, errChan := streamOf(lines, nil)
+			return chunks, errChan, nil
+		},
+	}
+	h := newTestHandler(exec)
+	h.mcpManager = manager
+
+	_, body := postChat(t, appFor(h), `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"what is the answer?"}]}`)
+
+	var results []models.StreamToolResult
+	var order []string
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		if i > 0 && lines[i-1] == "event: tool_result" {
+			var result models.StreamToolResult
+			if err := json.Unmarshal([]byte(data), &result); err != nil {
+				t.Fatalf("decode tool result %q: %v", data, err)
+			}
+			results = append(results, result)
+			order = append(order, "tool_result")
+			continue
+		}
+		var chunk models.ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("decode chunk %q: %v", data, err)
+		}
+		if content := chunk.Choices[0].Delta.Content; content != "" {
+			order = append(order, content)
+		}
+	}
+
+	want := models.StreamToolResult{Object: "claudex.tool_result", ToolCallID: "call_1", Name: "lookup", Content: "42"}
+	if len(results) != 1 {
+		t.Fatalf("got %d tool result events, want 1 (body=%s)", len(results), body)
+	}
+	results[0].ID = ""
+	if results[0] != want {
+		t.Errorf("got tool result %+v, want %+v", results[0], want)
+	}
+	if strings.Join(order, "|") != "tool_result|It is 42." {
+		t.Errorf("got events %q, want the tool result before the continuation", order)
+	}
+}
+
+func TestHandleNonStreaming_ToolChoiceRequired(t *testing.T) {
+	toolCall := `{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{}"}}]}`
+
+	tests := []struct {
+		name       string
+		mode       string
+		outputs    []string
+		wantStatus int
+		wantCalls  int
+		wantFinish string
+	}{
+		{name: "passthrough by default", mode: "", outputs: []string{"It is sunny."}, wantStatus: 200, wantCalls: 1, wantFinish: "stop"},
+		{name: "retry succeeds", mode: "retry", outputs: []string{"It is sunny.", toolCall}, wantStatus: 200, wantCalls: 2, wantFinish: "tool_calls"},
+		{name: "retry still text", mode: "retry", outputs: []string{"It is sunny."}, wantStatus: 502, wantCalls: 2},
+		{name: "error", mode: "error", outputs: []string{"It is sunny."}, wantStatus: 502, wantCalls: 1},
+		{name: "passthrough", mode: "passthrough", outputs: []string{"It is sunny."}, wantStatus: 200, wantCalls: 1, wantFinish: "stop"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_TOOL_CHOICE_REQUIRED_MODE", tt.mode)
+
+			var requests []*models.ChatCompletionRequest
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					out := tt.outputs[min(len(requests), len(tt.outputs)-1)]
+					requests = append(requests, req)
+					return resultJSON(out), nil
+				},
+			}
+
+			resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","tool_choice":"required","tools":[{"type":"function","function":{"name":"get_weather"}}],"messages":[{"role":"user","content":"weather?"}]}`)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if len(requests) != tt.wantCalls {
+				t.Fatalf("got %d executor calls, want %d", len(requests), tt.wantCalls)
+			}
+			if tt.wantCalls == 2 {
+				retry := requests[1].Messages
+				if last := retry[len(retry)-1]; last.Role != "system" || last.GetTextContent() != toolCallRequiredInstruction {
+					t.Errorf("got retry last message %+v, want tool call instruction", last)
+				}
+			}
+			if tt.wantStatus != 200 {
+				if !strings.Contains(body, "tool_choice_violation") {
+					t.Errorf("got body %s, want tool_choice_violation", body)
+				}
+				return
+			}
+
+			var out models.ChatCompletionResponse
+			if err := json.Unmarshal([]byte(body), &out); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			if got := out.Choices[0].FinishReason; got != tt.wantFinish {
+				t.Errorf("got finish_reason %q, want %q", got, tt.wantFinish)
+			}
+		})
+	}
+}
+
+func TestHandle_DefaultToolChoice(t *testing.T) {
+	tools := `"tools":[{"type":"function","function":{"name":"get_weather"}}],`
+
+	tests := []struct {
+		name   string
+		def    string
+		fields string
+		want   any
+	}{
+		{name: "unset", fields: tools, want: nil},
+		{name: "applied when absent", def: "required", fields: tools, want: "required"},
+		{name: "request overrides", def: "required", fields: tools + `"tool_choice":"none",`, want: "none"},
+		{name: "no tools", def: "required", want: nil},
+		{name: "invalid default", def: "always", fields: tools, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_DEFAULT_TOOL_CHOICE", tt.def)
+
+			var got any
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					got = req.ToolChoice
+					return resultJSON(`{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{}"}}]}`), nil
+				},
+			}
+
+			resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet",`+tt.fields+`"messages":[{"role":"user","content":"weather?"}]}`)
+			if resp.StatusCode != 200 {
+				t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+			}
+			if got != tt.want {
+				t.Errorf("got tool_choice %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleNonStreaming_MaxToolCalls(t *testing.T) {
+	toolCalls := `{"tool_calls":[` +
+		`{"id":"call_1","type":"function","function":{"name":"lookup","arguments":"{}"}},` +
+		`{"id":"call_2","type":"function","function":{"name":"lookup","arguments":"{}"}},` +
+		`{"id":"call_3","type":"function","function":{"name":"lookup","arguments":"{}"}}]}`
+
+	tests := []struct {
+		name       string
+		limit      string
+		mode       string
+		wantStatus int
+		wantRuns   int
+	}{
+		{name: "unlimited by default", wantStatus: 200, wantRuns: 3},
+		{name: "truncate", limit: "2", wantStatus: 200, wantRuns: 2},
+		{name: "reject", limit: "2", mode: "reject", wantStatus: 502, wantRuns: 0},
+		{name: "under the limit", limit: "3", mode: "reject", wantStatus: 200, wantRuns: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_MAX_TOOL_CALLS", tt.limit)
+			t.Setenv("CLAUDEX_TOOL_CALL_LIMIT_MODE", tt.mode)
+
+			runs := 0
+			manager := mcp.NewManager()
+			err := manager.RegisterLocalTool("lookup", nil, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+				runs++
+				return &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: "found"}}}, nil
+			})
+			if err != nil {
+				t.Fatalf("RegisterLocalTool: %v", err)
+			}
+
+			calls := 0
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					calls++
+					if calls == 1 {
+						return resultJSON(toolCalls), nil
+					}
+					return resultJSON("done"), nil
+				},
+			}
+			h := newTestHandler(exec)
+			h.mcpManager = manager
+
+			resp, body := postChat(t, appFor(h), `{"model":"claude-sonnet","messages":[{"role":"user","content":"look it up"}]}`)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantStatus != 200 && !strings.Contains(body, "too_many_tool_calls") {
+				t.Errorf("got body %s, want too_many_tool_calls", body)
+			}
+			if runs != tt.wantRuns {
+				t.Errorf("got %d tool executions, want %d", runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestHandleNonStreaming_InvalidToolArguments(t *testing.T) {
+	toolCalls := `{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"lookup","arguments":[1,2]}}]}`
+
+	tests := []struct {
+		name       string
+		mode       string
+		wantStatus int
+	}{
+		{name: "passthrough", mode: converter.ArgumentCoercionPassthrough, wantStatus: 200},
+		{name: "reject", mode: converter.ArgumentCoercionReject, wantStatus: 502},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					return resultJSON(toolCalls), nil
+				},
+			}
+			h := newTestHandler(exec)
+			if err := h.converter.SetArgumentCoercion(tt.mode); err != nil {
+				t.Fatalf("SetArgumentCoercion: %v", err)
+			}
+
+			resp, body := postChat(t, appFor(h), `{"model":"claude-sonnet","messages":[{"role":"user","content":"look it up"}],"tools":[{"type":"function","function":{"name":"lookup"}}]}`)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantStatus == 200 {
+				if !strings.Contains(body, `"arguments":"[1,2]"`) {
+					t.Errorf("got body %s, want the arguments passed through", body)
+				}
+				return
+			}
+			for _, want := range []string{"invalid_tool_arguments", "tool lookup", "must be a JSON object"} {
+				if !strings.Contains(body, want) {
+					t.Errorf("got body %s, want %q", body, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteMCPToolCalls_ErrorModes(t *testing.T) {
+	manager := mcp.NewManager()
+	err := manager.RegisterLocalTool("broken", nil, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		return nil, errors.New("backend unavailable")
+	})
+	if err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		mode       string
+		wantStatus int
+		wantCalls  int
+	}{
+		{name: "feedback by default", mode: "", wantStatus: 200, wantCalls: 2},
+		{name: "fail", mode: "fail", wantStatus: 502, wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_MCP_TOOL_ERROR_MODE", tt.mode)
+
+			var requests []*models.ChatCompletionRequest
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					requests = append(requests, req)
+					if len(requests) == 1 {
+						return resultJSON(`{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"broken","arguments":"{}"}}]}`), nil
+					}
+					return resultJSON("The backend is down."), nil
+				},
+			}
+			h := newTestHandler(exec)
+			h.mcpManager = manager
+
+			resp, body := postChat(t, appFor(h), `{"model":"claude-sonnet","messages":[{"role":"user","content":"try it"}]}`)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if len(requests) != tt.wantCalls {
+				t.Fatalf("got %d executor calls, want %d", len(requests), tt.wantCalls)
+			}
+
+			if tt.wantStatus != 200 {
+				if !strings.Contains(body, "tool_error") || !strings.Contains(body, "backend unavailable") {
+					t.Errorf("got body %s, want tool_error with detail", body)
+				}
+				return
+			}
+			msgs := requests[1].Messages
+			if last := msgs[len(msgs)-1]; last.Role != "tool" || !strings.Contains(last.GetTextContent(), "backend unavailable") {
+				t.Errorf("got continuation message %+v, want tool error result", last)
+			}
+		})
+	}
+}
+
+func TestHandle_ToolPrecedence(t *testing.T) {
+	manager := mcp.NewManager()
+	err := manager.RegisterLocalTool("lookup", nil, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		return &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: "from mcp"}}}, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	body := `{"model":"claude-sonnet","messages":[{"role":"user","content":"look"}],"tools":[{"type":"function","function":{"name":"lookup","description":"client lookup"}}]}`
+
+	tests := []struct {
+		name          string
+		precedence    string
+		wantStatus    int
+		wantCalls     int
+		wantAdvertise string // description of the advertised lookup tool
+		wantFinish    string
+	}{
+		{name: "mcp wins by default", precedence: "", wantStatus: 200, wantCalls: 2, wantAdvertise: "", wantFinish: "stop"},
+		{name: "client wins", precedence: "client", wantStatus: 200, wantCalls: 1, wantAdvertise: "client lookup", wantFinish: "tool_calls"},
+		{name: "error", precedence: "error", wantStatus: 400, wantCalls: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_TOOL_PRECEDENCE", tt.precedence)
+
+			var requests []*models.ChatCompletionRequest
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					requests = append(requests, req)
+					if len(requests) == 1 {
+						return resultJSON(`{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"lookup","arguments":"{}"}}]}`), nil
+					}
+					return resultJSON("done"), nil
+				},
+			}
+			h := newTestHandler(exec)
+			h.mcpManager = manager
+
+			resp, respBody := postChat(t, appFor(h), body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, respBody)
+			}
+			if len(requests) != tt.wantCalls {
+				t.Fatalf("got %d executor calls, want %d", len(requests), tt.wantCalls)
+			}
+			if tt.wantStatus != 200 {
+				if !strings.Contains(respBody, "tool_name_conflict") {
+					t.Errorf("got body %s, want tool_name_conflict", respBody)
+				}
+				return
+			}
+
+			var advertised []string
+			for _, tool := range requests[0].Tools {
+				if tool.Function.Name == "lookup" {
+					advertised = append(advertised, tool.Function.Description)
+				}
+			}
+			if len(advertised) != 1 || advertised[0] != tt.wantAdvertise {
+				t.Errorf("got advertised lookup tools %q, want [%q]", advertised, tt.wantAdvertise)
+			}
+
+			var out models.ChatCompletionResponse
+			if err := json.Unmarshal([]byte(respBody), &out); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			if got := out.Choices[0].FinishReason; got != tt.wantFinish {
+				t.Errorf("got finish_reason %q, want %q", got, tt.wantFinish)
+			}
+		})
+	}
+}
+
+// failingWriter fails every write, like a connection the client closed.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestWriteStream_ClientDisconnect(t *testing.T) {
+	var waited atomic.Bool
+	var errChan chan error
+	exec := &fakeExecutor{
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			// Like the CLI's producer: more output than the buffer holds,
+			// then the process is waited on once stdout is done
+			chunks := make(chan string, 100)
+			errChan = make(chan error, 1)
+			go func() {
+				defer close(errChan)
+				for i := 0; i < 1000 && ctx.Err() == nil; i++ {
+					chunks <- deltaLine("tick")
+				}
+				close(chunks)
+				waited.Store(true)
+			}()
+			return chunks, errChan, nil
+		},
+	}
+
+	before := counterValue(t, testMetrics.ClientDisconnects)
+	req := &models.ChatCompletionRequest{Model: "claude-sonnet", Stream: true, Messages: []models.Message{{Role: "user", Content: "hi"}}}
+	summary := newTestHandler(exec).writeStream(context.Background(), bufio.NewWriter(failingWriter{}), req, "chatcmpl-test", time.Now())
+
+	if summary.status != "client_disconnect" {
+		t.Errorf("got status %q, want client_disconnect", summary.status)
+	}
+	if got := counterValue(t, testMetrics.ClientDisconnects) - before; got != 1 {
+		t.Errorf("got %v client disconnects recorded, want 1", got)
+	}
+	released := make(chan struct{})
+	go func() {
+		for range errChan {
+		}
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("executor stream was not released after the client went away")
+	}
+	if !waited.Load() {
+		t.Error("executor process was not waited on after the client went away")
+	}
+}
+
+func TestWriteStream_FailedSendDrainsExecutor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	released := make(chan struct{})
+	exec := &fakeExecutor{
+		stream: func(context.Context, *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			// Like the CLI's producer: more lines than the buffer holds,
+			// sent without watching ctx
+			chunks := make(chan string, 100)
+			errChan := make(chan error, 1)
+			go func() {
+				defer close(released)
+				defer close(chunks)
+				for i := 0; i < 250; i++ {
+					if i == 20 {
+						cancel()
+					}
+					chunks <- deltaLine("tick")
+				}
+			}()
+			return chunks, errChan, nil
+		},
+	}
+
+	req := &models.ChatCompletionRequest{Model: "claude-sonnet", Stream: true, Messages: []models.Message{{Role: "user", Content: "hi"}}}
+	newTestHandler(exec).writeStream(ctx, bufio.NewWriter(io.Discard), req, "chatcmpl-test", time.Now())
+
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Error("executor producer blocked after a failed send")
+	}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatalf("read counter: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// assemblingExecutor is a fakeExecutor that reports the prompts the real
+// executor would assemble.
+type assemblingExecutor struct {
+	*fakeExecutor
+}
+
+func (a assemblingExecutor) AssemblePrompt(req *models.ChatCompletionRequest) (string, string, error) {
+	return claude.NewExecutor().AssemblePrompt(req)
+}
+
+func TestHandleNonStreaming_DebugPrompt(t *testing.T) {
+	exec := assemblingExecutor{&fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			return resultJSON("ok"), nil
+		},
+	}}
+	app := appFor(newTestHandler(exec))
+	body := `{"model":"claude-sonnet","tools":[{"type":"function","function":{"name":"get_weather"}}],"messages":[` +
+		`{"role":"system","content":"Be brief."},{"role":"user","content":"weather?"}]}`
+
+	for _, header := range []string{"", "1"} {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if header != "" {
+			req.Header.Set(DebugPromptHeader, header)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("app.Test: %v", err)
+		}
+		var out models.ChatCompletionResponse
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+
+		if header == "" {
+			if out.Debug != nil {
+				t.Errorf("got debug %+v without the header, want none", out.Debug)
+			}
+			continue
+		}
+		if out.Debug == nil {
+			t.Fatal("got no debug field, want the assembled prompt")
+		}
+		if !strings.HasPrefix(out.Debug.SystemPrompt, "Be brief.") || !strings.Contains(out.Debug.SystemPrompt, "#### get_weather") {
+			t.Errorf("got system prompt %q, want client system prompt and tools block", out.Debug.SystemPrompt)
+		}
+		if !strings.Contains(out.Debug.Prompt, `"content":"weather?"`) {
+			t.Errorf("got prompt %q, want the user message as stream-json input", out.Debug.Prompt)
+		}
+	}
+}
+
+func TestHandle_DisableMCPHeader(t *testing.T) {
+	manager := mcp.NewManager()
+	err := manager.RegisterLocalTool("lookup", nil, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		return &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: "from mcp"}}}, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	body := `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}],"tools":[{"type":"function","function":{"name":"get_weather"}}]}`
+
+	tests := []struct {
+		name   string
+		header string
+		want   []string
+	}{
+		{name: "mcp tools advertised", want: []string{"get_weather", "lookup"}},
+		{name: "disabled", header: "1", want: []string{"get_weather"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					for _, tool := range req.Tools {
+						got = append(got, tool.Function.Name)
+					}
+					return resultJSON("ok"), nil
+				},
+			}
+			h := newTestHandler(exec)
+			h.mcpManager = manager
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				req.Header.Set(DisableMCPHeader, tt.header)
+			}
+			resp, err := appFor(h).Test(req, -1)
+			if err != nil {
+				t.Fatalf("app.Test: %v", err)
+			}
+			if resp.StatusCode != 200 {
+				t.Fatalf("got status %d, want 200", resp.StatusCode)
+			}
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got tools %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleNonStreaming_DebugTools(t *testing.T) {
+	manager := mcp.NewManager()
+	err := manager.RegisterLocalTool("lookup", nil, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		return &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: "from mcp"}}}, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			return resultJSON("ok"), nil
+		},
+	}
+	h := newTestHandler(exec)
+	h.mcpManager = manager
+
+	body := `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}],"tools":[{"type":"function","function":{"name":"get_weather"}}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DebugPromptHeader, "1")
+	resp, err := appFor(h).Test(req, -1)
+	if err != nil {
+		t.Fatalf("app.Test: %v", err)
+	}
+	var out models.ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if out.Debug == nil {
+		t.Fatal("got no debug field, want the advertised tools")
+	}
+
+	var got []string
+	for _, tool := range out.Debug.Tools {
+		got = append(got, tool.Function.Name)
+	}
+	if want := []string{"get_weather", "lookup"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got tools %v, want %v", got, want)
+	}
+}
+
+func TestHandleNonStreaming_MalformedToolCalls(t *testing.T) {
+	output := `Let me look. {"tool_calls": [{"id": "call_1", "function": {"name": "lookup", "arguments": {q: 1}}}]}`
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{mode: "", want: output},
+		{mode: "log", want: output},
+		{mode: "strip", want: "Let me look."},
+	}
+
+	for _, tt := range tests {
+		t.Run("mode "+tt.mode, func(t *testing.T) {
+			t.Setenv("CLAUDEX_MALFORMED_TOOL_CALLS_MODE", tt.mode)
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					return resultJSON(output), nil
+				},
+			}
+
+			resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","messages":[{"role":"user","content":"look it up"}]}`)
+			if resp.StatusCode != 200 {
+				t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+			}
+			var out models.ChatCompletionResponse
+			if err := json.Unmarshal([]byte(body), &out); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if got := out.Choices[0].Message.Content; got != tt.want {
+				t.Errorf("got content %q, want %q", got, tt.want)
+			}
+			if len(out.Choices[0].Message.ToolCalls) != 0 {
+				t.Errorf("got tool calls %+v, want none", out.Choices[0].Message.ToolCalls)
+			}
+		})
+	}
+}
+
+// nonStreamingExecutor is a fakeExecutor that cannot stream.
+type nonStreamingExecutor struct {
+	*fakeExecutor
+}
+
+func (nonStreamingExecutor) SupportsStreaming(model string) bool {
+	return false
+}
+
+func TestHandle_StreamingNotSupported(t *testing.T) {
+	exec := nonStreamingExecutor{&fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			return resultJSON("ok"), nil
+		},
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			t.Error("streaming request reached a non-streaming executor")
+			return nil, nil, errors.New("not supported")
+		},
+	}}
+	app := appFor(newTestHandler(exec))
+
+	resp, body := postChat(t, app, `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("got status %d, want 400", resp.StatusCode)
+	}
+	var errResp models.ErrorResponse
+	if err := json.Unmarshal([]byte(body), &errResp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if errResp.Error.Type != "invalid_request_error" || errResp.Error.Code != "streaming_not_supported" {
+		t.Errorf("got error %+v, want invalid_request_error/streaming_not_supported", errResp.Error)
+	}
+
+	resp, _ = postChat(t, app, `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Errorf("non-streaming request: got status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestWriteStream_RecordsTTFTOnce(t *testing.T) {
+	exec := &fakeExecutor{
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			chunks, errChan := streamOf([]string{deltaLine("Hello"), deltaLine(", "), deltaLine("world")}, nil)
+			return chunks, errChan, nil
+		},
+	}
+	ttftSamples := func() uint64 {
+		var m dto.Metric
+		if err := testMetrics.TTFT.Write(&m); err != nil {
+			t.Fatalf("read histogram: %v", err)
+		}
+		return m.GetHistogram().GetSampleCount()
+	}
+
+	before := ttftSamples()
+	req := &models.ChatCompletionRequest{Model: "claude-sonnet", Stream: true, N: 2, Messages: []models.Message{{Role: "user", Content: "hi"}}}
+	var out strings.Builder
+	if summary := newTestHandler(exec).writeStream(context.Background(), bufio.NewWriter(&out), req, "chatcmpl-test", time.Now()); summary.status != "success" {
+		t.Fatalf("got status %q, want success", summary.status)
+	}
+
+	if got := ttftSamples() - before; got != 1 {
+		t.Errorf("got %d TTFT observations, want 1", got)
+	}
+}
+
+func TestHandleStreaming_BufferedToolCallsMatchNonStreaming(t *testing.T) {
+	t.Setenv("CLAUDEX_STREAM_TOOL_CALLS", "buffered")
+
+	output := "I'll look that up.\n```json\n" +
+		`{"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": {"city": "Paris"}}},` +
+		` {"id": "call_2", "type": "function", "function": {"name": "get_time", "arguments": "{\"tz\": \"CET\"}"}}]}` + "\n```"
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			return resultJSON(output), nil
+		},
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			// Split mid-block so no single delta holds a parseable object
+			chunks, errChan := streamOf([]string{deltaLine(output[:40]), deltaLine(output[40:90]), deltaLine(output[90:])}, nil)
+			return chunks, errChan, nil
+		},
+	}
+	app := newTestApp(exec)
+	request := `{"model":"claude-sonnet","stream":%v,"messages":[{"role":"user","content":"weather and time?"}],` +
+		`"tools":[{"type":"function","function":{"name":"get_weather"}},{"type":"function","function":{"name":"get_time"}}]}`
+
+	_, body := postChat(t, app, fmt.Sprintf(request, false))
+	var resp models.ChatCompletionResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("unmarshal %s: %v", body, err)
+	}
+	want := resp.Choices[0].Message
+	if len(want.ToolCalls) != 2 {
+		t.Fatalf("non-streaming: got tool calls %+v, want 2", want.ToolCalls)
+	}
+
+	_, body = postChat(t, app, fmt.Sprintf(request, true))
+	var content string
+	var calls []models.ToolCall
+	chunks := sseChunks(t, body)
+	for _, chunk := range chunks {
+		delta := chunk.Choices[0].Delta
+		content += delta.Content
+		for _, tc := range delta.ToolCalls {
+			if tc.ID != "" {
+				calls = append(calls, models.ToolCall{ID: tc.ID, Type: tc.Type})
+			}
+			if tc.Function != nil {
+				calls[tc.Index].Function.Name += tc.Function.Name
+				calls[tc.Index].Function.Arguments += tc.Function.Arguments
+			}
+		}
+	}
+
+	if content != want.GetTextContent() {
+		t.Errorf("got streamed content %q, want %q", content, want.GetTextContent())
+	}
+	if !reflect.DeepEqual(calls, want.ToolCalls) {
+		t.Errorf("got streamed tool calls %+v, want %+v", calls, want.ToolCalls)
+	}
+	if reason := chunks[len(chunks)-1].Choices[0].FinishReason; reason != "tool_calls" {
+		t.Errorf("got finish_reason %q, want tool_calls", reason)
+	}
+}
+
+func TestHandle_RequestTimeoutHeader(t *testing.T) {
+	t.Setenv("REQUEST_TIMEOUT", "30")
+	t.Setenv("CLAUDEX_MAX_REQUEST_TIMEOUT", "120")
+
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{name: "unset", header: "", want: 30 * time.Second},
+		{name: "invalid", header: "soon", want: 30 * time.Second},
+		{name: "within ceiling", header: "90", want: 90 * time.Second},
+		{name: "clamped", header: "3600", want: 120 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got time.Duration
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					if deadline, ok := ctx.Deadline(); ok {
+						got = time.Until(deadline)
+					}
+					return resultJSON("hi"), nil
+				},
+			}
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				req.Header.Set(RequestTimeoutHeader, tt.header)
+			}
+			resp, err := newTestApp(exec).Test(req, -1)
+			if err != nil {
+				t.Fatalf("app.Test: %v", err)
+			}
+			if resp.StatusCode != 200 {
+				t.Fatalf("got status %d, want 200", resp.StatusCode)
+			}
+			if got > tt.want || got < tt.want-5*time.Second {
+				t.Errorf("got deadline in %v, want about %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleStreaming_RequestTimeoutHeader(t *testing.T) {
+	t.Setenv("REQUEST_TIMEOUT", "30")
+	t.Setenv("CLAUDEX_MAX_REQUEST_TIMEOUT", "120")
+
+	tests := []struct {
+		name      string
+		header    string
+		want      time.Duration
+		wantError bool
+	}{
+		{name: "unset limits each CLI run", header: "", want: 30 * time.Second},
+		{name: "within ceiling", header: "90", want: 90 * time.Second},
+		{name: "clamped", header: "3600", want: 120 * time.Second},
+		{name: "expires mid-stream", header: "1", want: time.Second, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got time.Duration
+			exec := &fakeExecutor{
+				stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+					if deadline, ok := ctx.Deadline(); ok {
+						got = time.Until(deadline)
+					}
+					if !tt.wantError {
+						chunks, errChan := streamOf([]string{deltaLine("hi")}, nil)
+						return chunks, errChan, nil
+					}
+					// Like a CLI still thinking when the deadline hits. It
+					// reports no error, so the stream must report the timeout.
+					chunks := make(chan string, 1)
+					errChan := make(chan error, 1)
+					chunks <- deltaLine("partial")
+					go func() {
+						<-ctx.Done()
+						close(chunks)
+					}()
+					return chunks, errChan, nil
+				},
+			}
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				req.Header.Set(RequestTimeoutHeader, tt.header)
+			}
+			resp, err := newTestApp(exec).Test(req, -1)
+			if err != nil {
+				t.Fatalf("app.Test: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+
+			if got > tt.want || got < tt.want-5*time.Second {
+				t.Errorf("got deadline in %v, want about %v", got, tt.want)
+			}
+			if timedOut := strings.Contains(string(body), "request timed out"); timedOut != tt.wantError {
+				t.Errorf("got timed out %v, want %v (body=%s)", timedOut, tt.wantError, body)
+			}
+		})
+	}
+}
+
+func TestHandle_ModelHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		header string
+		want   string
+	}{
+		{name: "header overrides body", body: `"model":"claude-sonnet",`, header: "claude-opus", want: "claude-opus"},
+		{name: "no header", body: `"model":"claude-sonnet",`, want: "claude-sonnet"},
+		{name: "header without body model", header: "claude-haiku", want: "claude-haiku"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var executed string
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					executed = req.Model
+					return resultJSON("hi"), nil
+				},
+			}
+
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{`+tt.body+`"messages":[{"role":"user","content":"hi"}]}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				req.Header.Set(ModelHeader, tt.header)
+			}
+			resp, err := newTestApp(exec).Test(req, -1)
+			if err != nil {
+				t.Fatalf("app.Test: %v", err)
+			}
+			if resp.StatusCode != 200 {
+				t.Fatalf("got status %d, want 200", resp.StatusCode)
+			}
+
+			var out models.ChatCompletionResponse
+			if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if executed != tt.want {
+				t.Errorf("got executed model %q, want %q", executed, tt.want)
+			}
+			if out.Model != tt.want {
+				t.Errorf("got response model %q, want %q", out.Model, tt.want)
+			}
+		})
+	}
+}
+
+// textOutputExecutor is a fakeExecutor that asks the CLI for text output.
+type textOutputExecutor struct {
+	*fakeExecutor
+}
+
+func (textOutputExecutor) OutputFormat(req *models.ChatCompletionRequest) string {
+	return claude.OutputFormatText
+}
+
+func TestHandleNonStreaming_TextOutput(t *testing.T) {
+	exec := textOutputExecutor{&fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			return "plain answer\n", nil
+		},
+	}}
+
+	resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+	}
+	var out models.ChatCompletionResponse
+	if err := json.Unmarshal([]byte(body), &out); err != nil {
+		t.Fatalf("unmarshal response %s: %v", body, err)
+	}
+	if got := out.Choices[0].Message.Content; got != "plain answer" {
+		t.Errorf("got content %q, want the CLI's text output", got)
+	}
+}
@@ -0,0 +1,681 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/observability"
+)
+
+// DefaultMaxToolResultBytes is the default cap on MCP tool result text forwarded to Claude.
+const DefaultMaxToolResultBytes = 256 * 1024
+
+// Default limits on MCP tool calls run at once.
+const (
+	DefaultMaxConcurrentToolCalls          = 4
+	DefaultMaxConcurrentToolCallsPerServer = 2
+)
+
+// Default batch limits for /v1/batch/completions.
+const (
+	DefaultBatchConcurrency = 4
+	DefaultBatchMaxItems    = 32
+)
+
+// Empty response modes for CLAUDEX_EMPTY_RESPONSE_MODE.
+const (
+	// EmptyResponsePassthrough returns the empty assistant message as-is.
+	EmptyResponsePassthrough = "passthrough"
+	// EmptyResponseError returns a 502 error.
+	EmptyResponseError = "error"
+	// EmptyResponseRetry re-executes the request once.
+	EmptyResponseRetry = "retry"
+	// EmptyResponseSentinel replaces the empty content with a sentinel string.
+	EmptyResponseSentinel = "sentinel"
+)
+
+// tool_choice "required" modes for CLAUDEX_TOOL_CHOICE_REQUIRED_MODE.
+const (
+	// ToolChoiceRequiredRetry re-executes once with a stronger instruction,
+	// then returns an error if there is still no tool call.
+	ToolChoiceRequiredRetry = "retry"
+	// ToolChoiceRequiredError returns a 502 error.
+	ToolChoiceRequiredError = "error"
+	// ToolChoiceRequiredPassthrough returns the text response as-is (the
+	// default).
+	ToolChoiceRequiredPassthrough = "passthrough"
+)
+
+// MCP tool error modes for CLAUDEX_MCP_TOOL_ERROR_MODE.
+const (
+	// MCPToolErrorFeedback sends the error to Claude as the tool result.
+	MCPToolErrorFeedback = "feedback"
+	// MCPToolErrorFail fails the request with a 502.
+	MCPToolErrorFail = "fail"
+)
+
+// DefaultCharsPerToken is the characters per token used to estimate usage
+// when CLAUDEX_CHARS_PER_TOKEN is unset.
+const DefaultCharsPerToken = 4.0
+
+// DefaultModel is the model given to requests without one when
+// CLAUDEX_DEFAULT_MODEL is unset.
+const DefaultModel = "claude-sonnet"
+
+// Modes for requests without a model, for CLAUDEX_MISSING_MODEL_MODE.
+const (
+	// MissingModelDefault gives the request the default model, which the
+	// response then reports.
+	MissingModelDefault = "default"
+	// MissingModelReject fails the request with a 400.
+	MissingModelReject = "reject"
+)
+
+// Modes for tool_calls blocks left in response text because they could not
+// be parsed, for CLAUDEX_MALFORMED_TOOL_CALLS_MODE.
+const (
+	// MalformedToolCallsKeep leaves the text untouched.
+	MalformedToolCallsKeep = "keep"
+	// MalformedToolCallsLog leaves the text untouched and logs the block.
+	MalformedToolCallsLog = "log"
+	// MalformedToolCallsStrip removes the block from the text and logs it.
+	MalformedToolCallsStrip = "strip"
+)
+
+// Out-of-range parameter modes for CLAUDEX_PARAM_RANGE_MODE.
+const (
+	// ParamRangeClamp clamps the value to the nearest bound.
+	ParamRangeClamp = "clamp"
+	// ParamRangeReject fails the request with a 400.
+	ParamRangeReject = "reject"
+)
+
+// Modes for streaming requests with a strict json_schema response_format,
+// for CLAUDEX_STREAMING_STRICT_SCHEMA_MODE. A streamed response reaches the
+// client before it is complete, so it cannot be validated against the schema.
+const (
+	// StreamingStrictSchemaReject fails the request with a 400, since the
+	// strict validation it asks for cannot be honored.
+	StreamingStrictSchemaReject = "reject"
+	// StreamingStrictSchemaUnvalidated streams the response without
+	// validating it.
+	StreamingStrictSchemaUnvalidated = "unvalidated"
+)
+
+// Streamed tool call modes for CLAUDEX_STREAM_TOOL_CALLS, which also
+// accepts boolean values: true selects StreamToolCallsIncremental.
+const (
+	// StreamToolCallsText forwards the tool_calls block as text.
+	StreamToolCallsText = "false"
+	// StreamToolCallsIncremental turns the block into tool call deltas as
+	// it is written.
+	StreamToolCallsIncremental = "true"
+	// StreamToolCallsBuffered holds back the response text and extracts tool
+	// calls from it at the end of the stream with the non-streaming logic,
+	// so both modes return the same tool calls for the same output.
+	StreamToolCallsBuffered = "buffered"
+)
+
+// Sources of streamed text for CLAUDEX_STREAM_TEXT_SOURCE.
+const (
+	// StreamTextPartial forwards the text of partial content_block_delta
+	// events only.
+	StreamTextPartial = "partial"
+	// StreamTextMerged also forwards text from complete assistant messages
+	// that partial events did not already send, each piece of text once.
+	StreamTextMerged = "merged"
+)
+
+// Tool call limit modes for CLAUDEX_TOOL_CALL_LIMIT_MODE.
+const (
+	// ToolCallLimitTruncate keeps the first CLAUDEX_MAX_TOOL_CALLS tool calls.
+	ToolCallLimitTruncate = "truncate"
+	// ToolCallLimitReject fails the request with a 502.
+	ToolCallLimitReject = "reject"
+)
+
+// Duplicate tool name modes for CLAUDEX_DUPLICATE_TOOLS_MODE.
+const (
+	// DuplicateToolsDedupe keeps the last tool with each name.
+	DuplicateToolsDedupe = "dedupe"
+	// DuplicateToolsReject returns a 400 duplicate_tool_name error.
+	DuplicateToolsReject = "reject"
+)
+
+// Client/MCP tool name collision modes for CLAUDEX_TOOL_PRECEDENCE.
+const (
+	// ToolPrecedenceMCP advertises and executes the MCP tool.
+	ToolPrecedenceMCP = "mcp"
+	// ToolPrecedenceClient keeps the client tool and returns its calls to the client.
+	ToolPrecedenceClient = "client"
+	// ToolPrecedenceError returns a 400 tool_name_conflict error.
+	ToolPrecedenceError = "error"
+)
+
+// Unknown request field modes for CLAUDEX_UNKNOWN_FIELDS_MODE.
+const (
+	// UnknownFieldsIgnore silently drops unknown fields.
+	UnknownFieldsIgnore = "ignore"
+	// UnknownFieldsReject returns a 400 unknown_field error listing them.
+	UnknownFieldsReject = "reject"
+)
+
+// Control character modes for CLAUDEX_CONTROL_CHARS_MODE.
+const (
+	// ControlCharsStrip removes control characters and ANSI escape sequences.
+	ControlCharsStrip = "strip"
+	// ControlCharsEscape replaces control characters with visible escapes.
+	ControlCharsEscape = "escape"
+	// ControlCharsPassthrough leaves assistant text unchanged.
+	ControlCharsPassthrough = "passthrough"
+)
+
+// DefaultEmptyResponseSentinel is the content used in sentinel mode when none is configured.
+const DefaultEmptyResponseSentinel = "[empty response]"
+
+// Unsupported parameter modes for CLAUDEX_LOGIT_BIAS_MODE.
+const (
+	// UnsupportedParamIgnore accepts the parameter and ignores it.
+	UnsupportedParamIgnore = "ignore"
+	// UnsupportedParamReject returns a 400 unsupported_parameter error.
+	UnsupportedParamReject = "reject"
+)
+
+// getEnvInt returns the integer value of an environment variable, or def if unset or invalid.
+func getEnvInt(key string, def int) int {
+	if val := os.Getenv(key); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// DefaultMaxRequestTimeout is the ceiling for timeouts requested with
+// RequestTimeoutHeader when CLAUDEX_MAX_REQUEST_TIMEOUT is unset.
+const DefaultMaxRequestTimeout = 30 * time.Minute
+
+// getMaxRequestTimeout returns the ceiling for timeouts requested with
+// RequestTimeoutHeader from CLAUDEX_MAX_REQUEST_TIMEOUT (seconds).
+func getMaxRequestTimeout() time.Duration {
+	seconds := getEnvInt("CLAUDEX_MAX_REQUEST_TIMEOUT", 0)
+	if seconds <= 0 {
+		return DefaultMaxRequestTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// getExecTimeout returns the budget for a single Claude CLI execution from
+// CLAUDE_EXEC_TIMEOUT (seconds). It defaults to, and never exceeds, the time
+// left before ctx's deadline, or the HTTP request timeout if ctx has none.
+func getExecTimeout(ctx context.Context) time.Duration {
+	requestTimeout := getRequestTimeout()
+	if deadline, ok := ctx.Deadline(); ok {
+		requestTimeout = time.Until(deadline)
+	}
+	seconds := getEnvInt("CLAUDE_EXEC_TIMEOUT", 0)
+	if seconds <= 0 {
+		return requestTimeout
+	}
+	return min(time.Duration(seconds)*time.Second, requestTimeout)
+}
+
+// getEnvBool returns the boolean value of an environment variable, or false if unset or invalid.
+func getEnvBool(key string) bool {
+	b, _ := strconv.ParseBool(os.Getenv(key))
+	return b
+}
+
+// getMaxToolResultBytes returns the maximum tool result size from environment or default.
+// A value <= 0 disables truncation.
+func getMaxToolResultBytes() int {
+	return getEnvInt("CLAUDEX_MCP_MAX_RESULT_BYTES", DefaultMaxToolResultBytes)
+}
+
+// getMaxHistoryMessages returns the maximum number of conversation messages
+// passed to the CLI, not counting system messages and the latest user
+// message. A value <= 0 disables the limit.
+func getMaxHistoryMessages() int {
+	return getEnvInt("CLAUDEX_MAX_HISTORY_MESSAGES", 0)
+}
+
+// getMaxHistoryTokens returns the estimated token budget for conversation
+// history, counted like getMaxHistoryMessages. A value <= 0 disables the limit.
+func getMaxHistoryTokens() int {
+	return getEnvInt("CLAUDEX_MAX_HISTORY_TOKENS", 0)
+}
+
+// getSchemaRetries returns how many times a response that violates a strict
+// json_schema response_format is retried before failing.
+func getSchemaRetries() int {
+	return max(getEnvInt("CLAUDEX_SCHEMA_RETRIES", 1), 0)
+}
+
+// getEmptyResponseMode returns how empty assistant responses are handled.
+func getEmptyResponseMode() string {
+	switch mode := os.Getenv("CLAUDEX_EMPTY_RESPONSE_MODE"); mode {
+	case EmptyResponseError, EmptyResponseRetry, EmptyResponseSentinel:
+		return mode
+	}
+	return EmptyResponsePassthrough
+}
+
+// getToolChoiceRequiredMode returns how responses without a tool call are
+// handled when the request requires one.
+func getToolChoiceRequiredMode() string {
+	switch mode := os.Getenv("CLAUDEX_TOOL_CHOICE_REQUIRED_MODE"); mode {
+	case ToolChoiceRequiredRetry, ToolChoiceRequiredError:
+		return mode
+	}
+	return ToolChoiceRequiredPassthrough
+}
+
+// getMCPToolErrorMode returns how MCP tool errors are handled.
+func getMCPToolErrorMode() string {
+	if os.Getenv("CLAUDEX_MCP_TOOL_ERROR_MODE") == MCPToolErrorFail {
+		return MCPToolErrorFail
+	}
+	return MCPToolErrorFeedback
+}
+
+// getValidateImageArgs reports whether base64 image arguments of MCP tool
+// calls are checked against the tool's schema before the call is made.
+func getValidateImageArgs() bool {
+	return getEnvBool("CLAUDEX_MCP_VALIDATE_IMAGE_ARGS")
+}
+
+// getMaxConcurrentToolCalls returns how many of one response's MCP tool
+// calls run at once. Values below 1 run them one at a time.
+func getMaxConcurrentToolCalls() int {
+	return max(getEnvInt("CLAUDEX_MCP_MAX_CONCURRENT_CALLS", DefaultMaxConcurrentToolCalls), 1)
+}
+
+// getMaxConcurrentToolCallsPerServer returns how many MCP tool calls, across
+// all requests, run against one server at once. Values below 1 run them one
+// at a time. A stdio server still answers one call at a time, so for those
+// servers this bounds how many calls queue on the transport; their call
+// timeout starts once they are sent.
+func getMaxConcurrentToolCallsPerServer() int {
+	return max(getEnvInt("CLAUDEX_MCP_MAX_CONCURRENT_CALLS_PER_SERVER", DefaultMaxConcurrentToolCallsPerServer), 1)
+}
+
+// getMaxChoices returns the most choices (n) a request may ask for from
+// CLAUDEX_MAX_CHOICES. Each streamed choice runs its own CLI process.
+func getMaxChoices() int {
+	if n := getEnvInt("CLAUDEX_MAX_CHOICES", 4); n > 0 {
+		return n
+	}
+	return 4
+}
+
+// getMaxToolCalls returns the maximum number of tool calls accepted in one
+// response, or 0 for no limit.
+func getMaxToolCalls() int {
+	return max(getEnvInt("CLAUDEX_MAX_TOOL_CALLS", 0), 0)
+}
+
+// getToolCallLimitMode returns how responses over the tool call limit are handled.
+func getToolCallLimitMode() string {
+	if os.Getenv("CLAUDEX_TOOL_CALL_LIMIT_MODE") == ToolCallLimitReject {
+		return ToolCallLimitReject
+	}
+	return ToolCallLimitTruncate
+}
+
+// getDuplicateToolsMode returns how duplicate tool names in a request are handled.
+func getDuplicateToolsMode() string {
+	if os.Getenv("CLAUDEX_DUPLICATE_TOOLS_MODE") == DuplicateToolsReject {
+		return DuplicateToolsReject
+	}
+	return DuplicateToolsDedupe
+}
+
+// getToolPrecedence returns which tool wins when a client tool and an MCP tool
+// share a name.
+func getToolPrecedence() string {
+	switch mode := os.Getenv("CLAUDEX_TOOL_PRECEDENCE"); mode {
+	case ToolPrecedenceClient, ToolPrecedenceError:
+		return mode
+	}
+	return ToolPrecedenceMCP
+}
+
+// getEmptyResponseSentinel returns the sentinel content for empty responses.
+func getEmptyResponseSentinel() string {
+	if val := os.Getenv("CLAUDEX_EMPTY_RESPONSE_SENTINEL"); val != "" {
+		return val
+	}
+	return DefaultEmptyResponseSentinel
+}
+
+// getLogitBiasMode returns how requests carrying logit_bias are handled.
+func getLogitBiasMode() string {
+	if os.Getenv("CLAUDEX_LOGIT_BIAS_MODE") == UnsupportedParamReject {
+		return UnsupportedParamReject
+	}
+	return UnsupportedParamIgnore
+}
+
+// getMissingModelMode returns how requests without a model are handled. It
+// defaults to giving them the default model.
+func getMissingModelMode() string {
+	if os.Getenv("CLAUDEX_MISSING_MODEL_MODE") == MissingModelReject {
+		return MissingModelReject
+	}
+	return MissingModelDefault
+}
+
+// getDefaultModel returns the model for requests without one from
+// CLAUDEX_DEFAULT_MODEL.
+func getDefaultModel() string {
+	if model := os.Getenv("CLAUDEX_DEFAULT_MODEL"); model != "" {
+		return model
+	}
+	return DefaultModel
+}
+
+// getMalformedToolCallsMode returns how unparseable tool_calls blocks in
+// response text are handled. It defaults to leaving them.
+func getMalformedToolCallsMode() string {
+	switch mode := os.Getenv("CLAUDEX_MALFORMED_TOOL_CALLS_MODE"); mode {
+	case MalformedToolCallsLog, MalformedToolCallsStrip:
+		return mode
+	}
+	return MalformedToolCallsKeep
+}
+
+// getParamRangeMode returns how out-of-range parameters such as temperature
+// are handled. It defaults to clamping them.
+func getParamRangeMode() string {
+	if os.Getenv("CLAUDEX_PARAM_RANGE_MODE") == ParamRangeReject {
+		return ParamRangeReject
+	}
+	return ParamRangeClamp
+}
+
+// getStreamingStrictSchemaMode returns how streaming requests with a strict
+// json_schema response_format are handled.
+func getStreamingStrictSchemaMode() string {
+	if os.Getenv("CLAUDEX_STREAMING_STRICT_SCHEMA_MODE") == StreamingStrictSchemaUnvalidated {
+		return StreamingStrictSchemaUnvalidated
+	}
+	return StreamingStrictSchemaReject
+}
+
+// getUnknownFieldsMode returns how unknown top-level request fields are handled.
+func getUnknownFieldsMode() string {
+	if os.Getenv("CLAUDEX_UNKNOWN_FIELDS_MODE") == UnknownFieldsReject {
+		return UnknownFieldsReject
+	}
+	return UnknownFieldsIgnore
+}
+
+// getControlCharsMode returns how control characters in assistant output are
+// handled. It defaults to stripping them.
+func getControlCharsMode() string {
+	switch mode := os.Getenv("CLAUDEX_CONTROL_CHARS_MODE"); mode {
+	case ControlCharsEscape, ControlCharsPassthrough:
+		return mode
+	}
+	return ControlCharsStrip
+}
+
+// getFallbackModels returns the configured fallback model chain, in order.
+func getFallbackModels() []string {
+	var chain []string
+	for _, model := range strings.Split(os.Getenv("CLAUDEX_FALLBACK_MODELS"), ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			chain = append(chain, model)
+		}
+	}
+	return chain
+}
+
+// getDefaultToolChoice returns the tool_choice applied to requests that send
+// tools without one: "auto", "required", or "none". It returns "" when unset,
+// which leaves tool_choice absent.
+func getDefaultToolChoice() string {
+	switch choice := os.Getenv("CLAUDEX_DEFAULT_TOOL_CHOICE"); choice {
+	case "auto", "required", "none":
+		return choice
+	}
+	return ""
+}
+
+// getSaturatedStatus returns the status code for requests rejected by the
+// concurrency limiter: 503 (default) or 429 from CLAUDEX_SATURATED_STATUS.
+func getSaturatedStatus() int {
+	if getEnvInt("CLAUDEX_SATURATED_STATUS", 0) == fiber.StatusTooManyRequests {
+		return fiber.StatusTooManyRequests
+	}
+	return fiber.StatusServiceUnavailable
+}
+
+// getStreamErrorFinishReason returns the finish_reason sent for content already
+// streamed when the stream fails, or "" to send the error without one.
+func getStreamErrorFinishReason() string {
+	switch reason := os.Getenv("CLAUDEX_STREAM_ERROR_FINISH_REASON"); reason {
+	case "stop", "error":
+		return reason
+	}
+	return ""
+}
+
+// getStreamToolCallsMode returns how streamed responses to requests with
+// tools handle the model's tool_calls JSON block.
+func getStreamToolCallsMode() string {
+	if os.Getenv("CLAUDEX_STREAM_TOOL_CALLS") == StreamToolCallsBuffered {
+		return StreamToolCallsBuffered
+	}
+	if getEnvBool("CLAUDEX_STREAM_TOOL_CALLS") {
+		return StreamToolCallsIncremental
+	}
+	return StreamToolCallsText
+}
+
+// getStreamTextSource returns where streamed text is taken from in the
+// CLI's output.
+func getStreamTextSource() string {
+	if os.Getenv("CLAUDEX_STREAM_TEXT_SOURCE") == StreamTextMerged {
+		return StreamTextMerged
+	}
+	return StreamTextPartial
+}
+
+// getMergeConsecutiveMessages reports whether consecutive messages with the
+// same role are merged into one before the prompt is built.
+func getMergeConsecutiveMessages() bool {
+	return getEnvBool("CLAUDEX_MERGE_CONSECUTIVE_MESSAGES")
+}
+
+// getSSEEventNames reports whether streamed SSE events carry an event name
+// ("message", "error", or "done") in addition to their data line.
+func getSSEEventNames() bool {
+	return getEnvBool("CLAUDEX_SSE_EVENT_NAMES")
+}
+
+// getStreamSummaryLog reports whether a summary line is logged after each
+// streamed response completes.
+func getStreamSummaryLog() bool {
+	return getEnvBool("CLAUDEX_STREAM_SUMMARY_LOG")
+}
+
+// getStreamToolResults reports whether the results of MCP tool calls run
+// during a streamed tool loop are sent to the client as tool_result events.
+func getStreamToolResults() bool {
+	return getEnvBool("CLAUDEX_STREAM_TOOL_RESULTS")
+}
+
+// DefaultToolKeepAlive is how often a keep-alive comment is written to a
+// stream while its MCP tools run, when CLAUDEX_TOOL_KEEPALIVE_MS is unset.
+const DefaultToolKeepAlive = 15 * time.Second
+
+// getToolKeepAlive returns how often a keep-alive comment is written to a
+// stream while its MCP tools run, from CLAUDEX_TOOL_KEEPALIVE_MS. Zero or a
+// negative value disables the comments.
+func getToolKeepAlive() time.Duration {
+	ms := getEnvInt("CLAUDEX_TOOL_KEEPALIVE_MS", int(DefaultToolKeepAlive/time.Millisecond))
+	return time.Duration(max(ms, 0)) * time.Millisecond
+}
+
+// getAlwaysStreamUsage reports whether usage is attached to the final
+// streaming chunk even when the client did not ask for it. This is for
+// clients that expect usage there regardless of stream_options.
+func getAlwaysStreamUsage() bool {
+	return getEnvBool("CLAUDEX_STREAM_ALWAYS_USAGE")
+}
+
+// getEstimateUsage reports whether usage is estimated from character counts
+// when the CLI reports none.
+func getEstimateUsage() bool {
+	return getEnvBool("CLAUDEX_ESTIMATE_USAGE")
+}
+
+// getCharsPerToken returns the characters per token used to estimate usage
+// from CLAUDEX_CHARS_PER_TOKEN.
+func getCharsPerToken() float64 {
+	if ratio, err := strconv.ParseFloat(os.Getenv("CLAUDEX_CHARS_PER_TOKEN"), 64); err == nil && ratio > 0 {
+		return ratio
+	}
+	return DefaultCharsPerToken
+}
+
+// getBatchConcurrency returns how many batch items may execute at once.
+func getBatchConcurrency() int {
+	if n := getEnvInt("CLAUDEX_BATCH_CONCURRENCY", DefaultBatchConcurrency); n > 0 {
+		return n
+	}
+	return DefaultBatchConcurrency
+}
+
+// getBatchMaxItems returns the maximum number of requests accepted in one batch.
+func getBatchMaxItems() int {
+	if n := getEnvInt("CLAUDEX_BATCH_MAX_ITEMS", DefaultBatchMaxItems); n > 0 {
+		return n
+	}
+	return DefaultBatchMaxItems
+}
+
+// auditLoggerFromEnv returns a tool audit logger writing to CLAUDEX_AUDIT_LOG
+// ("stdout", "stderr", or a file path), or nil when unset or unusable.
+// CLAUDEX_AUDIT_REDACT_ARGS hides tool arguments.
+func auditLoggerFromEnv() *observability.AuditLogger {
+	dest := os.Getenv("CLAUDEX_AUDIT_LOG")
+	if dest == "" {
+		return nil
+	}
+	audit, err := observability.OpenAuditLogger(dest, getEnvBool("CLAUDEX_AUDIT_REDACT_ARGS"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: tool audit log disabled: %v\n", err)
+		return nil
+	}
+	return audit
+}
+
+// keyPoliciesFromEnv loads per-API-key policies from the file named by
+// CLAUDEX_KEY_POLICIES_PATH, or returns nil when unset. A file that cannot
+// be loaded denies every model, so a broken config does not lift the
+// restrictions.
+func keyPoliciesFromEnv() KeyPolicies {
+	path := os.Getenv("CLAUDEX_KEY_POLICIES_PATH")
+	if path == "" {
+		return nil
+	}
+	policies, err := LoadKeyPolicies(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: key policies unusable, denying all models: %v\n", err)
+		return KeyPolicies{}
+	}
+	return policies
+}
+
+// conversationCacheFromEnv returns a conversation cache holding up to
+// CLAUDEX_CONVERSATION_CACHE_SIZE conversations for
+// CLAUDEX_CONVERSATION_CACHE_TTL seconds (default 600), or nil when the size
+// is unset or <= 0.
+func conversationCacheFromEnv() *conversationCache {
+	size := getEnvInt("CLAUDEX_CONVERSATION_CACHE_SIZE", 0)
+	if size <= 0 {
+		return nil
+	}
+	ttl := getEnvInt("CLAUDEX_CONVERSATION_CACHE_TTL", 600)
+	if ttl <= 0 {
+		ttl = 600
+	}
+	return newConversationCache(size, time.Duration(ttl)*time.Second)
+}
+
+// completionStoreFromEnv returns the store for "store": true requests: a
+// file store in CLAUDEX_STORE_DIR, an HTTP store at CLAUDEX_STORE_URL, or nil
+// when neither is set.
+func completionStoreFromEnv() CompletionStore {
+	if dir := os.Getenv("CLAUDEX_STORE_DIR"); dir != "" {
+		store, err := NewFileCompletionStore(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: completion storage disabled: %v\n", err)
+			return nil
+		}
+		return store
+	}
+	if url := os.Getenv("CLAUDEX_STORE_URL"); url != "" {
+		return NewHTTPCompletionStore(url, 10*time.Second)
+	}
+	return nil
+}
+
+// outputProcessorFromEnv returns a regex redactor when
+// CLAUDEX_OUTPUT_REDACT_PATTERN is set, or a no-op processor. Matches are
+// replaced with CLAUDEX_OUTPUT_REDACT_REPLACEMENT (default "[REDACTED]"), and
+// streams hold back CLAUDEX_OUTPUT_REDACT_WINDOW bytes to catch matches split
+// across chunks.
+func outputProcessorFromEnv() OutputProcessor {
+	pattern := os.Getenv("CLAUDEX_OUTPUT_REDACT_PATTERN")
+	if pattern == "" {
+		return NoopOutputProcessor{}
+	}
+	replacement, ok := os.LookupEnv("CLAUDEX_OUTPUT_REDACT_REPLACEMENT")
+	if !ok {
+		replacement = "[REDACTED]"
+	}
+	redactor, err := NewRegexRedactor(pattern, replacement, getEnvInt("CLAUDEX_OUTPUT_REDACT_WINDOW", DefaultRedactWindow))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: output redaction disabled: %v\n", err)
+		return NoopOutputProcessor{}
+	}
+	return redactor
+}
+
+// limiterFromEnv returns a concurrency limiter allowing CLAUDEX_MAX_CONCURRENT
+// completions at once, with up to CLAUDEX_MAX_QUEUE requests waiting at most
+// CLAUDEX_QUEUE_TIMEOUT seconds (default 30). It returns nil when
+// CLAUDEX_MAX_CONCURRENT is unset or <= 0.
+func limiterFromEnv() *concurrencyLimiter {
+	maxConcurrent := getEnvInt("CLAUDEX_MAX_CONCURRENT", 0)
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	timeout := getEnvInt("CLAUDEX_QUEUE_TIMEOUT", 30)
+	if timeout <= 0 {
+		timeout = 30
+	}
+	return newConcurrencyLimiter(maxConcurrent, max(getEnvInt("CLAUDEX_MAX_QUEUE", 0), 0), time.Duration(timeout)*time.Second)
+}
+
+// moderatorFromEnv returns an HTTP moderator when CLAUDEX_MODERATION_URL is
+// set (timeout from CLAUDEX_MODERATION_TIMEOUT seconds), or a no-op moderator.
+func moderatorFromEnv() Moderator {
+	url := os.Getenv("CLAUDEX_MODERATION_URL")
+	if url == "" {
+		return NoopModerator{}
+	}
+	timeout := getEnvInt("CLAUDEX_MODERATION_TIMEOUT", 10)
+	if timeout <= 0 {
+		timeout = 10
+	}
+	return NewHTTPModerator(url, time.Duration(timeout)*time.Second)
+}
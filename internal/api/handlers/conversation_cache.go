@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// ConversationIDHeader names the conversation a request continues. When the
+// conversation cache is enabled, a non-streaming request repeating the
+// conversation's last request is answered with the cached final turn.
+const ConversationIDHeader = "X-Claudex-Conversation-ID"
+
+// CacheBypassHeader skips the conversation cache lookup when set to "1". The
+// fresh response still replaces the cached turn.
+const CacheBypassHeader = "X-Claudex-Cache-Bypass"
+
+// CacheStatusHeader is set on responses to requests with a
+// ConversationIDHeader: CacheStatusHit when the response came from the
+// conversation cache, CacheStatusMiss otherwise.
+const CacheStatusHeader = "X-Claudex-Cache"
+
+// CacheStatusHeader values.
+const (
+	CacheStatusHit  = "HIT"
+	CacheStatusMiss = "MISS"
+)
+
+// Conversation cache lookup results, as recorded in the conversation cache
+// metric.
+const (
+	cacheHit      = "hit"
+	cacheMiss     = "miss"
+	cacheDiverged = "diverged"
+	cacheBypass   = "bypass"
+)
+
+// conversationCache keeps the final turn of recent conversations so a
+// client retrying a request gets the same answer instead of a new
+// completion. Each conversation holds one turn, keyed by a fingerprint of
+// the whole request: a retry matches it, while a request whose history
+// differs drops it. Conversations are evicted least recently used first
+// and expire after ttl.
+type conversationCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // of *conversationTurn, most recently used first
+	entries map[string]*list.Element
+}
+
+// conversationTurn is a conversation's cached final turn.
+type conversationTurn struct {
+	key         string
+	fingerprint [sha256.Size]byte
+	response    []byte
+	expires     time.Time
+}
+
+// newConversationCache creates a cache of up to maxEntries conversations.
+func newConversationCache(maxEntries int, ttl time.Duration) *conversationCache {
+	return &conversationCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached response for key if its request had fingerprint,
+// and the lookup result. A turn whose request differs is removed, since the
+// conversation has moved on or its history was rewritten.
+func (cc *conversationCache) get(key string, fingerprint [sha256.Size]byte) ([]byte, string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	elem, ok := cc.entries[key]
+	if !ok {
+		return nil, cacheMiss
+	}
+	turn := elem.Value.(*conversationTurn)
+	if time.Now().After(turn.expires) {
+		cc.remove(elem)
+		return nil, cacheMiss
+	}
+	if turn.fingerprint != fingerprint {
+		cc.remove(elem)
+		return nil, cacheDiverged
+	}
+	cc.order.MoveToFront(elem)
+	return turn.response, cacheHit
+}
+
+// put caches response as the final turn of key's conversation.
+func (cc *conversationCache) put(key string, fingerprint [sha256.Size]byte, response []byte) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	turn := &conversationTurn{key: key, fingerprint: fingerprint, response: response, expires: time.Now().Add(cc.ttl)}
+	if elem, ok := cc.entries[key]; ok {
+		elem.Value = turn
+		cc.order.MoveToFront(elem)
+		return
+	}
+	cc.entries[key] = cc.order.PushFront(turn)
+	for cc.order.Len() > cc.maxEntries {
+		cc.remove(cc.order.Back())
+	}
+}
+
+// remove drops a cached turn. The caller holds cc.mu.
+func (cc *conversationCache) remove(elem *list.Element) {
+	cc.order.Remove(elem)
+	delete(cc.entries, elem.Value.(*conversationTurn).key)
+}
+
+// cachedConversation identifies a request's turn in the conversation cache.
+type cachedConversation struct {
+	key         string
+	fingerprint [sha256.Size]byte
+}
+
+// lookupConversation looks up a prepared non-streaming request's conversation
+// in the cache. It returns the cached response on a hit, and otherwise the
+// cache entry to fill once the request completes, or nil if the request is
+// not cached. Conversations are scoped to the request's API key and
+// prompt_cache_key, so requests with different prompt_cache_keys keep
+// separate turns.
+func (h *ChatCompletionsHandler) lookupConversation(c *fiber.Ctx, req *models.ChatCompletionRequest) ([]byte, *cachedConversation) {
+	id := c.Get(ConversationIDHeader)
+	if h.conversations == nil || id == "" || req.Stream {
+		return nil, nil
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil
+	}
+	turn := &cachedConversation{
+		key:         bearerKey(c) + "\x00" + req.PromptCacheKey + "\x00" + id,
+		fingerprint: sha256.Sum256(data),
+	}
+
+	result := cacheBypass
+	var response []byte
+	if c.Get(CacheBypassHeader) != "1" {
+		response, result = h.conversations.get(turn.key, turn.fingerprint)
+	}
+	h.metrics.RecordConversationCache(result)
+	if response != nil {
+		c.Set(CacheStatusHeader, CacheStatusHit)
+		h.logger.Debug("served cached conversation turn", "conversation_id", id)
+		return response, nil
+	}
+	c.Set(CacheStatusHeader, CacheStatusMiss)
+	return nil, turn
+}
+
+// cacheConversationTurn stores a completed response as its conversation's
+// final turn.
+func (h *ChatCompletionsHandler) cacheConversationTurn(turn *cachedConversation, resp *models.ChatCompletionResponse) {
+	if turn == nil {
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		h.logger.Warn("failed to cache conversation turn", "error", err.Error())
+		return
+	}
+	h.conversations.put(turn.key, turn.fingerprint, data)
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestHandle_ConversationCache(t *testing.T) {
+	var calls int
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			calls++
+			return resultJSON(fmt.Sprintf("answer %d", calls)), nil
+		},
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			chunks, errChan := streamOf([]string{deltaLine("streamed")}, nil)
+			return chunks, errChan, nil
+		},
+	}
+	h := newTestHandler(exec)
+	h.conversations = newConversationCache(10, time.Minute)
+	app := appFor(h)
+
+	original := `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"},{"role":"user","content":"what now?"}]}`
+	diverged := `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hey there"},{"role":"user","content":"what now?"}]}`
+
+	tests := []struct {
+		name         string
+		body         string
+		conversation string
+		key          string
+		bypass       bool
+		wantCache    string
+		wantContent  string
+	}{
+		{name: "first request", body: original, conversation: "c1", wantCache: CacheStatusMiss, wantContent: "answer 1"},
+		{name: "retry", body: original, conversation: "c1", wantCache: CacheStatusHit, wantContent: "answer 1"},
+		{name: "other API key", body: original, conversation: "c1", key: "sk-other", wantCache: CacheStatusMiss, wantContent: "answer 2"},
+		{name: "bypass", body: original, conversation: "c1", bypass: true, wantCache: CacheStatusMiss, wantContent: "answer 3"},
+		{name: "retry after bypass", body: original, conversation: "c1", wantCache: CacheStatusHit, wantContent: "answer 3"},
+		{name: "diverged history", body: diverged, conversation: "c1", wantCache: CacheStatusMiss, wantContent: "answer 4"},
+		{name: "retry after divergence", body: diverged, conversation: "c1", wantCache: CacheStatusHit, wantContent: "answer 4"},
+		{name: "original after divergence", body: original, conversation: "c1", wantCache: CacheStatusMiss, wantContent: "answer 5"},
+		{name: "no conversation id", body: original, wantContent: "answer 6"},
+		{name: "streaming", body: strings.Replace(original, `"model"`, `"stream":true,"model"`, 1), conversation: "c1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.conversation != "" {
+				req.Header.Set(ConversationIDHeader, tt.conversation)
+			}
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			if tt.bypass {
+				req.Header.Set(CacheBypassHeader, "1")
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("app.Test: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != 200 {
+				t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+			}
+
+			if got := resp.Header.Get(CacheStatusHeader); got != tt.wantCache {
+				t.Errorf("got %s %q, want %q", CacheStatusHeader, got, tt.wantCache)
+			}
+			if tt.wantContent == "" {
+				return
+			}
+			var out models.ChatCompletionResponse
+			if err := json.Unmarshal(body, &out); err != nil {
+				t.Fatalf("unmarshal response %s: %v", body, err)
+			}
+			if got := out.Choices[0].Message.Content; got != tt.wantContent {
+				t.Errorf("got content %q, want %q", got, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestHandle_ConversationCacheHitHeader(t *testing.T) {
+	var calls int
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			calls++
+			return resultJSON("answer"), nil
+		},
+	}
+	h := newTestHandler(exec)
+	h.conversations = newConversationCache(10, time.Minute)
+	app := appFor(h)
+	hits := h.metrics.ConversationCache.WithLabelValues("hit")
+	before := counterValue(t, hits)
+
+	body := `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`
+	for i, want := range []string{CacheStatusMiss, CacheStatusHit} {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(ConversationIDHeader, "c1")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("app.Test: %v", err)
+		}
+		if got := resp.Header.Get(CacheStatusHeader); got != want {
+			t.Errorf("request %d: got %s %q, want %q", i+1, CacheStatusHeader, got, want)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d executor calls, want 1", calls)
+	}
+	if got := counterValue(t, hits) - before; got != 1 {
+		t.Errorf("got %v cache hits recorded, want 1", got)
+	}
+}
+
+func TestHandle_ConversationCachePromptCacheKey(t *testing.T) {
+	var calls int
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			calls++
+			return resultJSON(fmt.Sprintf("answer %d", calls)), nil
+		},
+	}
+	h := newTestHandler(exec)
+	h.conversations = newConversationCache(10, time.Minute)
+	app := appFor(h)
+
+	body := func(cacheKey string) string {
+		return `{"model":"claude-sonnet","prompt_cache_key":"` + cacheKey + `","messages":[{"role":"user","content":"hi"}]}`
+	}
+	tests := []struct {
+		cacheKey    string
+		wantCache   string
+		wantContent string
+	}{
+		{cacheKey: "a", wantCache: CacheStatusMiss, wantContent: "answer 1"},
+		{cacheKey: "b", wantCache: CacheStatusMiss, wantContent: "answer 2"},
+		{cacheKey: "a", wantCache: CacheStatusHit, wantContent: "answer 1"},
+		{cacheKey: "b", wantCache: CacheStatusHit, wantContent: "answer 2"},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body(tt.cacheKey)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(ConversationIDHeader, "c1")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("app.Test: %v", err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if got := resp.Header.Get(CacheStatusHeader); got != tt.wantCache {
+			t.Errorf("request %d (prompt_cache_key %s): got %s %q, want %q", i+1, tt.cacheKey, CacheStatusHeader, got, tt.wantCache)
+		}
+		var out models.ChatCompletionResponse
+		if err := json.Unmarshal(data, &out); err != nil {
+			t.Fatalf("unmarshal response %s: %v", data, err)
+		}
+		if got := out.Choices[0].Message.Content; got != tt.wantContent {
+			t.Errorf("request %d (prompt_cache_key %s): got content %q, want %q", i+1, tt.cacheKey, got, tt.wantContent)
+		}
+	}
+}
+
+func TestConversationCache_Eviction(t *testing.T) {
+	fingerprint := sha256.Sum256([]byte("request"))
+
+	cache := newConversationCache(2, time.Minute)
+	cache.put("a", fingerprint, []byte("A"))
+	cache.put("b", fingerprint, []byte("B"))
+	cache.get("a", fingerprint) // a is now the most recently used
+	cache.put("c", fingerprint, []byte("C"))
+
+	for key, want := range map[string]string{"a": cacheHit, "b": cacheMiss, "c": cacheHit} {
+		if _, got := cache.get(key, fingerprint); got != want {
+			t.Errorf("get(%q): got %s, want %s", key, got, want)
+		}
+	}
+
+	expired := newConversationCache(2, -time.Second)
+	expired.put("a", fingerprint, []byte("A"))
+	if _, got := expired.get("a", fingerprint); got != cacheMiss {
+		t.Errorf("get of an expired turn: got %s, want %s", got, cacheMiss)
+	}
+}
+
+func TestConversationCache_Concurrent(t *testing.T) {
+	cache := newConversationCache(8, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := fmt.Sprintf("conversation-%d", i%4)
+			for j := range 100 {
+				fingerprint := sha256.Sum256([]byte{byte(j % 3)})
+				if response, result := cache.get(key, fingerprint); result == cacheHit && len(response) == 0 {
+					t.Errorf("hit for %s with an empty response", key)
+				}
+				cache.put(key, fingerprint, []byte("response"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := cache.order.Len(); n != len(cache.entries) || n > 4 {
+		t.Errorf("got %d turns in order and %d entries, want equal and at most 4", n, len(cache.entries))
+	}
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"slices"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// fallbackCodes lists the CLI error codes after which the next model in the
+// fallback chain is tried.
+var fallbackCodes = map[string]bool{
+	"overloaded_error": true,
+	"api_error":        true,
+	"not_found_error":  true,
+	"rate_limit_error": true,
+}
+
+// fallbackModels returns the models to try after model fails: the models
+// after it in chain, or the whole chain when model is not part of it. With a
+// non-nil allowed list, only the models in it are tried.
+func fallbackModels(model string, chain, allowed []string) []string {
+	for i, m := range chain {
+		if m == model {
+			chain = chain[i+1:]
+			break
+		}
+	}
+	if allowed == nil {
+		return chain
+	}
+	var models []string
+	for _, m := range chain {
+		if slices.Contains(allowed, m) {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// runCompletion runs the completion with the requested model and, on an error
+// the model may not share, with each model of the CLAUDEX_FALLBACK_MODELS
+// chain in turn, skipping models the API key may not use. The response
+// reports the model that produced it.
+func (h *ChatCompletionsHandler) runCompletion(ctx context.Context, req *models.ChatCompletionRequest) (*models.ChatCompletionResponse, *completionError) {
+	resp, cerr := h.runCompletionOnce(ctx, req)
+	failed := req.Model
+	for _, model := range fallbackModels(req.Model, getFallbackModels(), req.AllowedModels) {
+		if cerr == nil || !fallbackCodes[cerr.detail.Code] || ctx.Err() != nil {
+			break
+		}
+		h.logger.Warn("falling back to next model", "model", failed, "fallback", model, "code", cerr.detail.Code, "error", cerr.detail.Message)
+
+		fallbackReq := *req
+		fallbackReq.Model = model
+		resp, cerr = h.runCompletionOnce(ctx, &fallbackReq)
+		failed = model
+	}
+	return resp, cerr
+}
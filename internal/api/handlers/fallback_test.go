@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/claude"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestFallbackModels(t *testing.T) {
+	chain := []string{"claude-opus", "claude-sonnet", "claude-haiku"}
+	tests := []struct {
+		model   string
+		allowed []string
+		want    []string
+	}{
+		{model: "claude-opus", want: []string{"claude-sonnet", "claude-haiku"}},
+		{model: "claude-haiku", want: []string{}},
+		{model: "other", want: chain},
+		{model: "other", allowed: []string{"other", "claude-haiku"}, want: []string{"claude-haiku"}},
+		{model: "other", allowed: []string{"other"}, want: nil},
+	}
+	for _, tt := range tests {
+		if got := fallbackModels(tt.model, chain, tt.allowed); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("fallbackModels(%q, %v): got %v, want %v", tt.model, tt.allowed, got, tt.want)
+		}
+	}
+}
+
+func TestHandleNonStreaming_FallbackModel(t *testing.T) {
+	tests := []struct {
+		name       string
+		chain      string
+		code       string
+		keyModels  []string
+		wantStatus int
+		wantModels []string
+	}{
+		{name: "fallback succeeds", chain: "claude-opus,claude-sonnet", code: "overloaded_error", wantStatus: 200, wantModels: []string{"claude-opus", "claude-sonnet"}},
+		{name: "no chain", code: "overloaded_error", wantStatus: 503, wantModels: []string{"claude-opus"}},
+		{name: "fallback not allowed for key", chain: "claude-sonnet", code: "overloaded_error", keyModels: []string{"claude-opus"}, wantStatus: 503, wantModels: []string{"claude-opus"}},
+		{name: "not retriable", chain: "claude-opus,claude-sonnet", code: "invalid_request_error", wantStatus: 400, wantModels: []string{"claude-opus"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_FALLBACK_MODELS", tt.chain)
+
+			var tried []string
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					tried = append(tried, req.Model)
+					if req.Model == "claude-opus" {
+						return "", &claude.CLIError{Code: tt.code, Message: "unavailable"}
+					}
+					return resultJSON("ok"), nil
+				},
+			}
+
+			h := newTestHandler(exec)
+			if tt.keyModels != nil {
+				h.SetKeyPolicies(KeyPolicies{DefaultKeyPolicy: {Models: tt.keyModels}})
+			}
+
+			resp, body := postChat(t, appFor(h), `{"model":"claude-opus","messages":[{"role":"user","content":"hi"}]}`)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if !reflect.DeepEqual(tried, tt.wantModels) {
+				t.Errorf("got models tried %v, want %v", tried, tt.wantModels)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+
+			var out models.ChatCompletionResponse
+			if err := json.Unmarshal([]byte(body), &out); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			if out.Model != "claude-sonnet" {
+				t.Errorf("got model %q, want claude-sonnet", out.Model)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// isSystemRole reports whether a message carries system instructions.
+func isSystemRole(role string) bool {
+	return role == "system" || role == "developer"
+}
+
+// estimateTokens returns a rough token count for a message, assuming about
+// four characters per token.
+func estimateTokens(msg *models.Message) int {
+	tokens := len(msg.GetTextContent()) / 4
+	for _, tc := range msg.ToolCalls {
+		tokens += (len(tc.Function.Name) + len(tc.Function.Arguments)) / 4
+	}
+	return tokens + 1
+}
+
+// truncateHistory keeps the most recent messages that fit within maxMessages
+// and maxTokens (either limit is disabled when <= 0). System messages and the
+// latest user message are always kept and are not counted against the
+// limits. Tool results whose assistant message was dropped are dropped too.
+// It returns the kept messages and the number dropped.
+func truncateHistory(messages []models.Message, maxMessages, maxTokens int) ([]models.Message, int) {
+	if maxMessages <= 0 && maxTokens <= 0 {
+		return messages, 0
+	}
+
+	lastUser := -1
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			lastUser = i
+			break
+		}
+	}
+
+	keep := make([]bool, len(messages))
+	count, tokens := 0, 0
+	full := false
+	for i := len(messages) - 1; i >= 0; i-- {
+		if isSystemRole(messages[i].Role) || i == lastUser {
+			keep[i] = true
+			continue
+		}
+		if full {
+			continue
+		}
+		cost := estimateTokens(&messages[i])
+		if (maxMessages > 0 && count+1 > maxMessages) || (maxTokens > 0 && tokens+cost > maxTokens) {
+			full = true
+			continue
+		}
+		keep[i] = true
+		count++
+		tokens += cost
+	}
+
+	// Kept conversation messages form a suffix. A tool result is only
+	// meaningful after its assistant message, so drop tool results at the
+	// start of the suffix once the message before them is gone.
+	for i := range messages {
+		if isSystemRole(messages[i].Role) || i == lastUser || !keep[i] {
+			continue
+		}
+		if messages[i].Role != "tool" || prevKept(keep, messages, i) {
+			break
+		}
+		keep[i] = false
+	}
+
+	kept := make([]models.Message, 0, len(messages))
+	for i, msg := range messages {
+		if keep[i] {
+			kept = append(kept, msg)
+		}
+	}
+	return kept, len(messages) - len(kept)
+}
+
+// prevKept reports whether the closest non-system message before i was kept.
+func prevKept(keep []bool, messages []models.Message, i int) bool {
+	for j := i - 1; j >= 0; j-- {
+		if !isSystemRole(messages[j].Role) {
+			return keep[j]
+		}
+	}
+	return false
+}
+
+// mergeConsecutiveMessages merges runs of messages with the same role into
+// one message, joining their content. Tool results are never merged, and an
+// assistant message with tool calls ends a run so later text is not placed
+// before the calls. It returns the messages and the number merged away.
+func mergeConsecutiveMessages(messages []models.Message) ([]models.Message, int) {
+	merged := make([]models.Message, 0, len(messages))
+	for _, msg := range messages {
+		n := len(merged)
+		if n == 0 || !canMerge(&merged[n-1], &msg) {
+			merged = append(merged, msg)
+			continue
+		}
+		prev := &merged[n-1]
+		prev.Content = joinContent(prev.Content, msg.Content)
+		prev.ToolCalls = msg.ToolCalls
+	}
+	return merged, len(messages) - len(merged)
+}
+
+// canMerge reports whether next can be merged into prev.
+func canMerge(prev, next *models.Message) bool {
+	return prev.Role == next.Role && prev.Role != "tool" && len(prev.ToolCalls) == 0
+}
+
+// joinContent joins two message contents. Strings are joined with a blank
+// line; if either side has content parts, the result is their parts in order.
+func joinContent(a, b any) any {
+	as, aIsString := a.(string)
+	bs, bIsString := b.(string)
+	if aIsString && bIsString {
+		switch {
+		case as == "":
+			return bs
+		case bs == "":
+			return as
+		}
+		return as + "\n\n" + bs
+	}
+	return append(contentParts(a), contentParts(b)...)
+}
+
+// contentParts returns message content as a new slice of content parts.
+func contentParts(content any) []models.ContentPart {
+	switch c := content.(type) {
+	case string:
+		if c == "" {
+			return nil
+		}
+		return []models.ContentPart{{Type: "text", Text: c}}
+	case []models.ContentPart:
+		return append([]models.ContentPart(nil), c...)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// roles returns the role and content of each message, for comparison.
+func roles(messages []models.Message) []string {
+	out := make([]string, len(messages))
+	for i, msg := range messages {
+		out[i] = msg.Role + ":" + msg.GetTextContent()
+	}
+	return out
+}
+
+func TestTruncateHistory(t *testing.T) {
+	conversation := []models.Message{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "u1"},
+		{Role: "assistant", Content: "a1"},
+		{Role: "user", Content: "u2"},
+		{Role: "assistant", Content: "a2"},
+		{Role: "user", Content: "u3"},
+	}
+	withTools := []models.Message{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "u1"},
+		{Role: "assistant", ToolCalls: []models.ToolCall{{ID: "c1", Type: "function", Function: models.FunctionCall{Name: "f", Arguments: "{}"}}}},
+		{Role: "tool", ToolCallID: "c1", Content: "r1"},
+		{Role: "assistant", Content: "a1"},
+		{Role: "user", Content: "u2"},
+	}
+
+	tests := []struct {
+		name        string
+		messages    []models.Message
+		maxMessages int
+		maxTokens   int
+		want        []string
+	}{
+		{
+			name:     "disabled",
+			messages: conversation,
+			want:     []string{"system:sys", "user:u1", "assistant:a1", "user:u2", "assistant:a2", "user:u3"},
+		},
+		{
+			name:        "under limit",
+			messages:    conversation,
+			maxMessages: 10,
+			want:        []string{"system:sys", "user:u1", "assistant:a1", "user:u2", "assistant:a2", "user:u3"},
+		},
+		{
+			name:        "drops oldest turns",
+			messages:    conversation,
+			maxMessages: 2,
+			want:        []string{"system:sys", "user:u2", "assistant:a2", "user:u3"},
+		},
+		{
+			name:        "keeps system and latest user at zero budget",
+			messages:    append(conversation[:len(conversation):len(conversation)], models.Message{Role: "assistant", Content: "a3"}),
+			maxMessages: 1,
+			want:        []string{"system:sys", "user:u3", "assistant:a3"},
+		},
+		{
+			name:      "token budget",
+			messages:  conversation,
+			maxTokens: 2,
+			want:      []string{"system:sys", "user:u2", "assistant:a2", "user:u3"},
+		},
+		{
+			name:        "drops orphaned tool results",
+			messages:    withTools,
+			maxMessages: 2,
+			want:        []string{"system:sys", "assistant:a1", "user:u2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped := truncateHistory(tt.messages, tt.maxMessages, tt.maxTokens)
+			if !reflect.DeepEqual(roles(got), tt.want) {
+				t.Errorf("got %v, want %v", roles(got), tt.want)
+			}
+			if want := len(tt.messages) - len(tt.want); dropped != want {
+				t.Errorf("got %d dropped, want %d", dropped, want)
+			}
+		})
+	}
+}
+
+func TestHandle_MaxHistoryMessages(t *testing.T) {
+	t.Setenv("CLAUDEX_MAX_HISTORY_MESSAGES", "1")
+
+	var got []string
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			got = roles(req.Messages)
+			return resultJSON("ok"), nil
+		},
+	}
+
+	body := `{"model":"claude-sonnet","messages":[
+		{"role":"system","content":"sys"},
+		{"role":"user","content":"old"},
+		{"role":"assistant","content":"reply"},
+		{"role":"user","content":"new"}]}`
+	resp, respBody := postChat(t, newTestApp(exec), body)
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, respBody)
+	}
+
+	want := []string{"system:sys", "assistant:reply", "user:new"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got messages %v, want %v", got, want)
+	}
+}
+
+func TestMergeConsecutiveMessages(t *testing.T) {
+	call := []models.ToolCall{{ID: "c1", Type: "function", Function: models.FunctionCall{Name: "f", Arguments: "{}"}}}
+
+	tests := []struct {
+		name       string
+		messages   []models.Message
+		want       []string
+		wantMerged int
+	}{
+		{
+			name: "consecutive user and assistant",
+			messages: []models.Message{
+				{Role: "user", Content: "u1"},
+				{Role: "user", Content: "u2"},
+				{Role: "assistant", Content: "a1"},
+				{Role: "assistant", Content: "a2"},
+				{Role: "user", Content: "u3"},
+			},
+			want:       []string{"user:u1\n\nu2", "assistant:a1\n\na2", "user:u3"},
+			wantMerged: 2,
+		},
+		{
+			name: "tool results kept separate",
+			messages: []models.Message{
+				{Role: "user", Content: "u1"},
+				{Role: "assistant", Content: "thinking"},
+				{Role: "assistant", ToolCalls: call},
+				{Role: "tool", ToolCallID: "c1", Content: "r1"},
+				{Role: "tool", ToolCallID: "c2", Content: "r2"},
+				{Role: "assistant", Content: "a1"},
+			},
+			want:       []string{"user:u1", "assistant:thinking", "tool:r1", "tool:r2", "assistant:a1"},
+			wantMerged: 1,
+		},
+		{
+			name: "text after tool calls not merged",
+			messages: []models.Message{
+				{Role: "assistant", ToolCalls: call},
+				{Role: "assistant", Content: "a1"},
+			},
+			want: []string{"assistant:", "assistant:a1"},
+		},
+		{
+			name: "empty content skipped",
+			messages: []models.Message{
+				{Role: "user", Content: ""},
+				{Role: "user", Content: "u1"},
+			},
+			want:       []string{"user:u1"},
+			wantMerged: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, merged := mergeConsecutiveMessages(tt.messages)
+			if !reflect.DeepEqual(roles(got), tt.want) {
+				t.Errorf("got messages %q, want %q", roles(got), tt.want)
+			}
+			if merged != tt.wantMerged {
+				t.Errorf("got %d merged, want %d", merged, tt.wantMerged)
+			}
+		})
+	}
+}
+
+func TestMergeConsecutiveMessages_ContentParts(t *testing.T) {
+	image := models.ContentPart{Type: "image_url", ImageURL: &models.ImageURL{URL: "data:image/png;base64," + pngBase64}}
+	messages := []models.Message{
+		{Role: "user", Content: "look at this"},
+		{Role: "user", Content: []models.ContentPart{image}},
+	}
+
+	got, _ := mergeConsecutiveMessages(messages)
+
+	want := []models.ContentPart{{Type: "text", Text: "look at this"}, image}
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Content, want) {
+		t.Errorf("got %+v, want one user message with parts %+v", got, want)
+	}
+	if _, ok := messages[0].Content.(string); !ok {
+		t.Error("input message was modified")
+	}
+}
+
+func TestHandle_MergeConsecutiveMessages(t *testing.T) {
+	t.Setenv("CLAUDEX_MERGE_CONSECUTIVE_MESSAGES", "true")
+
+	var got []string
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			got = roles(req.Messages)
+			return resultJSON("ok"), nil
+		},
+	}
+
+	body := `{"model":"claude-sonnet","messages":[
+		{"role":"user","content":"first"},
+		{"role":"user","content":"second"}]}`
+	resp, respBody := postChat(t, newTestApp(exec), body)
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, respBody)
+	}
+
+	want := []string{"user:first\n\nsecond"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got messages %q, want %q", got, want)
+	}
+}
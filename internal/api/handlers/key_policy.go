@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// DefaultKeyPolicy names the policy applied to API keys without their own.
+const DefaultKeyPolicy = "*"
+
+// KeyPolicy restricts what requests made with an API key may use. An
+// omitted list allows everything and an empty list allows nothing.
+type KeyPolicy struct {
+	Models []string `yaml:"models" json:"models"` // Models the key may request
+	Tools  []string `yaml:"tools" json:"tools"`   // MCP tools advertised to the key's requests
+}
+
+// KeyPolicies maps API keys to their policies. Keys with no entry get the
+// DefaultKeyPolicy entry, if any, and are otherwise denied every model and
+// tool.
+type KeyPolicies map[string]KeyPolicy
+
+// denyAll is the policy of keys with neither an entry nor a default.
+var denyAll = KeyPolicy{Models: []string{}, Tools: []string{}}
+
+// LoadKeyPolicies reads key policies from a YAML or JSON file.
+func LoadKeyPolicies(path string) (KeyPolicies, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policies KeyPolicies
+	if err := yaml.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("parse key policies: %w", err)
+	}
+	if policies == nil {
+		// An empty file still restricts keys, denying all of them
+		policies = KeyPolicies{}
+	}
+	return policies, nil
+}
+
+// lookup returns the policy for key: its own entry, the default entry, or
+// denyAll.
+func (p KeyPolicies) lookup(key string) KeyPolicy {
+	if policy, ok := p[key]; ok && key != "" {
+		return policy
+	}
+	if policy, ok := p[DefaultKeyPolicy]; ok {
+		return policy
+	}
+	return denyAll
+}
+
+// allowsModel reports whether the policy permits model.
+func (p KeyPolicy) allowsModel(model string) bool {
+	return p.Models == nil || slices.Contains(p.Models, model)
+}
+
+// allowedTools returns the MCP tools the policy permits, or nil for all.
+func (p KeyPolicy) allowedTools() map[string]bool {
+	if p.Tools == nil {
+		return nil
+	}
+	allowed := make(map[string]bool, len(p.Tools))
+	for _, name := range p.Tools {
+		allowed[name] = true
+	}
+	return allowed
+}
+
+// SetKeyPolicies replaces the per-API-key policies. Nil leaves every key
+// unrestricted.
+func (h *ChatCompletionsHandler) SetKeyPolicies(p KeyPolicies) {
+	h.keyPolicies = p
+}
+
+// keyPolicy returns the policy of the request's API key, or nil when no
+// policies are configured.
+func (h *ChatCompletionsHandler) keyPolicy(c *fiber.Ctx) *KeyPolicy {
+	if h.keyPolicies == nil {
+		return nil
+	}
+	policy := h.keyPolicies.lookup(bearerKey(c))
+	return &policy
+}
+
+// bearerKey returns the API key sent in the Authorization header.
+func bearerKey(c *fiber.Ctx) string {
+	return strings.TrimSpace(strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "))
+}
+
+// modelNotAllowed is the error for a model the API key may not use.
+func modelNotAllowed(model string) *models.ErrorDetail {
+	return &models.ErrorDetail{
+		Message: fmt.Sprintf("this API key may not use model %s", model),
+		Type:    "permission_error",
+		Param:   "model",
+		Code:    "model_not_allowed",
+	}
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/mcp"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestLoadKeyPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	config := `
+sk-restricted:
+  models: [claude-haiku]
+  tools: []
+"*":
+  tools: [search]
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := LoadKeyPolicies(path)
+	if err != nil {
+		t.Fatalf("LoadKeyPolicies: %v", err)
+	}
+
+	restricted := policies.lookup("sk-restricted")
+	if !restricted.allowsModel("claude-haiku") || restricted.allowsModel("claude-sonnet") {
+		t.Errorf("got restricted policy %+v, want only claude-haiku", restricted)
+	}
+	if tools := restricted.allowedTools(); tools == nil || len(tools) != 0 {
+		t.Errorf("got allowed tools %v for an empty list, want none", tools)
+	}
+
+	other := policies.lookup("sk-other")
+	if !other.allowsModel("claude-sonnet") {
+		t.Errorf("got default policy %+v, want every model allowed", other)
+	}
+	if tools := other.allowedTools(); !reflect.DeepEqual(tools, map[string]bool{"search": true}) {
+		t.Errorf("got allowed tools %v, want search", tools)
+	}
+
+	// Without a default entry, unknown keys are denied
+	delete(policies, DefaultKeyPolicy)
+	if unknown := policies.lookup("sk-other"); unknown.allowsModel("claude-sonnet") || len(unknown.allowedTools()) != 0 {
+		t.Errorf("got policy %+v for an unknown key, want everything denied", unknown)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.yaml")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if policies, err := LoadKeyPolicies(empty); err != nil || policies == nil {
+		t.Errorf("loading an empty file: got %v, %v, want no policies that still deny unknown keys", policies, err)
+	}
+
+	if _, err := LoadKeyPolicies(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("loading a missing file: got nil error")
+	}
+}
+
+func TestHandle_KeyPolicies(t *testing.T) {
+	manager := mcp.NewManager()
+	for _, name := range []string{"search", "delete_all"} {
+		err := manager.RegisterLocalTool(name, nil, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+			return &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: "ok"}}}, nil
+		})
+		if err != nil {
+			t.Fatalf("RegisterLocalTool: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		key        string
+		body       string
+		wantStatus int
+		wantTools  string
+	}{
+		{name: "allowed model", key: "sk-restricted", body: `"model":"claude-haiku",`, wantStatus: 200, wantTools: "search"},
+		{name: "disallowed model", key: "sk-restricted", body: `"model":"claude-sonnet",`, wantStatus: 403},
+		{name: "disallowed default model", key: "sk-restricted", wantStatus: 403},
+		{name: "unknown key", key: "sk-guessed", body: `"model":"claude-haiku",`, wantStatus: 403},
+		{name: "no key", body: `"model":"claude-haiku",`, wantStatus: 403},
+		{name: "unrestricted key", key: "sk-admin", body: `"model":"claude-sonnet",`, wantStatus: 200, wantTools: "search,delete_all"},
+		{name: "batch disallowed model", path: "/v1/batch/completions", key: "sk-restricted", body: `"model":"claude-sonnet",`, wantStatus: 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tools []string
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					for _, tool := range req.Tools {
+						tools = append(tools, tool.Function.Name)
+					}
+					return resultJSON("hi"), nil
+				},
+			}
+			h := newTestHandler(exec)
+			h.mcpManager = manager
+			h.SetKeyPolicies(KeyPolicies{
+				"sk-restricted": {Models: []string{"claude-haiku"}, Tools: []string{"search"}},
+				"sk-admin":      {},
+			})
+
+			path, body := "/v1/chat/completions", `{`+tt.body+`"messages":[{"role":"user","content":"hi"}]}`
+			if tt.path != "" {
+				path, body = tt.path, `{"requests":[`+body+`]}`
+			}
+			req := httptest.NewRequest("POST", path, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			resp, err := appFor(h).Test(req, -1)
+			if err != nil {
+				t.Fatalf("app.Test: %v", err)
+			}
+			respBody, _ := io.ReadAll(resp.Body)
+
+			status := resp.StatusCode
+			if tt.path != "" {
+				var out models.BatchCompletionResponse
+				if err := json.Unmarshal(respBody, &out); err != nil || len(out.Data) != 1 {
+					t.Fatalf("unmarshal batch response %s: %v", respBody, err)
+				}
+				status = out.Data[0].Status
+			}
+			if status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", status, tt.wantStatus, respBody)
+			}
+			if tt.wantStatus == 403 {
+				if !strings.Contains(string(respBody), `"model_not_allowed"`) {
+					t.Errorf("got body %s, want code model_not_allowed", respBody)
+				}
+				return
+			}
+			if got := strings.Join(tools, ","); got != tt.wantTools {
+				t.Errorf("got advertised tools %s, want %s", got, tt.wantTools)
+			}
+		})
+	}
+}
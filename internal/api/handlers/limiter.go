@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// concurrencyLimiter bounds the number of completions running at once.
+// Requests beyond the limit wait in a bounded queue; when the queue is full,
+// or a request waits longer than queueTimeout, the request is rejected.
+type concurrencyLimiter struct {
+	slots        chan struct{}
+	maxQueue     int64
+	queueTimeout time.Duration
+	waiting      atomic.Int64
+
+	mu          sync.Mutex
+	avgDuration time.Duration // moving average of how long a slot is held
+}
+
+// newConcurrencyLimiter creates a limiter for maxConcurrent completions.
+func newConcurrencyLimiter(maxConcurrent, maxQueue int, queueTimeout time.Duration) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		slots:        make(chan struct{}, maxConcurrent),
+		maxQueue:     int64(maxQueue),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// acquire waits for a slot and returns the function that releases it, or
+// false when the limiter is saturated.
+func (l *concurrencyLimiter) acquire(ctx context.Context) (func(), bool) {
+	select {
+	case l.slots <- struct{}{}:
+		return l.releaser(), true
+	default:
+	}
+
+	if l.waiting.Add(1) > l.maxQueue {
+		l.waiting.Add(-1)
+		return nil, false
+	}
+	defer l.waiting.Add(-1)
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return l.releaser(), true
+	case <-timer.C:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// releaser returns a function that frees the slot taken now and records how
+// long it was held.
+func (l *concurrencyLimiter) releaser() func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.observe(time.Since(start))
+			<-l.slots
+		})
+	}
+}
+
+// observe folds a slot hold time into the moving average.
+func (l *concurrencyLimiter) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.avgDuration == 0 {
+		l.avgDuration = d
+		return
+	}
+	l.avgDuration = (l.avgDuration*4 + d) / 5
+}
+
+// retryAfter estimates, in whole seconds, how long until a new request would
+// get a slot: the queue ahead of it divided by the drain rate of
+// cap(slots) completions per average duration. It is at least one second.
+func (l *concurrencyLimiter) retryAfter() int {
+	l.mu.Lock()
+	avg := l.avgDuration
+	l.mu.Unlock()
+
+	ahead := float64(l.waiting.Load() + 1)
+	seconds := ahead * avg.Seconds() / float64(cap(l.slots))
+	return max(int(math.Ceil(seconds)), 1)
+}
+
+// acquireSlot takes a concurrency slot for a completion and returns the
+// function that releases it. When the server is saturated it returns the
+// error to send, carrying the Retry-After estimate.
+func (h *ChatCompletionsHandler) acquireSlot(ctx context.Context) (func(), *completionError) {
+	if h.limiter == nil {
+		return func() {}, nil
+	}
+	release, ok := h.limiter.acquire(ctx)
+	if ok {
+		return release, nil
+	}
+
+	retryAfter := h.limiter.retryAfter()
+	h.logger.Warn("rejecting request: concurrency limit reached", "retry_after", retryAfter)
+
+	status := getSaturatedStatus()
+	errType := "server_error"
+	if status == fiber.StatusTooManyRequests {
+		errType = "rate_limit_error"
+	}
+	return nil, &completionError{
+		status:     status,
+		metric:     "concurrency_limit",
+		retryAfter: time.Duration(retryAfter) * time.Second,
+		detail: models.ErrorDetail{
+			Message: "Server is at capacity; retry after " + strconv.Itoa(retryAfter) + "s",
+			Type:    errType,
+			Code:    "concurrency_limit",
+		},
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestConcurrencyLimiter_Queue(t *testing.T) {
+	l := newConcurrencyLimiter(1, 1, time.Second)
+	ctx := context.Background()
+
+	release, ok := l.acquire(ctx)
+	if !ok {
+		t.Fatal("first acquire: got saturated, want slot")
+	}
+
+	// The second request queues until the first releases its slot
+	queued := make(chan bool)
+	go func() {
+		r, ok := l.acquire(ctx)
+		if ok {
+			defer r()
+		}
+		queued <- ok
+	}()
+	for l.waiting.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// With the queue full a third request is rejected immediately
+	if _, ok := l.acquire(ctx); ok {
+		t.Error("acquire with a full queue: got slot, want saturated")
+	}
+
+	release()
+	release() // releasing twice must not free a second slot
+	if ok := <-queued; !ok {
+		t.Error("queued acquire: got saturated, want slot")
+	}
+}
+
+func TestConcurrencyLimiter_QueueTimeout(t *testing.T) {
+	l := newConcurrencyLimiter(1, 1, 20*time.Millisecond)
+	release, _ := l.acquire(context.Background())
+	defer release()
+
+	if _, ok := l.acquire(context.Background()); ok {
+		t.Error("got slot after queue timeout, want saturated")
+	}
+}
+
+func TestConcurrencyLimiter_RetryAfter(t *testing.T) {
+	l := newConcurrencyLimiter(2, 10, time.Second)
+	if got := l.retryAfter(); got != 1 {
+		t.Errorf("with no history: got %d, want 1", got)
+	}
+
+	l.observe(4 * time.Second)
+	l.waiting.Store(2)
+	// 3 requests ahead, draining 2 per 4s
+	if got := l.retryAfter(); got != 6 {
+		t.Errorf("got %d, want 6", got)
+	}
+}
+
+func TestHandle_ConcurrencySaturated(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		wantStatus int
+	}{
+		{name: "503 by default", wantStatus: http.StatusServiceUnavailable},
+		{name: "429", status: "429", wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_MAX_CONCURRENT", "1")
+			t.Setenv("CLAUDEX_MAX_QUEUE", "0")
+			t.Setenv("CLAUDEX_SATURATED_STATUS", tt.status)
+
+			started := make(chan struct{})
+			unblock := make(chan struct{})
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					close(started)
+					<-unblock
+					return resultJSON("ok"), nil
+				},
+			}
+			app := newTestApp(exec)
+			body := `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`
+
+			first := make(chan int)
+			go func() {
+				resp, _ := postChat(t, app, body)
+				first <- resp.StatusCode
+			}()
+			<-started
+
+			resp, respBody := postChat(t, app, body)
+			close(unblock)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, respBody)
+			}
+			retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+			if err != nil || retryAfter < 1 {
+				t.Errorf("got Retry-After %q, want a positive number of seconds", resp.Header.Get("Retry-After"))
+			}
+			if !strings.Contains(respBody, "concurrency_limit") {
+				t.Errorf("got body %s, want concurrency_limit", respBody)
+			}
+			if status := <-first; status != http.StatusOK {
+				t.Errorf("first request: got status %d, want 200", status)
+			}
+		})
+	}
+}
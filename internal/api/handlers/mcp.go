@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/mcp"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// MCPHandler serves the MCP debugging endpoints.
+type MCPHandler struct {
+	manager *mcp.Manager
+}
+
+// NewMCPHandler creates an MCP endpoint handler.
+func NewMCPHandler(manager *mcp.Manager) *MCPHandler {
+	return &MCPHandler{manager: manager}
+}
+
+// HandleTools lists all MCP tools. With ?format=openai they are returned as
+// OpenAI function tools, ready to copy into a request's tools array.
+func (h *MCPHandler) HandleTools(c *fiber.Ctx) error {
+	if c.Query("format") == "openai" {
+		tools := h.manager.GetToolsAsOpenAI()
+		return c.JSON(fiber.Map{
+			"tools": tools,
+			"count": len(tools),
+		})
+	}
+
+	tools := h.manager.GetAllTools()
+	return c.JSON(fiber.Map{
+		"tools": tools,
+		"count": len(tools),
+	})
+}
+
+// HandleServerTools lists the tools of the server named in the path.
+func (h *MCPHandler) HandleServerTools(c *fiber.Ctx) error {
+	name := c.Params("name")
+	tools, ok := h.manager.GetServerTools(name)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: models.ErrorDetail{
+				Message: "MCP server " + name + " is not running",
+				Type:    "invalid_request_error",
+				Code:    "server_not_found",
+			},
+		})
+	}
+	return c.JSON(fiber.Map{
+		"server": name,
+		"tools":  tools,
+		"count":  len(tools),
+	})
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/mcp"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestHandleServerTools(t *testing.T) {
+	manager := mcp.NewManager()
+	err := manager.RegisterLocalTool("lookup", nil, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		return &models.MCPToolResult{}, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	app := fiber.New()
+	app.Get("/v1/mcp/servers/:name/tools", NewMCPHandler(manager).HandleServerTools)
+
+	tests := []struct {
+		name       string
+		server     string
+		wantStatus int
+		wantTools  []string
+	}{
+		{name: "local tools", server: mcp.LocalServerName, wantStatus: 200, wantTools: []string{"lookup"}},
+		{name: "unknown server", server: "missing", wantStatus: 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := app.Test(httptest.NewRequest("GET", "/v1/mcp/servers/"+tt.server+"/tools", nil), -1)
+			if err != nil {
+				t.Fatalf("app.Test: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+
+			var out struct {
+				Server string           `json:"server"`
+				Tools  []models.MCPTool `json:"tools"`
+				Count  int              `json:"count"`
+			}
+			if err := json.Unmarshal(body, &out); err != nil {
+				t.Fatalf("unmarshal %s: %v", body, err)
+			}
+			if out.Server != tt.server || out.Count != len(tt.wantTools) {
+				t.Fatalf("got %+v, want server %s with %d tools", out, tt.server, len(tt.wantTools))
+			}
+			for i, name := range tt.wantTools {
+				if out.Tools[i].Name != name {
+					t.Errorf("tool %d: got %s, want %s", i, out.Tools[i].Name, name)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleTools_OpenAIFormat(t *testing.T) {
+	manager := mcp.NewManager()
+	schema := json.RawMessage(`{"type":"object","properties":{"q":{"type":"string"}}}`)
+	err := manager.RegisterLocalTool("lookup", schema, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		return &models.MCPToolResult{}, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	app := fiber.New()
+	app.Get("/v1/mcp/tools", NewMCPHandler(manager).HandleTools)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/v1/mcp/tools?format=openai", nil), -1)
+	if err != nil {
+		t.Fatalf("app.Test: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	var out struct {
+		Tools []models.Tool `json:"tools"`
+		Count int           `json:"count"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		t.Fatalf("unmarshal %s: %v", body, err)
+	}
+	if out.Count != 1 || len(out.Tools) != 1 {
+		t.Fatalf("got %s, want one tool", body)
+	}
+	tool := out.Tools[0]
+	if tool.Type != "function" || tool.Function.Name != "lookup" {
+		t.Errorf("got tool %+v, want function lookup", tool)
+	}
+	if params, _ := json.Marshal(tool.Function.Parameters); string(params) != string(schema) {
+		t.Errorf("got parameters %s, want %s", params, schema)
+	}
+	if strings.Contains(string(body), "inputSchema") {
+		t.Errorf("OpenAI format %s contains the raw MCP inputSchema", body)
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// Moderator inspects a request's prompt text before execution.
+type Moderator interface {
+	// Moderate returns a non-empty reason when the prompt must be rejected.
+	Moderate(ctx context.Context, prompt string) (string, error)
+}
+
+// NoopModerator allows every request. It is the default.
+type NoopModerator struct{}
+
+// Moderate always allows the prompt.
+func (NoopModerator) Moderate(ctx context.Context, prompt string) (string, error) {
+	return "", nil
+}
+
+// HTTPModerator calls an external moderation endpoint that accepts
+// {"input": "..."} and answers in the OpenAI moderation response format.
+type HTTPModerator struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPModerator creates a moderator that posts prompts to url.
+func NewHTTPModerator(url string, timeout time.Duration) *HTTPModerator {
+	return &HTTPModerator{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// moderationResponse is the subset of the OpenAI moderation response we use.
+type moderationResponse struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+}
+
+// Moderate posts the prompt to the moderation endpoint and reports flagged categories.
+func (m *HTTPModerator) Moderate(ctx context.Context, prompt string) (string, error) {
+	body, err := json.Marshal(map[string]string{"input": prompt})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal moderation request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create moderation request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("moderation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("moderation endpoint returned status %d", resp.StatusCode)
+	}
+
+	var result moderationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse moderation response: %w", err)
+	}
+
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		var categories []string
+		for name, flagged := range r.Categories {
+			if flagged {
+				categories = append(categories, name)
+			}
+		}
+		sort.Strings(categories)
+		if len(categories) == 0 {
+			return "flagged by moderation", nil
+		}
+		return "flagged for " + strings.Join(categories, ", "), nil
+	}
+
+	return "", nil
+}
+
+// SetModerator replaces the request moderator.
+func (h *ChatCompletionsHandler) SetModerator(m Moderator) {
+	h.moderator = m
+}
+
+// moderate runs the configured moderator over the request's message text.
+func (h *ChatCompletionsHandler) moderate(ctx context.Context, req *models.ChatCompletionRequest) *completionError {
+	if h.moderator == nil {
+		return nil
+	}
+
+	var parts []string
+	for _, msg := range req.Messages {
+		if text := msg.GetTextContent(); text != "" {
+			parts = append(parts, text)
+		}
+	}
+
+	reason, err := h.moderator.Moderate(ctx, strings.Join(parts, "\n"))
+	if err != nil {
+		h.logger.Error("moderation check failed", "error", err.Error())
+		return &completionError{
+			status: fiber.StatusBadGateway,
+			metric: "moderation_error",
+			detail: models.ErrorDetail{
+				Message: "Moderation check failed",
+				Type:    "server_error",
+				Code:    "moderation_error",
+			},
+		}
+	}
+
+	if reason != "" {
+		h.logger.Warn("request rejected by moderation", "reason", reason, "safety_identifier", boundSafetyIdentifier(req.SafetyIdentifier))
+		return &completionError{
+			status: fiber.StatusBadRequest,
+			metric: "content_policy_violation",
+			detail: models.ErrorDetail{
+				Message: "Request rejected by content policy: " + reason,
+				Type:    "invalid_request_error",
+				Code:    "content_policy_violation",
+			},
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// keywordModerator rejects prompts containing keyword.
+type keywordModerator struct {
+	keyword string
+}
+
+func (m keywordModerator) Moderate(ctx context.Context, prompt string) (string, error) {
+	if strings.Contains(prompt, m.keyword) {
+		return "contains " + m.keyword, nil
+	}
+	return "", nil
+}
+
+func TestHandle_ModeratorBlocksKeyword(t *testing.T) {
+	calls := 0
+	h := newTestHandler(&fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			calls++
+			return resultJSON("ok"), nil
+		},
+	})
+	h.SetModerator(keywordModerator{keyword: "forbidden"})
+	app := appFor(h)
+
+	resp, body := postChat(t, app, `{"model":"m","messages":[{"role":"user","content":"say something forbidden"}]}`)
+	if resp.StatusCode != 400 {
+		t.Fatalf("got status %d, want 400", resp.StatusCode)
+	}
+	var errResp models.ErrorResponse
+	if err := json.Unmarshal([]byte(body), &errResp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if errResp.Error.Code != "content_policy_violation" {
+		t.Errorf("got code %q, want content_policy_violation", errResp.Error.Code)
+	}
+	if calls != 0 {
+		t.Errorf("executor called %d times for a blocked request", calls)
+	}
+
+	resp, _ = postChat(t, app, `{"model":"m","messages":[{"role":"user","content":"say something nice"}]}`)
+	if resp.StatusCode != 200 {
+		t.Errorf("got status %d for allowed request, want 200", resp.StatusCode)
+	}
+}
+
+func TestHTTPModerator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input string `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		flagged := strings.Contains(req.Input, "bad")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"results": []map[string]any{{
+				"flagged":    flagged,
+				"categories": map[string]bool{"violence": flagged, "hate": false},
+			}},
+		})
+	}))
+	defer server.Close()
+
+	m := NewHTTPModerator(server.URL, time.Second)
+
+	reason, err := m.Moderate(context.Background(), "something bad")
+	if err != nil {
+		t.Fatalf("Moderate: %v", err)
+	}
+	if reason != "flagged for violence" {
+		t.Errorf("got reason %q, want %q", reason, "flagged for violence")
+	}
+
+	reason, err = m.Moderate(context.Background(), "something fine")
+	if err != nil || reason != "" {
+		t.Errorf("got (%q, %v), want allowed", reason, err)
+	}
+}
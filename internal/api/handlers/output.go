@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// OutputProcessor post-processes assistant text before it is returned.
+type OutputProcessor interface {
+	// Process transforms complete assistant content.
+	Process(text string) string
+	// Stream returns a processor for the deltas of one streamed choice.
+	Stream() OutputStream
+}
+
+// OutputStream post-processes streamed text incrementally. It may hold text
+// back until it is safe to emit.
+type OutputStream interface {
+	// Write accepts the next delta and returns the text ready to send.
+	Write(text string) string
+	// Flush returns any held-back text at the end of the stream.
+	Flush() string
+}
+
+// NoopOutputProcessor returns text unchanged. It is the default.
+type NoopOutputProcessor struct{}
+
+// Process returns text unchanged.
+func (NoopOutputProcessor) Process(text string) string { return text }
+
+// Stream returns a pass-through stream.
+func (NoopOutputProcessor) Stream() OutputStream { return noopOutputStream{} }
+
+type noopOutputStream struct{}
+
+func (noopOutputStream) Write(text string) string { return text }
+func (noopOutputStream) Flush() string            { return "" }
+
+// DefaultRedactWindow is the default length in bytes of the longest text the
+// redactor expects a single match to cover.
+const DefaultRedactWindow = 256
+
+// RegexRedactor replaces matches of a regular expression. When streaming it
+// holds back the last window bytes so that matches split across deltas are
+// still replaced; matches longer than window may be missed.
+type RegexRedactor struct {
+	re          *regexp.Regexp
+	replacement string
+	window      int
+}
+
+// NewRegexRedactor creates a redactor replacing matches of pattern with
+// replacement, which may reference groups as in regexp.Expand.
+func NewRegexRedactor(pattern, replacement string, window int) (*RegexRedactor, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redaction pattern: %w", err)
+	}
+	if window <= 0 {
+		window = DefaultRedactWindow
+	}
+	return &RegexRedactor{re: re, replacement: replacement, window: window}, nil
+}
+
+// Process replaces every match in text.
+func (r *RegexRedactor) Process(text string) string {
+	return r.re.ReplaceAllString(text, r.replacement)
+}
+
+// Stream returns a redacting stream.
+func (r *RegexRedactor) Stream() OutputStream {
+	return &redactStream{redactor: r}
+}
+
+type redactStream struct {
+	redactor *RegexRedactor
+	buf      string
+}
+
+// Write emits everything except the last window bytes of buffered text,
+// extending the cut past any match that straddles it.
+func (s *redactStream) Write(text string) string {
+	s.buf += text
+	cut := len(s.buf) - s.redactor.window
+	if cut <= 0 {
+		return ""
+	}
+	for cut > 0 && !utf8.RuneStart(s.buf[cut]) {
+		cut--
+	}
+
+	matches := s.redactor.re.FindAllStringSubmatchIndex(s.buf, -1)
+	for _, m := range matches {
+		if m[0] < cut && m[1] > cut {
+			cut = m[1]
+		}
+	}
+
+	var out []byte
+	last := 0
+	for _, m := range matches {
+		if m[0] >= cut || m[1] == m[0] {
+			continue
+		}
+		out = append(out, s.buf[last:m[0]]...)
+		out = s.redactor.re.ExpandString(out, s.redactor.replacement, s.buf, m)
+		last = m[1]
+	}
+	out = append(out, s.buf[last:cut]...)
+	s.buf = s.buf[cut:]
+	return string(out)
+}
+
+// Flush redacts and returns the remaining buffered text.
+func (s *redactStream) Flush() string {
+	out := s.redactor.Process(s.buf)
+	s.buf = ""
+	return out
+}
+
+// SetOutputProcessor replaces the assistant output post-processor.
+func (h *ChatCompletionsHandler) SetOutputProcessor(p OutputProcessor) {
+	h.output = p
+}
+
+// processOutput sanitizes control characters and applies the output
+// post-processor to each choice's content.
+func (h *ChatCompletionsHandler) processOutput(resp *models.ChatCompletionResponse) {
+	for i := range resp.Choices {
+		text, ok := resp.Choices[i].Message.Content.(string)
+		if !ok || text == "" {
+			continue
+		}
+		if h.sanitizer != nil {
+			text = h.sanitizer.Process(text)
+		}
+		if h.output != nil {
+			text = h.output.Process(text)
+		}
+		resp.Choices[i].Message.Content = text
+	}
+}
+
+// outputStream returns the sanitizer and post-processor for one streamed choice.
+func (h *ChatCompletionsHandler) outputStream() OutputStream {
+	var stream OutputStream = noopOutputStream{}
+	if h.output != nil {
+		stream = h.output.Stream()
+	}
+	if h.sanitizer == nil {
+		return stream
+	}
+	return &chainedStream{first: h.sanitizer.Stream(), second: stream}
+}
+
+// chainedStream feeds the output of one stream into another.
+type chainedStream struct {
+	first, second OutputStream
+}
+
+func (s *chainedStream) Write(text string) string {
+	return s.second.Write(s.first.Write(text))
+}
+
+func (s *chainedStream) Flush() string {
+	return s.second.Write(s.first.Flush()) + s.second.Flush()
+}
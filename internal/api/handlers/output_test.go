@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+const secretPattern = `sk-[A-Za-z0-9]{8}`
+
+func TestRegexRedactor_Stream(t *testing.T) {
+	redactor, err := NewRegexRedactor(secretPattern, "[REDACTED]", 16)
+	if err != nil {
+		t.Fatalf("NewRegexRedactor: %v", err)
+	}
+	text := "key sk-abcd1234 and sk-WXYZ9876, done. héllo"
+	want := "key [REDACTED] and [REDACTED], done. héllo"
+
+	if got := redactor.Process(text); got != want {
+		t.Fatalf("Process: got %q, want %q", got, want)
+	}
+
+	// Every split point and a byte-at-a-time stream must redact identically
+	var chunkings [][]string
+	for i := 1; i < len(text); i++ {
+		chunkings = append(chunkings, []string{text[:i], text[i:]})
+	}
+	var bytewise []string
+	for i := 0; i < len(text); i++ {
+		bytewise = append(bytewise, text[i:i+1])
+	}
+	chunkings = append(chunkings, bytewise)
+
+	for _, chunks := range chunkings {
+		stream := redactor.Stream()
+		var sb strings.Builder
+		for _, chunk := range chunks {
+			sb.WriteString(stream.Write(chunk))
+		}
+		sb.WriteString(stream.Flush())
+		if got := sb.String(); got != want {
+			t.Errorf("chunks %q: got %q, want %q", chunks, got, want)
+		}
+	}
+}
+
+func TestRegexRedactor_GroupReplacement(t *testing.T) {
+	redactor, err := NewRegexRedactor(`user=(\w+)`, "user=<$1>", 0)
+	if err != nil {
+		t.Fatalf("NewRegexRedactor: %v", err)
+	}
+	stream := redactor.Stream()
+	got := stream.Write("user=al") + stream.Write("ice!") + stream.Flush()
+	if want := "user=<alice>!"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNewRegexRedactor_InvalidPattern(t *testing.T) {
+	if _, err := NewRegexRedactor("(", "x", 0); err == nil {
+		t.Error("got nil error for an invalid pattern, want error")
+	}
+}
+
+func TestHandle_OutputRedaction(t *testing.T) {
+	t.Setenv("CLAUDEX_OUTPUT_REDACT_PATTERN", secretPattern)
+
+	t.Run("non-streaming", func(t *testing.T) {
+		exec := &fakeExecutor{
+			execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+				return resultJSON("your key is sk-abcd1234"), nil
+			},
+		}
+		_, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`)
+		if strings.Contains(body, "sk-abcd1234") || !strings.Contains(body, "your key is [REDACTED]") {
+			t.Errorf("got body %s, want the key redacted", body)
+		}
+	})
+
+	t.Run("streaming across chunk boundaries", func(t *testing.T) {
+		exec := &fakeExecutor{
+			stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+				chunks, errChan := streamOf([]string{deltaLine("your key is sk-ab"), deltaLine("cd12"), deltaLine("34, keep it safe")}, nil)
+				return chunks, errChan, nil
+			},
+		}
+		_, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+
+		var content string
+		roleChunks := 0
+		for _, chunk := range sseChunks(t, body) {
+			if chunk.Choices[0].Delta.Role != "" {
+				roleChunks++
+			}
+			content += chunk.Choices[0].Delta.Content
+		}
+		if want := "your key is [REDACTED], keep it safe"; content != want {
+			t.Errorf("got content %q, want %q", content, want)
+		}
+		if roleChunks != 1 {
+			t.Errorf("got %d role chunks, want 1", roleChunks)
+		}
+	})
+}
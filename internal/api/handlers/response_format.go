@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/jsonschema"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// maxReportedSchemaErrors caps the schema violations included in retry
+// instructions and error messages.
+const maxReportedSchemaErrors = 5
+
+// wantsJSON reports whether the request asks for a JSON response.
+func wantsJSON(req *models.ChatCompletionRequest) bool {
+	rf := req.ResponseFormat
+	return rf != nil && (rf.Type == "json_object" || rf.Type == "json_schema")
+}
+
+// strictSchema returns the schema that responses must satisfy, or nil when
+// the request does not ask for strict json_schema output.
+func strictSchema(req *models.ChatCompletionRequest) *jsonschema.Schema {
+	rf := req.ResponseFormat
+	if rf == nil || rf.Type != "json_schema" || rf.JSONSchema == nil || !rf.JSONSchema.Strict {
+		return nil
+	}
+	schema, err := jsonschema.Parse(rf.JSONSchema.Schema)
+	if err != nil {
+		return nil
+	}
+	return schema
+}
+
+// extractJSON returns content without surrounding whitespace or a markdown
+// code fence, if what remains is valid JSON. Otherwise content is returned
+// unchanged.
+func extractJSON(content string) string {
+	trimmed := strings.TrimSpace(content)
+	if rest, ok := strings.CutPrefix(trimmed, "```"); ok {
+		if nl := strings.IndexByte(rest, '\n'); nl >= 0 {
+			rest = rest[nl+1:]
+		}
+		trimmed = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(rest), "```"))
+	}
+	if json.Valid([]byte(trimmed)) {
+		return trimmed
+	}
+	return content
+}
+
+// normalizeJSONResponse strips code fences around JSON responses.
+func normalizeJSONResponse(resp *models.ChatCompletionResponse) {
+	for i := range resp.Choices {
+		msg := &resp.Choices[i].Message
+		if len(msg.ToolCalls) > 0 {
+			continue
+		}
+		msg.Content = extractJSON(msg.GetTextContent())
+	}
+}
+
+// schemaErrors returns the schema violations of the response's first choice.
+func schemaErrors(schema *jsonschema.Schema, resp *models.ChatCompletionResponse) []string {
+	if len(resp.Choices) == 0 {
+		return []string{"$: response has no choices"}
+	}
+	errs := schema.ValidateJSON([]byte(resp.Choices[0].Message.GetTextContent()))
+	if len(errs) > maxReportedSchemaErrors {
+		errs = errs[:maxReportedSchemaErrors]
+	}
+	return errs
+}
+
+// enforceResponseSchema validates a strict json_schema response, retrying up
+// to CLAUDEX_SCHEMA_RETRIES times with the violations before failing.
+func (h *ChatCompletionsHandler) enforceResponseSchema(ctx context.Context, req *models.ChatCompletionRequest, schema *jsonschema.Schema, resp *models.ChatCompletionResponse) (*models.ChatCompletionResponse, *completionError) {
+	errs := schemaErrors(schema, resp)
+	for attempt := 0; len(errs) > 0 && attempt < getSchemaRetries(); attempt++ {
+		h.logger.Warn("response does not match json_schema, retrying", "model", req.Model, "attempt", attempt+1, "errors", errs)
+
+		retryReq := *req
+		retryReq.Messages = append(append([]models.Message{}, req.Messages...), models.Message{
+			Role: "system",
+			Content: "Your previous response did not match the required JSON schema:\n- " + strings.Join(errs, "\n- ") +
+				"\nRespond again with only JSON that conforms to the schema.",
+		})
+		retryResp, cerr := h.runCompletion(ctx, &retryReq)
+		if cerr != nil {
+			return nil, cerr
+		}
+		normalizeJSONResponse(retryResp)
+		resp, errs = retryResp, schemaErrors(schema, retryResp)
+	}
+	if len(errs) == 0 {
+		return resp, nil
+	}
+
+	h.logger.Warn("response does not match json_schema", "model", req.Model, "errors", errs)
+	return nil, &completionError{
+		status: fiber.StatusBadGateway,
+		metric: "schema_violation",
+		detail: models.ErrorDetail{
+			Message: fmt.Sprintf("response does not match response_format json_schema: %s", strings.Join(errs, "; ")),
+			Type:    "server_error",
+			Code:    "schema_violation",
+		},
+	}
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+const weatherFormat = `"response_format":{"type":"json_schema","json_schema":{"name":"weather","strict":true,"schema":{
+	"type":"object",
+	"properties":{"city":{"type":"string"},"temp_c":{"type":"number"}},
+	"required":["city","temp_c"],
+	"additionalProperties":false}}}`
+
+func TestValidateResponseFormat(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    *models.ResponseFormat
+		wantParam string
+	}{
+		{name: "absent"},
+		{name: "text", format: &models.ResponseFormat{Type: "text"}},
+		{name: "json_object", format: &models.ResponseFormat{Type: "json_object"}},
+		{name: "json_schema", format: &models.ResponseFormat{Type: "json_schema", JSONSchema: &models.JSONSchemaFormat{Name: "s", Schema: []byte(`{"type":"object"}`)}}},
+		{name: "unknown type", format: &models.ResponseFormat{Type: "xml"}, wantParam: "response_format.type"},
+		{name: "json_schema without schema", format: &models.ResponseFormat{Type: "json_schema"}, wantParam: "response_format.json_schema.schema"},
+		{name: "json_schema with non-object schema", format: &models.ResponseFormat{Type: "json_schema", JSONSchema: &models.JSONSchemaFormat{Schema: []byte(`[1]`)}}, wantParam: "response_format.json_schema.schema"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detail := validateResponseFormat(tt.format)
+			if tt.wantParam == "" {
+				if detail != nil {
+					t.Fatalf("got error %+v, want nil", detail)
+				}
+				return
+			}
+			if detail == nil {
+				t.Fatalf("got nil, want error for %s", tt.wantParam)
+			}
+			if detail.Code != "invalid_response_format" || detail.Param != tt.wantParam {
+				t.Errorf("got code %q param %q, want invalid_response_format %q", detail.Code, detail.Param, tt.wantParam)
+			}
+		})
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{content: `{"a":1}`, want: `{"a":1}`},
+		{content: "  {\"a\":1}\n", want: `{"a":1}`},
+		{content: "```json\n{\"a\":1}\n```", want: `{"a":1}`},
+		{content: "```\n[1, 2]\n```\n", want: `[1, 2]`},
+		{content: "Here you go: {\"a\":1}", want: "Here you go: {\"a\":1}"},
+	}
+
+	for _, tt := range tests {
+		if got := extractJSON(tt.content); got != tt.want {
+			t.Errorf("extractJSON(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestHandle_StrictJSONSchema(t *testing.T) {
+	tests := []struct {
+		name        string
+		retries     string
+		outputs     []string
+		wantStatus  int
+		wantCalls   int
+		wantContent string
+	}{
+		{
+			name:        "valid output",
+			outputs:     []string{"```json\n{\"city\":\"Paris\",\"temp_c\":21.5}\n```"},
+			wantStatus:  200,
+			wantCalls:   1,
+			wantContent: `{"city":"Paris","temp_c":21.5}`,
+		},
+		{
+			name:        "retry fixes output",
+			outputs:     []string{`{"city":"Paris"}`, `{"city":"Paris","temp_c":21.5}`},
+			wantStatus:  200,
+			wantCalls:   2,
+			wantContent: `{"city":"Paris","temp_c":21.5}`,
+		},
+		{
+			name:       "still invalid after retry",
+			outputs:    []string{`{"city":"Paris"}`, `{"city":"Paris","temp_c":"warm"}`},
+			wantStatus: 502,
+			wantCalls:  2,
+		},
+		{
+			name:       "no retries",
+			retries:    "0",
+			outputs:    []string{"not json"},
+			wantStatus: 502,
+			wantCalls:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_SCHEMA_RETRIES", tt.retries)
+
+			calls := 0
+			var lastMessages []models.Message
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					output := tt.outputs[min(calls, len(tt.outputs)-1)]
+					calls++
+					lastMessages = req.Messages
+					return resultJSON(output), nil
+				},
+			}
+
+			resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","messages":[{"role":"user","content":"weather in Paris"}],`+weatherFormat+`}`)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("got %d executor calls, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantStatus != 200 {
+				if !strings.Contains(body, "schema_violation") {
+					t.Errorf("got body %s, want schema_violation", body)
+				}
+				return
+			}
+			if !strings.Contains(body, strings.ReplaceAll(tt.wantContent, `"`, `\"`)) {
+				t.Errorf("got body %s, want content %s", body, tt.wantContent)
+			}
+			if calls > 1 && !strings.Contains(lastMessages[len(lastMessages)-1].GetTextContent(), `missing required property "temp_c"`) {
+				t.Errorf("retry instruction %q does not report the violation", lastMessages[len(lastMessages)-1].GetTextContent())
+			}
+		})
+	}
+}
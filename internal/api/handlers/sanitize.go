@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxEscapeSequence bounds how long an unterminated ANSI sequence is held
+// back while streaming before its introducer is treated as a bare ESC.
+const maxEscapeSequence = 64
+
+// ControlCharSanitizer removes or escapes control characters and ANSI escape
+// sequences in assistant text. Tabs, newlines, and CRLF line endings are kept;
+// a bare CR is treated as a control character because SSE clients read it as
+// a line break.
+type ControlCharSanitizer struct {
+	mode string
+}
+
+// NewControlCharSanitizer creates a sanitizer for one of the ControlChars modes.
+func NewControlCharSanitizer(mode string) *ControlCharSanitizer {
+	return &ControlCharSanitizer{mode: mode}
+}
+
+// Process sanitizes complete assistant content.
+func (s *ControlCharSanitizer) Process(text string) string {
+	out, _ := s.sanitize(text, true)
+	return out
+}
+
+// Stream returns a sanitizing stream.
+func (s *ControlCharSanitizer) Stream() OutputStream {
+	if s.mode == ControlCharsPassthrough {
+		return noopOutputStream{}
+	}
+	return &sanitizeStream{sanitizer: s}
+}
+
+type sanitizeStream struct {
+	sanitizer *ControlCharSanitizer
+	held      string
+}
+
+// Write sanitizes text, holding back a trailing sequence that may continue
+// in the next delta.
+func (s *sanitizeStream) Write(text string) string {
+	out, rest := s.sanitizer.sanitize(s.held+text, false)
+	s.held = rest
+	return out
+}
+
+// Flush sanitizes and returns the held-back text.
+func (s *sanitizeStream) Flush() string {
+	out, _ := s.sanitizer.sanitize(s.held, true)
+	s.held = ""
+	return out
+}
+
+// sanitize returns the sanitized text and, unless final, the unprocessed
+// suffix that needs more input to decide.
+func (s *ControlCharSanitizer) sanitize(text string, final bool) (string, string) {
+	if s.mode == ControlCharsPassthrough {
+		return text, ""
+	}
+	var sb strings.Builder
+	for i := 0; i < len(text); {
+		if !final && !utf8.FullRuneInString(text[i:]) {
+			return sb.String(), text[i:]
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case r == '\x1b' && s.mode == ControlCharsStrip:
+			n := escapeSequenceLen(text[i:])
+			if n < 0 {
+				if !final {
+					return sb.String(), text[i:]
+				}
+				n = 1
+			}
+			i += n
+			continue
+		case r == '\r':
+			if i+1 == len(text) && !final {
+				return sb.String(), text[i:]
+			}
+			if i+1 < len(text) && text[i+1] == '\n' {
+				sb.WriteByte('\r')
+			} else {
+				s.replace(&sb, r)
+			}
+		case r == '\t' || r == '\n':
+			sb.WriteRune(r)
+		case isControlChar(r):
+			s.replace(&sb, r)
+		default:
+			sb.WriteString(text[i : i+size])
+		}
+		i += size
+	}
+	return sb.String(), ""
+}
+
+// replace writes the stand-in for a control character: nothing when
+// stripping, or a visible \xNN or \uNNNN escape.
+func (s *ControlCharSanitizer) replace(sb *strings.Builder, r rune) {
+	if s.mode != ControlCharsEscape {
+		return
+	}
+	if r < 0x80 {
+		fmt.Fprintf(sb, `\x%02x`, r)
+	} else {
+		fmt.Fprintf(sb, `\u%04x`, r)
+	}
+}
+
+// isControlChar reports whether r is a C0 or C1 control character or DEL.
+func isControlChar(r rune) bool {
+	return r < 0x20 || r == 0x7f || (r >= 0x80 && r <= 0x9f)
+}
+
+// escapeSequenceLen returns the length of the ANSI escape sequence at the
+// start of text, which begins with ESC, or -1 if it may be incomplete.
+func escapeSequenceLen(text string) int {
+	if len(text) < 2 {
+		return -1
+	}
+	switch text[1] {
+	case '[': // CSI: parameter and intermediate bytes, then a final byte
+		for i := 2; i < len(text) && i < maxEscapeSequence; i++ {
+			c := text[i]
+			if c >= 0x40 && c <= 0x7e {
+				return i + 1
+			}
+			if c < 0x20 || c > 0x3f {
+				return i
+			}
+		}
+	case ']': // OSC: terminated by BEL or ESC \
+		for i := 2; i < len(text) && i < maxEscapeSequence; i++ {
+			if text[i] == '\a' {
+				return i + 1
+			}
+			if text[i] == '\x1b' && i+1 < len(text) && text[i+1] == '\\' {
+				return i + 2
+			}
+		}
+	default:
+		if text[1] < 0x80 {
+			return 2
+		}
+		return 1
+	}
+	if len(text) >= maxEscapeSequence {
+		return 2
+	}
+	return -1
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestControlCharSanitizer(t *testing.T) {
+	text := "a\x00b\x1b[31mred\x1b[0m\tc\r\nd\re\x1b]0;title\x07f\x7fg\u0085h ü"
+
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{mode: ControlCharsStrip, want: "abred\tc\r\ndefgh ü"},
+		{mode: ControlCharsEscape, want: `a\x00b\x1b[31mred\x1b[0m` + "\tc\r\nd" + `\x0de\x1b]0;title\x07f\x7fg\u0085h ü`},
+		{mode: ControlCharsPassthrough, want: text},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			s := NewControlCharSanitizer(tt.mode)
+			if got := s.Process(text); got != tt.want {
+				t.Fatalf("Process: got %q, want %q", got, tt.want)
+			}
+
+			// Streaming must match at every split point
+			for i := 1; i < len(text); i++ {
+				stream := s.Stream()
+				got := stream.Write(text[:i]) + stream.Write(text[i:]) + stream.Flush()
+				if got != tt.want {
+					t.Errorf("split at %d: got %q, want %q", i, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestControlCharSanitizer_UnterminatedSequence(t *testing.T) {
+	s := NewControlCharSanitizer(ControlCharsStrip)
+	stream := s.Stream()
+	got := stream.Write("x\x1b[12") + stream.Flush()
+	if got != "x[12" {
+		t.Errorf("got %q, want %q", got, "x[12")
+	}
+}
+
+func TestGetControlCharsMode(t *testing.T) {
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{env: "", want: ControlCharsStrip},
+		{env: "escape", want: ControlCharsEscape},
+		{env: "passthrough", want: ControlCharsPassthrough},
+		{env: "bogus", want: ControlCharsStrip},
+	}
+	for _, tt := range tests {
+		t.Setenv("CLAUDEX_CONTROL_CHARS_MODE", tt.env)
+		if got := getControlCharsMode(); got != tt.want {
+			t.Errorf("env %q: got %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestHandle_ControlCharsStripped(t *testing.T) {
+	t.Run("non-streaming", func(t *testing.T) {
+		exec := &fakeExecutor{
+			execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+				return resultJSON("ok\x00 \x1b[1mbold\x1b[0m"), nil
+			},
+		}
+		_, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`)
+		if !strings.Contains(body, `"ok bold"`) {
+			t.Errorf("got body %s, want control characters stripped", body)
+		}
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		exec := &fakeExecutor{
+			stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+				chunks, errChan := streamOf([]string{deltaLine("ok\x00 \x1b["), deltaLine("1mbold\x1b[0m")}, nil)
+				return chunks, errChan, nil
+			},
+		}
+		_, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+		var content string
+		for _, chunk := range sseChunks(t, body) {
+			content += chunk.Choices[0].Delta.Content
+		}
+		if content != "ok bold" {
+			t.Errorf("got content %q, want %q", content, "ok bold")
+		}
+	})
+}
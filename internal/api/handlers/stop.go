@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// maxStopSequences is the most stop sequences a request may set, as in the
+// OpenAI API.
+const maxStopSequences = 4
+
+// validateStop checks the number of stop sequences and that none is empty.
+func validateStop(stop models.StopSequences) *models.ErrorDetail {
+	if len(stop) > maxStopSequences {
+		return invalidRequest("stop", "invalid_parameter",
+			fmt.Sprintf("stop may hold at most %d sequences, got %d", maxStopSequences, len(stop)))
+	}
+	for i, seq := range stop {
+		if seq == "" {
+			return invalidRequest("stop", "invalid_parameter",
+				fmt.Sprintf("stop[%d] must not be empty", i))
+		}
+	}
+	return nil
+}
+
+// cutAtStop returns text up to the earliest stop sequence in it, and whether
+// one was found.
+func cutAtStop(text string, stop []string) (string, bool) {
+	cut := -1
+	for _, seq := range stop {
+		if i := strings.Index(text, seq); i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+		}
+	}
+	if cut < 0 {
+		return text, false
+	}
+	return text[:cut], true
+}
+
+// applyStop cuts each choice's content at the request's earliest stop
+// sequence. A cut choice finishes with "stop". Choices with tool calls are
+// left as they are, so clients still see finish_reason "tool_calls".
+func applyStop(req *models.ChatCompletionRequest, resp *models.ChatCompletionResponse) {
+	if len(req.Stop) == 0 {
+		return
+	}
+	for i := range resp.Choices {
+		if len(resp.Choices[i].Message.ToolCalls) > 0 {
+			continue
+		}
+		text, ok := resp.Choices[i].Message.Content.(string)
+		if !ok {
+			continue
+		}
+		if cut, found := cutAtStop(text, req.Stop); found {
+			resp.Choices[i].Message.Content = cut
+			resp.Choices[i].FinishReason = "stop"
+		}
+	}
+}
+
+// stopStream cuts streamed text at the first stop sequence. It holds back
+// the longest tail of the text that could begin a stop sequence, so a
+// sequence split across deltas is never sent. Once a sequence is found,
+// everything from it on is dropped.
+type stopStream struct {
+	stop    []string
+	buf     string
+	matched bool
+}
+
+func newStopStream(stop []string) *stopStream {
+	return &stopStream{stop: stop}
+}
+
+// Write emits the text that cannot be part of a stop sequence.
+func (s *stopStream) Write(text string) string {
+	if s.matched {
+		return ""
+	}
+	s.buf += text
+	if cut, found := cutAtStop(s.buf, s.stop); found {
+		s.matched = true
+		s.buf = ""
+		return cut
+	}
+
+	hold := 0
+	for _, seq := range s.stop {
+		for n := min(len(seq)-1, len(s.buf)); n > hold; n-- {
+			if strings.HasSuffix(s.buf, seq[:n]) {
+				hold = n
+				break
+			}
+		}
+	}
+	out := s.buf[:len(s.buf)-hold]
+	s.buf = s.buf[len(s.buf)-hold:]
+	return out
+}
+
+// Flush returns the held-back text, which did not turn out to be a stop
+// sequence.
+func (s *stopStream) Flush() string {
+	out := s.buf
+	s.buf = ""
+	return out
+}
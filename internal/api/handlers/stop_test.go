@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestStopStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		stop   []string
+		deltas []string
+		want   string
+	}{
+		{name: "no match", stop: []string{"END"}, deltas: []string{"all ", "E", "xtra"}, want: "all Extra"},
+		{name: "within a delta", stop: []string{"END"}, deltas: []string{"done END ignored", " more"}, want: "done "},
+		{name: "spans deltas", stop: []string{"END"}, deltas: []string{"Hello EN", "D world"}, want: "Hello "},
+		{name: "spans three deltas", stop: []string{"\n\nUser:"}, deltas: []string{"Hi.\n", "\nUs", "er: next"}, want: "Hi."},
+		{name: "earliest of several", stop: []string{"zz", "b"}, deltas: []string{"aab", "zz"}, want: "aa"},
+		{name: "held tail released at end", stop: []string{"END"}, deltas: []string{"the EN"}, want: "the EN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := newStopStream(tt.stop)
+			var sent []string
+			for _, delta := range tt.deltas {
+				sent = append(sent, stream.Write(delta))
+			}
+			sent = append(sent, stream.Flush())
+
+			if got := strings.Join(sent, ""); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleStreaming_StopSequence(t *testing.T) {
+	var cancelled bool
+	exec := &fakeExecutor{
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			chunks := make(chan string)
+			errChan := make(chan error, 1)
+			go func() {
+				defer close(chunks)
+				defer close(errChan)
+				for _, text := range []string{"The answer is 4", "2.\nEN", "D of answer", " and more"} {
+					select {
+					case chunks <- deltaLine(text):
+					case <-ctx.Done():
+						cancelled = true
+						errChan <- ctx.Err()
+						return
+					}
+				}
+				<-ctx.Done()
+				cancelled = true
+				errChan <- ctx.Err()
+			}()
+			return chunks, errChan, nil
+		},
+	}
+
+	_, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","stream":true,"stop":"END","messages":[{"role":"user","content":"hi"}]}`)
+
+	if strings.Contains(body, "EN") || strings.Contains(body, "of answer") {
+		t.Errorf("stop sequence or text after it sent to the client: %s", body)
+	}
+	if strings.Contains(body, `"error"`) {
+		t.Errorf("got an error event, want a clean finish: %s", body)
+	}
+	var content, finish string
+	for _, chunk := range sseChunks(t, body) {
+		content += chunk.Choices[0].Delta.Content
+		if reason := chunk.Choices[0].FinishReason; reason != "" {
+			finish = reason
+		}
+	}
+	if content != "The answer is 42.\n" {
+		t.Errorf("got content %q, want the text before the stop sequence", content)
+	}
+	if finish != "stop" {
+		t.Errorf("got finish_reason %q, want stop", finish)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(body), "data: [DONE]") {
+		t.Errorf("got body not ending in [DONE]: %s", body)
+	}
+	if !cancelled {
+		t.Error("CLI stream not cancelled after the stop sequence")
+	}
+}
+
+func TestHandleNonStreaming_StopSequence(t *testing.T) {
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			return resultJSON("one two\n###\nthree"), nil
+		},
+	}
+
+	tests := []struct {
+		name       string
+		stop       string
+		wantStatus int
+		want       string
+	}{
+		{name: "no stop", stop: `null`, wantStatus: 200, want: "one two\n###\nthree"},
+		{name: "string", stop: `"###"`, wantStatus: 200, want: "one two\n"},
+		{name: "array", stop: `["three","\n#"]`, wantStatus: 200, want: "one two"},
+		{name: "too many", stop: `["a","b","c","d","e"]`, wantStatus: 400},
+		{name: "empty sequence", stop: `[""]`, wantStatus: 400},
+		{name: "wrong type", stop: `42`, wantStatus: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","stop":`+tt.stop+`,"messages":[{"role":"user","content":"hi"}]}`)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantStatus != 200 {
+				return
+			}
+			var out models.ChatCompletionResponse
+			if err := json.Unmarshal([]byte(body), &out); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			if got := out.Choices[0].Message.Content; got != tt.want {
+				t.Errorf("got content %q, want %q", got, tt.want)
+			}
+			if got := out.Choices[0].FinishReason; got != "stop" {
+				t.Errorf("got finish_reason %q, want stop", got)
+			}
+		})
+	}
+}
+
+func TestStopSequence_WithTools(t *testing.T) {
+	output := `Checking END {"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{}"}}]}`
+	tools := `"tools":[{"type":"function","function":{"name":"get_weather","parameters":{"type":"object"}}}]`
+
+	t.Run("non-streaming keeps tool calls", func(t *testing.T) {
+		exec := &fakeExecutor{
+			execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+				return resultJSON(output), nil
+			},
+		}
+		_, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","stop":"END",`+tools+`,"messages":[{"role":"user","content":"weather?"}]}`)
+
+		var out models.ChatCompletionResponse
+		if err := json.Unmarshal([]byte(body), &out); err != nil {
+			t.Fatalf("unmarshal response %s: %v", body, err)
+		}
+		choice := out.Choices[0]
+		if len(choice.Message.ToolCalls) != 1 || choice.FinishReason != "tool_calls" {
+			t.Errorf("got %d tool calls with finish_reason %q, want 1 with tool_calls", len(choice.Message.ToolCalls), choice.FinishReason)
+		}
+	})
+
+	for _, mode := range []string{StreamToolCallsIncremental, StreamToolCallsBuffered} {
+		t.Run("streaming "+mode, func(t *testing.T) {
+			t.Setenv("CLAUDEX_STREAM_TOOL_CALLS", mode)
+			exec := &fakeExecutor{
+				stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+					chunks, errChan := streamOf([]string{deltaLine(output[:12]), deltaLine(output[12:60]), deltaLine(output[60:])}, nil)
+					return chunks, errChan, nil
+				},
+			}
+			_, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","stream":true,"stop":"END",`+tools+`,"messages":[{"role":"user","content":"weather?"}]}`)
+
+			var content, finish string
+			for _, chunk := range sseChunks(t, body) {
+				if len(chunk.Choices[0].Delta.ToolCalls) > 0 {
+					t.Errorf("got tool call delta after the stop sequence: %+v", chunk.Choices[0].Delta.ToolCalls)
+				}
+				content += chunk.Choices[0].Delta.Content
+				if reason := chunk.Choices[0].FinishReason; reason != "" {
+					finish = reason
+				}
+			}
+			if content != "Checking " || finish != "stop" {
+				t.Errorf("got content %q with finish_reason %q, want the text before the stop sequence and stop", content, finish)
+			}
+		})
+	}
+}
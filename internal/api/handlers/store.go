@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// ErrCompletionNotFound is returned by a CompletionStore when no completion
+// has the requested ID.
+var ErrCompletionNotFound = errors.New("completion not found")
+
+// CompletionStore persists completions requested with "store": true so they
+// can be retrieved from GET /v1/chat/completions/{id}.
+type CompletionStore interface {
+	Save(ctx context.Context, completion *models.StoredCompletion) error
+	Get(ctx context.Context, id string) (*models.StoredCompletion, error)
+}
+
+// storeIDPattern matches the completion IDs that may be used as file names.
+var storeIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// FileCompletionStore keeps one JSON file per completion in a directory.
+type FileCompletionStore struct {
+	dir string
+}
+
+// NewFileCompletionStore creates a store in dir, creating it if needed.
+func NewFileCompletionStore(dir string) (*FileCompletionStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+	return &FileCompletionStore{dir: dir}, nil
+}
+
+// Save writes the completion to <dir>/<id>.json.
+func (s *FileCompletionStore) Save(ctx context.Context, completion *models.StoredCompletion) error {
+	if !storeIDPattern.MatchString(completion.ID) {
+		return fmt.Errorf("invalid completion id %q", completion.ID)
+	}
+	data, err := json.Marshal(completion)
+	if err != nil {
+		return fmt.Errorf("failed to marshal completion: %w", err)
+	}
+
+	// Write atomically so readers never see a partial file
+	tmp, err := os.CreateTemp(s.dir, completion.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write completion: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write completion: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write completion: %w", err)
+	}
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, completion.ID+".json"))
+}
+
+// Get reads a completion by ID.
+func (s *FileCompletionStore) Get(ctx context.Context, id string) (*models.StoredCompletion, error) {
+	if !storeIDPattern.MatchString(id) {
+		return nil, ErrCompletionNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCompletionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read completion: %w", err)
+	}
+	var completion models.StoredCompletion
+	if err := json.Unmarshal(data, &completion); err != nil {
+		return nil, fmt.Errorf("failed to parse stored completion: %w", err)
+	}
+	return &completion, nil
+}
+
+// HTTPCompletionStore sends completions to an external service: Save posts
+// the completion to the base URL and Get fetches <url>/<id>.
+type HTTPCompletionStore struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPCompletionStore creates a store backed by the service at url.
+func NewHTTPCompletionStore(url string, timeout time.Duration) *HTTPCompletionStore {
+	return &HTTPCompletionStore{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Save posts the completion as JSON.
+func (s *HTTPCompletionStore) Save(ctx context.Context, completion *models.StoredCompletion) error {
+	body, err := json.Marshal(completion)
+	if err != nil {
+		return fmt.Errorf("failed to marshal completion: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create store request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("store request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("store endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Get fetches a completion by ID.
+func (s *HTTPCompletionStore) Get(ctx context.Context, id string) (*models.StoredCompletion, error) {
+	endpoint, err := url.JoinPath(s.url, url.PathEscape(id))
+	if err != nil {
+		return nil, fmt.Errorf("invalid store url: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create store request: %w", err)
+	}
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("store request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrCompletionNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("store endpoint returned status %d", resp.StatusCode)
+	}
+
+	var completion models.StoredCompletion
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return nil, fmt.Errorf("failed to parse stored completion: %w", err)
+	}
+	return &completion, nil
+}
+
+// SetCompletionStore replaces the store used for "store": true requests.
+// A nil store disables storage.
+func (h *ChatCompletionsHandler) SetCompletionStore(store CompletionStore) {
+	h.store = store
+}
+
+// storeCompletion persists a completion when the request asked for it.
+// Failures are logged and do not affect the response.
+func (h *ChatCompletionsHandler) storeCompletion(ctx context.Context, req *models.ChatCompletionRequest, resp *models.ChatCompletionResponse) {
+	if !req.Store || h.store == nil {
+		return
+	}
+	completion := &models.StoredCompletion{
+		ChatCompletionResponse: *resp,
+		Metadata:               req.Metadata,
+		Request:                req,
+	}
+	if err := h.store.Save(ctx, completion); err != nil {
+		h.logger.Error("failed to store completion", "id", resp.ID, "error", err.Error())
+	}
+}
+
+// HandleGetCompletion returns a stored completion by ID.
+func (h *ChatCompletionsHandler) HandleGetCompletion(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if h.store == nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: models.ErrorDetail{
+				Message: "Completion storage is not enabled",
+				Type:    "invalid_request_error",
+				Code:    "not_found",
+			},
+		})
+	}
+
+	completion, err := h.store.Get(c.Context(), id)
+	if errors.Is(err, ErrCompletionNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.ErrorResponse{
+			Error: models.ErrorDetail{
+				Message: fmt.Sprintf("No completion found with id %q", id),
+				Type:    "invalid_request_error",
+				Code:    "not_found",
+			},
+		})
+	}
+	if err != nil {
+		h.logger.Error("failed to read stored completion", "id", id, "error", err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
+			Error: models.ErrorDetail{
+				Message: "Failed to read stored completion",
+				Type:    "server_error",
+				Code:    "store_error",
+			},
+		})
+	}
+	return c.JSON(completion)
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestFileCompletionStore(t *testing.T) {
+	store, err := NewFileCompletionStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileCompletionStore: %v", err)
+	}
+	ctx := context.Background()
+
+	want := &models.StoredCompletion{
+		ChatCompletionResponse: models.ChatCompletionResponse{ID: "chatcmpl-abc", Object: "chat.completion", Model: "claude-sonnet"},
+		Metadata:               map[string]string{"user": "alice"},
+	}
+	if err := store.Save(ctx, want); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got, err := store.Get(ctx, "chatcmpl-abc")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.ID != want.ID || got.Model != want.Model || got.Metadata["user"] != "alice" {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, id := range []string{"missing", "../etc/passwd"} {
+		if _, err := store.Get(ctx, id); !errors.Is(err, ErrCompletionNotFound) {
+			t.Errorf("Get(%q): got error %v, want ErrCompletionNotFound", id, err)
+		}
+	}
+	if err := store.Save(ctx, &models.StoredCompletion{ChatCompletionResponse: models.ChatCompletionResponse{ID: "../x"}}); err == nil {
+		t.Error("Save with a path in the id: got nil error, want error")
+	}
+}
+
+func TestHTTPCompletionStore(t *testing.T) {
+	var mu sync.Mutex
+	saved := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			body, _ := io.ReadAll(r.Body)
+			var completion models.StoredCompletion
+			if err := json.Unmarshal(body, &completion); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			saved[completion.ID] = body
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			body, ok := saved[r.URL.Path[len("/completions/"):]]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(body)
+		}
+	}))
+	defer server.Close()
+
+	store := NewHTTPCompletionStore(server.URL+"/completions", 0)
+	ctx := context.Background()
+
+	if err := store.Save(ctx, &models.StoredCompletion{ChatCompletionResponse: models.ChatCompletionResponse{ID: "chatcmpl-1"}}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	got, err := store.Get(ctx, "chatcmpl-1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.ID != "chatcmpl-1" {
+		t.Errorf("got id %q, want chatcmpl-1", got.ID)
+	}
+	if _, err := store.Get(ctx, "missing"); !errors.Is(err, ErrCompletionNotFound) {
+		t.Errorf("got error %v, want ErrCompletionNotFound", err)
+	}
+}
+
+func TestHandle_StoreAndRetrieve(t *testing.T) {
+	t.Setenv("CLAUDEX_STORE_DIR", t.TempDir())
+
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			return resultJSON("stored answer"), nil
+		},
+	}
+	app := newTestApp(exec)
+
+	// Not stored without "store": true
+	_, body := postChat(t, app, `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}]}`)
+	var unstored models.ChatCompletionResponse
+	if err := json.Unmarshal([]byte(body), &unstored); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp, _ := getPath(t, app, "/v1/chat/completions/"+unstored.ID); resp.StatusCode != 404 {
+		t.Errorf("unstored completion: got status %d, want 404", resp.StatusCode)
+	}
+
+	_, body = postChat(t, app, `{"model":"claude-sonnet","store":true,"metadata":{"session":"s1"},"messages":[{"role":"user","content":"hi"}]}`)
+	var created models.ChatCompletionResponse
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+
+	resp, body := getPath(t, app, "/v1/chat/completions/"+created.ID)
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+	}
+	var stored models.StoredCompletion
+	if err := json.Unmarshal([]byte(body), &stored); err != nil {
+		t.Fatalf("failed to unmarshal stored completion: %v", err)
+	}
+	if stored.ID != created.ID {
+		t.Errorf("got id %q, want %q", stored.ID, created.ID)
+	}
+	if got := stored.Choices[0].Message.GetTextContent(); got != "stored answer" {
+		t.Errorf("got content %q, want %q", got, "stored answer")
+	}
+	if stored.Metadata["session"] != "s1" {
+		t.Errorf("got metadata %v, want session=s1", stored.Metadata)
+	}
+	if stored.Request == nil || stored.Request.Messages[0].GetTextContent() != "hi" {
+		t.Errorf("got request %+v, want the original messages", stored.Request)
+	}
+}
+
+// getPath sends a GET request and returns the response and body.
+func getPath(t *testing.T, app *fiber.App, path string) (*http.Response, string) {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest("GET", path, nil), -1)
+	if err != nil {
+		t.Fatalf("app.Test: %v", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return resp, string(data)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// streamSummary describes a written stream, for the summary line logged when
+// CLAUDEX_STREAM_SUMMARY_LOG is enabled.
+type streamSummary struct {
+	status       string        // request status recorded in metrics
+	bytes        int           // SSE bytes written, including event framing
+	chunks       int           // chunk and tool result events written
+	ttft         time.Duration // time to the first content delta, zero if none was sent
+	finishReason string        // last finish reason sent, or "error"
+	toolCalls    bool          // whether any tool call deltas or tool results were sent
+}
+
+// record accounts for one event written to the client.
+func (s *streamSummary) record(ev streamEvent) {
+	if ev.keepAlive {
+		return
+	}
+	s.chunks++
+	if ev.toolResult != nil {
+		s.toolCalls = true
+		return
+	}
+	if ev.chunk == nil {
+		return
+	}
+	for _, choice := range ev.chunk.Choices {
+		if len(choice.Delta.ToolCalls) > 0 {
+			s.toolCalls = true
+		}
+		if choice.FinishReason != "" {
+			s.finishReason = choice.FinishReason
+		}
+	}
+}
+
+// logStreamSummary logs one structured line describing a finished stream.
+func (h *ChatCompletionsHandler) logStreamSummary(ctx context.Context, req *models.ChatCompletionRequest, completionID string, s streamSummary, duration time.Duration) {
+	h.logger.Info("stream completed",
+		"request_id", callerFromContext(ctx).requestID,
+		"completion_id", completionID,
+		"model", req.Model,
+		"status", s.status,
+		"bytes", s.bytes,
+		"chunks", s.chunks,
+		"ttft_ms", s.ttft.Milliseconds(),
+		"duration_ms", duration.Milliseconds(),
+		"finish_reason", s.finishReason,
+		"tool_calls", s.toolCalls,
+	)
+}
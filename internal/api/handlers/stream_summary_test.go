@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+	"github.com/leeaandrob/claudex/internal/observability"
+)
+
+func TestHandleStreaming_SummaryLog(t *testing.T) {
+	exec := &fakeExecutor{
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			chunks, errChan := streamOf([]string{deltaLine("Hello"), deltaLine(" there")}, nil)
+			return chunks, errChan, nil
+		},
+	}
+
+	tests := []struct {
+		name    string
+		enabled string
+		want    bool
+	}{
+		{name: "disabled", enabled: "", want: false},
+		{name: "enabled", enabled: "true", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_STREAM_SUMMARY_LOG", tt.enabled)
+			var logs bytes.Buffer
+			h := newTestHandler(exec)
+			h.logger = &observability.Logger{Logger: slog.New(slog.NewJSONHandler(&logs, nil))}
+
+			_, body := postChat(t, appFor(h), `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+
+			var summary map[string]any
+			scanner := bufio.NewScanner(&logs)
+			for scanner.Scan() {
+				var entry map[string]any
+				if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry["msg"] == "stream completed" {
+					summary = entry
+				}
+			}
+			if (summary != nil) != tt.want {
+				t.Fatalf("got summary %v, want logged %v", summary, tt.want)
+			}
+			if summary == nil {
+				return
+			}
+
+			chunks := float64(len(sseChunks(t, body)))
+			if summary["status"] != "success" || summary["model"] != "claude-sonnet" {
+				t.Errorf("got status %v model %v, want success claude-sonnet", summary["status"], summary["model"])
+			}
+			if summary["bytes"] != float64(len(body)) {
+				t.Errorf("got bytes %v, want %d", summary["bytes"], len(body))
+			}
+			if summary["chunks"] != chunks {
+				t.Errorf("got chunks %v, want %v", summary["chunks"], chunks)
+			}
+			if summary["finish_reason"] != "stop" || summary["tool_calls"] != false {
+				t.Errorf("got finish_reason %v tool_calls %v, want stop false", summary["finish_reason"], summary["tool_calls"])
+			}
+			if !strings.HasPrefix(summary["completion_id"].(string), "chatcmpl-") {
+				t.Errorf("got completion_id %v, want a chatcmpl- id", summary["completion_id"])
+			}
+			for _, field := range []string{"ttft_ms", "duration_ms"} {
+				if _, ok := summary[field].(float64); !ok {
+					t.Errorf("got %s %v, want a number", field, summary[field])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// imageSchema is the subset of a JSON schema used to find image fields.
+type imageSchema struct {
+	Type             any                     `json:"type"`
+	Format           string                  `json:"format"`
+	ContentEncoding  string                  `json:"contentEncoding"`
+	ContentMediaType string                  `json:"contentMediaType"`
+	Properties       map[string]*imageSchema `json:"properties"`
+	Items            *imageSchema            `json:"items"`
+}
+
+// isImageField reports whether a string property named name carries base64
+// image data: it declares an image contentMediaType, or its name mentions an
+// image and it declares base64 encoding.
+func (s *imageSchema) isImageField(name string) bool {
+	if strings.HasPrefix(s.ContentMediaType, "image/") {
+		return true
+	}
+	base64Encoded := s.ContentEncoding == "base64" || s.Format == "byte" || s.Format == "base64"
+	return base64Encoded && strings.Contains(strings.ToLower(name), "image")
+}
+
+// validateImageArguments checks that every image field declared by a tool's
+// input schema holds decodable base64 image data. Fields that are absent or
+// not declared as images are ignored.
+func validateImageArguments(schema json.RawMessage, arguments json.RawMessage) error {
+	if len(schema) == 0 {
+		return nil
+	}
+	var s imageSchema
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return nil // not a schema we can inspect
+	}
+	var args any
+	if err := json.Unmarshal(arguments, &args); err != nil {
+		return nil // reported by the tool itself
+	}
+	return s.validate("", args)
+}
+
+func (s *imageSchema) validate(path string, value any) error {
+	switch v := value.(type) {
+	case map[string]any:
+		for name, prop := range s.Properties {
+			field, ok := v[name]
+			if !ok || prop == nil {
+				continue
+			}
+			fieldPath := joinArgPath(path, name)
+			if str, ok := field.(string); ok && prop.isImageField(name) {
+				if err := checkImageData(str); err != nil {
+					return fmt.Errorf("argument %s: %w", fieldPath, err)
+				}
+				continue
+			}
+			if err := prop.validate(fieldPath, field); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range v {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			if str, ok := item.(string); ok && s.Items.isImageField(path) {
+				if err := checkImageData(str); err != nil {
+					return fmt.Errorf("argument %s: %w", itemPath, err)
+				}
+				continue
+			}
+			if err := s.Items.validate(itemPath, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func joinArgPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// checkImageData decodes base64 image data, optionally given as a data URL,
+// and checks that it looks like an image.
+func checkImageData(data string) error {
+	if rest, ok := strings.CutPrefix(data, "data:"); ok {
+		mediaType, payload, found := strings.Cut(rest, ",")
+		if !found || !strings.HasSuffix(mediaType, ";base64") {
+			return fmt.Errorf("data URL is not base64 encoded")
+		}
+		if !strings.HasPrefix(mediaType, "image/") {
+			return fmt.Errorf("data URL media type %q is not an image", strings.TrimSuffix(mediaType, ";base64"))
+		}
+		data = payload
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		if decoded, err = base64.RawStdEncoding.DecodeString(data); err != nil {
+			return fmt.Errorf("invalid base64 image data")
+		}
+	}
+	if len(decoded) == 0 {
+		return fmt.Errorf("empty image data")
+	}
+	if contentType := http.DetectContentType(decoded); !strings.HasPrefix(contentType, "image/") {
+		return fmt.Errorf("data is %s, not an image", contentType)
+	}
+	return nil
+}
+
+// validateToolArguments checks an MCP tool call's arguments before it is
+// executed. Image validation runs when CLAUDEX_MCP_VALIDATE_IMAGE_ARGS is set.
+func (h *ChatCompletionsHandler) validateToolArguments(tc models.ToolCall) error {
+	if !getValidateImageArgs() {
+		return nil
+	}
+	tool, ok := h.mcpManager.GetTool(tc.Function.Name)
+	if !ok {
+		return nil
+	}
+	return validateImageArguments(tool.InputSchema, json.RawMessage(tc.Function.Arguments))
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/mcp"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// pngBase64 is enough of a PNG file for content sniffing.
+var pngBase64 = base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+
+const imageToolSchema = `{
+	"type": "object",
+	"properties": {
+		"image": {"type": "string", "contentEncoding": "base64"},
+		"caption": {"type": "string"},
+		"pages": {"type": "array", "items": {"type": "string", "contentMediaType": "image/png"}}
+	}
+}`
+
+func TestValidateImageArguments(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		wantErr string
+	}{
+		{name: "valid base64", args: `{"image": "` + pngBase64 + `"}`},
+		{name: "valid data URL", args: `{"image": "data:image/png;base64,` + pngBase64 + `"}`},
+		{name: "valid array items", args: `{"pages": ["` + pngBase64 + `"]}`},
+		{name: "image field absent", args: `{"caption": "not base64!"}`},
+		{name: "invalid base64", args: `{"image": "not base64!"}`, wantErr: "argument image: invalid base64"},
+		{name: "not an image", args: `{"image": "` + base64.StdEncoding.EncodeToString([]byte("hello world")) + `"}`, wantErr: "not an image"},
+		{name: "non-image data URL", args: `{"image": "data:text/plain;base64,aGk="}`, wantErr: "not an image"},
+		{name: "invalid array item", args: `{"pages": ["` + pngBase64 + `", "@@"]}`, wantErr: "argument pages[1]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateImageArguments(json.RawMessage(imageToolSchema), json.RawMessage(tt.args))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got error %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateImageArguments_NoImageFields(t *testing.T) {
+	schema := `{"type": "object", "properties": {"query": {"type": "string"}}}`
+	if err := validateImageArguments(json.RawMessage(schema), json.RawMessage(`{"query": "@@"}`)); err != nil {
+		t.Errorf("got error %v, want nil", err)
+	}
+}
+
+func TestExecuteMCPToolCalls_InvalidImageArguments(t *testing.T) {
+	t.Setenv("CLAUDEX_MCP_VALIDATE_IMAGE_ARGS", "true")
+
+	runs := 0
+	manager := mcp.NewManager()
+	err := manager.RegisterLocalTool("describe", json.RawMessage(imageToolSchema), func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		runs++
+		return &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: "a cat"}}}, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	var continuation *models.ChatCompletionRequest
+	exec := &fakeExecutor{
+		execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+			if len(req.Messages) == 1 {
+				return resultJSON(`{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"describe","arguments":"{\"image\":\"garbage\"}"}}]}`), nil
+			}
+			continuation = req
+			return resultJSON("sorry"), nil
+		},
+	}
+	h := newTestHandler(exec)
+	h.mcpManager = manager
+
+	resp, body := postChat(t, appFor(h), `{"model":"claude-sonnet","messages":[{"role":"user","content":"describe it"}]}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+	}
+	if runs != 0 {
+		t.Errorf("got %d tool executions, want 0", runs)
+	}
+	if continuation == nil {
+		t.Fatal("got no continuation request, want the error fed back to Claude")
+	}
+	last := continuation.Messages[len(continuation.Messages)-1]
+	if last.Role != "tool" || !strings.Contains(last.GetTextContent(), "argument image") {
+		t.Errorf("got last message %+v, want a tool error about the image argument", last)
+	}
+}
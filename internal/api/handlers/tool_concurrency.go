@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+)
+
+// toolSlots bounds how many MCP tool calls run against each server at once,
+// across all requests.
+type toolSlots struct {
+	mu      sync.Mutex
+	servers map[string]chan struct{}
+}
+
+// forServer returns the slots of a server, creating them on first use.
+func (s *toolSlots) forServer(server string) chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.servers == nil {
+		s.servers = make(map[string]chan struct{})
+	}
+	slots, ok := s.servers[server]
+	if !ok {
+		slots = make(chan struct{}, getMaxConcurrentToolCallsPerServer())
+		s.servers[server] = slots
+	}
+	return slots
+}
+
+// acquireToolSlot waits for a slot in the request's slots and then in the
+// tool's server slots, counting the call as queued while it waits. It returns
+// the function that releases both, or the context's error.
+func (h *ChatCompletionsHandler) acquireToolSlot(ctx context.Context, requestSlots chan struct{}, tool string) (func(), error) {
+	var server chan struct{}
+	if name, ok := h.mcpManager.ServerForTool(tool); ok {
+		server = h.toolSlots.forServer(name)
+	}
+
+	// Only calls that can't start right away are queued
+	queued := false
+	take := func(slots chan struct{}) error {
+		select {
+		case slots <- struct{}{}:
+			return nil
+		default:
+		}
+		if !queued {
+			queued = true
+			h.metrics.MCPToolCallsQueued.Inc()
+		}
+		select {
+		case slots <- struct{}{}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	defer func() {
+		if queued {
+			h.metrics.MCPToolCallsQueued.Dec()
+		}
+	}()
+
+	if err := take(requestSlots); err != nil {
+		return nil, err
+	}
+	if server == nil {
+		return func() { <-requestSlots }, nil
+	}
+	if err := take(server); err != nil {
+		<-requestSlots
+		return nil, err
+	}
+	return func() {
+		<-server
+		<-requestSlots
+	}, nil
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/leeaandrob/claudex/internal/mcp"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestRunMCPTools_ConcurrencyCaps(t *testing.T) {
+	tests := []struct {
+		name       string
+		perRequest string
+		perServer  string
+		want       int64
+	}{
+		{name: "server cap", perRequest: "10", perServer: "2", want: 2},
+		{name: "request cap", perRequest: "1", perServer: "10", want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_MCP_MAX_CONCURRENT_CALLS", tt.perRequest)
+			t.Setenv("CLAUDEX_MCP_MAX_CONCURRENT_CALLS_PER_SERVER", tt.perServer)
+
+			var running, peak atomic.Int64
+			manager := mcp.NewManager()
+			err := manager.RegisterLocalTool("slow", nil, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+				n := running.Add(1)
+				defer running.Add(-1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(50 * time.Millisecond)
+				return &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: "ok " + string(arguments)}}}, nil
+			})
+			if err != nil {
+				t.Fatalf("RegisterLocalTool: %v", err)
+			}
+
+			var calls []string
+			for i := range 6 {
+				calls = append(calls, fmt.Sprintf(`{"id":"call_%d","type":"function","function":{"name":"slow","arguments":"{\"n\":%d}"}}`, i, i))
+			}
+			var requests []*models.ChatCompletionRequest
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					requests = append(requests, req)
+					if len(requests) == 1 {
+						return resultJSON(`{"tool_calls":[` + strings.Join(calls, ",") + `]}`), nil
+					}
+					return resultJSON("done"), nil
+				},
+			}
+			h := newTestHandler(exec)
+			h.mcpManager = manager
+
+			resp, body := postChat(t, appFor(h), `{"model":"claude-sonnet","messages":[{"role":"user","content":"go"}]}`)
+			if resp.StatusCode != 200 {
+				t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+			}
+			if got := peak.Load(); got != tt.want {
+				t.Errorf("got peak concurrency %d, want %d", got, tt.want)
+			}
+			if len(requests) != 2 {
+				t.Fatalf("got %d executor calls, want 2", len(requests))
+			}
+
+			// Results keep the order of the calls
+			var results []models.Message
+			for _, msg := range requests[1].Messages {
+				if msg.Role == "tool" {
+					results = append(results, msg)
+				}
+			}
+			if len(results) != len(calls) {
+				t.Fatalf("got %d tool results, want %d", len(results), len(calls))
+			}
+			for i, msg := range results {
+				if want := fmt.Sprintf("call_%d", i); msg.ToolCallID != want {
+					t.Errorf("result %d: got tool_call_id %q, want %q", i, msg.ToolCallID, want)
+				}
+			}
+		})
+	}
+}
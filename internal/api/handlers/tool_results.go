@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// How mime-typed tool result content is forwarded to Claude.
+const (
+	// mimeHandleText forwards the content as text, decoding base64 data.
+	mimeHandleText = "text"
+	// mimeHandleImage forwards the content as an image block.
+	mimeHandleImage = "image"
+	// mimeHandleOmit replaces the content with a short description.
+	mimeHandleOmit = "omit"
+)
+
+// imageMimeTypes are the image formats Claude accepts as image blocks.
+var imageMimeTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// textMimeTypes are non-text/* types whose content is readable text.
+var textMimeTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/yaml":       true,
+	"application/x-yaml":     true,
+	"application/toml":       true,
+	"application/sql":        true,
+}
+
+// mimeStrategy returns how content of a mime type is forwarded. Structured
+// text types such as SVG (image/svg+xml) are forwarded as text.
+func mimeStrategy(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return mimeHandleOmit
+	}
+	switch {
+	case imageMimeTypes[mediaType]:
+		return mimeHandleImage
+	case strings.HasPrefix(mediaType, "text/"), textMimeTypes[mediaType],
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return mimeHandleText
+	}
+	return mimeHandleOmit
+}
+
+// toolResultParts splits an MCP tool result into the text forwarded to
+// Claude and any image parts. Text content is kept as is; image and resource
+// content is handled according to its mime type.
+func toolResultParts(result *models.MCPToolResult) (string, []models.ContentPart) {
+	var text strings.Builder
+	var images []models.ContentPart
+	note := func(s string) {
+		if text.Len() > 0 {
+			text.WriteString("\n")
+		}
+		text.WriteString(s)
+	}
+
+	for _, c := range result.Content {
+		switch c.Type {
+		case "text":
+			text.WriteString(c.Text)
+		case "image":
+			if part, s := forwardBinary("image", c.MimeType, c.Data); part != nil {
+				images = append(images, *part)
+			} else {
+				note(s)
+			}
+		case "resource":
+			r := c.Resource
+			if r == nil {
+				continue
+			}
+			if r.Text != "" {
+				note(fmt.Sprintf("[Resource %s]\n%s", r.URI, r.Text))
+				continue
+			}
+			if part, s := forwardBinary("resource "+r.URI, r.MimeType, r.Blob); part != nil {
+				images = append(images, *part)
+			} else {
+				note(s)
+			}
+		}
+	}
+	return text.String(), images
+}
+
+// forwardBinary handles base64 data of a mime type: it returns an image part,
+// or the text to forward in its place.
+func forwardBinary(label, mimeType, data string) (*models.ContentPart, string) {
+	switch mimeStrategy(mimeType) {
+	case mimeHandleImage:
+		return &models.ContentPart{
+			Type:     "image_url",
+			ImageURL: &models.ImageURL{URL: "data:" + mimeType + ";base64," + data},
+		}, ""
+	case mimeHandleText:
+		if decoded, err := base64.StdEncoding.DecodeString(data); err == nil {
+			return nil, fmt.Sprintf("[%s (%s)]\n%s", label, mimeType, decoded)
+		}
+	}
+	if mimeType == "" {
+		mimeType = "unknown type"
+	}
+	return nil, fmt.Sprintf("[%s (%s, %d bytes) omitted]", label, mimeType, base64.StdEncoding.DecodedLen(len(data)))
+}
+
+// toolResultMessageContent builds tool message content from result text and
+// images: a string when there are no images, or content parts otherwise.
+func toolResultMessageContent(text string, images []models.ContentPart) any {
+	if len(images) == 0 {
+		return text
+	}
+	return append([]models.ContentPart{{Type: "text", Text: text}}, images...)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestMimeStrategy(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		want     string
+	}{
+		{mimeType: "text/plain", want: mimeHandleText},
+		{mimeType: "text/csv; charset=utf-8", want: mimeHandleText},
+		{mimeType: "application/json", want: mimeHandleText},
+		{mimeType: "application/ld+json", want: mimeHandleText},
+		{mimeType: "image/svg+xml", want: mimeHandleText},
+		{mimeType: "image/png", want: mimeHandleImage},
+		{mimeType: "image/jpeg", want: mimeHandleImage},
+		{mimeType: "image/tiff", want: mimeHandleOmit},
+		{mimeType: "application/pdf", want: mimeHandleOmit},
+		{mimeType: "", want: mimeHandleOmit},
+	}
+	for _, tt := range tests {
+		if got := mimeStrategy(tt.mimeType); got != tt.want {
+			t.Errorf("mimeStrategy(%q): got %q, want %q", tt.mimeType, got, tt.want)
+		}
+	}
+}
+
+func TestToolResultParts(t *testing.T) {
+	svg := base64.StdEncoding.EncodeToString([]byte("<svg/>"))
+	result := &models.MCPToolResult{Content: []models.MCPContent{
+		{Type: "text", Text: "Found 4 items."},
+		{Type: "image", MimeType: "image/png", Data: pngBase64},
+		{Type: "image", MimeType: "image/svg+xml", Data: svg},
+		{Type: "resource", Resource: &models.MCPResourceContents{URI: "file:///notes.md", MimeType: "text/markdown", Text: "# Notes"}},
+		{Type: "resource", Resource: &models.MCPResourceContents{URI: "file:///report.pdf", MimeType: "application/pdf", Blob: "JVBERi0xLjQK"}},
+		{Type: "resource", Resource: &models.MCPResourceContents{URI: "file:///blob", Blob: "AAAA"}},
+	}}
+
+	text, images := toolResultParts(result)
+
+	wantText := "Found 4 items.\n" +
+		"[image (image/svg+xml)]\n<svg/>\n" +
+		"[Resource file:///notes.md]\n# Notes\n" +
+		"[resource file:///report.pdf (application/pdf, 9 bytes) omitted]\n" +
+		"[resource file:///blob (unknown type, 3 bytes) omitted]"
+	if text != wantText {
+		t.Errorf("got text %q, want %q", text, wantText)
+	}
+	if len(images) != 1 || images[0].ImageURL.URL != "data:image/png;base64,"+pngBase64 {
+		t.Errorf("got images %+v, want the PNG as a data URL", images)
+	}
+
+	content, ok := toolResultMessageContent(text, images).([]models.ContentPart)
+	if !ok || len(content) != 2 || content[0].Text != text || content[1].Type != "image_url" {
+		t.Errorf("got content %+v, want text then image", content)
+	}
+}
+
+func TestToolResultParts_TextOnly(t *testing.T) {
+	result := &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: "a"}, {Type: "text", Text: "b"}}}
+	text, images := toolResultParts(result)
+	if content := toolResultMessageContent(text, images); content != "ab" {
+		t.Errorf("got content %#v, want %q", content, "ab")
+	}
+}
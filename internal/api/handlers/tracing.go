@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/leeaandrob/claudex/internal/claude"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// setRequestAttributes records the workload characteristics of a prepared
+// request on the span in ctx, so traces can be filtered by them.
+func setRequestAttributes(ctx context.Context, req *models.ChatCompletionRequest) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	hasImages := false
+	for i := range req.Messages {
+		if req.Messages[i].HasImages() {
+			hasImages = true
+			break
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int("claudex.tools.count", len(req.Tools)),
+		attribute.Int("claudex.tools.mcp_count", len(req.MCPTools)),
+		attribute.Bool("claudex.has_images", hasImages),
+		attribute.Bool("claudex.stream", req.Stream),
+		attribute.String("claudex.execution_mode", claude.ExecutionMode(req)),
+	)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestSetRequestAttributes(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+
+	req := &models.ChatCompletionRequest{
+		Stream: true,
+		Messages: []models.Message{{Role: "user", Content: []models.ContentPart{
+			{Type: "text", Text: "what is this?"},
+			{Type: "image_url", ImageURL: &models.ImageURL{URL: "https://example.com/cat.png"}},
+		}}},
+		Tools: []models.Tool{
+			{Type: "function", Function: models.Function{Name: "client_tool"}},
+			{Type: "function", Function: models.Function{Name: "mcp_tool"}},
+		},
+		MCPTools: map[string]bool{"mcp_tool": true},
+	}
+	setRequestAttributes(ctx, req)
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	got := make(map[attribute.Key]attribute.Value)
+	for _, kv := range spans[0].Attributes() {
+		got[kv.Key] = kv.Value
+	}
+
+	want := map[attribute.Key]attribute.Value{
+		"claudex.tools.count":     attribute.IntValue(2),
+		"claudex.tools.mcp_count": attribute.IntValue(1),
+		"claudex.has_images":      attribute.BoolValue(true),
+		"claudex.stream":          attribute.BoolValue(true),
+		"claudex.execution_mode":  attribute.StringValue("stream_json"),
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("got %s = %v, want %v", key, got[key].Emit(), value.Emit())
+		}
+	}
+}
+
+func TestSetRequestAttributes_NoSpan(t *testing.T) {
+	// Requests without a recording span must not panic
+	setRequestAttributes(context.Background(), &models.ChatCompletionRequest{})
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// estimateUsage fills in resp's usage from character counts when the CLI
+// reported none and CLAUDEX_ESTIMATE_USAGE is set. The estimate is marked
+// with the non-standard claudex_estimated field.
+func (h *ChatCompletionsHandler) estimateUsage(req *models.ChatCompletionRequest, resp *models.ChatCompletionResponse) {
+	if resp.Usage.TotalTokens > 0 || !getEstimateUsage() {
+		return
+	}
+	completionChars := 0
+	for _, choice := range resp.Choices {
+		completionChars += messageChars(choice.Message)
+	}
+	resp.Usage = estimatedUsage(req, completionChars)
+	h.logger.Debug("estimated usage", "prompt_tokens", resp.Usage.PromptTokens, "completion_tokens", resp.Usage.CompletionTokens)
+}
+
+// estimatedUsage returns the usage estimated from req's prompt and
+// completionChars characters of completion.
+func estimatedUsage(req *models.ChatCompletionRequest, completionChars int) models.Usage {
+	promptChars := 0
+	for _, msg := range req.Messages {
+		promptChars += messageChars(msg)
+	}
+	for _, tool := range req.Tools {
+		if data, err := json.Marshal(tool); err == nil {
+			promptChars += len(data)
+		}
+	}
+
+	prompt, completion := charsToTokens(promptChars), charsToTokens(completionChars)
+	return models.Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: completion,
+		TotalTokens:      prompt + completion,
+		Estimated:        true,
+	}
+}
+
+// messageChars counts the characters of a message's text and tool calls.
+func messageChars(msg models.Message) int {
+	n := len(msg.GetTextContent())
+	for _, tc := range msg.ToolCalls {
+		n += len(tc.Function.Name) + len(tc.Function.Arguments)
+	}
+	return n
+}
+
+// charsToTokens converts a character count to tokens at
+// CLAUDEX_CHARS_PER_TOKEN, rounding up.
+func charsToTokens(chars int) int {
+	return int(math.Ceil(float64(chars) / getCharsPerToken()))
+}
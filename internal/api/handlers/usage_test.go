@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestHandleNonStreaming_EstimatedUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		estimate string
+		want     models.Usage
+	}{
+		{name: "disabled", want: models.Usage{}},
+		{name: "estimated", estimate: "true", want: models.Usage{PromptTokens: 6, CompletionTokens: 2, TotalTokens: 8, Estimated: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_ESTIMATE_USAGE", tt.estimate)
+			t.Setenv("CLAUDEX_CHARS_PER_TOKEN", "2")
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					return resultJSON("abcd"), nil
+				},
+			}
+
+			resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","messages":[{"role":"user","content":"hello world!"}]}`)
+			if resp.StatusCode != 200 {
+				t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+			}
+			var out models.ChatCompletionResponse
+			if err := json.Unmarshal([]byte(body), &out); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if out.Usage != tt.want {
+				t.Errorf("got usage %+v, want %+v", out.Usage, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleStreaming_EstimatedUsage(t *testing.T) {
+	t.Setenv("CLAUDEX_ESTIMATE_USAGE", "true")
+	t.Setenv("CLAUDEX_STREAM_ALWAYS_USAGE", "true")
+	t.Setenv("CLAUDEX_CHARS_PER_TOKEN", "2")
+	exec := &fakeExecutor{
+		stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+			chunks, errChan := streamOf([]string{deltaLine("ab"), deltaLine("cd")}, nil)
+			return chunks, errChan, nil
+		},
+	}
+
+	resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet","stream":true,"messages":[{"role":"user","content":"hello world!"}]}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("got status %d, want 200 (body=%s)", resp.StatusCode, body)
+	}
+	chunks := sseChunks(t, body)
+	final := chunks[len(chunks)-1]
+	want := models.Usage{PromptTokens: 6, CompletionTokens: 2, TotalTokens: 8, Estimated: true}
+	if final.Usage == nil || *final.Usage != want {
+		t.Errorf("got final usage %+v, want %+v", final.Usage, want)
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/leeaandrob/claudex/internal/jsonschema"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// knownRoles lists the message roles accepted by the chat completions endpoint.
+var knownRoles = map[string]bool{
+	"system":    true,
+	"developer": true,
+	"user":      true,
+	"assistant": true,
+	"tool":      true,
+}
+
+// validateRequest checks message roles, tool linkage, and content shapes.
+// Returns nil when the request is well-formed.
+func validateRequest(req *models.ChatCompletionRequest) *models.ErrorDetail {
+	hasUser := false
+	for i, msg := range req.Messages {
+		param := fmt.Sprintf("messages[%d]", i)
+
+		if !knownRoles[msg.Role] {
+			return invalidRequest(param+".role", "invalid_role",
+				fmt.Sprintf("%s: unknown role %q", param, msg.Role))
+		}
+
+		if msg.Role == "tool" && msg.ToolCallID == "" {
+			return invalidRequest(param+".tool_call_id", "invalid_tool_message",
+				fmt.Sprintf("%s: tool messages must include tool_call_id", param))
+		}
+
+		if len(msg.ToolCalls) > 0 && msg.Role != "assistant" {
+			return invalidRequest(param+".tool_calls", "invalid_tool_calls",
+				fmt.Sprintf("%s: tool_calls are only allowed on assistant messages", param))
+		}
+
+		for j, tc := range msg.ToolCalls {
+			if detail := validateToolCall(tc, fmt.Sprintf("%s.tool_calls[%d]", param, j)); detail != nil {
+				return detail
+			}
+		}
+
+		if detail := validateContent(msg.Content, param+".content"); detail != nil {
+			return detail
+		}
+
+		if msg.Role == "user" {
+			hasUser = true
+		}
+	}
+
+	// System instructions alone leave the CLI with an empty prompt
+	if !hasUser {
+		return invalidRequest("messages", "missing_user_message",
+			"messages must include at least one user message")
+	}
+
+	if detail := validateStop(req.Stop); detail != nil {
+		return detail
+	}
+
+	return validateResponseFormat(req.ResponseFormat)
+}
+
+// validateImageURLs checks every image URL in the request with check, which
+// reports images the executor will not accept.
+func validateImageURLs(req *models.ChatCompletionRequest, check func(url string) error) *models.ErrorDetail {
+	for i, msg := range req.Messages {
+		parts, ok := msg.Content.([]models.ContentPart)
+		if !ok {
+			continue
+		}
+		for j, part := range parts {
+			if part.Type != "image_url" || part.ImageURL == nil {
+				continue
+			}
+			if err := check(part.ImageURL.URL); err != nil {
+				partParam := fmt.Sprintf("messages[%d].content[%d]", i, j)
+				return invalidRequest(partParam+".image_url.url", "image_too_large",
+					fmt.Sprintf("%s: %v", partParam, err))
+			}
+		}
+	}
+	return nil
+}
+
+// validateResponseFormat checks the response_format type and, for
+// "json_schema", that a usable schema is provided.
+func validateResponseFormat(rf *models.ResponseFormat) *models.ErrorDetail {
+	if rf == nil {
+		return nil
+	}
+	switch rf.Type {
+	case "text", "json_object":
+		return nil
+	case "json_schema":
+		if rf.JSONSchema == nil || len(rf.JSONSchema.Schema) == 0 {
+			return invalidRequest("response_format.json_schema.schema", "invalid_response_format",
+				"response_format json_schema requires json_schema.schema")
+		}
+		if _, err := jsonschema.Parse(rf.JSONSchema.Schema); err != nil {
+			return invalidRequest("response_format.json_schema.schema", "invalid_response_format",
+				fmt.Sprintf("response_format json_schema: %v", err))
+		}
+		return nil
+	}
+	return invalidRequest("response_format.type", "invalid_response_format",
+		fmt.Sprintf("unsupported response_format type %q", rf.Type))
+}
+
+// validateToolCall checks that an assistant tool call is well-formed.
+func validateToolCall(tc models.ToolCall, param string) *models.ErrorDetail {
+	if tc.ID == "" {
+		return invalidRequest(param+".id", "invalid_tool_calls",
+			fmt.Sprintf("%s: id is required", param))
+	}
+	if tc.Type != "function" {
+		return invalidRequest(param+".type", "invalid_tool_calls",
+			fmt.Sprintf("%s: unsupported type %q", param, tc.Type))
+	}
+	if tc.Function.Name == "" {
+		return invalidRequest(param+".function.name", "invalid_tool_calls",
+			fmt.Sprintf("%s: function name is required", param))
+	}
+	if tc.Function.Arguments != "" && !json.Valid([]byte(tc.Function.Arguments)) {
+		return invalidRequest(param+".function.arguments", "invalid_tool_calls",
+			fmt.Sprintf("%s: function arguments must be a JSON string", param))
+	}
+	return nil
+}
+
+// validateContent checks that content is a string or an array of known content parts.
+func validateContent(content any, param string) *models.ErrorDetail {
+	switch c := content.(type) {
+	case nil, string:
+		return nil
+	case []models.ContentPart:
+		for i, part := range c {
+			partParam := fmt.Sprintf("%s[%d]", param, i)
+			switch part.Type {
+			case "text":
+			case "image_url":
+				if part.ImageURL == nil || part.ImageURL.URL == "" {
+					return invalidRequest(partParam+".image_url", "invalid_content",
+						fmt.Sprintf("%s: image_url.url is required", partParam))
+				}
+			case "input_audio":
+				// Valid OpenAI content, but the CLI has no audio input
+				return invalidRequest(partParam+".type", "unsupported_content_type",
+					fmt.Sprintf("%s: %q content is not supported", partParam, part.Type))
+			default:
+				return invalidRequest(partParam+".type", "invalid_content",
+					fmt.Sprintf("%s: unknown content part type %q", partParam, part.Type))
+			}
+		}
+		return nil
+	default:
+		return invalidRequest(param, "invalid_content",
+			fmt.Sprintf("%s: must be a string or an array of content parts", param))
+	}
+}
+
+// dedupeTools removes tools whose function name appears again later in the
+// list, keeping the last definition in its position. It also returns the
+// duplicated names in order of first appearance.
+func dedupeTools(tools []models.Tool) ([]models.Tool, []string) {
+	last := make(map[string]int, len(tools))
+	for i, tool := range tools {
+		last[tool.Function.Name] = i
+	}
+	if len(last) == len(tools) {
+		return tools, nil
+	}
+
+	var duplicates []string
+	reported := make(map[string]bool)
+	result := make([]models.Tool, 0, len(last))
+	for i, tool := range tools {
+		name := tool.Function.Name
+		if last[name] != i {
+			if !reported[name] {
+				duplicates = append(duplicates, name)
+				reported[name] = true
+			}
+			continue
+		}
+		result = append(result, tool)
+	}
+	return result, duplicates
+}
+
+// paramRanges lists the numeric request parameters with an allowed range.
+var paramRanges = []struct {
+	name     string
+	min, max float64
+	value    func(req *models.ChatCompletionRequest) *float64
+}{
+	{name: "temperature", min: 0, max: 2, value: func(req *models.ChatCompletionRequest) *float64 { return req.Temperature }},
+	{name: "top_p", min: 0, max: 1, value: func(req *models.ChatCompletionRequest) *float64 { return req.TopP }},
+}
+
+// checkParamRanges checks numeric parameters against their allowed ranges.
+// With clamp set, out-of-range values are clamped in place and their names
+// returned; otherwise the first one is reported as an error.
+func checkParamRanges(req *models.ChatCompletionRequest, clamp bool) ([]string, *models.ErrorDetail) {
+	var clamped []string
+	for _, p := range paramRanges {
+		v := p.value(req)
+		if v == nil || (*v >= p.min && *v <= p.max) {
+			continue
+		}
+		if !clamp {
+			return nil, invalidRequest(p.name, "invalid_parameter",
+				fmt.Sprintf("%s must be between %g and %g, got %g", p.name, p.min, p.max, *v))
+		}
+		*v = min(max(*v, p.min), p.max)
+		clamped = append(clamped, p.name)
+	}
+	return clamped, nil
+}
+
+// ignoredRequestFields lists OpenAI request fields that are accepted but have
+// no effect, so strict mode does not report them as unknown.
+var ignoredRequestFields = []string{
+	"presence_penalty", "frequency_penalty",
+	"seed", "stream_options", "parallel_tool_calls", "logprobs", "top_logprobs",
+	"max_completion_tokens", "service_tier", "modalities", "reasoning_effort",
+}
+
+// knownRequestFields holds the top-level request fields recognized in strict mode.
+var knownRequestFields = func() map[string]bool {
+	known := make(map[string]bool)
+	t := reflect.TypeOf(models.ChatCompletionRequest{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+	for _, name := range ignoredRequestFields {
+		known[name] = true
+	}
+	return known
+}()
+
+// validateKnownFields returns an unknown_field error listing the top-level
+// fields of body that the chat completions API does not define.
+func validateKnownFields(body []byte) *models.ErrorDetail {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil // reported by the body parser
+	}
+
+	var unknown []string
+	for name := range fields {
+		if !knownRequestFields[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return invalidRequest(unknown[0], "unknown_field",
+		fmt.Sprintf("unknown fields in request body: %s", strings.Join(unknown, ", ")))
+}
+
+// invalidRequest builds an invalid_request_error detail.
+func invalidRequest(param, code, message string) *models.ErrorDetail {
+	return &models.ErrorDetail{
+		Message: message,
+		Type:    "invalid_request_error",
+		Param:   param,
+		Code:    code,
+	}
+}
@@ -0,0 +1,453 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/claude"
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestValidateRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		messages  string
+		wantCode  string
+		wantParam string
+	}{
+		{
+			name:     "valid conversation",
+			messages: `[{"role":"system","content":"be brief"},{"role":"user","content":[{"type":"text","text":"hi"}]},{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"f","arguments":"{}"}}]},{"role":"tool","tool_call_id":"call_1","content":"ok"}]`,
+		},
+		{
+			name:      "system message only",
+			messages:  `[{"role":"system","content":"be brief"}]`,
+			wantCode:  "missing_user_message",
+			wantParam: "messages",
+		},
+		{
+			name:      "system and developer messages only",
+			messages:  `[{"role":"system","content":"be brief"},{"role":"developer","content":"use tools"}]`,
+			wantCode:  "missing_user_message",
+			wantParam: "messages",
+		},
+		{
+			name:      "input_audio content part",
+			messages:  `[{"role":"user","content":[{"type":"text","text":"transcribe"},{"type":"input_audio","input_audio":{"data":"UklGRg==","format":"wav"}}]}]`,
+			wantCode:  "unsupported_content_type",
+			wantParam: "messages[0].content[1].type",
+		},
+		{
+			name:      "unknown role",
+			messages:  `[{"role":"bot","content":"hi"}]`,
+			wantCode:  "invalid_role",
+			wantParam: "messages[0].role",
+		},
+		{
+			name:      "tool message without tool_call_id",
+			messages:  `[{"role":"user","content":"hi"},{"role":"tool","content":"ok"}]`,
+			wantCode:  "invalid_tool_message",
+			wantParam: "messages[1].tool_call_id",
+		},
+		{
+			name:      "tool_calls on user message",
+			messages:  `[{"role":"user","content":"hi","tool_calls":[{"id":"call_1","type":"function","function":{"name":"f"}}]}]`,
+			wantCode:  "invalid_tool_calls",
+			wantParam: "messages[0].tool_calls",
+		},
+		{
+			name:      "tool call without id",
+			messages:  `[{"role":"assistant","content":"","tool_calls":[{"type":"function","function":{"name":"f"}}]}]`,
+			wantCode:  "invalid_tool_calls",
+			wantParam: "messages[0].tool_calls[0].id",
+		},
+		{
+			name:      "tool call with unsupported type",
+			messages:  `[{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"code","function":{"name":"f"}}]}]`,
+			wantCode:  "invalid_tool_calls",
+			wantParam: "messages[0].tool_calls[0].type",
+		},
+		{
+			name:      "tool call without function name",
+			messages:  `[{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"arguments":"{}"}}]}]`,
+			wantCode:  "invalid_tool_calls",
+			wantParam: "messages[0].tool_calls[0].function.name",
+		},
+		{
+			name:      "tool call with invalid arguments",
+			messages:  `[{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"f","arguments":"{not json"}}]}]`,
+			wantCode:  "invalid_tool_calls",
+			wantParam: "messages[0].tool_calls[0].function.arguments",
+		},
+		{
+			name:      "numeric content",
+			messages:  `[{"role":"user","content":42}]`,
+			wantCode:  "invalid_content",
+			wantParam: "messages[0].content",
+		},
+		{
+			name:      "array of strings content",
+			messages:  `[{"role":"user","content":["hi"]}]`,
+			wantCode:  "invalid_content",
+			wantParam: "messages[0].content",
+		},
+		{
+			name:      "unknown content part type",
+			messages:  `[{"role":"user","content":[{"type":"video","text":"x"}]}]`,
+			wantCode:  "invalid_content",
+			wantParam: "messages[0].content[0].type",
+		},
+		{
+			name:      "image part without url",
+			messages:  `[{"role":"user","content":[{"type":"image_url","image_url":{}}]}]`,
+			wantCode:  "invalid_content",
+			wantParam: "messages[0].content[0].image_url",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req models.ChatCompletionRequest
+			if err := json.Unmarshal([]byte(`{"model":"m","messages":`+tt.messages+`}`), &req); err != nil {
+				t.Fatalf("failed to unmarshal: %v", err)
+			}
+
+			detail := validateRequest(&req)
+
+			if tt.wantCode == "" {
+				if detail != nil {
+					t.Fatalf("got error %+v, want nil", detail)
+				}
+				return
+			}
+			if detail == nil {
+				t.Fatalf("got nil, want error code %q", tt.wantCode)
+			}
+			if detail.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", detail.Code, tt.wantCode)
+			}
+			if detail.Param != tt.wantParam {
+				t.Errorf("got param %q, want %q", detail.Param, tt.wantParam)
+			}
+			if detail.Type != "invalid_request_error" {
+				t.Errorf("got type %q, want invalid_request_error", detail.Type)
+			}
+		})
+	}
+}
+
+func TestDedupeTools(t *testing.T) {
+	tool := func(name, desc string) models.Tool {
+		return models.Tool{Type: "function", Function: models.Function{Name: name, Description: desc}}
+	}
+	tools := []models.Tool{tool("a", "first a"), tool("b", "only b"), tool("a", "second a"), tool("c", "first c"), tool("c", "second c")}
+
+	got, duplicates := dedupeTools(tools)
+
+	var descs []string
+	for _, tool := range got {
+		descs = append(descs, tool.Function.Description)
+	}
+	if want := []string{"only b", "second a", "second c"}; strings.Join(descs, ",") != strings.Join(want, ",") {
+		t.Errorf("got tools %q, want %q", descs, want)
+	}
+	if want := []string{"a", "c"}; strings.Join(duplicates, ",") != strings.Join(want, ",") {
+		t.Errorf("got duplicates %q, want %q", duplicates, want)
+	}
+}
+
+func TestHandle_DuplicateToolNames(t *testing.T) {
+	body := `{"model":"claude-sonnet","messages":[{"role":"user","content":"hi"}],"tools":[` +
+		`{"type":"function","function":{"name":"lookup","description":"old"}},` +
+		`{"type":"function","function":{"name":"lookup","description":"new"}}]}`
+
+	tests := []struct {
+		name       string
+		mode       string
+		wantStatus int
+	}{
+		{name: "dedupe by default", mode: "", wantStatus: 200},
+		{name: "reject", mode: "reject", wantStatus: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_DUPLICATE_TOOLS_MODE", tt.mode)
+
+			var got []models.Tool
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					got = req.Tools
+					return resultJSON("ok"), nil
+				},
+			}
+
+			resp, respBody := postChat(t, newTestApp(exec), body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, respBody)
+			}
+
+			if tt.wantStatus == 400 {
+				if !strings.Contains(respBody, "duplicate_tool_name") {
+					t.Errorf("got body %s, want duplicate_tool_name", respBody)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Function.Description != "new" {
+				t.Errorf("got tools %+v, want only the last 'lookup'", got)
+			}
+		})
+	}
+}
+
+func TestHandle_UnknownFields(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		body       string
+		wantStatus int
+		wantFields string
+	}{
+		{
+			name:       "lenient by default",
+			body:       `{"model":"claude-sonnet","maxtokens":10,"messages":[{"role":"user","content":"hi"}]}`,
+			wantStatus: 200,
+		},
+		{
+			name:       "strict rejects unknown fields",
+			mode:       "reject",
+			body:       `{"model":"claude-sonnet","maxtokens":10,"strem":true,"messages":[{"role":"user","content":"hi"}]}`,
+			wantStatus: 400,
+			wantFields: "maxtokens, strem",
+		},
+		{
+			name:       "strict accepts known and ignored fields",
+			mode:       "reject",
+			body:       `{"model":"claude-sonnet","max_tokens":10,"temperature":0.2,"messages":[{"role":"user","content":"hi"}]}`,
+			wantStatus: 200,
+		},
+		{
+			name:       "strict accepts prompt_cache_key and safety_identifier",
+			mode:       "reject",
+			body:       `{"model":"claude-sonnet","prompt_cache_key":"k1","safety_identifier":"u1","messages":[{"role":"user","content":"hi"}]}`,
+			wantStatus: 200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_UNKNOWN_FIELDS_MODE", tt.mode)
+
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					return resultJSON("ok"), nil
+				},
+			}
+
+			resp, body := postChat(t, newTestApp(exec), tt.body)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantFields == "" {
+				return
+			}
+			var errResp models.ErrorResponse
+			if err := json.Unmarshal([]byte(body), &errResp); err != nil {
+				t.Fatalf("failed to unmarshal error: %v", err)
+			}
+			if errResp.Error.Code != "unknown_field" {
+				t.Errorf("got code %q, want unknown_field", errResp.Error.Code)
+			}
+			if !strings.Contains(errResp.Error.Message, tt.wantFields) {
+				t.Errorf("got message %q, want it to list %s", errResp.Error.Message, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestHandle_ParamRanges(t *testing.T) {
+	tests := []struct {
+		name            string
+		mode            string
+		params          string
+		wantStatus      int
+		wantTemperature float64
+		wantTopP        float64
+	}{
+		{name: "in range", params: `"temperature":0.7,"top_p":0.9,`, wantStatus: 200, wantTemperature: 0.7, wantTopP: 0.9},
+		{name: "bounds", mode: "reject", params: `"temperature":2,"top_p":0,`, wantStatus: 200, wantTemperature: 2, wantTopP: 0},
+		{name: "clamp by default", params: `"temperature":3.5,"top_p":-0.1,`, wantStatus: 200, wantTemperature: 2, wantTopP: 0},
+		{name: "reject temperature", mode: "reject", params: `"temperature":-1,`, wantStatus: 400},
+		{name: "reject top_p", mode: "reject", params: `"top_p":1.5,`, wantStatus: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_PARAM_RANGE_MODE", tt.mode)
+
+			var got *models.ChatCompletionRequest
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					got = req
+					return resultJSON("ok"), nil
+				},
+			}
+
+			resp, body := postChat(t, newTestApp(exec), `{"model":"claude-sonnet",`+tt.params+`"messages":[{"role":"user","content":"hi"}]}`)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantStatus == 400 {
+				if !strings.Contains(body, "invalid_parameter") {
+					t.Errorf("got body %s, want invalid_parameter", body)
+				}
+				return
+			}
+			if got.Temperature != nil && *got.Temperature != tt.wantTemperature {
+				t.Errorf("got temperature %v, want %v", *got.Temperature, tt.wantTemperature)
+			}
+			if got.TopP != nil && *got.TopP != tt.wantTopP {
+				t.Errorf("got top_p %v, want %v", *got.TopP, tt.wantTopP)
+			}
+		})
+	}
+}
+
+func TestHandle_StreamingStrictSchema(t *testing.T) {
+	schema := `"response_format":{"type":"json_schema","json_schema":{"name":"answer","strict":%s,"schema":{"type":"object"}}},`
+	tests := []struct {
+		name       string
+		mode       string
+		stream     bool
+		strict     string
+		wantStatus int
+	}{
+		{name: "streaming strict rejected", stream: true, strict: "true", wantStatus: 400},
+		{name: "streaming non-strict", stream: true, strict: "false", wantStatus: 200},
+		{name: "non-streaming strict", strict: "true", wantStatus: 200},
+		{name: "streaming strict unvalidated", mode: "unvalidated", stream: true, strict: "true", wantStatus: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_STREAMING_STRICT_SCHEMA_MODE", tt.mode)
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					return resultJSON(`{}`), nil
+				},
+				stream: func(ctx context.Context, req *models.ChatCompletionRequest) (<-chan string, <-chan error, error) {
+					chunks, errChan := streamOf([]string{deltaLine(`{}`)}, nil)
+					return chunks, errChan, nil
+				},
+			}
+
+			body := fmt.Sprintf(`{"model":"claude-sonnet","stream":%v,`+schema+`"messages":[{"role":"user","content":"hi"}]}`, tt.stream, tt.strict)
+			resp, respBody := postChat(t, newTestApp(exec), body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, respBody)
+			}
+			if tt.wantStatus == 400 && !strings.Contains(respBody, "incompatible_parameters") {
+				t.Errorf("got body %s, want incompatible_parameters", respBody)
+			}
+		})
+	}
+}
+
+func TestHandle_MissingModel(t *testing.T) {
+	tests := []struct {
+		name         string
+		mode         string
+		defaultModel string
+		wantStatus   int
+		wantModel    string
+	}{
+		{name: "default", wantStatus: 200, wantModel: DefaultModel},
+		{name: "configured default", defaultModel: "claude-opus", wantStatus: 200, wantModel: "claude-opus"},
+		{name: "reject", mode: "reject", wantStatus: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_MISSING_MODEL_MODE", tt.mode)
+			t.Setenv("CLAUDEX_DEFAULT_MODEL", tt.defaultModel)
+			var gotModel string
+			exec := &fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					gotModel = req.Model
+					return resultJSON("hi"), nil
+				},
+			}
+
+			resp, respBody := postChat(t, newTestApp(exec), `{"messages":[{"role":"user","content":"hi"}]}`)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, respBody)
+			}
+			if tt.wantStatus == 400 {
+				if !strings.Contains(respBody, `"param":"model"`) || !strings.Contains(respBody, "invalid_request") {
+					t.Errorf("got body %s, want an invalid_request error for model", respBody)
+				}
+				return
+			}
+
+			var out models.ChatCompletionResponse
+			if err := json.Unmarshal([]byte(respBody), &out); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if gotModel != tt.wantModel {
+				t.Errorf("got executor model %q, want %q", gotModel, tt.wantModel)
+			}
+			if out.Model != tt.wantModel {
+				t.Errorf("got response model %q, want %q", out.Model, tt.wantModel)
+			}
+		})
+	}
+}
+
+// imageCheckingExecutor is a fakeExecutor that refuses image URLs the way
+// the real executor configured by checker does.
+type imageCheckingExecutor struct {
+	*fakeExecutor
+	checker *claude.Executor
+}
+
+func (e imageCheckingExecutor) CheckImageURL(url string) error {
+	return e.checker.CheckImageURL(url)
+}
+
+func TestHandle_ImageURLLength(t *testing.T) {
+	checker := claude.NewExecutor()
+	checker.SetMaxImageURLLength(64)
+
+	tests := []struct {
+		name       string
+		data       string
+		wantStatus int
+	}{
+		{name: "within limit", data: "iVBORw0KGgo=", wantStatus: 200},
+		{name: "over limit", data: strings.Repeat("A", 64), wantStatus: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := imageCheckingExecutor{&fakeExecutor{
+				execute: func(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
+					return resultJSON("a cat"), nil
+				},
+			}, checker}
+
+			body := `{"model":"claude-sonnet","messages":[{"role":"user","content":[{"type":"text","text":"what is this?"},` +
+				`{"type":"image_url","image_url":{"url":"data:image/png;base64,` + tt.data + `"}}]}]}`
+			resp, respBody := postChat(t, newTestApp(exec), body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d (body=%s)", resp.StatusCode, tt.wantStatus, respBody)
+			}
+			if tt.wantStatus == 400 && !strings.Contains(respBody, "image_too_large") {
+				t.Errorf("got body %s, want image_too_large", respBody)
+			}
+		})
+	}
+}
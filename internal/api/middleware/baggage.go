@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+// BaggageHeadersFromEnv returns the inbound headers listed in
+// CLAUDEX_BAGGAGE_HEADERS (comma-separated), or nil if none are configured.
+func BaggageHeadersFromEnv() []string {
+	var headers []string
+	for _, h := range strings.Split(os.Getenv("CLAUDEX_BAGGAGE_HEADERS"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, h)
+		}
+	}
+	return headers
+}
+
+// Baggage adds the values of the given inbound headers to the request's
+// OpenTelemetry baggage, keyed by the lowercased header name, so they can be
+// propagated to downstream calls. Headers that are absent are skipped, and
+// baggage already on the request context is kept.
+func Baggage(headers []string) fiber.Handler {
+	keys := make([]string, len(headers))
+	for i, header := range headers {
+		keys[i] = strings.ToLower(header)
+	}
+
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+		bag := baggage.FromContext(ctx)
+		changed := false
+		for i, header := range headers {
+			value := c.Get(header)
+			if value == "" {
+				continue
+			}
+			member, err := baggage.NewMemberRaw(keys[i], value)
+			if err != nil {
+				continue
+			}
+			if updated, err := bag.SetMember(member); err == nil {
+				bag = updated
+				changed = true
+			}
+		}
+
+		if changed {
+			c.SetUserContext(baggage.ContextWithBaggage(ctx, bag))
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+func TestBaggage_FromConfiguredHeaders(t *testing.T) {
+	t.Setenv("CLAUDEX_BAGGAGE_HEADERS", "X-Tenant-ID, X-Team")
+
+	app := fiber.New()
+	app.Use(Baggage(BaggageHeadersFromEnv()))
+	var got baggage.Baggage
+	app.Get("/", func(c *fiber.Ctx) error {
+		got = baggage.FromContext(c.UserContext())
+		return nil
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Tenant-ID", "acme corp")
+	req.Header.Set("X-Other", "ignored")
+	if _, err := app.Test(req); err != nil {
+		t.Fatalf("app.Test: %v", err)
+	}
+
+	if value := got.Member("x-tenant-id").Value(); value != "acme corp" {
+		t.Errorf("got x-tenant-id baggage %q, want %q", value, "acme corp")
+	}
+	if got.Len() != 1 {
+		t.Errorf("got baggage %s, want only x-tenant-id", got)
+	}
+}
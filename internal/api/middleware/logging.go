@@ -1,25 +1,71 @@
 package middleware
 
 import (
+	"os"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/leeaandrob/claudex/internal/observability"
 )
 
+// LoggingConfig configures which requests are logged.
+type LoggingConfig struct {
+	// SampleRate logs 1 in SampleRate requests. Zero logs only the requests
+	// that are always logged.
+	SampleRate int
+	// SlowThreshold always logs requests that take at least this long.
+	// Zero disables it.
+	SlowThreshold time.Duration
+}
+
+// DefaultLoggingConfig returns the default config, which logs every request.
+func DefaultLoggingConfig() LoggingConfig {
+	return LoggingConfig{SampleRate: 1}
+}
+
+// LoggingConfigFromEnv builds a config from CLAUDEX_LOG_SAMPLE_RATE and
+// CLAUDEX_LOG_SLOW_THRESHOLD (a duration such as "2s").
+func LoggingConfigFromEnv() LoggingConfig {
+	cfg := DefaultLoggingConfig()
+
+	if n, err := strconv.Atoi(os.Getenv("CLAUDEX_LOG_SAMPLE_RATE")); err == nil && n >= 0 {
+		cfg.SampleRate = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("CLAUDEX_LOG_SLOW_THRESHOLD")); err == nil && d > 0 {
+		cfg.SlowThreshold = d
+	}
+
+	return cfg
+}
+
 // Logging creates a middleware that logs requests.
 func Logging(logger *observability.Logger) fiber.Handler {
+	return LoggingWithConfig(logger, DefaultLoggingConfig())
+}
+
+// LoggingWithConfig is like Logging but logs only a sample of requests.
+// Failed requests (status 400 and up, or a handler error) and slow requests
+// are always logged on completion. Requests outside the sample get no
+// "request started" line.
+func LoggingWithConfig(logger *observability.Logger, cfg LoggingConfig) fiber.Handler {
+	var count atomic.Uint64
+
 	return func(c *fiber.Ctx) error {
 		start := time.Now()
 		requestID := GetRequestID(c)
+		sampled := cfg.SampleRate > 0 && count.Add(1)%uint64(cfg.SampleRate) == 0
 
 		// Log request start
-		logger.Info("request started",
-			"method", c.Method(),
-			"path", c.Path(),
-			"request_id", requestID,
-			"ip", c.IP(),
-		)
+		if sampled {
+			logger.Info("request started",
+				"method", c.Method(),
+				"path", c.Path(),
+				"request_id", requestID,
+				"ip", c.IP(),
+			)
+		}
 
 		// Process request
 		err := c.Next()
@@ -27,11 +73,17 @@ func Logging(logger *observability.Logger) fiber.Handler {
 		// Calculate duration
 		duration := time.Since(start)
 
+		status := c.Response().StatusCode()
+		slow := cfg.SlowThreshold > 0 && duration >= cfg.SlowThreshold
+		if !sampled && !slow && err == nil && status < fiber.StatusBadRequest {
+			return err
+		}
+
 		// Log request completion
 		logger.Info("request completed",
 			"method", c.Method(),
 			"path", c.Path(),
-			"status", c.Response().StatusCode(),
+			"status", status,
 			"duration_ms", duration.Milliseconds(),
 			"request_id", requestID,
 		)
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/leeaandrob/claudex/internal/observability"
+)
+
+func TestLoggingWithConfig_Sampling(t *testing.T) {
+	t.Setenv("CLAUDEX_LOG_SAMPLE_RATE", "5")
+	t.Setenv("CLAUDEX_LOG_SLOW_THRESHOLD", "50ms")
+
+	var buf bytes.Buffer
+	logger := &observability.Logger{Logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+	app := fiber.New()
+	app.Use(LoggingWithConfig(logger, LoggingConfigFromEnv()))
+	app.Get("/ok", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Get("/fail", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusInternalServerError) })
+	app.Get("/slow", func(c *fiber.Ctx) error {
+		time.Sleep(60 * time.Millisecond)
+		return c.SendString("ok")
+	})
+
+	// 20 successful requests, then 3 failed and 1 slow one
+	paths := []string{"/fail", "/fail", "/fail", "/slow"}
+	for range 20 {
+		paths = append([]string{"/ok"}, paths...)
+	}
+	for _, path := range paths {
+		if _, err := app.Test(httptest.NewRequest("GET", path, nil), -1); err != nil {
+			t.Fatalf("app.Test %s: %v", path, err)
+		}
+	}
+
+	completed := map[string]int{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.Contains(line, `"msg":"request completed"`) {
+			continue
+		}
+		for _, path := range []string{"/ok", "/fail", "/slow"} {
+			if strings.Contains(line, `"path":"`+path+`"`) {
+				completed[path]++
+			}
+		}
+	}
+
+	want := map[string]int{"/ok": 4, "/fail": 3, "/slow": 1}
+	for path, n := range want {
+		if completed[path] != n {
+			t.Errorf("got %d completed lines for %s, want %d", completed[path], path, n)
+		}
+	}
+}
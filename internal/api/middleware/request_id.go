@@ -1,6 +1,9 @@
 package middleware
 
 import (
+	"os"
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 )
@@ -12,11 +15,68 @@ const (
 	RequestIDKey = "request_id"
 )
 
+// RequestIDConfig configures which headers carry the request ID.
+type RequestIDConfig struct {
+	// Headers are checked in order for an inbound request ID.
+	Headers []string
+	// ResponseHeader is the header the request ID is echoed in.
+	ResponseHeader string
+}
+
+// DefaultRequestIDConfig returns the default config using X-Request-ID.
+func DefaultRequestIDConfig() RequestIDConfig {
+	return RequestIDConfig{
+		Headers:        []string{RequestIDHeader},
+		ResponseHeader: RequestIDHeader,
+	}
+}
+
+// RequestIDConfigFromEnv builds a config from CLAUDEX_REQUEST_ID_HEADERS
+// (comma-separated inbound headers) and CLAUDEX_REQUEST_ID_RESPONSE_HEADER.
+func RequestIDConfigFromEnv() RequestIDConfig {
+	cfg := DefaultRequestIDConfig()
+
+	if val := os.Getenv("CLAUDEX_REQUEST_ID_HEADERS"); val != "" {
+		var headers []string
+		for _, h := range strings.Split(val, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				headers = append(headers, h)
+			}
+		}
+		if len(headers) > 0 {
+			cfg.Headers = headers
+		}
+	}
+
+	if val := strings.TrimSpace(os.Getenv("CLAUDEX_REQUEST_ID_RESPONSE_HEADER")); val != "" {
+		cfg.ResponseHeader = val
+	}
+
+	return cfg
+}
+
 // RequestID generates and attaches a unique request ID to each request.
 func RequestID() fiber.Handler {
+	return RequestIDWithConfig(DefaultRequestIDConfig())
+}
+
+// RequestIDWithConfig is like RequestID but reads and writes the configured headers.
+func RequestIDWithConfig(cfg RequestIDConfig) fiber.Handler {
+	if len(cfg.Headers) == 0 {
+		cfg.Headers = []string{RequestIDHeader}
+	}
+	if cfg.ResponseHeader == "" {
+		cfg.ResponseHeader = RequestIDHeader
+	}
+
 	return func(c *fiber.Ctx) error {
-		// Check if request ID already exists in header
-		requestID := c.Get(RequestIDHeader)
+		// Check if request ID already exists in one of the inbound headers
+		var requestID string
+		for _, header := range cfg.Headers {
+			if requestID = c.Get(header); requestID != "" {
+				break
+			}
+		}
 		if requestID == "" {
 			requestID = uuid.New().String()
 		}
@@ -25,7 +85,7 @@ func RequestID() fiber.Handler {
 		c.Locals(RequestIDKey, requestID)
 
 		// Set response header
-		c.Set(RequestIDHeader, requestID)
+		c.Set(cfg.ResponseHeader, requestID)
 
 		return c.Next()
 	}
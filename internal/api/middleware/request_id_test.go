@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func newRequestIDApp(cfg RequestIDConfig) *fiber.App {
+	app := fiber.New()
+	app.Use(RequestIDWithConfig(cfg))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(GetRequestID(c))
+	})
+	return app
+}
+
+func TestRequestID_AlternateInboundHeader(t *testing.T) {
+	app := newRequestIDApp(RequestIDConfig{
+		Headers:        []string{"X-Request-ID", "X-Correlation-ID"},
+		ResponseHeader: "X-Correlation-ID",
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Correlation-ID", "corr-123")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("app.Test: %v", err)
+	}
+
+	if got := resp.Header.Get("X-Correlation-ID"); got != "corr-123" {
+		t.Errorf("got response header %q, want %q", got, "corr-123")
+	}
+}
+
+func TestRequestID_GeneratesWhenMissing(t *testing.T) {
+	app := newRequestIDApp(DefaultRequestIDConfig())
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("app.Test: %v", err)
+	}
+
+	if got := resp.Header.Get(RequestIDHeader); len(got) != 36 {
+		t.Errorf("got generated request ID %q, want a UUID", got)
+	}
+}
+
+func TestRequestIDConfigFromEnv(t *testing.T) {
+	t.Setenv("CLAUDEX_REQUEST_ID_HEADERS", "Request-Id, X-Correlation-ID")
+	t.Setenv("CLAUDEX_REQUEST_ID_RESPONSE_HEADER", "Request-Id")
+
+	cfg := RequestIDConfigFromEnv()
+
+	if len(cfg.Headers) != 2 || cfg.Headers[0] != "Request-Id" || cfg.Headers[1] != "X-Correlation-ID" {
+		t.Errorf("got headers %v, want [Request-Id X-Correlation-ID]", cfg.Headers)
+	}
+	if cfg.ResponseHeader != "Request-Id" {
+		t.Errorf("got response header %q, want Request-Id", cfg.ResponseHeader)
+	}
+}
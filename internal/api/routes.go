@@ -15,18 +15,26 @@ import (
 	"github.com/leeaandrob/claudex/internal/observability"
 )
 
-// RegisterRoutes registers all API routes.
-func RegisterRoutes(app *fiber.App, logger *observability.Logger, metrics *observability.Metrics, executor *claude.Executor, mcpManager *mcp.Manager) {
+// RegisterRoutes registers all API routes. The OpenTelemetry middleware is
+// only installed when tracing is set, i.e. a tracer provider was created.
+func RegisterRoutes(app *fiber.App, logger *observability.Logger, metrics *observability.Metrics, executor *claude.Executor, conv *converter.Converter, mcpManager *mcp.Manager, tracing bool) {
 	// Add OpenTelemetry middleware
-	app.Use(otelfiber.Middleware(
-		otelfiber.WithServerName("openai-claude-proxy"),
-	))
+	if tracing {
+		app.Use(otelfiber.Middleware(
+			otelfiber.WithServerName("openai-claude-proxy"),
+		))
+	}
+
+	// Carry configured headers as baggage for downstream calls
+	if headers := middleware.BaggageHeadersFromEnv(); len(headers) > 0 {
+		app.Use(middleware.Baggage(headers))
+	}
 
 	// Add request ID middleware
-	app.Use(middleware.RequestID())
+	app.Use(middleware.RequestIDWithConfig(middleware.RequestIDConfigFromEnv()))
 
 	// Add logging middleware
-	app.Use(middleware.Logging(logger))
+	app.Use(middleware.LoggingWithConfig(logger, middleware.LoggingConfigFromEnv()))
 
 	// Health check endpoints (no middleware)
 	app.Use(healthcheck.New(healthcheck.Config{
@@ -41,6 +49,16 @@ func RegisterRoutes(app *fiber.App, logger *observability.Logger, metrics *obser
 		ReadinessEndpoint: "/readyz",
 	}))
 
+	// Health check reporting what the installed Claude CLI supports
+	app.Get("/healthz", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"status": "ok",
+			"claude_cli": fiber.Map{
+				"stream_json_input": executor.StreamJSONInputSupport(),
+			},
+		})
+	})
+
 	// Prometheus metrics endpoint
 	app.Get("/metrics", func(c *fiber.Ctx) error {
 		fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())(c.Context())
@@ -49,21 +67,19 @@ func RegisterRoutes(app *fiber.App, logger *observability.Logger, metrics *obser
 
 	// Create chat completions handler
 	parser := claude.NewParser()
-	conv := converter.NewConverter()
 	chatHandler := handlers.NewChatCompletionsHandler(executor, parser, conv, mcpManager, metrics, logger)
 
 	// API routes
 	v1 := app.Group("/v1")
 	v1.Post("/chat/completions", chatHandler.Handle)
+	v1.Get("/chat/completions/:id", chatHandler.HandleGetCompletion)
+
+	// Non-standard batch endpoint (independent prompts in one round-trip)
+	v1.Post("/batch/completions", chatHandler.HandleBatch)
 
 	// MCP tools endpoint (for debugging/discovery)
-	v1.Get("/mcp/tools", func(c *fiber.Ctx) error {
-		tools := mcpManager.GetAllTools()
-		return c.JSON(fiber.Map{
-			"tools": tools,
-			"count": len(tools),
-		})
-	})
+	mcpHandler := handlers.NewMCPHandler(mcpManager)
+	v1.Get("/mcp/tools", mcpHandler.HandleTools)
 
 	// MCP servers endpoint (for debugging/discovery)
 	v1.Get("/mcp/servers", func(c *fiber.Ctx) error {
@@ -73,4 +89,7 @@ func RegisterRoutes(app *fiber.App, logger *observability.Logger, metrics *obser
 			"count":   len(clients),
 		})
 	})
+
+	// Tools of a single MCP server, including names shadowed by another server
+	v1.Get("/mcp/servers/:name/tools", mcpHandler.HandleServerTools)
 }
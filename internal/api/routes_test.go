@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/leeaandrob/claudex/internal/claude"
+	"github.com/leeaandrob/claudex/internal/converter"
+	"github.com/leeaandrob/claudex/internal/mcp"
+	"github.com/leeaandrob/claudex/internal/observability"
+)
+
+func TestRegisterRoutes_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	metrics := observability.InitMetrics()
+	logger := observability.NewLogger("error")
+
+	for _, tracing := range []bool{false, true} {
+		before := len(recorder.Ended())
+
+		app := fiber.New()
+		RegisterRoutes(app, logger, metrics, claude.NewExecutor(), converter.NewConverter(), mcp.NewManager(), tracing)
+		if _, err := app.Test(httptest.NewRequest("GET", "/livez", nil), -1); err != nil {
+			t.Fatalf("app.Test: %v", err)
+		}
+
+		if gotSpans := len(recorder.Ended()) > before; gotSpans != tracing {
+			t.Errorf("tracing=%v: got spans recorded=%v, want %v", tracing, gotSpans, tracing)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package claude
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// StreamJSONInputUnsupportedCode is the CLIError code returned when the
+// installed CLI rejects --input-format stream-json.
+const StreamJSONInputUnsupportedCode = "cli_upgrade_required"
+
+// Stream-json input support of the installed CLI, as reported by
+// StreamJSONInputSupport.
+const (
+	// CapabilityUnknown means no stream-json invocation has finished yet.
+	CapabilityUnknown = "unknown"
+	// CapabilitySupported means the CLI accepted stream-json input.
+	CapabilitySupported = "supported"
+	// CapabilityUnsupported means the CLI rejected the --input-format flag.
+	CapabilityUnsupported = "unsupported"
+)
+
+// inputFormatRejectedPattern matches the errors CLI versions without
+// stream-json input print for --input-format, such as "error: unknown
+// option '--input-format'".
+var inputFormatRejectedPattern = regexp.MustCompile(`(?i)(unknown|unrecognized|invalid|unsupported|not supported).*--input-format|--input-format.*(unknown|unrecognized|invalid|unsupported|not supported|allowed choices)`)
+
+// usesStreamJSONInput reports whether args pass input as stream-json.
+func usesStreamJSONInput(args []string) bool {
+	i := slices.Index(args, "--input-format")
+	return i >= 0 && i+1 < len(args) && args[i+1] == "stream-json"
+}
+
+// streamJSONInputError returns a CLIError telling the caller to upgrade the
+// CLI if stderr shows it rejected --input-format, or nil.
+func streamJSONInputError(stderr string) *CLIError {
+	if !inputFormatRejectedPattern.MatchString(stderr) {
+		return nil
+	}
+	return &CLIError{
+		Code: StreamJSONInputUnsupportedCode,
+		Message: "the installed claude CLI does not support --input-format stream-json, " +
+			"which requests with images or tools need; upgrade the claude CLI (" +
+			strings.TrimSpace(stderr) + ")",
+	}
+}
+
+// checkStreamJSONInput records whether a finished invocation with args
+// shows the CLI supports stream-json input, returning the upgrade error if
+// it was rejected.
+func (e *Executor) checkStreamJSONInput(args []string, stderr string, runErr error) *CLIError {
+	if !usesStreamJSONInput(args) {
+		return nil
+	}
+	if runErr == nil {
+		e.streamJSONInput.Store(CapabilitySupported)
+		return nil
+	}
+	cliErr := streamJSONInputError(stderr)
+	if cliErr != nil {
+		e.streamJSONInput.Store(CapabilityUnsupported)
+	}
+	return cliErr
+}
+
+// StreamJSONInputSupport reports whether the installed CLI accepts
+// stream-json input, as seen by the last invocation that used it.
+func (e *Executor) StreamJSONInputSupport() string {
+	if v, ok := e.streamJSONInput.Load().(string); ok {
+		return v
+	}
+	return CapabilityUnknown
+}
@@ -0,0 +1,74 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// rejectInputFormat is a fake CLI that fails like versions without
+// stream-json input, and answers text prompts.
+const rejectInputFormat = `case "$*" in
+*--input-format*) echo "error: unknown option '--input-format'" >&2; exit 1;;
+esac
+echo '{"type":"result","result":"text answer"}'
+`
+
+func TestExecuteWithMessages_StreamJSONInputUnsupported(t *testing.T) {
+	fakeClaude(t, rejectInputFormat)
+	imageReq := &models.ChatCompletionRequest{Messages: []models.Message{{Role: "user", Content: []models.ContentPart{
+		{Type: "text", Text: "what is this?"},
+		{Type: "image_url", ImageURL: &models.ImageURL{URL: "data:image/png;base64,iVBORw0KGgo="}},
+	}}}}
+
+	e := NewExecutor()
+	if got := e.StreamJSONInputSupport(); got != CapabilityUnknown {
+		t.Errorf("got capability %q before any stream-json request, want %q", got, CapabilityUnknown)
+	}
+
+	// Text prompts don't use stream-json input and still work
+	if _, err := e.ExecuteWithMessages(context.Background(), &models.ChatCompletionRequest{Messages: []models.Message{{Role: "user", Content: "hi"}}}); err != nil {
+		t.Fatalf("text request: %v", err)
+	}
+
+	_, err := e.ExecuteWithMessages(context.Background(), imageReq)
+	var cliErr *CLIError
+	if !errors.As(err, &cliErr) || cliErr.Code != StreamJSONInputUnsupportedCode || !strings.Contains(cliErr.Message, "upgrade the claude CLI") {
+		t.Fatalf("got error %v, want an upgrade error", err)
+	}
+
+	// Streaming requests report the same error
+	chunks, errChan, err := e.ExecuteStreamingWithMessages(context.Background(), imageReq)
+	if err != nil {
+		t.Fatalf("ExecuteStreamingWithMessages: %v", err)
+	}
+	for range chunks {
+	}
+	if err := <-errChan; !errors.As(err, &cliErr) || cliErr.Code != StreamJSONInputUnsupportedCode {
+		t.Errorf("got stream error %v, want an upgrade error", err)
+	}
+
+	if got := e.StreamJSONInputSupport(); got != CapabilityUnsupported {
+		t.Errorf("got capability %q, want %q", got, CapabilityUnsupported)
+	}
+}
+
+func TestStreamJSONInputError(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   bool
+	}{
+		{stderr: "error: unknown option '--input-format'", want: true},
+		{stderr: "error: option '--input-format <format>' argument 'stream-json' is invalid. Allowed choices are text.", want: true},
+		{stderr: "Error: Invalid API key", want: false},
+		{stderr: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := streamJSONInputError(tt.stderr) != nil; got != tt.want {
+			t.Errorf("streamJSONInputError(%q): got %v, want %v", tt.stderr, got, tt.want)
+		}
+	}
+}
@@ -5,19 +5,42 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os/exec"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/leeaandrob/claudex/internal/models"
 )
 
 // Executor handles Claude CLI execution.
-type Executor struct{}
+type Executor struct {
+	idleTimeout time.Duration
+	tracer      *InvocationTracer
+	textOutput  bool // plain text prompts ask for text output
+
+	// assistantTranscript sends assistant turns as labeled user messages
+	assistantTranscript bool
+	// omitAssistantToolCalls leaves tool calls out of assistant turns
+	omitAssistantToolCalls bool
+	// maxImageURLLength caps image data URLs, in bytes; 0 removes the cap
+	maxImageURLLength int
+
+	// streamJSONInput holds the CLI's stream-json input capability
+	streamJSONInput atomic.Value
+}
 
 // NewExecutor creates a new Claude CLI executor.
 func NewExecutor() *Executor {
-	return &Executor{}
+	return &Executor{maxImageURLLength: DefaultMaxImageURLLength}
+}
+
+// SetIdleTimeout sets how long a streaming CLI process may go without
+// producing output before it is killed. Zero disables the idle timeout.
+func (e *Executor) SetIdleTimeout(timeout time.Duration) {
+	e.idleTimeout = timeout
 }
 
 // StreamJSONMessage represents a message in stream-json input format.
@@ -46,20 +69,136 @@ type StreamJSONSource struct {
 	Data      string `json:"data"`       // base64 encoded data
 }
 
+// Execution modes reported by ExecutionMode.
+const (
+	// ExecutionModeText sends the conversation as a plain text prompt.
+	ExecutionModeText = "text"
+	// ExecutionModeStreamJSON sends the messages as stream-json input.
+	ExecutionModeStreamJSON = "stream_json"
+)
+
+// ExecutionMode returns how the CLI input is built for a request: stream-json
+// for images or tools, or when content is complex (arrays), and plain text
+// otherwise.
+func ExecutionMode(req *models.ChatCompletionRequest) string {
+	var e Executor
+	if e.messagesHaveImages(req.Messages) || len(req.Tools) > 0 || e.messagesHaveComplexContent(req.Messages) {
+		return ExecutionModeStreamJSON
+	}
+	return ExecutionModeText
+}
+
+// CLI output formats accepted by SetOutputFormat.
+const (
+	// OutputFormatJSON asks the CLI for a JSON result object (the default).
+	OutputFormatJSON = "json"
+	// OutputFormatText asks the CLI for the bare response text.
+	OutputFormatText = "text"
+)
+
+// SetOutputFormat selects the CLI output format for plain text prompts.
+// Forcing text skips JSON parsing but loses the CLI's usage and session
+// fields. An empty format keeps the default.
+func (e *Executor) SetOutputFormat(format string) error {
+	switch format {
+	case "", OutputFormatJSON:
+		e.textOutput = false
+	case OutputFormatText:
+		e.textOutput = true
+	default:
+		return fmt.Errorf("unknown CLI output format %q", format)
+	}
+	return nil
+}
+
+// Representations of assistant history in stream-json input, accepted by
+// SetAssistantHistory.
+const (
+	// AssistantHistoryMessages sends assistant turns as stream-json
+	// assistant messages (the default).
+	AssistantHistoryMessages = "assistant"
+	// AssistantHistoryTranscript sends assistant turns as user messages
+	// labeled "Assistant: ", the way text prompts render them.
+	AssistantHistoryTranscript = "transcript"
+)
+
+// SetAssistantHistory selects how assistant turns are sent in stream-json
+// input. An empty value keeps the default.
+func (e *Executor) SetAssistantHistory(mode string) error {
+	switch mode {
+	case "", AssistantHistoryMessages:
+		e.assistantTranscript = false
+	case AssistantHistoryTranscript:
+		e.assistantTranscript = true
+	default:
+		return fmt.Errorf("unknown assistant history mode %q", mode)
+	}
+	return nil
+}
+
+// Renderings of the tool_calls on assistant messages in CLI input, accepted
+// by SetAssistantToolCalls.
+const (
+	// AssistantToolCallsJSON appends the calls to the message text as the
+	// tool_calls JSON block the tools prompt asks the model to write (the
+	// default), so the transcript shows which calls the tool results answer.
+	AssistantToolCallsJSON = "json"
+	// AssistantToolCallsOmit sends only the message text.
+	AssistantToolCallsOmit = "omit"
+)
+
+// SetAssistantToolCalls selects how tool calls on assistant messages are
+// rendered in CLI input. An empty value keeps the default.
+func (e *Executor) SetAssistantToolCalls(mode string) error {
+	switch mode {
+	case "", AssistantToolCallsJSON:
+		e.omitAssistantToolCalls = false
+	case AssistantToolCallsOmit:
+		e.omitAssistantToolCalls = true
+	default:
+		return fmt.Errorf("unknown assistant tool calls mode %q", mode)
+	}
+	return nil
+}
+
+// assistantText returns an assistant message's text, followed by its tool
+// calls unless they are omitted.
+func (e *Executor) assistantText(msg models.Message) string {
+	text := msg.GetTextContent()
+	if len(msg.ToolCalls) == 0 || e.omitAssistantToolCalls {
+		return text
+	}
+
+	block, err := json.Marshal(struct {
+		ToolCalls []models.ToolCall `json:"tool_calls"`
+	}{msg.ToolCalls})
+	if err != nil {
+		return text
+	}
+	calls := "```json\n" + string(block) + "\n```"
+	if text == "" {
+		return calls
+	}
+	return text + "\n\n" + calls
+}
+
+// OutputFormat returns the CLI output format used for a request. Forced text
+// output only applies to non-streaming requests in text execution mode;
+// stream-json input always produces JSON.
+func (e *Executor) OutputFormat(req *models.ChatCompletionRequest) string {
+	if e.textOutput && !req.Stream && ExecutionMode(req) == ExecutionModeText {
+		return OutputFormatText
+	}
+	return OutputFormatJSON
+}
+
 // ExecuteWithMessages executes Claude CLI with OpenAI-style messages.
 // Supports images and tools via stream-json input format.
 func (e *Executor) ExecuteWithMessages(ctx context.Context, req *models.ChatCompletionRequest) (string, error) {
 	// Build system prompt with tools if present
 	systemPrompt := e.buildSystemPromptWithTools(req)
 
-	// Check if we need stream-json input (for images or tools)
-	hasImages := e.messagesHaveImages(req.Messages)
-	hasTools := len(req.Tools) > 0
-
-	// Use stream-json for images or tools, or when content is complex (arrays)
-	useStreamJSON := hasImages || hasTools || e.messagesHaveComplexContent(req.Messages)
-
-	if useStreamJSON {
+	if ExecutionMode(req) == ExecutionModeStreamJSON {
 		return e.executeWithStreamJSON(ctx, req.Messages, systemPrompt, req.Stream)
 	}
 
@@ -70,7 +209,19 @@ func (e *Executor) ExecuteWithMessages(ctx context.Context, req *models.ChatComp
 		// This method is for non-streaming only
 		return "", fmt.Errorf("use ExecuteStreamingWithMessages for streaming")
 	}
-	return e.ExecuteNonStreaming(ctx, prompt, systemPrompt)
+	return e.executeNonStreaming(ctx, prompt, systemPrompt, e.OutputFormat(req))
+}
+
+// AssemblePrompt returns the system prompt and the prompt input that
+// ExecuteWithMessages passes to the CLI for req. In stream-json mode the
+// prompt is the stream-json message input.
+func (e *Executor) AssemblePrompt(req *models.ChatCompletionRequest) (string, string, error) {
+	systemPrompt := e.buildSystemPromptWithTools(req)
+	if ExecutionMode(req) == ExecutionModeStreamJSON {
+		input, err := e.buildStreamJSONInput(req.Messages)
+		return systemPrompt, input, err
+	}
+	return systemPrompt, e.messagesToPrompt(req.Messages), nil
 }
 
 // messagesHaveComplexContent checks if any message has array content (potential images).
@@ -89,14 +240,7 @@ func (e *Executor) ExecuteStreamingWithMessages(ctx context.Context, req *models
 	// Build system prompt with tools if present
 	systemPrompt := e.buildSystemPromptWithTools(req)
 
-	// Check if we need stream-json input (for images or tools)
-	hasImages := e.messagesHaveImages(req.Messages)
-	hasTools := len(req.Tools) > 0
-
-	// Use stream-json for images or tools, or when content is complex (arrays)
-	useStreamJSON := hasImages || hasTools || e.messagesHaveComplexContent(req.Messages)
-
-	if useStreamJSON {
+	if ExecutionMode(req) == ExecutionModeStreamJSON {
 		return e.executeStreamingWithStreamJSON(ctx, req.Messages, systemPrompt)
 	}
 
@@ -117,30 +261,30 @@ func (e *Executor) executeWithStreamJSON(ctx context.Context, messages []models.
 	cmd := exec.CommandContext(ctx, "claude", args...)
 
 	// Convert messages to stream-json format
-	var inputLines []string
-	for _, msg := range messages {
-		if msg.Role == "system" {
-			continue // System prompt handled separately
-		}
-
-		streamMsg := e.convertToStreamJSON(msg)
-		jsonBytes, err := json.Marshal(streamMsg)
-		if err != nil {
-			return "", fmt.Errorf("failed to marshal message: %w", err)
-		}
-		inputLines = append(inputLines, string(jsonBytes))
+	input, err := e.buildStreamJSONInput(messages)
+	if err != nil {
+		return "", err
 	}
-
-	// Join with newlines for NDJSON
-	input := strings.Join(inputLines, "\n")
 	cmd.Stdin = bytes.NewReader([]byte(input))
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
+	start := time.Now()
+	err = cmd.Run()
+	e.trace(start, args, input, stdout.String(), stderr.String(), err)
+	if cliErr := e.checkStreamJSONInput(args, stderr.String(), err); cliErr != nil {
+		return "", cliErr
+	}
+	if err != nil {
+		if cliErr := ParseCLIError(stdout.String()); cliErr != nil {
+			return "", cliErr
+		}
 		stderrStr := stderr.String()
+		if cliErr := ParseRateLimit(stderrStr); cliErr != nil {
+			return "", cliErr
+		}
 		if stderrStr != "" {
 			return "", fmt.Errorf("claude cli error: %s", stderrStr)
 		}
@@ -155,6 +299,11 @@ func (e *Executor) executeWithStreamJSON(ctx context.Context, messages []models.
 func (e *Executor) parseStreamJSONOutput(output string) (string, error) {
 	var resultText string
 
+	// Surface CLI errors reported in the stream rather than an empty result
+	if cliErr := ParseCLIError(output); cliErr != nil {
+		return "", cliErr
+	}
+
 	lines := strings.Split(output, "\n")
 	for _, line := range lines {
 		if line == "" {
@@ -235,82 +384,34 @@ func (e *Executor) executeStreamingWithStreamJSON(ctx context.Context, messages
 		args = append(args, "--system-prompt", systemPrompt)
 	}
 
-	cmd := exec.CommandContext(ctx, "claude", args...)
-
 	// Convert messages to stream-json format
+	input, err := e.buildStreamJSONInput(messages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return e.startStreaming(ctx, args, input)
+}
+
+// buildStreamJSONInput converts messages to NDJSON stream-json input.
+// System and developer messages are skipped since they go in the system prompt.
+func (e *Executor) buildStreamJSONInput(messages []models.Message) (string, error) {
 	var inputLines []string
 	for _, msg := range messages {
-		if msg.Role == "system" {
+		if isSystemRole(msg.Role) {
 			continue // System prompt handled separately
 		}
 
 		streamMsg := e.convertToStreamJSON(msg)
 		jsonBytes, err := json.Marshal(streamMsg)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to marshal message: %w", err)
+			return "", fmt.Errorf("failed to marshal message: %w", err)
 		}
 		inputLines = append(inputLines, string(jsonBytes))
 	}
 
-	input := strings.Join(inputLines, "\n")
-	cmd.Stdin = bytes.NewReader([]byte(input))
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create stdout pipe: %w", err)
-	}
-
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create stderr pipe: %w", err)
-	}
-
-	if err := cmd.Start(); err != nil {
-		return nil, nil, fmt.Errorf("failed to start claude cli: %w", err)
-	}
-
-	chunks := make(chan string, 100)
-	errChan := make(chan error, 1)
-
-	go func() {
-		defer close(chunks)
-		defer close(errChan)
-
-		var stderrBuf bytes.Buffer
-		go func() {
-			scanner := bufio.NewScanner(stderr)
-			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
-			for scanner.Scan() {
-				stderrBuf.WriteString(scanner.Text())
-				stderrBuf.WriteString("\n")
-			}
-		}()
-
-		scanner := bufio.NewScanner(stdout)
-		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if line != "" {
-				chunks <- line
-			}
-		}
-
-		if err := scanner.Err(); err != nil {
-			errChan <- fmt.Errorf("scanner error: %w", err)
-			return
-		}
-
-		if err := cmd.Wait(); err != nil {
-			if stderrBuf.Len() > 0 {
-				errChan <- fmt.Errorf("claude cli error: %s", stderrBuf.String())
-			} else {
-				errChan <- fmt.Errorf("claude cli error: %w", err)
-			}
-			return
-		}
-	}()
-
-	return chunks, errChan, nil
+	// Join with newlines for NDJSON
+	return strings.Join(inputLines, "\n"), nil
 }
 
 // convertToStreamJSON converts an OpenAI message to stream-json format.
@@ -324,13 +425,40 @@ func (e *Executor) convertToStreamJSON(msg models.Message) StreamJSONMessage {
 
 	// Map OpenAI roles to Claude roles
 	if msg.Role == "assistant" {
+		if e.assistantTranscript {
+			// Rendered as a labeled transcript turn, as in text prompts
+			streamMsg.Message.Role = "user"
+			streamMsg.Message.Content = "Assistant: " + e.assistantText(msg)
+			return streamMsg
+		}
 		streamMsg.Type = "assistant"
+		if len(msg.ToolCalls) > 0 {
+			streamMsg.Message.Content = e.assistantText(msg)
+			return streamMsg
+		}
 	} else if msg.Role == "tool" {
 		// Tool results are sent as user messages
 		streamMsg.Type = "user"
 		streamMsg.Message.Role = "user"
-		// Include tool result as text
-		streamMsg.Message.Content = fmt.Sprintf("[Tool Result for %s]: %s", msg.ToolCallID, msg.GetTextContent())
+		// Include tool result as text, or as blocks in their original order
+		// if it returned images
+		header := fmt.Sprintf("[Tool Result for %s]:", msg.ToolCallID)
+		streamMsg.Message.Content = header + " " + msg.GetTextContent()
+		if msg.HasImages() {
+			var blocks []StreamJSONContent
+			switch c := msg.Content.(type) {
+			case []models.ContentPart:
+				blocks = e.convertContentParts(c)
+			case []any:
+				blocks = e.convertContentPartsFromAny(c)
+			}
+			if len(blocks) > 0 && blocks[0].Type == "text" {
+				blocks[0].Text = header + " " + blocks[0].Text
+			} else {
+				blocks = append([]StreamJSONContent{{Type: "text", Text: header}}, blocks...)
+			}
+			streamMsg.Message.Content = blocks
+		}
 		return streamMsg
 	}
 
@@ -394,13 +522,42 @@ func (e *Executor) convertContentPartsFromAny(parts []any) []StreamJSONContent {
 	return result
 }
 
+// DefaultMaxImageURLLength is the default cap on the length of an image
+// data URL, in bytes.
+const DefaultMaxImageURLLength = 20 << 20
+
+// ErrImageURLTooLong is returned by CheckImageURL for data URLs over the
+// length cap.
+var ErrImageURLTooLong = errors.New("image data URL is too long")
+
+// SetMaxImageURLLength caps the length of image data URLs, in bytes. Zero
+// removes the cap.
+func (e *Executor) SetMaxImageURLLength(n int) {
+	e.maxImageURLLength = max(n, 0)
+}
+
+// CheckImageURL returns ErrImageURLTooLong if url is a data URL over the
+// length cap. Only the length is checked, so it is cheap to call before the
+// image is decoded.
+func (e *Executor) CheckImageURL(url string) error {
+	limit := e.maxImageURLLength
+	if limit > 0 && strings.HasPrefix(url, "data:") && len(url) > limit {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrImageURLTooLong, len(url), limit)
+	}
+	return nil
+}
+
 // convertImageURL converts an OpenAI image_url to stream-json image format.
+// Data URLs over the length cap are dropped.
 func (e *Executor) convertImageURL(imageURL *models.ImageURL) *StreamJSONContent {
 	if imageURL == nil {
 		return nil
 	}
 
 	url := imageURL.URL
+	if e.CheckImageURL(url) != nil {
+		return nil
+	}
 
 	// Parse data URL: data:image/png;base64,xxxxx
 	if strings.HasPrefix(url, "data:") {
@@ -441,26 +598,113 @@ func (e *Executor) buildSystemPromptWithTools(req *models.ChatCompletionRequest)
 
 	// Get system prompt from messages
 	for _, msg := range req.Messages {
-		if msg.Role == "system" {
+		if isSystemRole(msg.Role) {
 			parts = append(parts, msg.GetTextContent())
 		}
 	}
 
 	// Add tool definitions if present
 	if len(req.Tools) > 0 {
-		toolsPrompt := e.buildToolsPrompt(req.Tools, req.ToolChoice)
+		toolsPrompt := e.buildToolsPrompt(req.Tools, req.ToolChoice, req.Model)
 		parts = append(parts, toolsPrompt)
 	}
 
+	// Add output format instructions if requested
+	if formatPrompt := buildResponseFormatPrompt(req.ResponseFormat); formatPrompt != "" {
+		parts = append(parts, formatPrompt)
+	}
+
 	return strings.Join(parts, "\n\n")
 }
 
-// buildToolsPrompt creates a prompt section describing available tools.
-func (e *Executor) buildToolsPrompt(tools []models.Tool, toolChoice any) string {
+// buildResponseFormatPrompt creates a prompt section for JSON response
+// formats, or returns "" for plain text.
+func buildResponseFormatPrompt(rf *models.ResponseFormat) string {
+	if rf == nil {
+		return ""
+	}
+
 	var sb strings.Builder
+	switch rf.Type {
+	case "json_object":
+		sb.WriteString("## Response Format\n\n")
+		sb.WriteString("Respond with a single valid JSON object and nothing else: no prose, no markdown code fences.\n")
+	case "json_schema":
+		if rf.JSONSchema == nil {
+			return ""
+		}
+		sb.WriteString("## Response Format\n\n")
+		sb.WriteString("Respond with a single valid JSON value that conforms to the JSON schema below, and nothing else: no prose, no markdown code fences.\n")
+		if rf.JSONSchema.Description != "" {
+			sb.WriteString(fmt.Sprintf("Description: %s\n", rf.JSONSchema.Description))
+		}
+		sb.WriteString(fmt.Sprintf("\n```json\n%s\n```\n", string(rf.JSONSchema.Schema)))
+		if rf.JSONSchema.Strict {
+			sb.WriteString("\nThe response is validated against the schema: include every required property and no properties the schema does not allow.\n")
+		}
+	default:
+		return ""
+	}
+	return sb.String()
+}
+
+// toolsPromptVariant holds the model-specific wording of the tool-calling prompt.
+type toolsPromptVariant struct {
+	intro string   // Instruction preceding the format example
+	rules []string // Numbered rules following the format example
+}
+
+// defaultToolsPromptVariant is used for models without a specific variant.
+var defaultToolsPromptVariant = toolsPromptVariant{
+	intro: "You have access to the following tools. When you decide to use a tool, you MUST respond with ONLY a JSON object (no other text before or after) in this exact format:",
+	rules: []string{
+		"The 'arguments' field MUST be a JSON-encoded STRING, not a raw object",
+		"Generate unique IDs like 'call_' followed by random alphanumeric characters",
+		"When using tools, output ONLY the JSON - no explanation text",
+		"You can include brief reasoning BEFORE the JSON if needed, but the JSON must be last",
+	},
+}
+
+// strictToolsPromptVariant leaves no room for prose around tool calls, which
+// smaller models otherwise tend to add.
+var strictToolsPromptVariant = toolsPromptVariant{
+	intro: "You have access to the following tools. To use a tool, your ENTIRE response must be a single JSON object in exactly this format, with nothing before or after it:",
+	rules: []string{
+		"The 'arguments' field MUST be a JSON-encoded STRING, not a raw object",
+		"Generate unique IDs like 'call_' followed by random alphanumeric characters",
+		"Do NOT write reasoning, explanations, or any text outside the JSON when calling tools",
+		"Only call tools listed under Tool Definitions, using their exact names",
+	},
+}
+
+// toolsPromptVariants maps model name substrings to prompt variants. The first
+// match against the lower-cased model name wins.
+var toolsPromptVariants = []struct {
+	match   string
+	variant toolsPromptVariant
+}{
+	{match: "haiku", variant: strictToolsPromptVariant},
+}
+
+// toolsPromptVariantFor returns the tool prompt variant for a model.
+func toolsPromptVariantFor(model string) toolsPromptVariant {
+	model = strings.ToLower(model)
+	for _, v := range toolsPromptVariants {
+		if strings.Contains(model, v.match) {
+			return v.variant
+		}
+	}
+	return defaultToolsPromptVariant
+}
+
+// buildToolsPrompt creates a prompt section describing available tools,
+// worded for the requested model.
+func (e *Executor) buildToolsPrompt(tools []models.Tool, toolChoice any, model string) string {
+	var sb strings.Builder
+	variant := toolsPromptVariantFor(model)
 
 	sb.WriteString("## Available Tools\n\n")
-	sb.WriteString("You have access to the following tools. When you decide to use a tool, you MUST respond with ONLY a JSON object (no other text before or after) in this exact format:\n\n")
+	sb.WriteString(variant.intro + "\n\n")
 	sb.WriteString("```json\n")
 	sb.WriteString("{\n")
 	sb.WriteString("  \"tool_calls\": [\n")
@@ -476,10 +720,10 @@ func (e *Executor) buildToolsPrompt(tools []models.Tool, toolChoice any) string
 	sb.WriteString("}\n")
 	sb.WriteString("```\n\n")
 	sb.WriteString("CRITICAL RULES:\n")
-	sb.WriteString("1. The 'arguments' field MUST be a JSON-encoded STRING, not a raw object\n")
-	sb.WriteString("2. Generate unique IDs like 'call_' followed by random alphanumeric characters\n")
-	sb.WriteString("3. When using tools, output ONLY the JSON - no explanation text\n")
-	sb.WriteString("4. You can include brief reasoning BEFORE the JSON if needed, but the JSON must be last\n\n")
+	for i, rule := range variant.rules {
+		sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, rule))
+	}
+	sb.WriteString("\n")
 
 	sb.WriteString("### Tool Definitions:\n\n")
 
@@ -520,6 +764,12 @@ func (e *Executor) buildToolsPrompt(tools []models.Tool, toolChoice any) string
 	return sb.String()
 }
 
+// isSystemRole reports whether a role carries system instructions.
+// OpenAI's newer "developer" role is treated the same as "system".
+func isSystemRole(role string) bool {
+	return role == "system" || role == "developer"
+}
+
 // messagesHaveImages checks if any message contains images.
 func (e *Executor) messagesHaveImages(messages []models.Message) bool {
 	for _, msg := range messages {
@@ -536,12 +786,12 @@ func (e *Executor) messagesToPrompt(messages []models.Message) string {
 
 	for _, msg := range messages {
 		switch msg.Role {
-		case "system":
+		case "system", "developer":
 			// Skip, handled separately
 		case "user":
 			parts = append(parts, "User: "+msg.GetTextContent())
 		case "assistant":
-			parts = append(parts, "Assistant: "+msg.GetTextContent())
+			parts = append(parts, "Assistant: "+e.assistantText(msg))
 		case "tool":
 			parts = append(parts, fmt.Sprintf("[Tool Result for %s]: %s", msg.ToolCallID, msg.GetTextContent()))
 		}
@@ -556,7 +806,11 @@ func (e *Executor) messagesToPrompt(messages []models.Message) string {
 
 // ExecuteNonStreaming executes Claude CLI and returns the complete response.
 func (e *Executor) ExecuteNonStreaming(ctx context.Context, prompt, systemPrompt string) (string, error) {
-	args := []string{"-p", "--output-format", "json", "--dangerously-skip-permissions", "--no-chrome"}
+	return e.executeNonStreaming(ctx, prompt, systemPrompt, OutputFormatJSON)
+}
+
+func (e *Executor) executeNonStreaming(ctx context.Context, prompt, systemPrompt, format string) (string, error) {
+	args := []string{"-p", "--output-format", format, "--dangerously-skip-permissions", "--no-chrome"}
 
 	if systemPrompt != "" {
 		args = append(args, "--system-prompt", systemPrompt)
@@ -570,8 +824,17 @@ func (e *Executor) ExecuteNonStreaming(ctx context.Context, prompt, systemPrompt
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
+	start := time.Now()
+	err := cmd.Run()
+	e.trace(start, args, prompt, stdout.String(), stderr.String(), err)
+	if err != nil {
+		if cliErr := ParseCLIError(stdout.String()); cliErr != nil {
+			return "", cliErr
+		}
 		stderrStr := stderr.String()
+		if cliErr := ParseRateLimit(stderrStr); cliErr != nil {
+			return "", cliErr
+		}
 		if stderrStr != "" {
 			return "", fmt.Errorf("claude cli error: %s", stderrStr)
 		}
@@ -590,20 +853,33 @@ func (e *Executor) ExecuteStreaming(ctx context.Context, prompt, systemPrompt st
 	}
 	args = append(args, "-")
 
+	return e.startStreaming(ctx, args, prompt)
+}
+
+// startStreaming starts the Claude CLI and streams its non-empty stdout lines.
+// When an idle timeout is set and no line arrives within it (including before
+// the first line), the process is killed and an idle timeout error is sent.
+func (e *Executor) startStreaming(ctx context.Context, args []string, input string) (<-chan string, <-chan error, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
 	cmd := exec.CommandContext(ctx, "claude", args...)
-	cmd.Stdin = bytes.NewReader([]byte(prompt))
+	cmd.Stdin = strings.NewReader(input)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
+		cancel()
 		return nil, nil, fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
+		cancel()
 		return nil, nil, fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
 
+	start := time.Now()
 	if err := cmd.Start(); err != nil {
+		cancel()
 		return nil, nil, fmt.Errorf("failed to start claude cli: %w", err)
 	}
 
@@ -611,11 +887,19 @@ func (e *Executor) ExecuteStreaming(ctx context.Context, prompt, systemPrompt st
 	errChan := make(chan error, 1)
 
 	go func() {
+		defer cancel()
 		defer close(chunks)
 		defer close(errChan)
 
 		var stderrBuf bytes.Buffer
+		var stdoutBuf strings.Builder // kept only for tracing
+		var runErr error
+		defer func() {
+			e.trace(start, args, input, stdoutBuf.String(), stderrBuf.String(), runErr)
+		}()
+		stderrDone := make(chan struct{})
 		go func() {
+			defer close(stderrDone)
 			scanner := bufio.NewScanner(stderr)
 			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 			for scanner.Scan() {
@@ -624,25 +908,78 @@ func (e *Executor) ExecuteStreaming(ctx context.Context, prompt, systemPrompt st
 			}
 		}()
 
+		// Kill the process if it produces no output for idleTimeout
+		var idle atomic.Bool
+		var idleTimer *time.Timer
+		if e.idleTimeout > 0 {
+			idleTimer = time.AfterFunc(e.idleTimeout, func() {
+				idle.Store(true)
+				cancel()
+			})
+			defer idleTimer.Stop()
+		}
+
 		scanner := bufio.NewScanner(stdout)
 		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 		for scanner.Scan() {
 			line := scanner.Text()
-			if line != "" {
-				chunks <- line
+			if line == "" {
+				continue
+			}
+			if e.tracer != nil {
+				stdoutBuf.WriteString(line)
+				stdoutBuf.WriteString("\n")
+			}
+
+			// Don't count time blocked on a slow consumer as inactivity
+			if idleTimer != nil && !idleTimer.Stop() {
+				break
+			}
+			// A consumer that stops reading cancels ctx, which also kills the
+			// CLI, so the send must not block past it
+			select {
+			case chunks <- line:
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			if idleTimer != nil {
+				idleTimer.Reset(e.idleTimeout)
 			}
 		}
 
+		<-stderrDone
+
+		if idle.Load() {
+			cmd.Wait()
+			runErr = fmt.Errorf("claude cli idle timeout: no output for %v", e.idleTimeout)
+			errChan <- runErr
+			return
+		}
+
 		if err := scanner.Err(); err != nil {
-			errChan <- fmt.Errorf("scanner error: %w", err)
+			runErr = fmt.Errorf("scanner error: %w", err)
+			errChan <- runErr
 			return
 		}
 
-		if err := cmd.Wait(); err != nil {
-			if stderrBuf.Len() > 0 {
-				errChan <- fmt.Errorf("claude cli error: %s", stderrBuf.String())
+		waitErr := cmd.Wait()
+		if cliErr := e.checkStreamJSONInput(args, stderrBuf.String(), waitErr); cliErr != nil {
+			runErr = cliErr
+			errChan <- runErr
+			return
+		}
+		if waitErr != nil {
+			if cliErr := ParseRateLimit(stderrBuf.String()); cliErr != nil {
+				runErr = cliErr
+				errChan <- runErr
+			} else if stderrBuf.Len() > 0 {
+				runErr = fmt.Errorf("claude cli error: %s", stderrBuf.String())
+				errChan <- runErr
 			} else {
-				errChan <- fmt.Errorf("claude cli error: %w", err)
+				runErr = fmt.Errorf("claude cli error: %w", waitErr)
+				errChan <- runErr
 			}
 			return
 		}
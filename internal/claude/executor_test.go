@@ -0,0 +1,514 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestBuildSystemPromptWithTools_DeveloperRole(t *testing.T) {
+	e := NewExecutor()
+	req := &models.ChatCompletionRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: "System instructions."},
+			{Role: "developer", Content: "Developer instructions."},
+			{Role: "user", Content: "Hello"},
+		},
+	}
+
+	got := e.buildSystemPromptWithTools(req)
+
+	if !strings.Contains(got, "System instructions.") {
+		t.Errorf("system prompt %q missing system message", got)
+	}
+	if !strings.Contains(got, "Developer instructions.") {
+		t.Errorf("system prompt %q missing developer message", got)
+	}
+}
+
+func TestMessagesToPrompt_SkipsDeveloperRole(t *testing.T) {
+	e := NewExecutor()
+	messages := []models.Message{
+		{Role: "developer", Content: "Developer instructions."},
+		{Role: "user", Content: "Hello"},
+	}
+
+	if got := e.messagesToPrompt(messages); got != "Hello" {
+		t.Errorf("got prompt %q, want %q", got, "Hello")
+	}
+}
+
+func TestBuildStreamJSONInput_SkipsDeveloperRole(t *testing.T) {
+	e := NewExecutor()
+	messages := []models.Message{
+		{Role: "developer", Content: "Developer instructions."},
+		{Role: "user", Content: "Hello"},
+	}
+
+	input, err := e.buildStreamJSONInput(messages)
+	if err != nil {
+		t.Fatalf("buildStreamJSONInput: %v", err)
+	}
+
+	if strings.Contains(input, "Developer instructions.") {
+		t.Errorf("stream-json input %q should not contain developer message", input)
+	}
+	if lines := strings.Split(input, "\n"); len(lines) != 1 {
+		t.Errorf("got %d input lines, want 1", len(lines))
+	}
+}
+
+// fakeClaude puts a shell script named claude at the front of PATH.
+func fakeClaude(t *testing.T, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "claude"), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestExecuteStreaming_IdleTimeout(t *testing.T) {
+	fakeClaude(t, "echo first\nexec sleep 10\n")
+
+	e := NewExecutor()
+	e.SetIdleTimeout(200 * time.Millisecond)
+
+	start := time.Now()
+	chunks, errChan, err := e.ExecuteStreaming(context.Background(), "hi", "")
+	if err != nil {
+		t.Fatalf("ExecuteStreaming: %v", err)
+	}
+
+	var lines []string
+	for line := range chunks {
+		lines = append(lines, line)
+	}
+	streamErr := <-errChan
+
+	if len(lines) != 1 || lines[0] != "first" {
+		t.Errorf("got lines %q, want [first]", lines)
+	}
+	if streamErr == nil || !strings.Contains(streamErr.Error(), "idle timeout") {
+		t.Errorf("got error %v, want idle timeout", streamErr)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stalled process ran for %v, want it killed promptly", elapsed)
+	}
+}
+
+func TestExecuteStreaming_ConsumerStopsReading(t *testing.T) {
+	fakeClaude(t, "i=0; while [ $i -lt 500 ]; do echo line$i; i=$((i+1)); done\nexec sleep 10\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chunks, errChan, err := NewExecutor().ExecuteStreaming(ctx, "hi", "")
+	if err != nil {
+		t.Fatalf("ExecuteStreaming: %v", err)
+	}
+
+	// Read one line, then give up on the stream with the rest pending
+	<-chunks
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for range errChan {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("producer did not exit after the consumer canceled")
+	}
+}
+
+func TestExecuteNonStreaming_RateLimit(t *testing.T) {
+	fakeClaude(t, "echo 'API Error: 429 Too Many Requests. Please try again in 30 seconds' >&2\nexit 1\n")
+
+	_, err := NewExecutor().ExecuteNonStreaming(context.Background(), "hi", "")
+	var cliErr *CLIError
+	if !errors.As(err, &cliErr) {
+		t.Fatalf("got error %v, want *CLIError", err)
+	}
+	if cliErr.Code != RateLimitErrorCode || cliErr.RetryAfter != 30*time.Second {
+		t.Errorf("got code %q retry after %v, want %q 30s", cliErr.Code, cliErr.RetryAfter, RateLimitErrorCode)
+	}
+}
+
+func TestExecuteStreaming_NoIdleTimeoutWhileActive(t *testing.T) {
+	fakeClaude(t, "for i in 1 2 3; do echo line$i; sleep 0.1; done\n")
+
+	e := NewExecutor()
+	e.SetIdleTimeout(time.Second)
+
+	chunks, errChan, err := e.ExecuteStreaming(context.Background(), "hi", "")
+	if err != nil {
+		t.Fatalf("ExecuteStreaming: %v", err)
+	}
+
+	var n int
+	for range chunks {
+		n++
+	}
+	if err := <-errChan; err != nil {
+		t.Errorf("got error %v, want nil", err)
+	}
+	if n != 3 {
+		t.Errorf("got %d lines, want 3", n)
+	}
+}
+
+func TestToolsPromptVariantFor(t *testing.T) {
+	tests := []struct {
+		model      string
+		wantStrict bool
+	}{
+		{model: "claude-3-5-haiku-20241022", wantStrict: true},
+		{model: "Claude-Haiku-4-5", wantStrict: true},
+		{model: "claude-sonnet-4-5", wantStrict: false},
+		{model: "claude-opus-4-1", wantStrict: false},
+		{model: "", wantStrict: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			got := toolsPromptVariantFor(tt.model)
+			if isStrict := got.intro == strictToolsPromptVariant.intro; isStrict != tt.wantStrict {
+				t.Errorf("got strict=%v, want %v", isStrict, tt.wantStrict)
+			}
+		})
+	}
+}
+
+func TestBuildToolsPrompt_ModelVariant(t *testing.T) {
+	e := NewExecutor()
+	tools := []models.Tool{{Type: "function", Function: models.Function{Name: "get_weather"}}}
+
+	strict := e.buildToolsPrompt(tools, nil, "claude-3-5-haiku")
+	if !strings.Contains(strict, strictToolsPromptVariant.intro) {
+		t.Error("haiku prompt does not use the strict variant")
+	}
+	if strings.Contains(strict, "brief reasoning BEFORE the JSON") {
+		t.Error("haiku prompt allows reasoning before the JSON")
+	}
+
+	def := e.buildToolsPrompt(tools, nil, "claude-sonnet-4-5")
+	if !strings.Contains(def, defaultToolsPromptVariant.intro) || !strings.Contains(def, "4. You can include brief reasoning") {
+		t.Error("sonnet prompt does not use the default variant")
+	}
+
+	for _, prompt := range []string{strict, def} {
+		if !strings.Contains(prompt, "#### get_weather") {
+			t.Error("prompt is missing the tool definition")
+		}
+	}
+}
+
+func TestBuildSystemPromptWithTools_ResponseFormat(t *testing.T) {
+	e := NewExecutor()
+	schema := `{"type":"object","required":["answer"]}`
+	tests := []struct {
+		name   string
+		format *models.ResponseFormat
+		want   []string
+	}{
+		{name: "text", format: &models.ResponseFormat{Type: "text"}},
+		{name: "json_object", format: &models.ResponseFormat{Type: "json_object"}, want: []string{"single valid JSON object"}},
+		{
+			name:   "strict json_schema",
+			format: &models.ResponseFormat{Type: "json_schema", JSONSchema: &models.JSONSchemaFormat{Name: "answer", Schema: []byte(schema), Strict: true}},
+			want:   []string{"conforms to the JSON schema", schema, "validated against the schema"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := e.buildSystemPromptWithTools(&models.ChatCompletionRequest{
+				Messages:       []models.Message{{Role: "user", Content: "Hello"}},
+				ResponseFormat: tt.format,
+			})
+			if len(tt.want) == 0 && got != "" {
+				t.Errorf("got system prompt %q, want empty", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("system prompt %q missing %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteWithMessages_ForcedTextOutput(t *testing.T) {
+	// Echo plain text only when asked for text output
+	fakeClaude(t, `if [ "$3" = text ]; then echo "plain answer"; else echo '{"type":"result","result":"json answer"}'; fi`+"\n")
+
+	plain := &models.ChatCompletionRequest{Messages: []models.Message{{Role: "user", Content: "hi"}}}
+	tests := []struct {
+		name       string
+		format     string
+		req        *models.ChatCompletionRequest
+		wantFormat string
+		wantResult string
+	}{
+		{name: "json by default", req: plain, wantFormat: OutputFormatJSON, wantResult: "json answer"},
+		{name: "forced text", format: OutputFormatText, req: plain, wantFormat: OutputFormatText, wantResult: "plain answer"},
+		{
+			name:       "tools keep json",
+			format:     OutputFormatText,
+			req:        &models.ChatCompletionRequest{Messages: plain.Messages, Tools: []models.Tool{{Type: "function", Function: models.Function{Name: "f"}}}},
+			wantFormat: OutputFormatJSON,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewExecutor()
+			if err := e.SetOutputFormat(tt.format); err != nil {
+				t.Fatalf("SetOutputFormat: %v", err)
+			}
+			format := e.OutputFormat(tt.req)
+			if format != tt.wantFormat {
+				t.Fatalf("got format %q, want %q", format, tt.wantFormat)
+			}
+			if tt.wantResult == "" {
+				return
+			}
+
+			output, err := e.ExecuteWithMessages(context.Background(), tt.req)
+			if err != nil {
+				t.Fatalf("ExecuteWithMessages: %v", err)
+			}
+			resp, err := NewParser().ParseResponse(output, format)
+			if err != nil {
+				t.Fatalf("ParseResponse: %v", err)
+			}
+			if resp.Result != tt.wantResult {
+				t.Errorf("got result %q, want %q", resp.Result, tt.wantResult)
+			}
+		})
+	}
+}
+
+func TestSetOutputFormat_Unknown(t *testing.T) {
+	if err := NewExecutor().SetOutputFormat("xml"); err == nil {
+		t.Error("got nil error for an unknown format, want error")
+	}
+}
+
+func TestAssemblePrompt(t *testing.T) {
+	e := NewExecutor()
+
+	systemPrompt, prompt, err := e.AssemblePrompt(&models.ChatCompletionRequest{
+		Messages: []models.Message{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "Hello"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("AssemblePrompt: %v", err)
+	}
+	if systemPrompt != "Be brief." || prompt != "Hello" {
+		t.Errorf("got %q/%q, want %q/%q", systemPrompt, prompt, "Be brief.", "Hello")
+	}
+
+	_, prompt, err = e.AssemblePrompt(&models.ChatCompletionRequest{
+		Messages: []models.Message{
+			{Role: "user", Content: []models.ContentPart{{Type: "text", Text: "Hello"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("AssemblePrompt: %v", err)
+	}
+	if !strings.Contains(prompt, `"type":"user"`) {
+		t.Errorf("got prompt %q, want stream-json input", prompt)
+	}
+}
+
+func TestExecuteWithMessages_AssistantHistory(t *testing.T) {
+	captured := filepath.Join(t.TempDir(), "stdin")
+	fakeClaude(t, `cat > "`+captured+`"; echo '{"type":"result","result":"ok"}'`+"\n")
+
+	req := &models.ChatCompletionRequest{Messages: []models.Message{
+		{Role: "user", Content: []models.ContentPart{{Type: "text", Text: "What is 2+2?"}}},
+		{Role: "assistant", Content: "4"},
+		{Role: "user", Content: "And doubled?"},
+	}}
+
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{mode: "", want: `{"type":"assistant","message":{"role":"assistant","content":"4"}}`},
+		{mode: AssistantHistoryTranscript, want: `{"type":"user","message":{"role":"user","content":"Assistant: 4"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			e := NewExecutor()
+			if err := e.SetAssistantHistory(tt.mode); err != nil {
+				t.Fatalf("SetAssistantHistory: %v", err)
+			}
+			if _, err := e.ExecuteWithMessages(context.Background(), req); err != nil {
+				t.Fatalf("ExecuteWithMessages: %v", err)
+			}
+
+			input, err := os.ReadFile(captured)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(input)), "\n")
+			if len(lines) != 3 || lines[1] != tt.want {
+				t.Errorf("got CLI input %q, want assistant line %s", lines, tt.want)
+			}
+		})
+	}
+
+	if err := NewExecutor().SetAssistantHistory("user"); err == nil {
+		t.Error("got nil error for an unknown mode, want error")
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	invoked := filepath.Join(t.TempDir(), "invoked")
+	fakeClaude(t, `echo "$@" >> "`+invoked+`"
+case "$*" in
+  --version) echo "1.0.0 (Claude Code)" ;;
+  *) cat > /dev/null; echo '{"type":"result","subtype":"error_during_execution","is_error":true,"result":"Invalid API key"}'; exit 1 ;;
+esac
+`)
+
+	tests := []struct {
+		mode     string
+		wantErr  string
+		wantArgs string
+	}{
+		{mode: WarmUpOff},
+		{mode: WarmUpVersion, wantArgs: "--version"},
+		{mode: WarmUpPrompt, wantErr: "Invalid API key", wantArgs: "-p --output-format json"},
+		{mode: "pool", wantErr: "unknown warm-up mode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			os.Remove(invoked)
+			err := NewExecutor().WarmUp(context.Background(), tt.mode)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("WarmUp: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("got error %v, want %q", err, tt.wantErr)
+			}
+
+			args, _ := os.ReadFile(invoked)
+			if tt.wantArgs == "" && len(args) > 0 {
+				t.Errorf("got CLI invoked with %q, want no invocation", args)
+			}
+			if !strings.HasPrefix(string(args), tt.wantArgs) {
+				t.Errorf("got CLI args %q, want prefix %q", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestConvertToStreamJSON_PartOrder(t *testing.T) {
+	e := NewExecutor()
+	image := &models.ImageURL{URL: "data:image/png;base64,AAAA"}
+	parts := []models.ContentPart{
+		{Type: "text", Text: "Before"},
+		{Type: "image_url", ImageURL: image},
+		{Type: "text", Text: "After"},
+	}
+	anyParts := []any{
+		map[string]any{"type": "text", "text": "Before"},
+		map[string]any{"type": "image_url", "image_url": map[string]any{"url": image.URL}},
+		map[string]any{"type": "text", "text": "After"},
+	}
+
+	tests := []struct {
+		name string
+		msg  models.Message
+		want []string
+	}{
+		{name: "user", msg: models.Message{Role: "user", Content: parts}, want: []string{"text:Before", "image", "text:After"}},
+		{name: "user untyped", msg: models.Message{Role: "user", Content: anyParts}, want: []string{"text:Before", "image", "text:After"}},
+		{name: "tool", msg: models.Message{Role: "tool", ToolCallID: "call_1", Content: parts}, want: []string{"text:[Tool Result for call_1]: Before", "image", "text:After"}},
+		{
+			name: "tool starting with image",
+			msg:  models.Message{Role: "tool", ToolCallID: "call_1", Content: parts[1:]},
+			want: []string{"text:[Tool Result for call_1]:", "image", "text:After"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks, ok := e.convertToStreamJSON(tt.msg).Message.Content.([]StreamJSONContent)
+			if !ok {
+				t.Fatalf("got content %T, want content blocks", e.convertToStreamJSON(tt.msg).Message.Content)
+			}
+			var got []string
+			for _, block := range blocks {
+				if block.Type == "text" {
+					got = append(got, "text:"+block.Text)
+				} else {
+					got = append(got, block.Type)
+				}
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got blocks %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssistantToolCalls(t *testing.T) {
+	assistant := models.Message{
+		Role:    "assistant",
+		Content: "Let me check.",
+		ToolCalls: []models.ToolCall{{
+			ID:       "call_1",
+			Type:     "function",
+			Function: models.FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`},
+		}},
+	}
+	calls := "```json\n" + `{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]}` + "\n```"
+
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{mode: "", want: "Let me check.\n\n" + calls},
+		{mode: AssistantToolCallsOmit, want: "Let me check."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			e := NewExecutor()
+			if err := e.SetAssistantToolCalls(tt.mode); err != nil {
+				t.Fatalf("SetAssistantToolCalls: %v", err)
+			}
+
+			msg := e.convertToStreamJSON(assistant)
+			if msg.Type != "assistant" || msg.Message.Content != tt.want {
+				t.Errorf("got stream-json %s message %q, want assistant message %q", msg.Type, msg.Message.Content, tt.want)
+			}
+
+			prompt := e.messagesToPrompt([]models.Message{{Role: "user", Content: "Weather?"}, assistant})
+			if want := "User: Weather?\nAssistant: " + tt.want; prompt != want {
+				t.Errorf("got prompt %q, want %q", prompt, want)
+			}
+		})
+	}
+
+	if err := NewExecutor().SetAssistantToolCalls("xml"); err == nil {
+		t.Error("got nil error for an unknown mode, want error")
+	}
+}
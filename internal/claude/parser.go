@@ -3,6 +3,10 @@ package claude
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/leeaandrob/claudex/internal/models"
 )
@@ -15,15 +19,206 @@ func NewParser() *Parser {
 	return &Parser{}
 }
 
+// CLIError is an error reported by the Claude CLI in its JSON output, or a
+// rate limit it reported on stderr.
+type CLIError struct {
+	Code    string // Error type or result subtype, e.g. "rate_limit_error", "error_max_turns"
+	Message string
+
+	// RetryAfter is how long to wait before retrying, when the CLI gave a
+	// hint for a rate limit. Zero if it gave none.
+	RetryAfter time.Duration
+}
+
+func (e *CLIError) Error() string {
+	if e.Code == "" {
+		return "claude cli error: " + e.Message
+	}
+	return fmt.Sprintf("claude cli error (%s): %s", e.Code, e.Message)
+}
+
 // ParseJSONResponse parses a non-streaming Claude CLI JSON response.
+// Progress lines some CLI versions print around the JSON are ignored.
+// If the output is a CLI error, it returns a *CLIError.
 func (p *Parser) ParseJSONResponse(output string) (*models.ClaudeJSONResponse, error) {
 	var resp models.ClaudeJSONResponse
 	if err := json.Unmarshal([]byte(output), &resp); err != nil {
-		return nil, fmt.Errorf("failed to parse claude json response: %w", err)
+		found, ok := findResultObject(output)
+		if !ok {
+			if cliErr := ParseCLIError(output); cliErr != nil {
+				return nil, cliErr
+			}
+			return nil, fmt.Errorf("failed to parse claude json response: %w", err)
+		}
+		resp = *found
+	}
+	if cliErr := cliErrorFrom(&resp); cliErr != nil {
+		return nil, cliErr
 	}
 	return &resp, nil
 }
 
+// findResultObject looks for the CLI's result object among other output,
+// such as progress lines, and parses it.
+func findResultObject(output string) (*models.ClaudeJSONResponse, bool) {
+	for i := 0; i < len(output); i++ {
+		if output[i] != '{' {
+			continue
+		}
+		obj := balancedObject(output[i:])
+		if obj == "" {
+			continue
+		}
+		var resp models.ClaudeJSONResponse
+		if err := json.Unmarshal([]byte(obj), &resp); err != nil {
+			continue
+		}
+		if resp.Type == "result" {
+			return &resp, true
+		}
+		// Skip past other JSON objects rather than into them
+		i += len(obj) - 1
+	}
+	return nil, false
+}
+
+// balancedObject returns the JSON object at the start of s, matching braces
+// outside of strings, or "" if it is not closed.
+func balancedObject(s string) string {
+	depth := 0
+	inString := false
+	escaped := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if escaped {
+			escaped = false
+			continue
+		}
+		if c == '\\' && inString {
+			escaped = true
+			continue
+		}
+		if c == '"' {
+			inString = !inString
+			continue
+		}
+		if inString {
+			continue
+		}
+		if c == '{' {
+			depth++
+		} else if c == '}' {
+			depth--
+			if depth == 0 {
+				return s[:i+1]
+			}
+		}
+	}
+	return ""
+}
+
+// ParseResponse parses non-streaming Claude CLI output in the given output
+// format. Text output is the response itself and carries no usage.
+func (p *Parser) ParseResponse(output, format string) (*models.ClaudeJSONResponse, error) {
+	if format == OutputFormatText {
+		return p.ParseTextResponse(output), nil
+	}
+	return p.ParseJSONResponse(output)
+}
+
+// ParseTextResponse wraps plain text CLI output as a result.
+func (p *Parser) ParseTextResponse(output string) *models.ClaudeJSONResponse {
+	return &models.ClaudeJSONResponse{
+		Type:    "result",
+		Subtype: "success",
+		Result:  strings.TrimSuffix(output, "\n"),
+	}
+}
+
+// ParseCLIError looks for a Claude CLI error object in output, either as the
+// whole output or as one of its lines. Returns nil if none is found.
+func ParseCLIError(output string) *CLIError {
+	candidates := append([]string{output}, strings.Split(output, "\n")...)
+	for _, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if !strings.HasPrefix(candidate, "{") {
+			continue
+		}
+		var resp models.ClaudeJSONResponse
+		if err := json.Unmarshal([]byte(candidate), &resp); err != nil {
+			continue
+		}
+		if cliErr := cliErrorFrom(&resp); cliErr != nil {
+			return cliErr
+		}
+	}
+	return nil
+}
+
+// cliErrorFrom returns the error described by a CLI response, or nil.
+func cliErrorFrom(resp *models.ClaudeJSONResponse) *CLIError {
+	switch {
+	case resp.Type == "error" && resp.Error != nil:
+		cliErr := &CLIError{Code: resp.Error.Type, Message: resp.Error.Message}
+		if cliErr.Code == RateLimitErrorCode {
+			cliErr.RetryAfter = retryAfterHint(cliErr.Message, time.Now())
+		}
+		return cliErr
+	case resp.IsError:
+		msg := resp.Result
+		if msg == "" {
+			msg = "claude cli reported an error"
+		}
+		return &CLIError{Code: resp.Subtype, Message: msg}
+	}
+	return nil
+}
+
+// RateLimitErrorCode is the CLIError code of rate limits.
+const RateLimitErrorCode = "rate_limit_error"
+
+// rateLimitPattern matches the rate limit messages the CLI prints on stderr.
+var rateLimitPattern = regexp.MustCompile(`(?i)rate[ _-]?limit|too many requests|usage limit reached`)
+
+// Retry hints in rate limit messages: a delay in seconds or minutes, or the
+// Unix time the limit resets at ("usage limit reached|1712345678").
+var (
+	retryDelayPattern = regexp.MustCompile(`(?i)(?:retry[- ]after|try again in)[:\s]+(\d+)\s*(s|sec|secs|seconds?|m|min|mins|minutes?)?\b`)
+	retryResetPattern = regexp.MustCompile(`(?i)limit reached\|(\d{10})\b`)
+)
+
+// ParseRateLimit returns a rate limit CLIError if stderr reports one, with
+// any retry hint it carries, or nil.
+func ParseRateLimit(stderr string) *CLIError {
+	if !rateLimitPattern.MatchString(stderr) {
+		return nil
+	}
+	return &CLIError{
+		Code:       RateLimitErrorCode,
+		Message:    strings.TrimSpace(stderr),
+		RetryAfter: retryAfterHint(stderr, time.Now()),
+	}
+}
+
+// retryAfterHint returns the retry delay hinted at in msg, or zero.
+func retryAfterHint(msg string, now time.Time) time.Duration {
+	if m := retryDelayPattern.FindStringSubmatch(msg); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		if strings.HasPrefix(strings.ToLower(m[2]), "m") {
+			return time.Duration(n) * time.Minute
+		}
+		return time.Duration(n) * time.Second
+	}
+	if m := retryResetPattern.FindStringSubmatch(msg); m != nil {
+		reset, _ := strconv.ParseInt(m[1], 10, 64)
+		if d := time.Unix(reset, 0).Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
 // ParseStreamLine parses a single line from Claude CLI stream-json output.
 func (p *Parser) ParseStreamLine(line string) (*models.ClaudeStreamMessage, error) {
 	var msg models.ClaudeStreamMessage
@@ -0,0 +1,140 @@
+package claude
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseJSONResponse_CLIError(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		wantCode    string
+		wantMessage string
+	}{
+		{
+			name:        "result with is_error",
+			output:      `{"type":"result","subtype":"error_max_turns","is_error":true,"result":"Reached max turns (1)","session_id":"abc"}`,
+			wantCode:    "error_max_turns",
+			wantMessage: "Reached max turns (1)",
+		},
+		{
+			name:        "error object",
+			output:      `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			wantCode:    "overloaded_error",
+			wantMessage: "Overloaded",
+		},
+		{
+			name:        "error line among progress output",
+			output:      "Retrying request...\n{\"type\":\"error\",\"error\":{\"type\":\"rate_limit_error\",\"message\":\"Rate limited\"}}\n",
+			wantCode:    "rate_limit_error",
+			wantMessage: "Rate limited",
+		},
+	}
+
+	p := NewParser()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := p.ParseJSONResponse(tt.output)
+			if resp != nil {
+				t.Errorf("got response %+v, want nil", resp)
+			}
+
+			var cliErr *CLIError
+			if !errors.As(err, &cliErr) {
+				t.Fatalf("got error %v, want *CLIError", err)
+			}
+			if cliErr.Code != tt.wantCode || cliErr.Message != tt.wantMessage {
+				t.Errorf("got %q/%q, want %q/%q", cliErr.Code, cliErr.Message, tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestParseJSONResponse_Success(t *testing.T) {
+	resp, err := NewParser().ParseJSONResponse(`{"type":"result","subtype":"success","is_error":false,"result":"hello"}`)
+	if err != nil {
+		t.Fatalf("ParseJSONResponse: %v", err)
+	}
+	if resp.Result != "hello" {
+		t.Errorf("got result %q, want %q", resp.Result, "hello")
+	}
+}
+
+func TestParseJSONResponse_Noise(t *testing.T) {
+	result := `{"type":"result","subtype":"success","is_error":false,"result":"use {braces} and \"quotes\" freely"}`
+	tests := []struct {
+		name   string
+		output string
+	}{
+		{name: "leading", output: "Loading plugins...\nConnected {mcp: 2 servers}\n" + result},
+		{name: "trailing", output: result + "\nDone in 1.2s\n"},
+		{name: "both", output: "[progress] 50%\n" + result + "\n[progress] 100% }"},
+		{name: "other json first", output: `{"type":"system","subtype":"init"}` + "\n" + result},
+	}
+
+	p := NewParser()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := p.ParseJSONResponse(tt.output)
+			if err != nil {
+				t.Fatalf("ParseJSONResponse: %v", err)
+			}
+			if want := `use {braces} and "quotes" freely`; resp.Result != want {
+				t.Errorf("got result %q, want %q", resp.Result, want)
+			}
+		})
+	}
+}
+
+func TestParseJSONResponse_NoisyCLIError(t *testing.T) {
+	output := "Starting...\n" + `{"type":"result","subtype":"error_max_turns","is_error":true,"result":"Reached max turns (1)"}` + "\nbye"
+	_, err := NewParser().ParseJSONResponse(output)
+	var cliErr *CLIError
+	if !errors.As(err, &cliErr) || cliErr.Code != "error_max_turns" {
+		t.Errorf("got error %v, want error_max_turns CLI error", err)
+	}
+}
+
+func TestParseJSONResponse_Garbage(t *testing.T) {
+	_, err := NewParser().ParseJSONResponse("not json")
+	var cliErr *CLIError
+	if err == nil || errors.As(err, &cliErr) {
+		t.Errorf("got error %v, want plain parse error", err)
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	now := time.Unix(1712345000, 0)
+	tests := []struct {
+		name      string
+		stderr    string
+		wantLimit bool
+		wantRetry time.Duration
+	}{
+		{name: "seconds", stderr: "API Error: 429 Too Many Requests. Please try again in 30 seconds", wantLimit: true, wantRetry: 30 * time.Second},
+		{name: "minutes", stderr: "rate limit exceeded, retry after 2m", wantLimit: true, wantRetry: 2 * time.Minute},
+		{name: "reset time", stderr: "Claude AI usage limit reached|1712345600", wantLimit: true, wantRetry: 600 * time.Second},
+		{name: "no hint", stderr: "Error: rate_limit_error", wantLimit: true},
+		{name: "other error", stderr: "Error: invalid API key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cliErr := ParseRateLimit(tt.stderr)
+			if (cliErr != nil) != tt.wantLimit {
+				t.Fatalf("got %v, want rate limit %v", cliErr, tt.wantLimit)
+			}
+			if cliErr == nil {
+				return
+			}
+			if cliErr.Code != RateLimitErrorCode {
+				t.Errorf("got code %q, want %q", cliErr.Code, RateLimitErrorCode)
+			}
+			if got := retryAfterHint(tt.stderr, now); got != tt.wantRetry {
+				t.Errorf("got retry after %v, want %v", got, tt.wantRetry)
+			}
+		})
+	}
+}
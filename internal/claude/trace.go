@@ -0,0 +1,150 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTraceMaxFiles is the number of invocation traces kept when the
+// tracer is created with a non-positive limit.
+const DefaultTraceMaxFiles = 100
+
+// traceFilePrefix starts the name of every invocation trace file, so
+// rotation leaves other files in the directory alone.
+const traceFilePrefix = "invocation-"
+
+// InvocationTracer records each Claude CLI invocation (its args, stdin, and
+// raw stdout and stderr) as a JSON file in a directory, for replaying
+// unexpected requests against the CLI by hand. Only the newest maxFiles
+// traces are kept. Base64 image data is redacted.
+type InvocationTracer struct {
+	dir      string
+	maxFiles int
+	logger   *slog.Logger
+	seq      atomic.Uint64
+	mu       sync.Mutex // serializes rotation
+}
+
+// invocationTrace is the content of one trace file.
+type invocationTrace struct {
+	Time     time.Time `json:"time"`
+	Duration string    `json:"duration"`
+	Args     []string  `json:"args"`
+	Stdin    string    `json:"stdin"`
+	Stdout   string    `json:"stdout"`
+	Stderr   string    `json:"stderr"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// NewInvocationTracer creates a tracer writing to dir, creating it if needed.
+// Failures to write a trace are logged to logger.
+func NewInvocationTracer(dir string, maxFiles int, logger *slog.Logger) (*InvocationTracer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create trace directory: %w", err)
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultTraceMaxFiles
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &InvocationTracer{dir: dir, maxFiles: maxFiles, logger: logger}, nil
+}
+
+// SetTracer records every CLI invocation with t. A nil tracer disables
+// tracing.
+func (e *Executor) SetTracer(t *InvocationTracer) {
+	e.tracer = t
+}
+
+// trace records an invocation if tracing is enabled.
+func (e *Executor) trace(start time.Time, args []string, stdin, stdout, stderr string, err error) {
+	if e.tracer == nil {
+		return
+	}
+	if werr := e.tracer.record(start, args, stdin, stdout, stderr, err); werr != nil {
+		e.tracer.logger.Warn("failed to write CLI invocation trace", "dir", e.tracer.dir, "error", werr.Error())
+	}
+}
+
+// record writes one invocation trace and removes the oldest traces beyond
+// the limit.
+func (t *InvocationTracer) record(start time.Time, args []string, stdin, stdout, stderr string, err error) error {
+	entry := invocationTrace{
+		Time:     start.UTC(),
+		Duration: time.Since(start).String(),
+		Args:     make([]string, len(args)),
+		Stdin:    redactImageData(stdin),
+		Stdout:   redactImageData(stdout),
+		Stderr:   stderr,
+	}
+	for i, arg := range args {
+		entry.Args[i] = redactImageData(arg)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	data, merr := json.MarshalIndent(entry, "", "  ")
+	if merr != nil {
+		return merr
+	}
+	// Timestamp then sequence, so names sort in invocation order
+	name := fmt.Sprintf("%s%s-%06d.json", traceFilePrefix, start.UTC().Format("20060102T150405.000000000"), t.seq.Add(1)%1000000)
+	if werr := os.WriteFile(filepath.Join(t.dir, name), data, 0o600); werr != nil {
+		return werr
+	}
+	return t.rotate()
+}
+
+// rotate removes the oldest trace files beyond maxFiles.
+func (t *InvocationTracer) rotate() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), traceFilePrefix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for len(names) > t.maxFiles {
+		if err := os.Remove(filepath.Join(t.dir, names[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// imageDataPatterns match base64 image data in stream-json image sources and
+// in data URLs.
+var imageDataPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`("type":"base64","media_type":"[^"]*","data":")[^"]*`),
+	regexp.MustCompile(`(data:image/[a-zA-Z0-9.+-]+;base64,)[A-Za-z0-9+/=]+`),
+}
+
+// redactImageData replaces base64 image data in s with its length.
+func redactImageData(s string) string {
+	for _, re := range imageDataPatterns {
+		s = re.ReplaceAllStringFunc(s, func(match string) string {
+			prefix := re.FindStringSubmatch(match)[1]
+			return fmt.Sprintf("%s[redacted %d bytes]", prefix, len(match)-len(prefix))
+		})
+	}
+	return s
+}
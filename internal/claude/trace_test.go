@@ -0,0 +1,67 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestInvocationTracer(t *testing.T) {
+	fakeClaude(t, `cat > /dev/null; echo '{"type":"result","result":"a cat"}'; echo "warning: slow" >&2`)
+
+	dir := filepath.Join(t.TempDir(), "traces")
+	tracer, err := NewInvocationTracer(dir, 2, nil)
+	if err != nil {
+		t.Fatalf("NewInvocationTracer: %v", err)
+	}
+	e := NewExecutor()
+	e.SetTracer(tracer)
+
+	image := strings.Repeat("iVBORw0KGgo", 10)
+	req := &models.ChatCompletionRequest{Messages: []models.Message{{Role: "user", Content: []models.ContentPart{
+		{Type: "text", Text: "what is this?"},
+		{Type: "image_url", ImageURL: &models.ImageURL{URL: "data:image/png;base64," + image}},
+	}}}}
+	for range 3 {
+		if _, err := e.ExecuteWithMessages(context.Background(), req); err != nil {
+			t.Fatalf("ExecuteWithMessages: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read trace dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d trace files, want 2 after rotation", len(entries))
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, entries[1].Name()))
+	if err != nil {
+		t.Fatalf("read trace: %v", err)
+	}
+	var trace invocationTrace
+	if err := json.Unmarshal(data, &trace); err != nil {
+		t.Fatalf("decode trace: %v", err)
+	}
+	if len(trace.Args) == 0 || trace.Args[0] != "-p" {
+		t.Errorf("got args %q, want the CLI args", trace.Args)
+	}
+	if !strings.Contains(trace.Stdin, "what is this?") {
+		t.Errorf("got stdin %q, want the stream-json input", trace.Stdin)
+	}
+	if strings.Contains(trace.Stdin, image) || !strings.Contains(trace.Stdin, "[redacted 110 bytes]") {
+		t.Errorf("got stdin %q, want the image data redacted", trace.Stdin)
+	}
+	if !strings.Contains(trace.Stdout, `"result":"a cat"`) {
+		t.Errorf("got stdout %q, want the raw CLI output", trace.Stdout)
+	}
+	if trace.Stderr != "warning: slow\n" {
+		t.Errorf("got stderr %q, want %q", trace.Stderr, "warning: slow\n")
+	}
+}
@@ -0,0 +1,46 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// Warm-up modes accepted by WarmUp. The CLI is started per request, so there
+// is no process to keep warm; a warm-up instead pays the first invocation's
+// one-off costs, such as loading the binary from disk, before traffic arrives.
+const (
+	// WarmUpOff skips the warm-up.
+	WarmUpOff = "off"
+	// WarmUpVersion runs claude --version.
+	WarmUpVersion = "version"
+	// WarmUpPrompt sends a one-word prompt, which also checks authentication
+	// but uses tokens.
+	WarmUpPrompt = "prompt"
+)
+
+// warmUpPrompt is the prompt sent in WarmUpPrompt mode.
+const warmUpPrompt = "Reply with the single word: ok"
+
+// WarmUp runs a trivial CLI invocation in the given mode. An empty mode is
+// the same as WarmUpOff.
+func (e *Executor) WarmUp(ctx context.Context, mode string) error {
+	switch mode {
+	case "", WarmUpOff:
+		return nil
+	case WarmUpVersion:
+		if out, err := exec.CommandContext(ctx, "claude", "--version").CombinedOutput(); err != nil {
+			return fmt.Errorf("claude --version: %w: %s", err, out)
+		}
+		return nil
+	case WarmUpPrompt:
+		output, err := e.executeNonStreaming(ctx, warmUpPrompt, "", OutputFormatJSON)
+		if err != nil {
+			return err
+		}
+		_, err = NewParser().ParseJSONResponse(output)
+		return err
+	default:
+		return fmt.Errorf("unknown warm-up mode %q", mode)
+	}
+}
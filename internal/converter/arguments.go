@@ -0,0 +1,108 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// Argument coercion modes accepted by SetArgumentCoercion. Both unwrap
+// double-encoded arguments; they differ in what happens to arguments that
+// are not a JSON object.
+const (
+	// ArgumentCoercionPassthrough returns such arguments unchanged.
+	ArgumentCoercionPassthrough = "passthrough"
+	// ArgumentCoercionReject fails the response, naming the tool and why
+	// its arguments were rejected.
+	ArgumentCoercionReject = "reject"
+)
+
+// ErrInvalidArguments is returned for tool call arguments that are not
+// valid JSON.
+var ErrInvalidArguments = errors.New("tool call arguments are not valid JSON")
+
+// ErrNonObjectArguments is returned for tool call arguments that are valid
+// JSON but not an object, such as an array or a number.
+var ErrNonObjectArguments = errors.New("tool call arguments must be a JSON object")
+
+// maxArgumentEncodings bounds how many layers of string encoding are
+// unwrapped from arguments.
+const maxArgumentEncodings = 3
+
+// SetArgumentCoercion sets how tool call arguments that are not a JSON
+// object are handled. An empty mode keeps the default, passthrough.
+func (c *Converter) SetArgumentCoercion(mode string) error {
+	if mode == "" {
+		mode = ArgumentCoercionPassthrough
+	}
+
+	switch mode {
+	case ArgumentCoercionPassthrough, ArgumentCoercionReject:
+	default:
+		return fmt.Errorf("unknown argument coercion mode %q", mode)
+	}
+
+	c.argumentCoercion = mode
+	return nil
+}
+
+// ArgumentCoercion returns the mode set by SetArgumentCoercion.
+func (c *Converter) ArgumentCoercion() string {
+	return c.argumentCoercion
+}
+
+// CheckToolCallArguments returns an error naming the tool if a tool call's
+// arguments are not a JSON object. The error wraps ErrInvalidArguments or
+// ErrNonObjectArguments.
+func CheckToolCallArguments(tc models.ToolCall) error {
+	if _, err := CoerceArguments(json.RawMessage(tc.Function.Arguments)); err != nil {
+		return fmt.Errorf("tool %s: %w", tc.Function.Name, err)
+	}
+	return nil
+}
+
+// CoerceArguments returns arguments as a JSON object string. Missing, null,
+// and empty-string arguments become "{}", and arguments encoded as a JSON
+// string (possibly more than once) are unwrapped. Anything that is not then
+// a JSON object yields ErrInvalidArguments or ErrNonObjectArguments.
+func CoerceArguments(raw json.RawMessage) (string, error) {
+	data := bytes.TrimSpace(raw)
+	for range maxArgumentEncodings {
+		if len(data) == 0 || data[0] != '"' {
+			break
+		}
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidArguments, err)
+		}
+		data = bytes.TrimSpace([]byte(s))
+	}
+
+	if len(data) == 0 || string(data) == "null" {
+		return "{}", nil
+	}
+	if !json.Valid(data) {
+		return "", ErrInvalidArguments
+	}
+	if data[0] != '{' {
+		return "", fmt.Errorf("%w, got %s", ErrNonObjectArguments, jsonKind(data[0]))
+	}
+	return string(data), nil
+}
+
+// jsonKind names the type of the JSON value starting with c.
+func jsonKind(c byte) string {
+	switch c {
+	case '[':
+		return "array"
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "boolean"
+	default:
+		return "number"
+	}
+}
@@ -0,0 +1,75 @@
+package converter
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCoerceArguments(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr error
+	}{
+		{name: "missing", raw: ``, want: `{}`},
+		{name: "null", raw: `null`, want: `{}`},
+		{name: "empty string", raw: `""`, want: `{}`},
+		{name: "object", raw: `{"city":"Paris"}`, want: `{"city":"Paris"}`},
+		{name: "string", raw: `"{\"city\":\"Paris\"}"`, want: `{"city":"Paris"}`},
+		{name: "double encoded", raw: `"\"{\\\"city\\\":\\\"Paris\\\"}\""`, want: `{"city":"Paris"}`},
+		{name: "array", raw: `["Paris"]`, wantErr: ErrNonObjectArguments},
+		{name: "number", raw: `42`, wantErr: ErrNonObjectArguments},
+		{name: "stringified array", raw: `"[1,2]"`, wantErr: ErrNonObjectArguments},
+		{name: "not json", raw: `"city=Paris"`, wantErr: ErrInvalidArguments},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CoerceArguments(json.RawMessage(tt.raw))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractToolCalls_ArgumentCoercion(t *testing.T) {
+	doubleEncoded := `{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"\"{\\\"city\\\":\\\"Paris\\\"}\""}}]}`
+	nonObject := `{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":["Paris"]}}]}`
+
+	tests := []struct {
+		name     string
+		content  string
+		wantArgs string
+		wantErr  error
+	}{
+		{name: "double encoded", content: doubleEncoded, wantArgs: `{"city":"Paris"}`},
+		{name: "non-object", content: nonObject, wantArgs: `["Paris"]`, wantErr: ErrNonObjectArguments},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, calls := NewConverter().ExtractToolCalls(tt.content)
+			if len(calls) != 1 || calls[0].Function.Arguments != tt.wantArgs {
+				t.Fatalf("got calls %+v, want arguments %s", calls, tt.wantArgs)
+			}
+			err := CheckToolCallArguments(calls[0])
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "get_weather") {
+				t.Errorf("error %q does not name the tool", err)
+			}
+		})
+	}
+
+	if err := NewConverter().SetArgumentCoercion("wrap"); err == nil {
+		t.Error("got nil error for an unknown mode, want error")
+	}
+}
@@ -2,6 +2,7 @@ package converter
 
 import (
 	"encoding/json"
+	"sort"
 	"strings"
 	"time"
 
@@ -10,11 +11,20 @@ import (
 )
 
 // Converter handles format conversion between OpenAI and Claude CLI.
-type Converter struct{}
+type Converter struct {
+	idPrefix         string
+	idFormat         string
+	argumentCoercion string
+}
 
-// NewConverter creates a new format converter.
+// NewConverter creates a new format converter with the default completion
+// ID format and argument coercion.
 func NewConverter() *Converter {
-	return &Converter{}
+	return &Converter{
+		idPrefix:         DefaultCompletionIDPrefix,
+		idFormat:         CompletionIDUUID,
+		argumentCoercion: ArgumentCoercionPassthrough,
+	}
 }
 
 // MessagesToPrompt converts OpenAI messages to Claude CLI prompt format.
@@ -26,7 +36,7 @@ func (c *Converter) MessagesToPrompt(messages []models.Message) (prompt, systemP
 
 	for _, msg := range messages {
 		switch msg.Role {
-		case "system":
+		case "system", "developer":
 			systemParts = append(systemParts, msg.GetTextContent())
 		case "user":
 			conversationParts = append(conversationParts, "User: "+msg.GetTextContent())
@@ -70,8 +80,12 @@ type FunctionCallJSON struct {
 }
 
 // GetArgumentsString returns arguments as a JSON string.
-// Handles both string and object formats.
+// Handles both string and object formats, unwrapping double encoding.
+// Arguments CoerceArguments rejects are returned as the model sent them.
 func (f *FunctionCallJSON) GetArgumentsString() string {
+	if args, err := CoerceArguments(f.Arguments); err == nil {
+		return args
+	}
 	if len(f.Arguments) == 0 {
 		return "{}"
 	}
@@ -104,7 +118,7 @@ func (c *Converter) ClaudeToOpenAIResponse(claudeResp *models.ClaudeJSONResponse
 	}
 
 	return &models.ChatCompletionResponse{
-		ID:      GenerateCompletionID(),
+		ID:      c.GenerateCompletionID(),
 		Object:  "chat.completion",
 		Created: time.Now().Unix(),
 		Model:   model,
@@ -132,16 +146,15 @@ func (c *Converter) ClaudeToOpenAIResponse(claudeResp *models.ClaudeJSONResponse
 func (c *Converter) ExtractToolCalls(content string) (string, []models.ToolCall) {
 	content = strings.TrimSpace(content)
 
-	// Try to find JSON with tool_calls in the response
-	// Look for JSON block in markdown code fence
-	jsonContent := c.extractJSONFromContent(content)
-	if jsonContent == "" {
+	// Find the JSON object carrying tool_calls (raw or inside a code fence)
+	start, end := c.findToolCallsObject(content)
+	if start < 0 {
 		return content, nil
 	}
 
 	// Try to parse as tool calls response
 	var toolCallsResp ToolCallsResponse
-	if err := json.Unmarshal([]byte(jsonContent), &toolCallsResp); err != nil {
+	if err := json.Unmarshal([]byte(content[start:end]), &toolCallsResp); err != nil {
 		return content, nil
 	}
 
@@ -172,54 +185,181 @@ func (c *Converter) ExtractToolCalls(content string) (string, []models.ToolCall)
 	}
 
 	// Extract text before/after the JSON block
-	remainingText := c.extractTextOutsideJSON(content)
+	remainingText := c.removeJSONBlock(content, start, end)
 
 	return remainingText, toolCalls
 }
 
-// extractJSONFromContent extracts JSON from various formats (raw, code fence, etc.)
-func (c *Converter) extractJSONFromContent(content string) string {
-	// Try to find JSON in markdown code fence
-	if idx := strings.Index(content, "```json"); idx != -1 {
-		start := idx + 7
-		end := strings.Index(content[start:], "```")
-		if end != -1 {
-			return strings.TrimSpace(content[start : start+end])
+// findToolCallsObject locates the first balanced JSON object in content whose
+// top-level key is "tool_calls" and returns its start and end offsets, or
+// -1, -1 if there is none. Candidates are matched with string-aware brace
+// scanning and must decode as JSON, so braces in surrounding prose or
+// "tool_calls" mentioned in text or inside string values are ignored.
+func (c *Converter) findToolCallsObject(content string) (int, int) {
+	// Most responses carry no tool calls; don't scan their braces
+	if !strings.Contains(content, `"tool_calls"`) {
+		return -1, -1
+	}
+
+	for i := 0; i < len(content); i++ {
+		if content[i] != '{' {
+			continue
+		}
+
+		obj := c.extractJSONObject(content[i:])
+		if obj == "" {
+			// The brace is never closed, so its scan ran to the end of
+			// content; look through what it passed over without
+			// rescanning from every later brace
+			return c.findNestedToolCallsObject(content, i)
 		}
+
+		valid, found := isToolCallsObject(obj)
+		if found {
+			return i, i + len(obj)
+		}
+		if valid {
+			// Some other JSON object; skip past it
+			i += len(obj) - 1
+		}
+		// Otherwise not JSON (e.g. a brace in prose); a later brace may
+		// start the object
 	}
 
-	// Try to find JSON in generic code fence
-	if idx := strings.Index(content, "```"); idx != -1 {
-		start := idx + 3
-		// Skip optional language identifier
-		if newline := strings.Index(content[start:], "\n"); newline != -1 {
-			start += newline + 1
+	return -1, -1
+}
+
+// findNestedToolCallsObject is findToolCallsObject for the objects nested
+// after the unclosed brace at content[start].
+func (c *Converter) findNestedToolCallsObject(content string, start int) (int, int) {
+	skipUntil := 0
+	for _, span := range nestedJSONObjects(content[start:]) {
+		objStart, objEnd := start+span[0], start+span[1]
+		if objStart < skipUntil {
+			continue
 		}
-		end := strings.Index(content[start:], "```")
-		if end != -1 {
-			jsonCandidate := strings.TrimSpace(content[start : start+end])
-			if strings.HasPrefix(jsonCandidate, "{") {
-				return jsonCandidate
-			}
+		valid, found := isToolCallsObject(content[objStart:objEnd])
+		if found {
+			return objStart, objEnd
+		}
+		if valid {
+			skipUntil = objEnd
 		}
 	}
 
-	// Try to find raw JSON starting with { and containing "tool_calls"
-	if idx := strings.Index(content, `{"tool_calls"`); idx != -1 {
-		// Find the matching closing brace
-		jsonStr := c.extractJSONObject(content[idx:])
-		if jsonStr != "" {
-			return jsonStr
+	// A stray quote in prose (e.g. 5" screen) throws off the string
+	// tracking of that single scan; retry from the braces that open with
+	// the "tool_calls" key
+	return c.findToolCallsKeyObject(content, start)
+}
+
+// findToolCallsKeyObject looks for a tool calls object after start by
+// scanning only from braces directly followed by a "tool_calls" key. Each
+// scan resumes past the previous one, so content is scanned about once.
+func (c *Converter) findToolCallsKeyObject(content string, start int) (int, int) {
+	const key = `"tool_calls"`
+	for pos := start; ; {
+		k := strings.Index(content[pos:], key)
+		if k < 0 {
+			return -1, -1
+		}
+		k += pos
+		pos = k + len(key)
+
+		brace := len(strings.TrimRight(content[:k], " \t\r\n")) - 1
+		if brace < start || content[brace] != '{' {
+			continue
+		}
+		obj := c.extractJSONObject(content[brace:])
+		if obj == "" {
+			return -1, -1
 		}
+		if _, found := isToolCallsObject(obj); found {
+			return brace, brace + len(obj)
+		}
+		pos = max(pos, brace+len(obj))
 	}
+}
 
-	// Try the entire content if it looks like JSON
-	trimmed := strings.TrimSpace(content)
-	if strings.HasPrefix(trimmed, "{") && strings.Contains(trimmed, "tool_calls") {
-		return trimmed
+// isToolCallsObject reports whether obj is a JSON object and whether it has
+// a top-level "tool_calls" key.
+func isToolCallsObject(obj string) (valid, found bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(obj), &fields); err != nil {
+		return false, false
 	}
+	_, found = fields["tool_calls"]
+	return true, found
+}
 
-	return ""
+// nestedJSONObjects returns the start and end offsets of the objects that
+// close within content, which opens with a brace that is never closed,
+// ordered by start. It scans content once, tracking strings as
+// extractJSONObject does.
+func nestedJSONObjects(content string) [][2]int {
+	var open []int
+	var spans [][2]int
+	inString := false
+	escaped := false
+
+	for i := 0; i < len(content); i++ {
+		char := content[i]
+		if escaped {
+			escaped = false
+			continue
+		}
+		if char == '\\' && inString {
+			escaped = true
+			continue
+		}
+		if char == '"' {
+			inString = !inString
+			continue
+		}
+		if inString {
+			continue
+		}
+
+		if char == '{' {
+			open = append(open, i)
+		} else if char == '}' && len(open) > 0 {
+			spans = append(spans, [2]int{open[len(open)-1], i + 1})
+			open = open[:len(open)-1]
+		}
+	}
+
+	sort.Slice(spans, func(a, b int) bool { return spans[a][0] < spans[b][0] })
+	return spans
+}
+
+// StripMalformedToolCalls removes a block from content that opens like a
+// tool_calls object ('{' followed by a "tool_calls" key) but that
+// ExtractToolCalls could not use, such as invalid or truncated JSON. It is
+// meant for content ExtractToolCalls returned no tool calls for. It returns
+// the remaining text and the removed block, or content and "" if there is
+// none. An unterminated block runs to its closing code fence or the end of
+// content.
+func (c *Converter) StripMalformedToolCalls(content string) (string, string) {
+	for offset := 0; ; {
+		idx := strings.Index(content[offset:], `"tool_calls"`)
+		if idx < 0 {
+			return content, ""
+		}
+		idx += offset
+		offset = idx + 1
+
+		start := strings.LastIndex(content[:idx], "{")
+		if start < 0 || strings.TrimSpace(content[start+1:idx]) != "" {
+			continue
+		}
+		end := len(content)
+		if obj := c.extractJSONObject(content[start:]); obj != "" {
+			end = start + len(obj)
+		} else if fence := strings.Index(content[start:], "```"); fence >= 0 {
+			end = start + fence
+		}
+		return c.removeJSONBlock(content, start, end), content[start:end]
+	}
 }
 
 // extractJSONObject extracts a complete JSON object starting from the current position.
@@ -265,53 +405,34 @@ func (c *Converter) extractJSONObject(content string) string {
 	return ""
 }
 
-// extractTextOutsideJSON removes JSON blocks and returns remaining text.
-func (c *Converter) extractTextOutsideJSON(content string) string {
-	// Remove markdown code fences with JSON
-	result := content
-
-	// Remove ```json ... ``` blocks
-	for {
-		if idx := strings.Index(result, "```json"); idx != -1 {
-			start := idx
-			endMarker := strings.Index(result[idx+7:], "```")
-			if endMarker != -1 {
-				end := idx + 7 + endMarker + 3
-				result = result[:start] + result[end:]
-				continue
-			}
-		}
-		break
-	}
-
-	// Remove ``` ... ``` blocks that contain tool_calls
-	for {
-		if idx := strings.Index(result, "```"); idx != -1 {
-			endMarker := strings.Index(result[idx+3:], "```")
-			if endMarker != -1 {
-				blockContent := result[idx+3 : idx+3+endMarker]
-				if strings.Contains(blockContent, "tool_calls") {
-					end := idx + 3 + endMarker + 3
-					result = result[:idx] + result[end:]
-					continue
-				}
+// removeJSONBlock removes content[start:end] along with a code fence that
+// wraps it, and returns the remaining text.
+func (c *Converter) removeJSONBlock(content string, start, end int) string {
+	before := strings.TrimRight(content[:start], " \t\r\n")
+	after := strings.TrimLeft(content[end:], " \t\r\n")
+
+	// Drop the enclosing ```json / ``` fence, if any
+	if strings.HasPrefix(after, "```") {
+		for _, fence := range []string{"```json", "```JSON", "```"} {
+			if strings.HasSuffix(before, fence) {
+				before = strings.TrimSuffix(before, fence)
+				after = strings.TrimPrefix(after, "```")
+				break
 			}
 		}
-		break
 	}
 
-	// Remove raw JSON objects with tool_calls
-	if idx := strings.Index(result, `{"tool_calls"`); idx != -1 {
-		jsonStr := c.extractJSONObject(result[idx:])
-		if jsonStr != "" {
-			result = result[:idx] + result[idx+len(jsonStr):]
-		}
+	before = strings.TrimSpace(before)
+	after = strings.TrimSpace(after)
+	if before != "" && after != "" {
+		return before + "\n\n" + after
 	}
-
-	return strings.TrimSpace(result)
+	return before + after
 }
 
 // ClaudeStreamToOpenAIChunk converts Claude streaming message to OpenAI chunk format.
+// It diffs complete messages only; use TextStream for streams that mix them
+// with partial events.
 // Note: Role is sent separately via CreateRoleChunk, so isFirst is unused but kept for API compatibility.
 func (c *Converter) ClaudeStreamToOpenAIChunk(msg *models.ClaudeStreamMessage, id, model string, isFirst bool, prevContent string) (*models.ChatCompletionChunk, string) {
 	chunk := &models.ChatCompletionChunk{
@@ -446,11 +567,6 @@ func (c *Converter) CreateToolCallFinalChunk(id, model string) *models.ChatCompl
 	}
 }
 
-// GenerateCompletionID generates a unique completion ID in OpenAI format.
-func GenerateCompletionID() string {
-	return "chatcmpl-" + uuid.New().String()
-}
-
 // GenerateToolCallID generates a unique tool call ID.
 func GenerateToolCallID() string {
 	return "call_" + uuid.New().String()[:24]
@@ -0,0 +1,56 @@
+package converter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Completion ID formats accepted by SetCompletionIDFormat.
+const (
+	// CompletionIDUUID appends a dashed UUID, e.g. chatcmpl-2f1c...-....
+	CompletionIDUUID = "uuid"
+	// CompletionIDHex appends the UUID as 32 hex characters without dashes.
+	CompletionIDHex = "hex"
+	// CompletionIDShort appends 24 hex characters.
+	CompletionIDShort = "short"
+)
+
+// DefaultCompletionIDPrefix is the OpenAI completion ID prefix.
+const DefaultCompletionIDPrefix = "chatcmpl-"
+
+// SetCompletionIDFormat sets the prefix and random-part format used by
+// GenerateCompletionID for both streaming and non-streaming responses.
+// An empty prefix or format keeps the default.
+func (c *Converter) SetCompletionIDFormat(prefix, format string) error {
+	if prefix == "" {
+		prefix = DefaultCompletionIDPrefix
+	}
+	if format == "" {
+		format = CompletionIDUUID
+	}
+
+	switch format {
+	case CompletionIDUUID, CompletionIDHex, CompletionIDShort:
+	default:
+		return fmt.Errorf("unknown completion ID format %q", format)
+	}
+
+	c.idPrefix = prefix
+	c.idFormat = format
+	return nil
+}
+
+// GenerateCompletionID generates a unique completion ID in the configured format.
+func (c *Converter) GenerateCompletionID() string {
+	id := uuid.New().String()
+
+	switch c.idFormat {
+	case CompletionIDHex:
+		id = strings.ReplaceAll(id, "-", "")
+	case CompletionIDShort:
+		id = strings.ReplaceAll(id, "-", "")[:24]
+	}
+	return c.idPrefix + id
+}
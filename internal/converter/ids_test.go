@@ -0,0 +1,55 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateCompletionID_Formats(t *testing.T) {
+	tests := []struct {
+		name       string
+		prefix     string
+		format     string
+		wantPrefix string
+		wantLen    int
+	}{
+		{name: "default", wantPrefix: "chatcmpl-", wantLen: len("chatcmpl-") + 36},
+		{name: "custom prefix", prefix: "tenant-a-", wantPrefix: "tenant-a-", wantLen: len("tenant-a-") + 36},
+		{name: "hex", format: "hex", wantPrefix: "chatcmpl-", wantLen: len("chatcmpl-") + 32},
+		{name: "short with prefix", prefix: "cx-", format: "short", wantPrefix: "cx-", wantLen: len("cx-") + 24},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewConverter()
+			if err := c.SetCompletionIDFormat(tt.prefix, tt.format); err != nil {
+				t.Fatalf("SetCompletionIDFormat: %v", err)
+			}
+
+			seen := make(map[string]bool)
+			for i := 0; i < 1000; i++ {
+				id := c.GenerateCompletionID()
+				if !strings.HasPrefix(id, tt.wantPrefix) {
+					t.Fatalf("got %q, want prefix %q", id, tt.wantPrefix)
+				}
+				if len(id) != tt.wantLen {
+					t.Fatalf("got %q with length %d, want %d", id, len(id), tt.wantLen)
+				}
+				if seen[id] {
+					t.Fatalf("got duplicate ID %q", id)
+				}
+				seen[id] = true
+			}
+		})
+	}
+}
+
+func TestSetCompletionIDFormat_Invalid(t *testing.T) {
+	c := NewConverter()
+	if err := c.SetCompletionIDFormat("x-", "base64"); err == nil {
+		t.Error("got nil error for unknown format, want error")
+	}
+	if id := c.GenerateCompletionID(); !strings.HasPrefix(id, "chatcmpl-") {
+		t.Errorf("got %q after rejected format, want default prefix", id)
+	}
+}
@@ -0,0 +1,169 @@
+package converter
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// TextStream turns the CLI's stream-json output into text deltas, forwarding
+// each piece of text exactly once.
+//
+// With --include-partial-messages the CLI sends a content block's text as
+// content_block_delta events and may also send the complete assistant
+// message, so the same text can arrive twice, in either order. A complete
+// message need not carry every block of the message being streamed, so its
+// blocks are matched to the partial events' blocks by their text rather than
+// by position. TextStream tracks how much of each block has been forwarded
+// and only passes on text beyond that.
+type TextStream struct {
+	fullMessages bool
+	message      string // key of the message being streamed
+	messages     int
+	blocks       map[string][]*textBlock // by message key, in order of arrival
+}
+
+// textBlock is the progress of a text content block within a streamed
+// message.
+type textBlock struct {
+	index   int    // content block index in partial events, or -1 if none arrived yet
+	partial string // text received in partial events
+	full    string // text in the latest complete message carrying the block
+	emitted int    // bytes forwarded
+}
+
+// known returns the most text known for the block.
+func (b *textBlock) known() string {
+	if len(b.full) > len(b.partial) {
+		return b.full
+	}
+	return b.partial
+}
+
+// NewTextStream creates a TextStream. With fullMessages, text in complete
+// assistant messages is forwarded when partial events have not already sent
+// it; otherwise only partial events are used.
+func NewTextStream(fullMessages bool) *TextStream {
+	return &TextStream{fullMessages: fullMessages, blocks: make(map[string][]*textBlock)}
+}
+
+// Write returns the text in msg that has not been forwarded yet.
+func (s *TextStream) Write(msg *models.ClaudeStreamMessage) string {
+	switch msg.Type {
+	case "stream_event":
+		if msg.Event != nil && msg.Event.Type == "message_start" {
+			s.startMessage(msg.Event.Message)
+			return ""
+		}
+		text := msg.GetDeltaText()
+		if text == "" {
+			return ""
+		}
+		return s.writePartial(s.partialBlock(msg.Event.Index, text), text)
+	case "assistant":
+		if !s.fullMessages || msg.Message == nil {
+			return ""
+		}
+		return s.writeMessage(msg.Message)
+	}
+	return ""
+}
+
+// startMessage begins a new message. Messages without an ID are told apart
+// by their position in the stream.
+func (s *TextStream) startMessage(msg *models.ClaudeMessage) {
+	s.messages++
+	if msg != nil && msg.ID != "" {
+		s.message = msg.ID
+		return
+	}
+	s.message = "#" + strconv.Itoa(s.messages)
+}
+
+// partialBlock returns the block of the streamed message that a partial
+// event's text belongs to. A block first seen in a complete message is
+// matched by the text it starts with.
+func (s *TextStream) partialBlock(index int, text string) *textBlock {
+	blocks := s.blocks[s.message]
+	for _, b := range blocks {
+		if b.index == index {
+			return b
+		}
+	}
+	for _, b := range blocks {
+		if b.index < 0 && strings.HasPrefix(b.full, text) {
+			b.index = index
+			return b
+		}
+	}
+	b := &textBlock{index: index}
+	s.blocks[s.message] = append(blocks, b)
+	return b
+}
+
+// writePartial returns the part of a partial event's text beyond what the
+// block has already forwarded.
+func (s *TextStream) writePartial(b *textBlock, text string) string {
+	start := len(b.partial)
+	b.partial += text
+	if len(b.partial) <= b.emitted {
+		return ""
+	}
+	if start < b.emitted {
+		text = text[b.emitted-start:]
+	}
+	b.emitted = len(b.partial)
+	return text
+}
+
+// writeMessage returns the text of a complete message's blocks beyond what
+// each block has already forwarded. A message without an ID is taken to be
+// the one being streamed.
+func (s *TextStream) writeMessage(msg *models.ClaudeMessage) string {
+	message := msg.ID
+	if message == "" {
+		message = s.message
+	}
+	var out string
+	matched := make(map[*textBlock]bool)
+	for _, block := range msg.Content {
+		if block.Type != "text" || block.Text == "" {
+			continue
+		}
+		b := s.fullBlock(message, block.Text, matched)
+		matched[b] = true
+		b.full = block.Text
+		if len(block.Text) > b.emitted {
+			out += block.Text[b.emitted:]
+			b.emitted = len(block.Text)
+		}
+	}
+	return out
+}
+
+// fullBlock returns the block of message that a complete message's text
+// block is, skipping blocks already matched in that message. Blocks known
+// only from partial events are preferred, so a block repeated in a later
+// message is told apart from a new block with the same opening.
+func (s *TextStream) fullBlock(message, text string, matched map[*textBlock]bool) *textBlock {
+	blocks := s.blocks[message]
+	for _, b := range blocks {
+		if !matched[b] && b.full == "" && sharesPrefix(b.partial, text) {
+			return b
+		}
+	}
+	for _, b := range blocks {
+		if !matched[b] && sharesPrefix(b.known(), text) {
+			return b
+		}
+	}
+	b := &textBlock{index: -1}
+	s.blocks[message] = append(blocks, b)
+	return b
+}
+
+// sharesPrefix reports whether one of a and b begins with the other.
+func sharesPrefix(a, b string) bool {
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
@@ -0,0 +1,129 @@
+package converter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+const (
+	msgStart1 = `{"type":"stream_event","event":{"type":"message_start","message":{"id":"msg_1","role":"assistant","content":[]}}}`
+	msgStart2 = `{"type":"stream_event","event":{"type":"message_start","message":{"id":"msg_2","role":"assistant","content":[]}}}`
+	full1     = `{"type":"assistant","message":{"id":"msg_1","role":"assistant","content":[{"type":"text","text":"Hello world"},{"type":"tool_use"},{"type":"text","text":"Done."}]}}`
+	full2     = `{"type":"assistant","message":{"id":"msg_2","role":"assistant","content":[{"type":"text","text":"Second turn"}]}}`
+
+	// Complete messages carrying only some of msg_1's blocks
+	thinkingThenText = `{"type":"assistant","message":{"id":"msg_1","role":"assistant","content":[{"type":"thinking","thinking":"hmm"},{"type":"text","text":"Hello world"}]}}`
+	textOnly         = `{"type":"assistant","message":{"id":"msg_1","role":"assistant","content":[{"type":"text","text":"Hello world"}]}}`
+	secondTextOnly   = `{"type":"assistant","message":{"id":"msg_1","role":"assistant","content":[{"type":"text","text":"Done."}]}}`
+)
+
+// partial returns a text_delta event for a content block.
+func partial(index int, text string) string {
+	data, _ := json.Marshal(map[string]any{
+		"type": "stream_event",
+		"event": map[string]any{
+			"type":  "content_block_delta",
+			"index": index,
+			"delta": map[string]any{"type": "text_delta", "text": text},
+		},
+	})
+	return string(data)
+}
+
+func TestTextStream(t *testing.T) {
+	tests := []struct {
+		name         string
+		fullMessages bool
+		lines        []string
+		want         string
+	}{
+		{
+			name:         "partial events only",
+			fullMessages: true,
+			lines:        []string{msgStart1, partial(0, "Hello "), partial(0, "world"), partial(2, "Done.")},
+			want:         "Hello worldDone.",
+		},
+		{
+			name:         "full message after partial events",
+			fullMessages: true,
+			lines:        []string{msgStart1, partial(0, "Hello "), partial(0, "world"), partial(2, "Done."), full1},
+			want:         "Hello worldDone.",
+		},
+		{
+			name:         "full message completes partial events",
+			fullMessages: true,
+			lines:        []string{msgStart1, partial(0, "Hello "), full1},
+			want:         "Hello worldDone.",
+		},
+		{
+			name:         "partial events after full message",
+			fullMessages: true,
+			lines:        []string{msgStart1, partial(0, "Hel"), full1, partial(0, "lo "), partial(0, "world"), partial(2, "Done.")},
+			want:         "Hello worldDone.",
+		},
+		{
+			name:         "partial event overlapping full message",
+			fullMessages: true,
+			lines:        []string{msgStart1, full1, partial(0, "Hello world"), partial(0, "!")},
+			want:         "Hello worldDone.!",
+		},
+		{
+			name:         "several messages interleaved",
+			fullMessages: true,
+			lines: []string{
+				msgStart1, partial(0, "Hello "), partial(0, "world"), partial(2, "Done."),
+				msgStart2, partial(0, "Second"), full1, partial(0, " turn"), full2,
+			},
+			want: "Hello worldDone.Second turn",
+		},
+		{
+			name:         "full messages only",
+			fullMessages: true,
+			lines:        []string{full1, full2},
+			want:         "Hello worldDone.Second turn",
+		},
+		{
+			name:         "text block after a thinking block",
+			fullMessages: true,
+			lines:        []string{msgStart1, partial(1, "Hello "), partial(1, "world"), thinkingThenText, textOnly},
+			want:         "Hello world",
+		},
+		{
+			name:         "one block per message before partial events",
+			fullMessages: true,
+			lines:        []string{msgStart1, textOnly, partial(1, "Hello "), partial(1, "world"), partial(3, "Done."), secondTextOnly},
+			want:         "Hello worldDone.",
+		},
+		{
+			name:         "one block per message interleaved",
+			fullMessages: true,
+			lines:        []string{msgStart1, partial(1, "Hello "), textOnly, partial(1, "world"), secondTextOnly, partial(3, "Done.")},
+			want:         "Hello worldDone.",
+		},
+		{
+			name:  "full messages ignored",
+			lines: []string{msgStart1, partial(0, "Hello "), full1, partial(0, "world")},
+			want:  "Hello world",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewTextStream(tt.fullMessages)
+			var got strings.Builder
+			for _, line := range tt.lines {
+				var msg models.ClaudeStreamMessage
+				if err := json.Unmarshal([]byte(line), &msg); err != nil {
+					t.Fatalf("unmarshal %s: %v", line, err)
+				}
+				got.WriteString(s.Write(&msg))
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
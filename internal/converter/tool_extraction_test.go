@@ -1,7 +1,9 @@
 package converter
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestExtractToolCalls(t *testing.T) {
@@ -94,3 +96,160 @@ func TestExtractToolCalls(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractToolCalls_Hardened(t *testing.T) {
+	conv := NewConverter()
+
+	tests := []struct {
+		name          string
+		input         string
+		wantToolCalls int
+		wantToolName  string
+		wantContent   string
+	}{
+		{
+			name:          "braces in prose before JSON",
+			input:         `Using set {a, b} and map {"x": 1}, I'll call: {"tool_calls":[{"id":"call_1","type":"function","function":{"name":"lookup","arguments":"{}"}}]}`,
+			wantToolCalls: 1,
+			wantToolName:  "lookup",
+			wantContent:   `Using set {a, b} and map {"x": 1}, I'll call:`,
+		},
+		{
+			name:          "whitespace after opening brace",
+			input:         "{ \"tool_calls\": [{\"id\":\"call_1\",\"type\":\"function\",\"function\":{\"name\":\"lookup\",\"arguments\":\"{}\"}}] }",
+			wantToolCalls: 1,
+			wantToolName:  "lookup",
+			wantContent:   "",
+		},
+		{
+			name:          "tool_calls mentioned in text only",
+			input:         `To call tools, respond with {"tool_calls": [...]} as described. No tools are needed here.`,
+			wantToolCalls: 0,
+			wantContent:   `To call tools, respond with {"tool_calls": [...]} as described. No tools are needed here.`,
+		},
+		{
+			name:          "tool_calls inside a string value",
+			input:         `{"note":"{\"tool_calls\":[{\"id\":\"x\",\"type\":\"function\",\"function\":{\"name\":\"fake\"}}]}"}`,
+			wantToolCalls: 0,
+			wantContent:   `{"note":"{\"tool_calls\":[{\"id\":\"x\",\"type\":\"function\",\"function\":{\"name\":\"fake\"}}]}"}`,
+		},
+		{
+			name:          "unclosed brace in prose before tool calls",
+			input:         `Given the set {a, b I'll call: {"tool_calls":[{"id":"call_1","type":"function","function":{"name":"lookup","arguments":"{}"}}]}`,
+			wantToolCalls: 1,
+			wantToolName:  "lookup",
+			wantContent:   `Given the set {a, b I'll call:`,
+		},
+		{
+			name:          "unclosed brace and stray quote before tool calls",
+			input:         `For the 5" screen {size: 5" I'll call: {"tool_calls":[{"id":"call_1","type":"function","function":{"name":"lookup","arguments":"{}"}}]}`,
+			wantToolCalls: 1,
+			wantToolName:  "lookup",
+			wantContent:   `For the 5" screen {size: 5" I'll call:`,
+		},
+		{
+			name:          "unrelated code block before tool calls",
+			input:         "Example config:\n```json\n{\"debug\": true}\n```\n" + `{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"apply","arguments":{"debug":true}}}]}`,
+			wantToolCalls: 1,
+			wantToolName:  "apply",
+			wantContent:   "Example config:\n```json\n{\"debug\": true}\n```",
+		},
+		{
+			name:          "fenced tool calls with braces in string arguments",
+			input:         "Sure.\n```json\n" + `{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"write","arguments":"{\"text\":\"a } b { c\"}"}}]}` + "\n```\nDone.",
+			wantToolCalls: 1,
+			wantToolName:  "write",
+			wantContent:   "Sure.\n\nDone.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, toolCalls := conv.ExtractToolCalls(tt.input)
+
+			if len(toolCalls) != tt.wantToolCalls {
+				t.Fatalf("got %d tool calls, want %d", len(toolCalls), tt.wantToolCalls)
+			}
+			if tt.wantToolCalls > 0 && toolCalls[0].Function.Name != tt.wantToolName {
+				t.Errorf("got tool name %q, want %q", toolCalls[0].Function.Name, tt.wantToolName)
+			}
+			if content != tt.wantContent {
+				t.Errorf("got content %q, want %q", content, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestExtractToolCalls_UnclosedBraces(t *testing.T) {
+	conv := NewConverter()
+
+	// Scanning from every unclosed brace to the end would take minutes
+	tests := []struct {
+		input         string
+		wantToolCalls int
+	}{
+		{strings.Repeat("{", 200000) + ` "tool_calls"`, 0},
+		{strings.Repeat(`{"a": `, 50000) + `{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"lookup","arguments":"{}"}}]}`, 1},
+		{`5" ` + strings.Repeat(`{"tool_calls": `, 50000), 0},
+	}
+	for i, tt := range tests {
+		start := time.Now()
+		_, toolCalls := conv.ExtractToolCalls(tt.input)
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("input %d: took %v, want linear time", i, elapsed)
+		}
+		if len(toolCalls) != tt.wantToolCalls {
+			t.Errorf("input %d: got %d tool calls, want %d", i, len(toolCalls), tt.wantToolCalls)
+		}
+	}
+}
+
+func TestStripMalformedToolCalls(t *testing.T) {
+	conv := NewConverter()
+
+	tests := []struct {
+		name        string
+		input       string
+		wantContent string
+		wantBlock   string
+	}{
+		{
+			name:        "invalid JSON",
+			input:       `Let me check. {"tool_calls": [{"id": "call_1", "function": {"name": "lookup", "arguments": {q: 1}}}]} Done.`,
+			wantContent: "Let me check.\n\nDone.",
+			wantBlock:   `{"tool_calls": [{"id": "call_1", "function": {"name": "lookup", "arguments": {q: 1}}}]}`,
+		},
+		{
+			name:        "truncated in a code fence",
+			input:       "Checking.\n```json\n{\"tool_calls\": [{\"id\": \"call_1\", \"function\": {\"name\": \"lookup\"\n```",
+			wantContent: "Checking.",
+			wantBlock:   "{\"tool_calls\": [{\"id\": \"call_1\", \"function\": {\"name\": \"lookup\"\n",
+		},
+		{
+			name:        "truncated at the end",
+			input:       `Checking. {"tool_calls": [{"id": "call_1"`,
+			wantContent: "Checking.",
+			wantBlock:   `{"tool_calls": [{"id": "call_1"`,
+		},
+		{
+			name:        "tool_calls mentioned in prose",
+			input:       `The "tool_calls" field lists calls.`,
+			wantContent: `The "tool_calls" field lists calls.`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, toolCalls := conv.ExtractToolCalls(tt.input); len(toolCalls) > 0 {
+				t.Fatalf("got %d tool calls, want none", len(toolCalls))
+			}
+			content, block := conv.StripMalformedToolCalls(tt.input)
+			if content != tt.wantContent {
+				t.Errorf("got content %q, want %q", content, tt.wantContent)
+			}
+			if block != tt.wantBlock {
+				t.Errorf("got block %q, want %q", block, tt.wantBlock)
+			}
+		})
+	}
+}
@@ -0,0 +1,539 @@
+package converter
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// ToolCallStreamEvent is either assistant text or a tool call delta produced
+// by ToolCallStream.
+type ToolCallStreamEvent struct {
+	Text     string
+	ToolCall *models.ToolCallDelta
+}
+
+// ToolCallStream turns streamed assistant text into content and tool call
+// deltas for the prompt-based tool calling path, where Claude writes a
+// {"tool_calls": [...]} JSON block (optionally in a code fence) as text.
+//
+// Text that could start such a block is held back until it either confirms
+// {"tool_calls": [{ or diverges from it, in which case it is forwarded as
+// text. Inside a confirmed block, each call's id and name are sent once the
+// name is complete, and its arguments are sent as they arrive: decoded when
+// they are a JSON string, verbatim when they are an object.
+type ToolCallStream struct {
+	mode    int
+	pending string // text not yet classified
+	fenced  bool   // the current block was opened with a code fence
+	raw     strings.Builder
+	events  []ToolCallStreamEvent
+	calls   int
+
+	// JSON scanning state inside a tool_calls block
+	stack     []jsonFrame
+	inString  bool
+	stringFor int
+	str       strings.Builder
+	escape    bool
+	unicode   []byte
+	high      rune
+	rawDepth  int
+	rawString bool
+	rawEscape bool
+	call      *streamedCall
+}
+
+// Stream modes.
+const (
+	modeText = iota
+	modeToolCalls
+	modeAfterToolCalls
+)
+
+// What a JSON string inside the block is captured for.
+const (
+	captureNone = iota
+	captureKey
+	captureID
+	captureName
+	captureArguments
+)
+
+// jsonFrame is an open JSON object or array.
+type jsonFrame struct {
+	object    bool
+	key       string
+	expectKey bool
+}
+
+// streamedCall tracks the tool call being parsed.
+type streamedCall struct {
+	index       int
+	id          string
+	started     bool
+	pendingArgs strings.Builder
+	sentArgs    bool
+}
+
+// toolCallsKey is the key a tool_calls block must start with.
+const toolCallsKey = `"tool_calls"`
+
+// NewToolCallStream creates a stream parser.
+func NewToolCallStream() *ToolCallStream {
+	return &ToolCallStream{}
+}
+
+// HasToolCalls reports whether any tool call has been emitted.
+func (s *ToolCallStream) HasToolCalls() bool {
+	return s.calls > 0
+}
+
+// Write feeds the next text delta and returns the events ready to send.
+func (s *ToolCallStream) Write(text string) []ToolCallStreamEvent {
+	s.pending += text
+	s.process()
+	return s.take()
+}
+
+// Flush returns the remaining events at the end of the stream. Held-back
+// text, and a block that never produced a tool call, are returned as text.
+func (s *ToolCallStream) Flush() []ToolCallStreamEvent {
+	switch s.mode {
+	case modeToolCalls:
+		if s.calls == 0 {
+			s.emitText(s.raw.String())
+		}
+	case modeText:
+		s.emitText(s.pending)
+	}
+	s.pending = ""
+	s.mode = modeText
+	return s.take()
+}
+
+func (s *ToolCallStream) take() []ToolCallStreamEvent {
+	events := s.events
+	s.events = nil
+	return events
+}
+
+func (s *ToolCallStream) process() {
+	for s.pending != "" {
+		switch s.mode {
+		case modeText:
+			idx := strings.IndexAny(s.pending, "{`")
+			if idx < 0 {
+				s.emitText(s.pending)
+				s.pending = ""
+				return
+			}
+			s.emitText(s.pending[:idx])
+			s.pending = s.pending[idx:]
+
+			brace, fenced, decided := classifyToolCallsBlock(s.pending)
+			if !decided {
+				return // wait for more text
+			}
+			if brace < 0 {
+				s.emitText(s.pending[:1])
+				s.pending = s.pending[1:]
+				continue
+			}
+			s.startBlock(fenced)
+			s.pending = s.pending[brace:]
+
+		case modeToolCalls:
+			n := s.scan(s.pending)
+			s.pending = s.pending[n:]
+
+		case modeAfterToolCalls:
+			if !s.fenced {
+				s.mode = modeText
+				continue
+			}
+			rest := strings.TrimLeft(s.pending, " \t\r\n")
+			if rest == "" || (len(rest) < 3 && strings.HasPrefix("```", rest)) {
+				return // the closing fence may still follow
+			}
+			s.pending = strings.TrimPrefix(rest, "```")
+			s.mode = modeText
+		}
+	}
+}
+
+// classifyToolCallsBlock decides whether text, which starts with '{' or '`',
+// opens a tool_calls block. It returns the offset of the block's opening
+// brace (or -1 if it is not a block), whether it is fenced, and whether
+// enough text was seen to decide.
+func classifyToolCallsBlock(text string) (brace int, fenced bool, decided bool) {
+	i := 0
+	if text[0] == '`' {
+		if len(text) < 3 {
+			return -1, false, !strings.HasPrefix("```", text)
+		}
+		if !strings.HasPrefix(text, "```") {
+			return -1, false, true
+		}
+		fenced = true
+		i = 3
+		for i < len(text) && isLetter(text[i]) {
+			i++
+		}
+		i = skipSpace(text, i)
+		if i == len(text) {
+			return -1, false, false
+		}
+		if text[i] != '{' {
+			return -1, false, true
+		}
+	}
+	brace = i
+
+	// {"tool_calls" : [ {
+	i = skipSpace(text, i+1)
+	rest := text[i:]
+	if len(rest) < len(toolCallsKey) {
+		return -1, false, !strings.HasPrefix(toolCallsKey, rest)
+	}
+	if !strings.HasPrefix(rest, toolCallsKey) {
+		return -1, false, true
+	}
+	i += len(toolCallsKey)
+	for _, want := range []byte{':', '[', '{'} {
+		i = skipSpace(text, i)
+		if i == len(text) {
+			return -1, false, false
+		}
+		if text[i] != want {
+			return -1, false, true
+		}
+		i++
+	}
+	return brace, fenced, true
+}
+
+func skipSpace(text string, i int) int {
+	for i < len(text) && strings.IndexByte(" \t\r\n", text[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func (s *ToolCallStream) startBlock(fenced bool) {
+	s.mode = modeToolCalls
+	s.fenced = fenced
+	s.raw.Reset()
+	s.stack = s.stack[:0]
+	s.inString = false
+	s.rawDepth = 0
+	s.call = nil
+}
+
+// scan consumes block text and returns how many bytes were used. It stops
+// early when the block closes.
+func (s *ToolCallStream) scan(text string) int {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		s.raw.WriteByte(c)
+
+		switch {
+		case s.rawDepth > 0:
+			s.scanRawArguments(c)
+		case s.inString:
+			s.scanString(c)
+		default:
+			if !s.scanToken(c) {
+				s.abortBlock()
+				return i + 1
+			}
+			if len(s.stack) == 0 {
+				s.mode = modeAfterToolCalls
+				return i + 1
+			}
+		}
+	}
+	return len(text)
+}
+
+// scanToken handles a byte outside strings. It returns false if the text is
+// not valid tool_calls JSON.
+func (s *ToolCallStream) scanToken(c byte) bool {
+	top := len(s.stack) - 1
+	switch c {
+	case ' ', '\t', '\r', '\n':
+	case '{', '[':
+		if s.atArguments() {
+			s.rawDepth = 1
+			s.sendArguments(string(c))
+			return true
+		}
+		inToolCalls := top >= 0 && s.stack[0].key == "tool_calls"
+		if top == 0 && inToolCalls && c != '[' {
+			return false // tool_calls must be an array
+		}
+		if top == 1 && inToolCalls {
+			s.startCall()
+		}
+		s.stack = append(s.stack, jsonFrame{object: c == '{', expectKey: c == '{'})
+	case '}', ']':
+		if top < 0 || s.stack[top].object != (c == '}') {
+			return false
+		}
+		if top == 2 && s.call != nil {
+			s.finishCall()
+		}
+		s.stack = s.stack[:top]
+	case ':':
+		if top < 0 || !s.stack[top].object {
+			return false
+		}
+		s.stack[top].expectKey = false
+	case ',':
+		if top >= 0 && s.stack[top].object {
+			s.stack[top].expectKey = true
+			s.stack[top].key = ""
+		}
+	case '"':
+		s.inString = true
+		s.str.Reset()
+		s.stringFor = s.captureTarget()
+	default:
+		if top == 0 && s.stack[0].key == "tool_calls" && !s.stack[0].expectKey {
+			return false // tool_calls must be an array
+		}
+	}
+	return true
+}
+
+// captureTarget returns what the string starting now is captured for.
+func (s *ToolCallStream) captureTarget() int {
+	top := len(s.stack) - 1
+	if top < 0 {
+		return captureNone
+	}
+	frame := s.stack[top]
+	if frame.object && frame.expectKey {
+		return captureKey
+	}
+	switch {
+	case top == 2 && frame.key == "id":
+		return captureID
+	case s.inFunction() && frame.key == "name":
+		return captureName
+	case s.inFunction() && frame.key == "arguments":
+		return captureArguments
+	}
+	return captureNone
+}
+
+// inFunction reports whether the innermost frame is a tool call's function object.
+func (s *ToolCallStream) inFunction() bool {
+	return len(s.stack) == 4 && s.stack[2].key == "function" && s.stack[3].object
+}
+
+// atArguments reports whether the next value is a function's arguments.
+func (s *ToolCallStream) atArguments() bool {
+	return s.inFunction() && !s.stack[3].expectKey && s.stack[3].key == "arguments"
+}
+
+// scanString handles a byte inside a JSON string, decoding escapes.
+func (s *ToolCallStream) scanString(c byte) {
+	if s.unicode != nil {
+		s.unicode = append(s.unicode, c)
+		if len(s.unicode) == 4 {
+			n, _ := strconv.ParseUint(string(s.unicode), 16, 16)
+			s.unicode = nil
+			s.writeRune(rune(n))
+		}
+		return
+	}
+	if s.escape {
+		s.escape = false
+		switch c {
+		case 'u':
+			s.unicode = make([]byte, 0, 4)
+		case 'n':
+			s.writeString("\n")
+		case 't':
+			s.writeString("\t")
+		case 'r':
+			s.writeString("\r")
+		case 'b':
+			s.writeString("\b")
+		case 'f':
+			s.writeString("\f")
+		default:
+			s.writeString(string([]byte{c}))
+		}
+		return
+	}
+	switch c {
+	case '\\':
+		s.escape = true
+	case '"':
+		s.inString = false
+		s.endString()
+	default:
+		s.writeString(string([]byte{c}))
+	}
+}
+
+// writeRune appends a decoded \u escape, joining surrogate pairs.
+func (s *ToolCallStream) writeRune(r rune) {
+	if utf16.IsSurrogate(r) {
+		if s.high == 0 {
+			s.high = r
+			return
+		}
+		r = utf16.DecodeRune(s.high, r)
+	}
+	s.high = 0
+	s.writeString(string(r))
+}
+
+func (s *ToolCallStream) writeString(text string) {
+	if s.stringFor == captureArguments {
+		s.sendArguments(text)
+		return
+	}
+	if s.stringFor != captureNone {
+		s.str.WriteString(text)
+	}
+}
+
+// endString records a completed key, id, or name.
+func (s *ToolCallStream) endString() {
+	top := len(s.stack) - 1
+	switch s.stringFor {
+	case captureKey:
+		s.stack[top].key = s.str.String()
+	case captureID:
+		if s.call != nil {
+			s.call.id = s.str.String()
+		}
+	case captureName:
+		s.sendHeader(s.str.String())
+	case captureArguments:
+		if s.call != nil {
+			s.call.sentArgs = true
+		}
+	}
+}
+
+// scanRawArguments forwards an object-valued arguments field verbatim.
+func (s *ToolCallStream) scanRawArguments(c byte) {
+	s.sendArguments(string([]byte{c}))
+	switch {
+	case s.rawEscape:
+		s.rawEscape = false
+	case s.rawString:
+		if c == '\\' {
+			s.rawEscape = true
+		} else if c == '"' {
+			s.rawString = false
+		}
+	case c == '"':
+		s.rawString = true
+	case c == '{' || c == '[':
+		s.rawDepth++
+	case c == '}' || c == ']':
+		s.rawDepth--
+		if s.rawDepth == 0 && s.call != nil {
+			s.call.sentArgs = true
+		}
+	}
+}
+
+func (s *ToolCallStream) startCall() {
+	s.call = &streamedCall{index: s.calls}
+}
+
+// sendHeader emits the call's id and name, followed by any arguments that
+// arrived before the name.
+func (s *ToolCallStream) sendHeader(name string) {
+	call := s.call
+	if call == nil || call.started {
+		return
+	}
+	call.started = true
+	s.calls++
+
+	id := call.id
+	if id == "" {
+		id = GenerateToolCallID()
+	}
+	s.events = append(s.events, ToolCallStreamEvent{ToolCall: &models.ToolCallDelta{
+		Index:    call.index,
+		ID:       id,
+		Type:     "function",
+		Function: &models.FunctionCallDelta{Name: name},
+	}})
+	if call.pendingArgs.Len() > 0 {
+		args := call.pendingArgs.String()
+		call.pendingArgs.Reset()
+		s.sendArguments(args)
+	}
+}
+
+// sendArguments emits an arguments fragment, merging it with the previous
+// event for the same call.
+func (s *ToolCallStream) sendArguments(fragment string) {
+	call := s.call
+	if call == nil {
+		return
+	}
+	if !call.started {
+		call.pendingArgs.WriteString(fragment)
+		return
+	}
+	if n := len(s.events); n > 0 {
+		last := s.events[n-1].ToolCall
+		if last != nil && last.Index == call.index && last.ID == "" {
+			last.Function.Arguments += fragment
+			return
+		}
+	}
+	s.events = append(s.events, ToolCallStreamEvent{ToolCall: &models.ToolCallDelta{
+		Index:    call.index,
+		Function: &models.FunctionCallDelta{Arguments: fragment},
+	}})
+}
+
+// finishCall completes a tool call object, sending its header if the name
+// never arrived and "{}" if it had no arguments.
+func (s *ToolCallStream) finishCall() {
+	s.sendHeader("")
+	if !s.call.sentArgs && s.call.pendingArgs.Len() == 0 {
+		s.sendArguments("{}")
+	}
+	s.call = nil
+}
+
+// abortBlock returns to text mode after invalid JSON. The block is sent as
+// text unless tool calls were already emitted from it.
+func (s *ToolCallStream) abortBlock() {
+	if s.calls == 0 {
+		s.emitText(s.raw.String())
+	}
+	s.mode = modeText
+	s.stack = s.stack[:0]
+	s.call = nil
+}
+
+func (s *ToolCallStream) emitText(text string) {
+	if text == "" {
+		return
+	}
+	if n := len(s.events); n > 0 && s.events[n-1].ToolCall == nil {
+		s.events[n-1].Text += text
+		return
+	}
+	s.events = append(s.events, ToolCallStreamEvent{Text: text})
+}
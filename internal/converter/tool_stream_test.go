@@ -0,0 +1,132 @@
+package converter
+
+import (
+	"strings"
+	"testing"
+)
+
+type streamedToolCall struct {
+	id, name, args string
+}
+
+// collectToolStream feeds chunks through a ToolCallStream and assembles the
+// text and tool calls it produced.
+func collectToolStream(chunks []string) (string, []streamedToolCall) {
+	s := NewToolCallStream()
+	var text strings.Builder
+	var calls []streamedToolCall
+	add := func(evs []ToolCallStreamEvent) {
+		for _, ev := range evs {
+			if ev.ToolCall == nil {
+				text.WriteString(ev.Text)
+				continue
+			}
+			tc := ev.ToolCall
+			if tc.ID != "" {
+				calls = append(calls, streamedToolCall{id: tc.ID})
+			}
+			if tc.Function != nil {
+				calls[tc.Index].name += tc.Function.Name
+				calls[tc.Index].args += tc.Function.Arguments
+			}
+		}
+	}
+	for _, chunk := range chunks {
+		add(s.Write(chunk))
+	}
+	add(s.Flush())
+	return text.String(), calls
+}
+
+// chunkings returns text split at every point and one byte at a time.
+func chunkings(text string) [][]string {
+	result := [][]string{{text}}
+	for i := 1; i < len(text); i++ {
+		result = append(result, []string{text[:i], text[i:]})
+	}
+	var bytewise []string
+	for i := 0; i < len(text); i++ {
+		bytewise = append(bytewise, text[i:i+1])
+	}
+	return append(result, bytewise)
+}
+
+func TestToolCallStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantText  string
+		wantCalls []streamedToolCall
+	}{
+		{
+			name: "fenced block with string arguments",
+			input: "Checking the weather.\n\n```json\n" +
+				`{"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\": \"Zürich\", \"note\": \"a\\\\b\"}"}}]}` +
+				"\n```",
+			wantText:  "Checking the weather.\n\n",
+			wantCalls: []streamedToolCall{{id: "call_1", name: "get_weather", args: `{"city": "Zürich", "note": "a\\b"}`}},
+		},
+		{
+			name:  "bare block with object arguments",
+			input: `{"tool_calls":[{"id":"call_1","type":"function","function":{"name":"a","arguments":{"q":"x}{","n":[1,2]}}},{"id":"call_2","type":"function","function":{"name":"b"}}]}`,
+			wantCalls: []streamedToolCall{
+				{id: "call_1", name: "a", args: `{"q":"x}{","n":[1,2]}`},
+				{id: "call_2", name: "b", args: "{}"},
+			},
+		},
+		{
+			name:     "other JSON stays text",
+			input:    `Here: {"tools": [1]} and {"tool_calls": "none"} done`,
+			wantText: `Here: {"tools": [1]} and {"tool_calls": "none"} done`,
+		},
+		{
+			name:     "code fence without tool calls stays text",
+			input:    "```go\nfunc main() {}\n```",
+			wantText: "```go\nfunc main() {}\n```",
+		},
+		{
+			name:     "unfinished block is flushed as text",
+			input:    `Almost {"tool_calls": [{"id": "call_1"`,
+			wantText: `Almost {"tool_calls": [{"id": "call_1"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, chunks := range chunkings(tt.input) {
+				text, calls := collectToolStream(chunks)
+				if text != tt.wantText {
+					t.Fatalf("chunks %q: got text %q, want %q", chunks, text, tt.wantText)
+				}
+				if len(calls) != len(tt.wantCalls) {
+					t.Fatalf("chunks %q: got %d tool calls, want %d", chunks, len(calls), len(tt.wantCalls))
+				}
+				for i, want := range tt.wantCalls {
+					if calls[i] != want {
+						t.Fatalf("chunks %q: tool call %d: got %+v, want %+v", chunks, i, calls[i], want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestToolCallStream_ArgumentsBeforeBlockCloses(t *testing.T) {
+	s := NewToolCallStream()
+	s.Write(`{"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "search", `)
+
+	evs := s.Write(`"arguments": "{\"query\": \"go`)
+	if len(evs) != 1 || evs[0].ToolCall == nil || evs[0].ToolCall.Function.Arguments != `{"query": "go` {
+		t.Fatalf("got events %+v, want an arguments delta for the partial value", evs)
+	}
+	if !s.HasToolCalls() {
+		t.Error("got HasToolCalls false after the name was sent, want true")
+	}
+}
+
+func TestToolCallStream_GeneratesMissingID(t *testing.T) {
+	_, calls := collectToolStream([]string{`{"tool_calls": [{"function": {"name": "f", "arguments": "{}"}}]}`})
+	if len(calls) != 1 || !strings.HasPrefix(calls[0].id, "call_") {
+		t.Errorf("got %+v, want one call with a generated id", calls)
+	}
+}
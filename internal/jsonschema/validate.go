@@ -0,0 +1,290 @@
+// Package jsonschema validates JSON documents against the subset of JSON
+// Schema used by OpenAI structured outputs and tool parameters.
+//
+// Supported keywords: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum, maximum, anyOf, oneOf, allOf, and local $ref to
+// $defs/definitions. Unknown keywords are ignored.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema is a parsed JSON schema.
+type Schema struct {
+	root map[string]any
+}
+
+// Parse parses a JSON schema document.
+func Parse(data []byte) (*Schema, error) {
+	var root map[string]any
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("schema must be a JSON object: %w", err)
+	}
+	return &Schema{root: root}, nil
+}
+
+// Validate checks a decoded JSON value against the schema and returns one
+// message per violation, or nil if the value is valid.
+func (s *Schema) Validate(value any) []string {
+	var errs []string
+	s.validate(s.root, value, "$", &errs)
+	return errs
+}
+
+// ValidateJSON decodes data and validates it against the schema.
+func (s *Schema) ValidateJSON(data []byte) []string {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return []string{fmt.Sprintf("$: invalid JSON: %v", err)}
+	}
+	return s.Validate(value)
+}
+
+func (s *Schema) validate(schema map[string]any, value any, path string, errs *[]string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := s.resolve(ref)
+		if err != nil {
+			*errs = append(*errs, fmt.Sprintf("%s: %v", path, err))
+			return
+		}
+		schema = target
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		*errs = append(*errs, fmt.Sprintf("%s: expected type %s, got %s", path, typeString(t), jsonType(value)))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && !containsValue(enum, value) {
+		*errs = append(*errs, fmt.Sprintf("%s: value is not one of the allowed values", path))
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		*errs = append(*errs, fmt.Sprintf("%s: value does not match const", path))
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		s.validateObject(schema, v, path, errs)
+	case []any:
+		s.validateArray(schema, v, path, errs)
+	case string:
+		validateString(schema, v, path, errs)
+	case float64:
+		validateNumber(schema, v, path, errs)
+	}
+
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, sub := range all {
+			if m, ok := sub.(map[string]any); ok {
+				s.validate(m, value, path, errs)
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok && s.countMatches(anyOf, value, path) == 0 {
+		*errs = append(*errs, fmt.Sprintf("%s: value does not match any schema in anyOf", path))
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		if n := s.countMatches(oneOf, value, path); n != 1 {
+			*errs = append(*errs, fmt.Sprintf("%s: value matches %d schemas in oneOf, want exactly 1", path, n))
+		}
+	}
+}
+
+func (s *Schema) validateObject(schema map[string]any, obj map[string]any, path string, errs *[]string) {
+	if required, ok := schema["required"].([]any); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, present := obj[name]; !present {
+					*errs = append(*errs, fmt.Sprintf("%s: missing required property %q", path, name))
+				}
+			}
+		}
+	}
+
+	props, _ := schema["properties"].(map[string]any)
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		childPath := path + "." + k
+		if sub, ok := props[k].(map[string]any); ok {
+			s.validate(sub, obj[k], childPath, errs)
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				*errs = append(*errs, fmt.Sprintf("%s: unexpected property %q", path, k))
+			}
+		case map[string]any:
+			s.validate(extra, obj[k], childPath, errs)
+		}
+	}
+}
+
+func (s *Schema) validateArray(schema map[string]any, arr []any, path string, errs *[]string) {
+	if n, ok := number(schema["minItems"]); ok && float64(len(arr)) < n {
+		*errs = append(*errs, fmt.Sprintf("%s: expected at least %v items, got %d", path, n, len(arr)))
+	}
+	if n, ok := number(schema["maxItems"]); ok && float64(len(arr)) > n {
+		*errs = append(*errs, fmt.Sprintf("%s: expected at most %v items, got %d", path, n, len(arr)))
+	}
+	if items, ok := schema["items"].(map[string]any); ok {
+		for i, item := range arr {
+			s.validate(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+func validateString(schema map[string]any, str string, path string, errs *[]string) {
+	length := float64(len([]rune(str)))
+	if n, ok := number(schema["minLength"]); ok && length < n {
+		*errs = append(*errs, fmt.Sprintf("%s: expected at least %v characters", path, n))
+	}
+	if n, ok := number(schema["maxLength"]); ok && length > n {
+		*errs = append(*errs, fmt.Sprintf("%s: expected at most %v characters", path, n))
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			*errs = append(*errs, fmt.Sprintf("%s: invalid pattern %q: %v", path, pattern, err))
+		} else if !re.MatchString(str) {
+			*errs = append(*errs, fmt.Sprintf("%s: value does not match pattern %q", path, pattern))
+		}
+	}
+}
+
+func validateNumber(schema map[string]any, num float64, path string, errs *[]string) {
+	if n, ok := number(schema["minimum"]); ok && num < n {
+		*errs = append(*errs, fmt.Sprintf("%s: expected a value >= %v", path, n))
+	}
+	if n, ok := number(schema["maximum"]); ok && num > n {
+		*errs = append(*errs, fmt.Sprintf("%s: expected a value <= %v", path, n))
+	}
+}
+
+// countMatches returns how many of the schemas value satisfies.
+func (s *Schema) countMatches(schemas []any, value any, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		m, ok := sub.(map[string]any)
+		if !ok {
+			continue
+		}
+		var subErrs []string
+		s.validate(m, value, path, &subErrs)
+		if len(subErrs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// resolve looks up a local reference such as "#/$defs/item".
+func (s *Schema) resolve(ref string) (map[string]any, error) {
+	if ref == "#" {
+		return s.root, nil
+	}
+	rest, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	var node any = s.root
+	for _, part := range strings.Split(rest, "/") {
+		m, ok := node.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		node = m[part]
+	}
+	target, ok := node.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unresolvable $ref %q", ref)
+	}
+	return target, nil
+}
+
+// matchesType reports whether value has the schema type, which may be a
+// single type name or a list of them.
+func matchesType(t any, value any) bool {
+	switch t := t.(type) {
+	case string:
+		return matchesTypeName(t, value)
+	case []any:
+		for _, name := range t {
+			if s, ok := name.(string); ok && matchesTypeName(s, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesTypeName(name string, value any) bool {
+	switch name {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return jsonType(value) == name
+}
+
+// jsonType returns the JSON type name of a decoded value.
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func typeString(t any) string {
+	if list, ok := t.([]any); ok {
+		names := make([]string, 0, len(list))
+		for _, name := range list {
+			names = append(names, fmt.Sprint(name))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func containsValue(values []any, value any) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func number(v any) (float64, bool) {
+	n, ok := v.(float64)
+	return n, ok
+}
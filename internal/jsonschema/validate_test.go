@@ -0,0 +1,94 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+)
+
+const personSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+		"address": {"$ref": "#/$defs/address"}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"$defs": {
+		"address": {
+			"type": "object",
+			"properties": {"city": {"type": "string"}},
+			"required": ["city"]
+		}
+	}
+}`
+
+func TestValidateJSON(t *testing.T) {
+	schema, err := Parse([]byte(personSchema))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr string
+	}{
+		{name: "valid", doc: `{"name":"Ada","age":36,"role":"admin","tags":["a"],"address":{"city":"London"}}`},
+		{name: "invalid JSON", doc: `{"name":`, wantErr: "invalid JSON"},
+		{name: "wrong root type", doc: `[]`, wantErr: "expected type object, got array"},
+		{name: "missing required", doc: `{"name":"Ada"}`, wantErr: `missing required property "age"`},
+		{name: "wrong property type", doc: `{"name":"Ada","age":"36"}`, wantErr: "$.age: expected type integer, got string"},
+		{name: "non-integer", doc: `{"name":"Ada","age":1.5}`, wantErr: "expected type integer"},
+		{name: "below minimum", doc: `{"name":"Ada","age":-1}`, wantErr: "expected a value >= 0"},
+		{name: "empty string", doc: `{"name":"","age":1}`, wantErr: "at least 1 characters"},
+		{name: "enum", doc: `{"name":"Ada","age":1,"role":"root"}`, wantErr: "$.role: value is not one of the allowed values"},
+		{name: "additional property", doc: `{"name":"Ada","age":1,"extra":true}`, wantErr: `unexpected property "extra"`},
+		{name: "array items", doc: `{"name":"Ada","age":1,"tags":[1]}`, wantErr: "$.tags[0]: expected type string"},
+		{name: "max items", doc: `{"name":"Ada","age":1,"tags":["a","b","c"]}`, wantErr: "at most 2 items"},
+		{name: "ref", doc: `{"name":"Ada","age":1,"address":{}}`, wantErr: `$.address: missing required property "city"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := schema.ValidateJSON([]byte(tt.doc))
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Errorf("got errors %v, want none", errs)
+				}
+				return
+			}
+			if !strings.Contains(strings.Join(errs, "\n"), tt.wantErr) {
+				t.Errorf("got errors %v, want one containing %q", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Combinators(t *testing.T) {
+	schema, err := Parse([]byte(`{
+		"anyOf": [{"type": "string"}, {"type": "null"}],
+		"oneOf": [{"type": "string"}, {"type": ["string", "null"]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if errs := schema.Validate(nil); len(errs) > 0 {
+		t.Errorf("null: got errors %v, want none", errs)
+	}
+	if errs := schema.Validate("x"); len(errs) != 1 || !strings.Contains(errs[0], "oneOf") {
+		t.Errorf("string: got errors %v, want one oneOf error", errs)
+	}
+	if errs := schema.Validate(1.0); len(errs) != 2 {
+		t.Errorf("number: got errors %v, want anyOf and oneOf errors", errs)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	if _, err := Parse([]byte(`"string"`)); err == nil {
+		t.Error("got nil error for a non-object schema, want error")
+	}
+}
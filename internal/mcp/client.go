@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"sync"
 	"time"
 
@@ -27,10 +28,22 @@ type Client struct {
 	transport   *StdioTransport
 	tools       []models.MCPTool
 	serverInfo  models.MCPImplementationInfo
+	serverCaps  models.MCPServerCapabilities
 	initialized bool
 	initTimeout time.Duration
 	callTimeout time.Duration
 	mu          sync.RWMutex
+
+	discoveryRetries int
+	discoveryDelay   time.Duration
+
+	// inFlight counts tools/call requests awaiting a response; draining
+	// rejects new calls during shutdown. Both are guarded by mu.
+	inFlight int
+	draining bool
+
+	// probe holds the result of the last health probe, guarded by mu.
+	probe probeState
 }
 
 // NewClient creates a new MCP client.
@@ -50,6 +63,19 @@ func (c *Client) SetTimeouts(initTimeout, callTimeout time.Duration) {
 	c.callTimeout = callTimeout
 }
 
+// SetDiscoveryRetry sets how many more times tools/list is tried, and how long
+// to wait between attempts, when a server advertising tools lists none.
+func (c *Client) SetDiscoveryRetry(retries int, delay time.Duration) {
+	c.discoveryRetries = retries
+	c.discoveryDelay = delay
+}
+
+// SetTransportLimits sets the stdout message size limit and the number of
+// stderr lines kept. It must be called before Start.
+func (c *Client) SetTransportLimits(maxMessageBytes, stderrLines int) {
+	c.transport.SetLimits(maxMessageBytes, stderrLines)
+}
+
 // Start starts the MCP server and initializes the connection.
 func (c *Client) Start(ctx context.Context, command string, args []string, env map[string]string) error {
 	c.mu.Lock()
@@ -72,6 +98,24 @@ func (c *Client) Start(ctx context.Context, command string, args []string, env m
 		return fmt.Errorf("failed to discover tools: %w", err)
 	}
 
+	// A server may still be registering its tools right after initialize
+	for attempt := 1; attempt <= c.discoveryRetries && len(c.tools) == 0 && c.serverCaps.Tools != nil; attempt++ {
+		select {
+		case <-ctx.Done():
+			c.transport.Stop()
+			return fmt.Errorf("failed to discover tools: %w", ctx.Err())
+		case <-time.After(c.discoveryDelay):
+		}
+
+		if err := c.discoverTools(ctx); err != nil {
+			c.transport.Stop()
+			return fmt.Errorf("failed to discover tools: %w", err)
+		}
+		if len(c.tools) > 0 {
+			fmt.Fprintf(os.Stderr, "MCP server %s listed %d tools after %d discovery retries\n", c.name, len(c.tools), attempt)
+		}
+	}
+
 	c.initialized = true
 	return nil
 }
@@ -114,6 +158,7 @@ func (c *Client) initialize(ctx context.Context) error {
 		}
 
 		c.serverInfo = result.ServerInfo
+		c.serverCaps = result.Capabilities
 
 		// Send initialized notification
 		if err := c.transport.SendNotification("notifications/initialized", nil); err != nil {
@@ -204,80 +249,131 @@ func (c *Client) IsInitialized() bool {
 
 // CallTool executes a tool and returns the result.
 func (c *Client) CallTool(ctx context.Context, name string, arguments json.RawMessage) (*models.MCPToolResult, error) {
-	c.mu.RLock()
+	c.mu.Lock()
 	if !c.initialized {
-		c.mu.RUnlock()
+		c.mu.Unlock()
 		return nil, fmt.Errorf("client not initialized")
 	}
-	c.mu.RUnlock()
+	if c.draining {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("MCP server %s is shutting down", c.name)
+	}
+	c.inFlight++
+	c.mu.Unlock()
 
 	params := models.MCPToolsCallParams{
 		Name:      name,
 		Arguments: arguments,
 	}
 
+	// The call stays in flight until the server answers, even if the caller
+	// has given up waiting. The server runs one request at a time, so the
+	// timeout only counts once this call is sent.
+	response, err := c.sendTimed(ctx, "tools/call", params, c.callTimeout, c.finishCall)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Error != nil {
+		// Return error as tool result, not as Go error
+		// This allows the conversation to continue
+		return &models.MCPToolResult{
+			Content: []models.MCPContent{{
+				Type: "text",
+				Text: fmt.Sprintf("Tool error: %s (code: %d)", response.Error.Message, response.Error.Code),
+			}},
+			IsError: true,
+		}, nil
+	}
+
+	var result models.MCPToolsCallResult
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse tools/call result: %w", err)
+	}
+
+	return &models.MCPToolResult{
+		Content: result.Content,
+		IsError: result.IsError,
+	}, nil
+}
+
+// sendTimed sends a request and waits for its response until ctx is done or
+// timeout passes. The timeout starts once the transport is free to send the
+// request, so time spent queued behind other requests does not count.
+// finished, if set, runs once the response arrives, even if the caller has
+// given up waiting.
+func (c *Client) sendTimed(ctx context.Context, method string, params interface{}, timeout time.Duration, finished func()) (*models.JSONRPCResponse, error) {
+	acquired := make(chan struct{})
 	resultCh := make(chan struct {
-		result *models.MCPToolResult
-		err    error
+		response *models.JSONRPCResponse
+		err      error
 	}, 1)
-
 	go func() {
-		response, err := c.transport.Send("tools/call", params)
-		if err != nil {
-			resultCh <- struct {
-				result *models.MCPToolResult
-				err    error
-			}{nil, fmt.Errorf("tools/call request failed: %w", err)}
-			return
+		if finished != nil {
+			defer finished()
 		}
+		response, err := c.transport.send(method, params, acquired)
+		resultCh <- struct {
+			response *models.JSONRPCResponse
+			err      error
+		}{response, err}
+	}()
 
-		if response.Error != nil {
-			// Return error as tool result, not as Go error
-			// This allows the conversation to continue
-			resultCh <- struct {
-				result *models.MCPToolResult
-				err    error
-			}{
-				&models.MCPToolResult{
-					Content: []models.MCPContent{{
-						Type: "text",
-						Text: fmt.Sprintf("Tool error: %s (code: %d)", response.Error.Message, response.Error.Code),
-					}},
-					IsError: true,
-				},
-				nil,
+	var expired <-chan time.Time
+	for {
+		select {
+		case <-acquired:
+			acquired = nil
+			expired = time.After(timeout)
+		case res := <-resultCh:
+			if res.err != nil {
+				return nil, fmt.Errorf("%s request failed: %w", method, res.err)
 			}
-			return
+			return res.response, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-expired:
+			return nil, fmt.Errorf("%s timeout after %v", method, timeout)
 		}
+	}
+}
 
-		var result models.MCPToolsCallResult
-		if err := json.Unmarshal(response.Result, &result); err != nil {
-			resultCh <- struct {
-				result *models.MCPToolResult
-				err    error
-			}{nil, fmt.Errorf("failed to parse tools/call result: %w", err)}
-			return
-		}
+// finishCall marks a tools/call request as answered.
+func (c *Client) finishCall() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+}
 
-		resultCh <- struct {
-			result *models.MCPToolResult
-			err    error
-		}{
-			&models.MCPToolResult{
-				Content: result.Content,
-				IsError: result.IsError,
-			},
-			nil,
-		}
-	}()
+// InFlight returns the number of tool calls awaiting a server response.
+func (c *Client) InFlight() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.inFlight
+}
 
-	select {
-	case res := <-resultCh:
-		return res.result, res.err
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case <-time.After(c.callTimeout):
-		return nil, fmt.Errorf("tools/call timeout after %v", c.callTimeout)
+// drainPollInterval is how often Drain checks for in-flight calls.
+const drainPollInterval = 10 * time.Millisecond
+
+// Drain rejects new tool calls and waits until in-flight calls finish or ctx
+// is done. It does not close the transport.
+func (c *Client) Drain(ctx context.Context) error {
+	c.mu.Lock()
+	c.draining = true
+	c.mu.Unlock()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		n := c.InFlight()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("MCP server %s: %d tool calls still in flight: %w", c.name, n, ctx.Err())
+		case <-ticker.C:
+		}
 	}
 }
 
@@ -294,6 +390,11 @@ func (c *Client) HasTool(name string) bool {
 	return false
 }
 
+// GetStderrTail returns the most recent stderr lines from the server.
+func (c *Client) GetStderrTail() []string {
+	return c.transport.StderrTail()
+}
+
 // Close closes the client connection.
 func (c *Client) Close() error {
 	c.mu.Lock()
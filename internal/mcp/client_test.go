@@ -0,0 +1,75 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartServer_DiscoveryRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		emptyLists string
+		retries    int
+		wantTools  int
+	}{
+		{name: "no retry", emptyLists: "1", retries: 0, wantTools: 0},
+		{name: "retry recovers tools", emptyLists: "2", retries: 3, wantTools: 2},
+		{name: "retries exhausted", emptyLists: "5", retries: 2, wantTools: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fakeServerConfig("slow", "first", "second")
+			server.Env["FAKE_MCP_EMPTY_LISTS"] = tt.emptyLists
+
+			m := newTestManager(server)
+			m.settings.DiscoveryRetries = tt.retries
+			m.settings.DiscoveryDelayMS = 10
+			defer m.StopAll()
+
+			if err := m.StartServer(context.Background(), "slow"); err != nil {
+				t.Fatalf("StartServer: %v", err)
+			}
+			if got := len(m.GetAllTools()); got != tt.wantTools {
+				t.Errorf("got %d tools, want %d", got, tt.wantTools)
+			}
+		})
+	}
+}
+
+func TestCallTool_QueuedCallsNotTimedOut(t *testing.T) {
+	server := fakeServerConfig("stdio", "slow_tool")
+	server.Env["FAKE_MCP_CALL_DELAY_MS"] = "200"
+
+	m := newTestManager(server)
+	t.Cleanup(func() { m.StopAll() })
+	if err := m.StartAll(context.Background()); err != nil {
+		t.Fatalf("StartAll: %v", err)
+	}
+	// Each call fits the timeout, but not behind the others
+	m.clients["stdio"].callTimeout = 300 * time.Millisecond
+
+	// The server answers one call at a time, so the calls queue on its transport
+	const calls = 4
+	errs := make(chan error, calls)
+	var wg sync.WaitGroup
+	for range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.CallTool(context.Background(), "slow_tool", json.RawMessage(`{}`))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("got error %v, want queued calls timed from when they are sent", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package mcp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// startOrder returns the servers ordered so each comes after the servers it
+// depends on. Servers with no ordering constraint between them keep their
+// config order. It fails on a dependency that names no configured server and
+// on dependency cycles, naming the servers in the cycle.
+func startOrder(servers []models.MCPServerConfig) ([]models.MCPServerConfig, error) {
+	byName := make(map[string]int, len(servers))
+	for i, server := range servers {
+		byName[server.Name] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(servers))
+	order := make([]models.MCPServerConfig, 0, len(servers))
+	var path []string
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case done:
+			return nil
+		case visiting:
+			// path runs from the first server visited; the cycle starts where i was entered
+			start := 0
+			for path[start] != servers[i].Name {
+				start++
+			}
+			cycle := append(append([]string{}, path[start:]...), servers[i].Name)
+			return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[i] = visiting
+		path = append(path, servers[i].Name)
+		for _, dep := range servers[i].DependsOn {
+			j, ok := byName[dep]
+			if !ok {
+				return fmt.Errorf("server %s: depends on unknown server %s", servers[i].Name, dep)
+			}
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = done
+		order = append(order, servers[i])
+		return nil
+	}
+
+	for i := range servers {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// missingDependency returns the first dependency of server that is not
+// running, or "" if all are. Callers must hold m.mu.
+func (m *Manager) missingDependency(server models.MCPServerConfig) string {
+	for _, dep := range server.DependsOn {
+		if _, ok := m.clients[dep]; !ok {
+			return dep
+		}
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package mcp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestStartOrder(t *testing.T) {
+	server := func(name string, deps ...string) models.MCPServerConfig {
+		return models.MCPServerConfig{Name: name, Command: "x", DependsOn: deps}
+	}
+
+	tests := []struct {
+		name    string
+		servers []models.MCPServerConfig
+		want    string
+		wantErr string
+	}{
+		{name: "no dependencies", servers: []models.MCPServerConfig{server("a"), server("b")}, want: "a,b"},
+		{name: "dependency listed later", servers: []models.MCPServerConfig{server("app", "auth"), server("auth")}, want: "auth,app"},
+		{name: "chain", servers: []models.MCPServerConfig{server("c", "b"), server("b", "a"), server("a"), server("d")}, want: "a,b,c,d"},
+		{name: "shared dependency", servers: []models.MCPServerConfig{server("x", "auth"), server("y", "auth"), server("auth")}, want: "auth,x,y"},
+		{name: "unknown dependency", servers: []models.MCPServerConfig{server("a", "ghost")}, wantErr: "server a: depends on unknown server ghost"},
+		{name: "self cycle", servers: []models.MCPServerConfig{server("a", "a")}, wantErr: "dependency cycle: a -> a"},
+		{name: "cycle", servers: []models.MCPServerConfig{server("z"), server("a", "b"), server("b", "c"), server("c", "a")}, wantErr: "dependency cycle: a -> b -> c -> a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := startOrder(tt.servers)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("startOrder: %v", err)
+			}
+			var names []string
+			for _, server := range order {
+				names = append(names, server.Name)
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("got order %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_DependencyCycle(t *testing.T) {
+	config := `{"mcp":{"servers":[
+		{"name":"a","command":"x","enabled":true,"depends_on":["b"]},
+		{"name":"b","command":"x","enabled":true,"depends_on":["a"]}]}}`
+
+	err := NewManager().loadConfigData([]byte(config))
+	if err == nil || !strings.Contains(err.Error(), "dependency cycle: a -> b -> a") {
+		t.Errorf("got error %v, want dependency cycle", err)
+	}
+}
+
+func TestStartAll_Dependencies(t *testing.T) {
+	app := fakeServerConfig("app", "app1")
+	app.DependsOn = []string{"auth"}
+	auth := fakeServerConfig("auth", "auth1")
+
+	m := newTestManager(app, auth)
+	defer m.StopAll()
+	if err := m.StartAll(context.Background()); err != nil {
+		t.Fatalf("StartAll: %v", err)
+	}
+
+	// Tools are registered in start order
+	var names []string
+	for _, tool := range m.GetAllTools() {
+		names = append(names, tool.Name)
+	}
+	if got := strings.Join(names, ","); got != "auth1,app1" {
+		t.Errorf("got tools %s, want auth1,app1", got)
+	}
+}
+
+func TestStartAll_DependencyNotRunning(t *testing.T) {
+	app := fakeServerConfig("app", "app1")
+	app.DependsOn = []string{"auth"}
+	auth := fakeServerConfig("auth", "auth1")
+	auth.Enabled = false
+	other := fakeServerConfig("other", "other1")
+
+	m := newTestManager(app, auth, other)
+	defer m.StopAll()
+	err := m.StartAll(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "server app: dependency auth is not running") {
+		t.Errorf("got error %v, want dependency auth not running", err)
+	}
+
+	clients := m.GetClients()
+	if _, ok := clients["app"]; ok {
+		t.Error("app started without its dependency")
+	}
+	if _, ok := clients["other"]; !ok {
+		t.Error("independent server other did not start")
+	}
+}
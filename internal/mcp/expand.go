@@ -0,0 +1,74 @@
+package mcp
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// ExpandConfigValue expands environment variables in an MCP config value.
+// Besides $VAR and ${VAR}, it supports ${VAR:-default}, which uses default
+// when VAR is unset or empty, and ${VAR:?message}, which fails with message
+// when VAR is unset or empty.
+func ExpandConfigValue(s string) (string, error) {
+	var err error
+	expanded := os.Expand(s, func(expr string) string {
+		name, word, op := splitExpansion(expr)
+		value := os.Getenv(name)
+		if value != "" {
+			return value
+		}
+		switch op {
+		case ":-":
+			return word
+		case ":?":
+			if err == nil {
+				if word == "" {
+					word = "is required"
+				}
+				err = fmt.Errorf("%s: %s", name, word)
+			}
+		}
+		return ""
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+// splitExpansion splits the contents of ${...} into the variable name, the
+// operator (":-", ":?", or ""), and the word after it.
+func splitExpansion(expr string) (name, word, op string) {
+	i := strings.Index(expr, ":")
+	if i < 0 || i+1 >= len(expr) || (expr[i+1] != '-' && expr[i+1] != '?') {
+		return expr, "", ""
+	}
+	return expr[:i], expr[i+2:], expr[i : i+2]
+}
+
+// expandServerConfig expands environment variables in a server's command,
+// args, and env values.
+func expandServerConfig(cfg *models.MCPServerConfig) (string, []string, map[string]string, error) {
+	command, err := ExpandConfigValue(cfg.Command)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("server %s command: %w", cfg.Name, err)
+	}
+
+	args := make([]string, len(cfg.Args))
+	for i, arg := range cfg.Args {
+		if args[i], err = ExpandConfigValue(arg); err != nil {
+			return "", nil, nil, fmt.Errorf("server %s args[%d]: %w", cfg.Name, i, err)
+		}
+	}
+
+	env := make(map[string]string, len(cfg.Env))
+	for k, v := range cfg.Env {
+		if env[k], err = ExpandConfigValue(v); err != nil {
+			return "", nil, nil, fmt.Errorf("server %s env %s: %w", cfg.Name, k, err)
+		}
+	}
+	return command, args, env, nil
+}
@@ -0,0 +1,69 @@
+package mcp
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExpandConfigValue(t *testing.T) {
+	t.Setenv("CLAUDEX_TEST_SET", "value")
+	t.Setenv("CLAUDEX_TEST_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr string
+	}{
+		{name: "plain", in: "no vars", want: "no vars"},
+		{name: "dollar", in: "$CLAUDEX_TEST_SET/x", want: "value/x"},
+		{name: "braces", in: "${CLAUDEX_TEST_SET}", want: "value"},
+		{name: "unset", in: "[${CLAUDEX_TEST_UNSET}]", want: "[]"},
+		{name: "default unused", in: "${CLAUDEX_TEST_SET:-other}", want: "value"},
+		{name: "default for unset", in: "${CLAUDEX_TEST_UNSET:-http://localhost:8080}", want: "http://localhost:8080"},
+		{name: "default for empty", in: "${CLAUDEX_TEST_EMPTY:-fallback}", want: "fallback"},
+		{name: "required set", in: "${CLAUDEX_TEST_SET:?must be set}", want: "value"},
+		{name: "required unset", in: "key=${CLAUDEX_TEST_UNSET:?API key missing}", wantErr: "CLAUDEX_TEST_UNSET: API key missing"},
+		{name: "required empty", in: "${CLAUDEX_TEST_EMPTY:?}", wantErr: "CLAUDEX_TEST_EMPTY: is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandConfigValue(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartAll_RequiredVariableMissing(t *testing.T) {
+	missing := fakeServerConfig("missing", "tool_a")
+	missing.Env["FAKE_MCP_TOOLS"] = "${CLAUDEX_TEST_TOOLS_UNSET:?tool list not configured}"
+	defaulted := fakeServerConfig("defaulted")
+	defaulted.Env["FAKE_MCP_TOOLS"] = "${CLAUDEX_TEST_TOOLS_UNSET:-tool_b}"
+
+	m := newTestManager(missing, defaulted)
+	t.Cleanup(func() { m.StopAll() })
+
+	err := m.StartAll(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "server missing env FAKE_MCP_TOOLS: CLAUDEX_TEST_TOOLS_UNSET: tool list not configured") {
+		t.Fatalf("got error %v, want the missing variable reported", err)
+	}
+	if _, running := m.GetClients()["missing"]; running {
+		t.Error("server with a missing required variable was started")
+	}
+	if !m.IsToolAvailable("tool_b") {
+		t.Error("got tool_b unavailable, want the defaulted server started")
+	}
+}
@@ -0,0 +1,119 @@
+package mcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// TestHelperMCPServer is not a real test. It runs as a fake stdio MCP server
+// when GO_WANT_HELPER_MCP_SERVER=1, exposing the comma-separated tools in
+// FAKE_MCP_TOOLS. Calling a tool echoes its arguments as text. Lines in
+// FAKE_MCP_STDERR (separated by "|") are written to stderr at startup. The
+// first FAKE_MCP_EMPTY_LISTS tools/list calls return no tools. Tool calls
+// take FAKE_MCP_CALL_DELAY_MS milliseconds and prefix their result with
+// FAKE_MCP_RESULT_PREFIX. With FAKE_MCP_PING_HANG=1, pings are never answered.
+func TestHelperMCPServer(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_MCP_SERVER") != "1" {
+		return
+	}
+	runFakeMCPServer()
+	os.Exit(0)
+}
+
+// fakeServerConfig returns a server config that runs the fake MCP server.
+func fakeServerConfig(name string, tools ...string) models.MCPServerConfig {
+	return models.MCPServerConfig{
+		Name:    name,
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^TestHelperMCPServer$"},
+		Env: map[string]string{
+			"GO_WANT_HELPER_MCP_SERVER": "1",
+			"FAKE_MCP_TOOLS":            strings.Join(tools, ","),
+		},
+		Enabled: true,
+	}
+}
+
+// newTestManager returns a manager configured with the given servers.
+func newTestManager(servers ...models.MCPServerConfig) *Manager {
+	m := NewManager()
+	m.config = &models.MCPConfig{MCP: models.MCPSection{Settings: m.settings, Servers: servers}}
+	return m
+}
+
+func runFakeMCPServer() {
+	var tools []models.MCPTool
+	for _, name := range strings.Split(os.Getenv("FAKE_MCP_TOOLS"), ",") {
+		if name != "" {
+			tools = append(tools, models.MCPTool{Name: name, InputSchema: json.RawMessage(`{"type":"object"}`)})
+		}
+	}
+
+	if lines := os.Getenv("FAKE_MCP_STDERR"); lines != "" {
+		for _, line := range strings.Split(lines, "|") {
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}
+
+	emptyLists, _ := strconv.Atoi(os.Getenv("FAKE_MCP_EMPTY_LISTS"))
+	callDelay, _ := strconv.Atoi(os.Getenv("FAKE_MCP_CALL_DELAY_MS"))
+	resultPrefix := os.Getenv("FAKE_MCP_RESULT_PREFIX")
+	pingHang := os.Getenv("FAKE_MCP_PING_HANG") == "1"
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	out := bufio.NewWriter(os.Stdout)
+
+	for scanner.Scan() {
+		var req struct {
+			ID     *int            `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil || req.ID == nil {
+			continue // Notifications have no ID
+		}
+
+		if req.Method == "ping" && pingHang {
+			continue
+		}
+
+		var result any
+		switch req.Method {
+		case "initialize":
+			result = models.MCPInitializeResult{
+				ProtocolVersion: MCPProtocolVersion,
+				Capabilities:    models.MCPServerCapabilities{Tools: &models.MCPToolsCapability{}},
+				ServerInfo:      models.MCPImplementationInfo{Name: "fake", Version: "0.0.1"},
+			}
+		case "tools/list":
+			if emptyLists > 0 {
+				emptyLists--
+				result = models.MCPToolsListResult{Tools: []models.MCPTool{}}
+				break
+			}
+			result = models.MCPToolsListResult{Tools: tools}
+		case "tools/call":
+			time.Sleep(time.Duration(callDelay) * time.Millisecond)
+			var params models.MCPToolsCallParams
+			_ = json.Unmarshal(req.Params, &params)
+			result = models.MCPToolsCallResult{
+				Content: []models.MCPContent{{Type: "text", Text: resultPrefix + string(params.Arguments)}},
+			}
+		default:
+			result = map[string]any{}
+		}
+
+		data, _ := json.Marshal(result)
+		fmt.Fprintf(out, `{"jsonrpc":"2.0","id":%d,"result":%s}`+"\n", *req.ID, data)
+		out.Flush()
+	}
+}
@@ -0,0 +1,62 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// LocalServerName is the server name reported for in-process tools.
+const LocalServerName = "local"
+
+// LocalToolHandler executes an in-process tool with the raw JSON arguments
+// supplied by the model.
+type LocalToolHandler func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error)
+
+// RegisterLocalTool registers a Go-native tool that runs in-process instead of
+// through an MCP server. It is listed by GetAllTools and routed by CallTool
+// like any discovered tool, and survives StopAll.
+func (m *Manager) RegisterLocalTool(name string, schema json.RawMessage, handler LocalToolHandler) error {
+	if name == "" {
+		return fmt.Errorf("local tool name is required")
+	}
+	if handler == nil {
+		return fmt.Errorf("local tool %s has no handler", name)
+	}
+	if len(schema) == 0 {
+		schema = json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	if !json.Valid(schema) {
+		return fmt.Errorf("local tool %s has an invalid input schema", name)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.toolToClient[name]; exists {
+		return fmt.Errorf("tool %s is already registered", name)
+	}
+
+	m.localHandlers[name] = handler
+	m.tools = append(m.tools, models.MCPTool{
+		Name:        name,
+		InputSchema: schema,
+		ServerName:  LocalServerName,
+	})
+	m.toolToClient[name] = LocalServerName
+
+	return nil
+}
+
+// localTools returns the registered in-process tools. Callers must hold m.mu.
+func (m *Manager) localTools() []models.MCPTool {
+	tools := []models.MCPTool{}
+	for _, tool := range m.tools {
+		if tool.ServerName == LocalServerName {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
@@ -0,0 +1,85 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestRegisterLocalTool(t *testing.T) {
+	m := newTestManager(fakeServerConfig("remote", "remote_echo"))
+	defer m.StopAll()
+
+	schema := json.RawMessage(`{"type":"object","properties":{"a":{"type":"number"},"b":{"type":"number"}}}`)
+	err := m.RegisterLocalTool("add", schema, func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		var args struct{ A, B float64 }
+		if err := json.Unmarshal(arguments, &args); err != nil {
+			return nil, err
+		}
+		sum, _ := json.Marshal(args.A + args.B)
+		return &models.MCPToolResult{Content: []models.MCPContent{{Type: "text", Text: string(sum)}}}, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	if err := m.StartServer(context.Background(), "remote"); err != nil {
+		t.Fatalf("StartServer: %v", err)
+	}
+
+	names := map[string]string{}
+	for _, tool := range m.GetAllTools() {
+		names[tool.Name] = tool.ServerName
+	}
+	if names["add"] != LocalServerName || names["remote_echo"] != "remote" {
+		t.Errorf("got tools %v, want local 'add' alongside 'remote_echo'", names)
+	}
+
+	result, err := m.CallTool(context.Background(), "add", json.RawMessage(`{"a":2,"b":3}`))
+	if err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+	if got := result.GetTextContent(); got != "5" {
+		t.Errorf("got result %q, want %q", got, "5")
+	}
+
+	if err := m.StopAll(); err != nil {
+		t.Fatalf("StopAll: %v", err)
+	}
+	if !m.IsToolAvailable("add") || m.IsToolAvailable("remote_echo") {
+		t.Error("got wrong tools after StopAll, want only the local tool")
+	}
+}
+
+func TestRegisterLocalTool_Errors(t *testing.T) {
+	noop := func(ctx context.Context, arguments json.RawMessage) (*models.MCPToolResult, error) {
+		return &models.MCPToolResult{}, nil
+	}
+
+	m := NewManager()
+	if err := m.RegisterLocalTool("dup", nil, noop); err != nil {
+		t.Fatalf("RegisterLocalTool: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		tool    string
+		schema  json.RawMessage
+		handler LocalToolHandler
+	}{
+		{name: "empty name", tool: "", handler: noop},
+		{name: "nil handler", tool: "nohandler"},
+		{name: "invalid schema", tool: "bad", schema: json.RawMessage(`{`), handler: noop},
+		{name: "duplicate", tool: "dup", handler: noop},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.RegisterLocalTool(tt.tool, tt.schema, tt.handler); err == nil {
+				t.Error("got nil error, want error")
+			}
+		})
+	}
+}
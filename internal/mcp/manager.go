@@ -3,8 +3,10 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,6 +22,8 @@ type Manager struct {
 	toolToClient map[string]string // tool name -> client name
 	config      *models.MCPConfig
 	settings    models.MCPSettings
+	restarts    map[string]*restartTracker
+	localHandlers map[string]LocalToolHandler
 	mu          sync.RWMutex
 }
 
@@ -30,11 +34,17 @@ func NewManager() *Manager {
 		tools:        []models.MCPTool{},
 		toolToClient: make(map[string]string),
 		settings: models.MCPSettings{
-			InitTimeout: 30,
-			CallTimeout: 60,
-			AutoRestart: true,
-			MaxRestarts: 3,
+			InitTimeout:       30,
+			CallTimeout:       60,
+			AutoRestart:       true,
+			MaxRestarts:       3,
+			RestartWindow:     600,
+			RestartResetAfter: 300,
+			DiscoveryDelayMS:  500,
+			ProbeTimeout:      10,
 		},
+		restarts:      make(map[string]*restartTracker),
+		localHandlers: make(map[string]LocalToolHandler),
 	}
 }
 
@@ -45,9 +55,21 @@ func (m *Manager) LoadConfig(path string) error {
 		return fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	if err := m.loadConfigData(data); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return nil
+}
+
+// loadConfigData parses, validates, and applies a YAML or JSON config body.
+func (m *Manager) loadConfigData(data []byte) error {
 	var config models.MCPConfig
 	if err := yaml.Unmarshal(data, &config); err != nil {
-		return fmt.Errorf("failed to parse config file: %w", err)
+		return err
+	}
+
+	if err := validateConfig(&config); err != nil {
+		return err
 	}
 
 	m.mu.Lock()
@@ -66,12 +88,60 @@ func (m *Manager) LoadConfig(path string) error {
 	if m.settings.MaxRestarts <= 0 {
 		m.settings.MaxRestarts = 3
 	}
+	if m.settings.RestartWindow <= 0 {
+		m.settings.RestartWindow = 600
+	}
+	if m.settings.RestartResetAfter <= 0 {
+		m.settings.RestartResetAfter = 300
+	}
+	if m.settings.DiscoveryDelayMS <= 0 {
+		m.settings.DiscoveryDelayMS = 500
+	}
+	if m.settings.ProbeTimeout <= 0 {
+		m.settings.ProbeTimeout = 10
+	}
+	m.restarts = make(map[string]*restartTracker)
 
 	return nil
 }
 
-// LoadConfigFromEnv loads MCP configuration from environment variable.
+// validateConfig checks that every server has a unique name and a command,
+// and that server dependencies name configured servers without cycles.
+func validateConfig(config *models.MCPConfig) error {
+	seen := make(map[string]bool)
+	for i, server := range config.MCP.Servers {
+		if server.Name == "" {
+			return fmt.Errorf("servers[%d]: name is required", i)
+		}
+		if server.Name == LocalServerName {
+			return fmt.Errorf("server %s: name is reserved for in-process tools", server.Name)
+		}
+		if server.Command == "" {
+			return fmt.Errorf("server %s: command is required", server.Name)
+		}
+		if seen[server.Name] {
+			return fmt.Errorf("server %s: duplicate name", server.Name)
+		}
+		seen[server.Name] = true
+	}
+	if _, err := startOrder(config.MCP.Servers); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LoadConfigFromEnv loads MCP configuration from environment variables.
+// CLAUDEX_MCP_CONFIG holds an inline YAML/JSON config body and takes
+// precedence over CLAUDEX_MCP_CONFIG_PATH and the default file locations.
 func (m *Manager) LoadConfigFromEnv() error {
+	if inline := os.Getenv("CLAUDEX_MCP_CONFIG"); inline != "" {
+		if err := m.loadConfigData([]byte(inline)); err != nil {
+			return fmt.Errorf("failed to parse CLAUDEX_MCP_CONFIG: %w", err)
+		}
+		m.applyEnvOverrides()
+		return nil
+	}
+
 	configPath := os.Getenv("CLAUDEX_MCP_CONFIG_PATH")
 	if configPath == "" {
 		// Try default locations
@@ -93,7 +163,61 @@ func (m *Manager) LoadConfigFromEnv() error {
 		return nil
 	}
 
-	return m.LoadConfig(configPath)
+	if err := m.LoadConfig(configPath); err != nil {
+		return err
+	}
+	m.applyEnvOverrides()
+	return nil
+}
+
+// applyEnvOverrides flips the Enabled flag of servers named in
+// CLAUDEX_MCP_ENABLE and CLAUDEX_MCP_DISABLE (comma-separated lists).
+// Environment overrides take precedence over the config file, and a server
+// listed in both is disabled.
+func (m *Manager) applyEnvOverrides() {
+	enable := parseServerList(os.Getenv("CLAUDEX_MCP_ENABLE"))
+	disable := parseServerList(os.Getenv("CLAUDEX_MCP_DISABLE"))
+	if len(enable) == 0 && len(disable) == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.config == nil {
+		return
+	}
+
+	known := make(map[string]bool)
+	for i := range m.config.MCP.Servers {
+		server := &m.config.MCP.Servers[i]
+		known[server.Name] = true
+		if enable[server.Name] {
+			server.Enabled = true
+		}
+		if disable[server.Name] {
+			server.Enabled = false
+		}
+	}
+
+	for _, list := range []map[string]bool{enable, disable} {
+		for name := range list {
+			if !known[name] {
+				fmt.Fprintf(os.Stderr, "MCP override references unknown server %s\n", name)
+			}
+		}
+	}
+}
+
+// parseServerList parses a comma-separated list of server names into a set.
+func parseServerList(val string) map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range strings.Split(val, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			set[name] = true
+		}
+	}
+	return set
 }
 
 // StartAll starts all enabled MCP servers.
@@ -105,29 +229,45 @@ func (m *Manager) StartAll(ctx context.Context) error {
 		return nil // No config loaded, nothing to start
 	}
 
-	for _, serverConfig := range m.config.MCP.Servers {
+	// Dependencies start before the servers that need them
+	servers, err := startOrder(m.config.MCP.Servers)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	started := 0
+	for _, serverConfig := range servers {
 		if !serverConfig.Enabled {
 			continue
 		}
 
+		if dep := m.missingDependency(serverConfig); dep != "" {
+			errs = append(errs, fmt.Errorf("server %s: dependency %s is not running", serverConfig.Name, dep))
+			continue
+		}
+
+		// Caps guard against a runaway config exhausting processes or the prompt budget
+		if m.settings.MaxServers > 0 && started >= m.settings.MaxServers {
+			fmt.Fprintf(os.Stderr, "Skipping MCP server %s: max_servers limit of %d reached\n", serverConfig.Name, m.settings.MaxServers)
+			continue
+		}
+
+		// Expand environment variables in command, args, and env
+		command, args, env, err := expandServerConfig(&serverConfig)
+		if err != nil {
+			// A missing required variable must not start a misconfigured server
+			errs = append(errs, err)
+			continue
+		}
+
 		client := NewClient(serverConfig.Name)
 		client.SetTimeouts(
 			time.Duration(m.settings.InitTimeout)*time.Second,
 			time.Duration(m.settings.CallTimeout)*time.Second,
 		)
-
-		// Expand environment variables in command and args
-		command := os.ExpandEnv(serverConfig.Command)
-		args := make([]string, len(serverConfig.Args))
-		for i, arg := range serverConfig.Args {
-			args[i] = os.ExpandEnv(arg)
-		}
-
-		// Expand environment variables in env map
-		env := make(map[string]string)
-		for k, v := range serverConfig.Env {
-			env[k] = os.ExpandEnv(v)
-		}
+		client.SetTransportLimits(serverConfig.MaxMessageBytes, serverConfig.StderrLines)
+		client.SetDiscoveryRetry(m.settings.DiscoveryRetries, time.Duration(m.settings.DiscoveryDelayMS)*time.Millisecond)
 
 		if err := client.Start(ctx, command, args, env); err != nil {
 			// Log error but continue with other servers
@@ -136,15 +276,13 @@ func (m *Manager) StartAll(ctx context.Context) error {
 		}
 
 		m.clients[serverConfig.Name] = client
+		started++
 
 		// Aggregate tools from this client
-		for _, tool := range client.GetTools() {
-			m.tools = append(m.tools, tool)
-			m.toolToClient[tool.Name] = serverConfig.Name
-		}
+		m.addTools(serverConfig.Name, client.GetTools())
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
 // StartServer starts a specific MCP server by name.
@@ -179,16 +317,12 @@ func (m *Manager) StartServer(ctx context.Context, name string) error {
 		time.Duration(m.settings.InitTimeout)*time.Second,
 		time.Duration(m.settings.CallTimeout)*time.Second,
 	)
+	client.SetTransportLimits(serverConfig.MaxMessageBytes, serverConfig.StderrLines)
+	client.SetDiscoveryRetry(m.settings.DiscoveryRetries, time.Duration(m.settings.DiscoveryDelayMS)*time.Millisecond)
 
-	command := os.ExpandEnv(serverConfig.Command)
-	args := make([]string, len(serverConfig.Args))
-	for i, arg := range serverConfig.Args {
-		args[i] = os.ExpandEnv(arg)
-	}
-
-	env := make(map[string]string)
-	for k, v := range serverConfig.Env {
-		env[k] = os.ExpandEnv(v)
+	command, args, env, err := expandServerConfig(serverConfig)
+	if err != nil {
+		return err
 	}
 
 	if err := client.Start(ctx, command, args, env); err != nil {
@@ -196,14 +330,101 @@ func (m *Manager) StartServer(ctx context.Context, name string) error {
 	}
 
 	m.clients[name] = client
+	m.trackerFor(name).healthy(time.Now(), true)
 
 	// Add tools from this client
-	for _, tool := range client.GetTools() {
+	m.addTools(name, client.GetTools())
+
+	return nil
+}
+
+// addTools registers a server's tools, skipping any beyond the max_tools cap.
+// Callers must hold m.mu.
+func (m *Manager) addTools(server string, tools []models.MCPTool) {
+	if limit := m.settings.MaxTools; limit > 0 && len(m.tools)+len(tools) > limit {
+		kept := max(limit-len(m.tools), 0)
+		fmt.Fprintf(os.Stderr, "Skipping %d tools from MCP server %s: max_tools limit of %d reached\n", len(tools)-kept, server, limit)
+		tools = tools[:kept]
+	}
+	for _, tool := range tools {
 		m.tools = append(m.tools, tool)
-		m.toolToClient[tool.Name] = name
+		m.toolToClient[tool.Name] = server
 	}
+}
 
-	return nil
+// RestartServer stops and starts a server by name. It refuses once the server
+// has been restarted MaxRestarts times within RestartWindow.
+func (m *Manager) RestartServer(ctx context.Context, name string) error {
+	if !m.allowRestart(name, time.Now()) {
+		return fmt.Errorf("server %s exceeded %d restarts within %ds", name, m.settings.MaxRestarts, m.settings.RestartWindow)
+	}
+
+	// A crashed server may already be gone, so a failed stop is not fatal
+	_ = m.StopServer(name)
+
+	return m.StartServer(ctx, name)
+}
+
+// allowRestart records a restart attempt for a server and reports whether it
+// is within the configured restart limit.
+func (m *Manager) allowRestart(name string, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.trackerFor(name).allow(now)
+}
+
+// recordHealth tells a server's restart tracker whether it was healthy at
+// now, which starts or ends the healthy period that resets its restarts.
+func (m *Manager) recordHealth(name string, now time.Time, healthy bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.trackerFor(name).healthy(now, healthy)
+}
+
+// trackerFor returns a server's restart tracker, creating it if needed.
+// Callers must hold m.mu.
+func (m *Manager) trackerFor(name string) *restartTracker {
+	tracker, exists := m.restarts[name]
+	if !exists {
+		tracker = newRestartTracker(m.settings)
+		m.restarts[name] = tracker
+	}
+	return tracker
+}
+
+// Shutdown stops all MCP servers after draining them: new tool calls are
+// rejected and in-flight calls are given until ctx is done to finish before
+// transports are closed. Servers with calls still running at the deadline
+// are killed.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.RLock()
+	clients := make([]*Client, 0, len(m.clients))
+	for _, client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(clients))
+	for i, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = client.Drain(ctx)
+		}()
+	}
+	wg.Wait()
+
+	// Calls still running at the deadline would block Stop; abandon them
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v; killing server\n", err)
+			clients[i].transport.Kill()
+		}
+	}
+
+	return errors.Join(append(errs, m.StopAll())...)
 }
 
 // StopAll stops all running MCP servers.
@@ -219,9 +440,13 @@ func (m *Manager) StopAll() error {
 		}
 	}
 
+	// Keep in-process tools; they don't depend on any server
 	m.clients = make(map[string]*Client)
-	m.tools = []models.MCPTool{}
+	m.tools = m.localTools()
 	m.toolToClient = make(map[string]string)
+	for _, tool := range m.tools {
+		m.toolToClient[tool.Name] = LocalServerName
+	}
 
 	return lastErr
 }
@@ -263,11 +488,23 @@ func (m *Manager) GetAllTools() []models.MCPTool {
 	return m.tools
 }
 
-// GetToolsAsOpenAI returns all MCP tools in OpenAI tool format.
+// GetToolsAsOpenAI returns all MCP tools in OpenAI tool format. With
+// hide_unhealthy_tools, tools of servers that are down or failed their last
+// health probe are left out so the model is not offered them.
 func (m *Manager) GetToolsAsOpenAI() []models.Tool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return models.ToOpenAITools(m.tools)
+	if !m.settings.HideUnhealthyTools {
+		return models.ToOpenAITools(m.tools)
+	}
+
+	tools := make([]models.MCPTool, 0, len(m.tools))
+	for _, tool := range m.tools {
+		if m.serverHealthy(m.toolToClient[tool.Name]) {
+			tools = append(tools, tool)
+		}
+	}
+	return models.ToOpenAITools(tools)
 }
 
 // HasTools returns whether any MCP tools are available.
@@ -299,6 +536,12 @@ func (m *Manager) CallTool(ctx context.Context, name string, arguments json.RawM
 		return nil, fmt.Errorf("tool %s not found", name)
 	}
 
+	if clientName == LocalServerName {
+		handler := m.localHandlers[name]
+		m.mu.RUnlock()
+		return handler(ctx, arguments)
+	}
+
 	client, clientExists := m.clients[clientName]
 	m.mu.RUnlock()
 
@@ -309,6 +552,57 @@ func (m *Manager) CallTool(ctx context.Context, name string, arguments json.RawM
 	return client.CallTool(ctx, name, arguments)
 }
 
+// ServerForTool returns the name of the server that runs a tool, or
+// LocalServerName for in-process tools. It returns false if no server has it.
+func (m *Manager) ServerForTool(name string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	server, ok := m.toolToClient[name]
+	return server, ok
+}
+
+// GetServerTools returns the tools of a single server, including tools whose
+// names collide with another server's. The in-process tools are listed under
+// LocalServerName. It returns false if the server is not running.
+func (m *Manager) GetServerTools(server string) ([]models.MCPTool, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if server == LocalServerName {
+		return m.localTools(), true
+	}
+	client, exists := m.clients[server]
+	if !exists {
+		return nil, false
+	}
+	return client.GetTools(), true
+}
+
+// CallToolOnServer executes a tool on a specific server, bypassing routing
+// by tool name.
+func (m *Manager) CallToolOnServer(ctx context.Context, server, name string, arguments json.RawMessage) (*models.MCPToolResult, error) {
+	m.mu.RLock()
+	if server == LocalServerName {
+		handler, exists := m.localHandlers[name]
+		m.mu.RUnlock()
+		if !exists {
+			return nil, fmt.Errorf("tool %s not found on server %s", name, server)
+		}
+		return handler(ctx, arguments)
+	}
+
+	client, exists := m.clients[server]
+	m.mu.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("server %s is not running", server)
+	}
+	if !client.HasTool(name) {
+		return nil, fmt.Errorf("tool %s not found on server %s", name, server)
+	}
+	return client.CallTool(ctx, name, arguments)
+}
+
 // GetClientCount returns the number of connected MCP clients.
 func (m *Manager) GetClientCount() int {
 	m.mu.RLock()
@@ -316,14 +610,20 @@ func (m *Manager) GetClientCount() int {
 	return len(m.clients)
 }
 
-// GetClients returns information about all connected clients.
-func (m *Manager) GetClients() map[string]models.MCPImplementationInfo {
+// GetClients returns information about all connected clients, including any
+// captured stderr lines and the result of the last health probe.
+func (m *Manager) GetClients() map[string]models.MCPServerStatus {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	result := make(map[string]models.MCPImplementationInfo)
+	result := make(map[string]models.MCPServerStatus)
 	for name, client := range m.clients {
-		result[name] = client.GetServerInfo()
+		status := models.MCPServerStatus{
+			MCPImplementationInfo: client.GetServerInfo(),
+			Stderr:                client.GetStderrTail(),
+		}
+		client.probeStatus(&status)
+		result[name] = status
 	}
 	return result
 }
@@ -0,0 +1,219 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestLoadConfigFromEnv_Inline(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{
+			name: "yaml",
+			config: `
+mcp:
+  settings:
+    call_timeout: 15
+  servers:
+    - name: tools
+      command: /bin/tools
+      enabled: true
+    - name: other
+      command: /bin/other
+`,
+		},
+		{
+			name:   "json",
+			config: `{"mcp":{"settings":{"call_timeout":15},"servers":[{"name":"tools","command":"/bin/tools","enabled":true},{"name":"other","command":"/bin/other"}]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_MCP_CONFIG", tt.config)
+			t.Setenv("CLAUDEX_MCP_CONFIG_PATH", "/nonexistent/claudex.yaml")
+
+			m := NewManager()
+			if err := m.LoadConfigFromEnv(); err != nil {
+				t.Fatalf("LoadConfigFromEnv: %v", err)
+			}
+
+			if got := len(m.config.MCP.Servers); got != 2 {
+				t.Fatalf("got %d servers, want 2", got)
+			}
+			if m.config.MCP.Servers[0].Name != "tools" || !m.config.MCP.Servers[0].Enabled {
+				t.Errorf("got first server %+v, want enabled 'tools'", m.config.MCP.Servers[0])
+			}
+			if m.settings.CallTimeout != 15 {
+				t.Errorf("got call timeout %d, want 15", m.settings.CallTimeout)
+			}
+			if m.settings.InitTimeout != 30 {
+				t.Errorf("got init timeout %d, want default 30", m.settings.InitTimeout)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFromEnv_InlineErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{name: "malformed", config: "mcp: [unclosed", wantErr: "failed to parse CLAUDEX_MCP_CONFIG"},
+		{name: "missing command", config: `{"mcp":{"servers":[{"name":"tools"}]}}`, wantErr: "server tools: command is required"},
+		{name: "missing name", config: `{"mcp":{"servers":[{"command":"/bin/tools"}]}}`, wantErr: "servers[0]: name is required"},
+		{name: "duplicate name", config: `{"mcp":{"servers":[{"name":"a","command":"x"},{"name":"a","command":"y"}]}}`, wantErr: "server a: duplicate name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLAUDEX_MCP_CONFIG", tt.config)
+
+			err := NewManager().LoadConfigFromEnv()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyEnvOverrides_DisabledServerDoesNotStart(t *testing.T) {
+	disabled := fakeServerConfig("disabled-by-env", "tool_b")
+	enabled := fakeServerConfig("enabled-by-env", "tool_c")
+	enabled.Enabled = false
+	config, err := json.Marshal(models.MCPConfig{MCP: models.MCPSection{Servers: []models.MCPServerConfig{
+		fakeServerConfig("kept", "tool_a"),
+		disabled,
+		enabled,
+	}}})
+	if err != nil {
+		t.Fatalf("marshal config: %v", err)
+	}
+
+	t.Setenv("CLAUDEX_MCP_CONFIG", string(config))
+	t.Setenv("CLAUDEX_MCP_DISABLE", "disabled-by-env")
+	t.Setenv("CLAUDEX_MCP_ENABLE", "enabled-by-env")
+
+	m := NewManager()
+	if err := m.LoadConfigFromEnv(); err != nil {
+		t.Fatalf("LoadConfigFromEnv: %v", err)
+	}
+	if err := m.StartAll(context.Background()); err != nil {
+		t.Fatalf("StartAll: %v", err)
+	}
+	defer m.StopAll()
+
+	clients := m.GetClients()
+	if _, ok := clients["disabled-by-env"]; ok {
+		t.Errorf("env-disabled server was started")
+	}
+	for _, name := range []string{"kept", "enabled-by-env"} {
+		if _, ok := clients[name]; !ok {
+			t.Errorf("server %s was not started", name)
+		}
+	}
+	if m.IsToolAvailable("tool_b") {
+		t.Errorf("tool from env-disabled server is available")
+	}
+}
+
+func TestApplyEnvOverrides_DisableWins(t *testing.T) {
+	t.Setenv("CLAUDEX_MCP_ENABLE", "a")
+	t.Setenv("CLAUDEX_MCP_DISABLE", "a")
+
+	m := newTestManager(fakeServerConfig("a"))
+	m.applyEnvOverrides()
+
+	if m.config.MCP.Servers[0].Enabled {
+		t.Errorf("server listed in both ENABLE and DISABLE should be disabled")
+	}
+}
+
+func TestCallToolOnServer(t *testing.T) {
+	first := fakeServerConfig("first", "echo", "only_first")
+	first.Env["FAKE_MCP_RESULT_PREFIX"] = "first:"
+	second := fakeServerConfig("second", "echo")
+	second.Env["FAKE_MCP_RESULT_PREFIX"] = "second:"
+
+	m := newTestManager(first, second)
+	defer m.StopAll()
+	if err := m.StartAll(context.Background()); err != nil {
+		t.Fatalf("StartAll: %v", err)
+	}
+
+	// Both servers expose "echo"; each can be called explicitly
+	for _, server := range []string{"first", "second"} {
+		result, err := m.CallToolOnServer(context.Background(), server, "echo", json.RawMessage(`{"x":1}`))
+		if err != nil {
+			t.Fatalf("CallToolOnServer(%s): %v", server, err)
+		}
+		if got, want := result.GetTextContent(), server+`:{"x":1}`; got != want {
+			t.Errorf("server %s: got result %q, want %q", server, got, want)
+		}
+	}
+
+	tools, ok := m.GetServerTools("second")
+	if !ok || len(tools) != 1 || tools[0].Name != "echo" {
+		t.Errorf("got tools %v (ok=%v), want only echo", tools, ok)
+	}
+
+	if _, err := m.CallToolOnServer(context.Background(), "second", "only_first", nil); err == nil {
+		t.Error("calling a tool the server does not have: got nil error")
+	}
+	if _, err := m.CallToolOnServer(context.Background(), "missing", "echo", nil); err == nil {
+		t.Error("calling a server that is not running: got nil error")
+	}
+	if _, ok := m.GetServerTools("missing"); ok {
+		t.Error("got tools for a server that is not running")
+	}
+}
+
+func TestStartAll_Limits(t *testing.T) {
+	servers := []models.MCPServerConfig{
+		fakeServerConfig("a", "a1", "a2"),
+		fakeServerConfig("b", "b1", "b2"),
+		fakeServerConfig("c", "c1"),
+	}
+
+	tests := []struct {
+		name        string
+		maxServers  int
+		maxTools    int
+		wantServers int
+		wantTools   []string
+	}{
+		{name: "unlimited", wantServers: 3, wantTools: []string{"a1", "a2", "b1", "b2", "c1"}},
+		{name: "max servers", maxServers: 2, wantServers: 2, wantTools: []string{"a1", "a2", "b1", "b2"}},
+		{name: "max tools", maxTools: 3, wantServers: 3, wantTools: []string{"a1", "a2", "b1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(servers...)
+			m.settings.MaxServers = tt.maxServers
+			m.settings.MaxTools = tt.maxTools
+			defer m.StopAll()
+			if err := m.StartAll(context.Background()); err != nil {
+				t.Fatalf("StartAll: %v", err)
+			}
+
+			if got := len(m.GetClients()); got != tt.wantServers {
+				t.Errorf("got %d servers, want %d", got, tt.wantServers)
+			}
+			var names []string
+			for _, tool := range m.GetAllTools() {
+				names = append(names, tool.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantTools, ",") {
+				t.Errorf("got tools %v, want %v", names, tt.wantTools)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// probeState is the result of a client's last health probe.
+type probeState struct {
+	at      time.Time
+	err     error
+	pending bool // a ping is still waiting for its response
+}
+
+// Ping sends an MCP ping and waits up to timeout for the response, counted
+// from when the transport is free to send it. The transport runs one request
+// at a time, so a server busy with a tool call is counted alive without a
+// ping, unless the call has run longer than the call timeout. A server that
+// has not answered a previous ping is reported unhealthy without sending
+// another, since its transport is still busy with the first.
+func (c *Client) Ping(ctx context.Context, timeout time.Duration) error {
+	if active := c.transport.current(); active != nil && active.method == "tools/call" {
+		if busy := time.Since(active.since); busy > c.callTimeout {
+			return fmt.Errorf("tools/call unanswered for %v", busy.Round(time.Second))
+		}
+		return nil
+	}
+
+	c.mu.Lock()
+	if c.probe.pending {
+		c.mu.Unlock()
+		return fmt.Errorf("previous ping still unanswered")
+	}
+	c.probe.pending = true
+	c.mu.Unlock()
+
+	response, err := c.sendTimed(ctx, "ping", nil, timeout, func() {
+		c.mu.Lock()
+		c.probe.pending = false
+		c.mu.Unlock()
+	})
+	if err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return fmt.Errorf("ping error: %s (code: %d)", response.Error.Message, response.Error.Code)
+	}
+	return nil
+}
+
+// recordProbe stores the result of a health probe.
+func (c *Client) recordProbe(at time.Time, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probe.at = at
+	c.probe.err = err
+}
+
+// probeStatus adds the last probe result to status, if the client was probed.
+func (c *Client) probeStatus(status *models.MCPServerStatus) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.probe.at.IsZero() {
+		return
+	}
+	at := c.probe.at
+	healthy := c.probe.err == nil
+	status.LastProbe = &at
+	status.Healthy = &healthy
+	if c.probe.err != nil {
+		status.ProbeError = c.probe.err.Error()
+	}
+}
+
+// healthy reports whether the client is running, initialized, and passed
+// its last health probe. A client that has not been probed yet counts as
+// healthy.
+func (c *Client) healthy() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.initialized && c.probe.err == nil && c.transport.IsRunning()
+}
+
+// serverHealthy reports whether the named server can take tool calls.
+// In-process tools are always available. Callers must hold m.mu.
+func (m *Manager) serverHealthy(name string) bool {
+	if name == LocalServerName {
+		return true
+	}
+	client, ok := m.clients[name]
+	return ok && client.healthy()
+}
+
+// StartProbes pings every running server each ProbeInterval until ctx is
+// done, so servers that are alive but no longer answering are detected.
+// Servers that fail a probe are marked unhealthy and, with ProbeRestart,
+// restarted. It does nothing when ProbeInterval is 0.
+func (m *Manager) StartProbes(ctx context.Context) {
+	m.mu.RLock()
+	interval := time.Duration(m.settings.ProbeInterval) * time.Second
+	m.mu.RUnlock()
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.probeAll(ctx)
+			}
+		}
+	}()
+}
+
+// probeAll pings all running servers concurrently and handles failures.
+func (m *Manager) probeAll(ctx context.Context) {
+	m.mu.RLock()
+	timeout := time.Duration(m.settings.ProbeTimeout) * time.Second
+	restart := m.settings.ProbeRestart
+	clients := make(map[string]*Client, len(m.clients))
+	for name, client := range m.clients {
+		clients[name] = client
+	}
+	m.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for name, client := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := client.Ping(ctx, timeout)
+			if ctx.Err() != nil {
+				return
+			}
+			now := time.Now()
+			client.recordProbe(now, err)
+			m.recordHealth(name, now, err == nil)
+			if err == nil {
+				return
+			}
+
+			fmt.Fprintf(os.Stderr, "MCP server %s failed health probe: %v\n", name, err)
+			if !restart {
+				return
+			}
+			// A wedged server holds its transport; kill it so the stop can proceed
+			client.transport.Kill()
+			if err := m.RestartServer(ctx, name); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to restart MCP server %s: %v\n", name, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,156 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbeAll_UnresponsiveServer(t *testing.T) {
+	wedged := fakeServerConfig("wedged", "tool_a")
+	wedged.Env["FAKE_MCP_PING_HANG"] = "1"
+
+	m := newTestManager(fakeServerConfig("healthy", "tool_b"), wedged)
+	m.settings.ProbeTimeout = 1
+	t.Cleanup(func() {
+		for _, client := range m.clients {
+			client.transport.Kill()
+		}
+		m.StopAll()
+	})
+	if err := m.StartAll(context.Background()); err != nil {
+		t.Fatalf("StartAll: %v", err)
+	}
+
+	m.probeAll(context.Background())
+
+	clients := m.GetClients()
+	if s := clients["healthy"]; s.LastProbe == nil || s.Healthy == nil || !*s.Healthy {
+		t.Errorf("got healthy server status %+v, want a successful probe", s)
+	}
+	s := clients["wedged"]
+	if s.Healthy == nil || *s.Healthy || !strings.Contains(s.ProbeError, "ping timeout") {
+		t.Errorf("got wedged server status %+v, want a timed out probe", s)
+	}
+	if !m.IsToolAvailable("tool_a") {
+		t.Error("got tool_a unavailable, want the unhealthy server kept without probe_restart")
+	}
+
+	// With probe_restart the wedged server is replaced by a fresh instance
+	m.settings.ProbeRestart = true
+	old := m.clients["wedged"]
+	m.probeAll(context.Background())
+
+	m.mu.RLock()
+	restarted := m.clients["wedged"]
+	m.mu.RUnlock()
+	if restarted == nil || restarted == old {
+		t.Fatal("got wedged server not restarted")
+	}
+	if s := m.GetClients()["wedged"]; s.LastProbe != nil {
+		t.Errorf("got restarted server status %+v, want no probe yet", s)
+	}
+	if !m.IsToolAvailable("tool_a") {
+		t.Error("got tool_a unavailable after restart")
+	}
+}
+
+func TestProbeAll_BusyServer(t *testing.T) {
+	busy := fakeServerConfig("busy", "slow_tool")
+	busy.Env["FAKE_MCP_CALL_DELAY_MS"] = "1500"
+
+	m := newTestManager(busy)
+	m.settings.ProbeTimeout = 1
+	m.settings.ProbeRestart = true
+	t.Cleanup(func() { m.StopAll() })
+	if err := m.StartAll(context.Background()); err != nil {
+		t.Fatalf("StartAll: %v", err)
+	}
+	client := m.clients["busy"]
+
+	// Probe while a tool call that outlasts the probe timeout is running
+	callErr := make(chan error, 1)
+	go func() {
+		_, err := client.CallTool(context.Background(), "slow_tool", json.RawMessage(`{}`))
+		callErr <- err
+	}()
+	for client.transport.current() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	m.probeAll(context.Background())
+
+	if s := m.GetClients()["busy"]; s.Healthy == nil || !*s.Healthy {
+		t.Errorf("got status %+v, want a busy server counted healthy", s)
+	}
+	if err := <-callErr; err != nil {
+		t.Errorf("got tool call error %v, want the call left running", err)
+	}
+	if m.clients["busy"] != client {
+		t.Error("got busy server restarted")
+	}
+
+	// A tool call running past the call timeout means the server is stuck
+	client.callTimeout = 100 * time.Millisecond
+	go client.CallTool(context.Background(), "slow_tool", json.RawMessage(`{}`))
+	time.Sleep(300 * time.Millisecond)
+	if err := client.Ping(context.Background(), time.Second); err == nil || !strings.Contains(err.Error(), "unanswered") {
+		t.Errorf("got ping error %v, want the stuck tool call reported", err)
+	}
+}
+
+func TestStartProbes_Disabled(t *testing.T) {
+	m := newTestManager(fakeServerConfig("healthy", "tool_b"))
+	t.Cleanup(func() { m.StopAll() })
+	if err := m.StartAll(context.Background()); err != nil {
+		t.Fatalf("StartAll: %v", err)
+	}
+
+	m.StartProbes(context.Background())
+
+	if s := m.GetClients()["healthy"]; s.LastProbe != nil {
+		t.Errorf("got status %+v, want no probes when probe_interval is 0", s)
+	}
+}
+
+func TestGetToolsAsOpenAI_HideUnhealthyTools(t *testing.T) {
+	wedged := fakeServerConfig("wedged", "tool_a")
+	wedged.Env["FAKE_MCP_PING_HANG"] = "1"
+
+	m := newTestManager(fakeServerConfig("healthy", "tool_b"), wedged)
+	m.settings.ProbeTimeout = 1
+	t.Cleanup(func() {
+		for _, client := range m.clients {
+			client.transport.Kill()
+		}
+		m.StopAll()
+	})
+	if err := m.StartAll(context.Background()); err != nil {
+		t.Fatalf("StartAll: %v", err)
+	}
+	m.probeAll(context.Background())
+
+	advertised := func() string {
+		var names []string
+		for _, tool := range m.GetToolsAsOpenAI() {
+			names = append(names, tool.Function.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if got := advertised(); got != "tool_b,tool_a" {
+		t.Errorf("got tools %s without hide_unhealthy_tools, want tool_b,tool_a", got)
+	}
+
+	m.settings.HideUnhealthyTools = true
+	if got := advertised(); got != "tool_b" {
+		t.Errorf("got tools %s, want the wedged server's tool_a hidden", got)
+	}
+
+	// A server that is no longer running is hidden too
+	m.clients["healthy"].transport.Stop()
+	if got := advertised(); got != "" {
+		t.Errorf("got tools %s, want none", got)
+	}
+}
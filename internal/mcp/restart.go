@@ -0,0 +1,63 @@
+package mcp
+
+import (
+	"time"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+// restartTracker limits restarts to a maximum count within a sliding window.
+// A server that has stayed healthy for resetAfter has its history cleared,
+// so one restart after a long healthy run is not held against it.
+type restartTracker struct {
+	max          int
+	window       time.Duration
+	resetAfter   time.Duration
+	restarts     []time.Time
+	healthySince time.Time // zero while the server is not known to be healthy
+}
+
+// newRestartTracker creates a tracker from the MCP restart settings.
+func newRestartTracker(settings models.MCPSettings) *restartTracker {
+	return &restartTracker{
+		max:        settings.MaxRestarts,
+		window:     time.Duration(settings.RestartWindow) * time.Second,
+		resetAfter: time.Duration(settings.RestartResetAfter) * time.Second,
+	}
+}
+
+// healthy records whether the server was healthy at now: after it started,
+// or at a health probe. The healthy period starts at the first healthy
+// report and ends at an unhealthy one or a restart.
+func (t *restartTracker) healthy(now time.Time, ok bool) {
+	switch {
+	case !ok:
+		t.healthySince = time.Time{}
+	case t.healthySince.IsZero():
+		t.healthySince = now
+	}
+}
+
+// allow reports whether a restart at now is permitted, recording it if so.
+func (t *restartTracker) allow(now time.Time) bool {
+	if !t.healthySince.IsZero() && t.resetAfter > 0 && now.Sub(t.healthySince) >= t.resetAfter {
+		t.restarts = nil
+	}
+	t.healthySince = time.Time{}
+
+	// Drop restarts that have slid out of the window
+	cutoff := now.Add(-t.window)
+	kept := t.restarts[:0]
+	for _, at := range t.restarts {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	t.restarts = kept
+
+	if len(t.restarts) >= t.max {
+		return false
+	}
+	t.restarts = append(t.restarts, now)
+	return true
+}
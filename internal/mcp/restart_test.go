@@ -0,0 +1,99 @@
+package mcp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leeaandrob/claudex/internal/models"
+)
+
+func TestRestartTracker_SlidingWindow(t *testing.T) {
+	tracker := newRestartTracker(models.MCPSettings{
+		MaxRestarts:       2,
+		RestartWindow:     60,
+		RestartResetAfter: 3600,
+	})
+	base := time.Unix(0, 0)
+
+	steps := []struct {
+		offset time.Duration
+		want   bool
+	}{
+		{0, true},
+		{10 * time.Second, true},
+		{20 * time.Second, false}, // third restart inside the window
+		{61 * time.Second, true},  // first restart slid out of the window
+		{65 * time.Second, false}, // restarts at 10s and 61s still count
+		{69 * time.Second, false}, // refused attempts are not recorded
+		{71 * time.Second, true},  // 10s restart slid out
+	}
+
+	for _, step := range steps {
+		if got := tracker.allow(base.Add(step.offset)); got != step.want {
+			t.Errorf("allow at %v: got %v, want %v", step.offset, got, step.want)
+		}
+	}
+}
+
+func TestRestartTracker_ResetAfterHealthyPeriod(t *testing.T) {
+	base := time.Unix(0, 0)
+	at := func(seconds int) time.Time { return base.Add(time.Duration(seconds) * time.Second) }
+
+	// Default settings: 3 restarts within 600s, reset after 300s healthy.
+	// Each case restarts at 0s, 10s, and 20s, reports health, and restarts
+	// again at 400s, while all three earlier restarts are in the window.
+	tests := []struct {
+		name   string
+		health map[int]bool // seconds to healthy
+		want   bool
+	}{
+		{name: "never healthy", want: false},
+		{name: "healthy for less than reset_after", health: map[int]bool{200: true}, want: false},
+		{name: "healthy for reset_after", health: map[int]bool{30: true, 200: true}, want: true},
+		{name: "healthy period ended by a failed probe", health: map[int]bool{30: true, 200: false, 210: true}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newRestartTracker(NewManager().settings)
+			for _, seconds := range []int{0, 10, 20} {
+				if !tracker.allow(at(seconds)) {
+					t.Fatalf("restart at %ds refused", seconds)
+				}
+			}
+			for _, seconds := range []int{30, 200, 210} {
+				if healthy, ok := tt.health[seconds]; ok {
+					tracker.healthy(at(seconds), healthy)
+				}
+			}
+			if got := tracker.allow(at(400)); got != tt.want {
+				t.Errorf("allow at 400s: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestartServer_Limit(t *testing.T) {
+	m := newTestManager(fakeServerConfig("echo", "echo"))
+	m.settings.MaxRestarts = 1
+	defer m.StopAll()
+
+	ctx := context.Background()
+	if err := m.StartServer(ctx, "echo"); err != nil {
+		t.Fatalf("StartServer: %v", err)
+	}
+
+	if err := m.RestartServer(ctx, "echo"); err != nil {
+		t.Fatalf("first RestartServer: %v", err)
+	}
+	if !m.IsToolAvailable("echo") {
+		t.Error("got tool unavailable after restart, want available")
+	}
+
+	err := m.RestartServer(ctx, "echo")
+	if err == nil || !strings.Contains(err.Error(), "exceeded 1 restarts") {
+		t.Errorf("got error %v, want restart limit error", err)
+	}
+}
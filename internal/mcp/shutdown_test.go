@@ -0,0 +1,86 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdown_DrainsInFlightCalls(t *testing.T) {
+	server := fakeServerConfig("slow", "sleep")
+	server.Env["FAKE_MCP_CALL_DELAY_MS"] = "200"
+
+	m := newTestManager(server)
+	if err := m.StartServer(context.Background(), "slow"); err != nil {
+		t.Fatalf("StartServer: %v", err)
+	}
+	client := m.clients["slow"]
+
+	type callResult struct {
+		text string
+		err  error
+	}
+	done := make(chan callResult, 1)
+	go func() {
+		result, err := m.CallTool(context.Background(), "sleep", json.RawMessage(`{"n":1}`))
+		if err != nil {
+			done <- callResult{err: err}
+			return
+		}
+		done <- callResult{text: result.GetTextContent()}
+	}()
+	for client.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	res := <-done
+	if res.err != nil || res.text != `{"n":1}` {
+		t.Errorf("in-flight call: got (%q, %v), want its result", res.text, res.err)
+	}
+	if m.GetClientCount() != 0 {
+		t.Errorf("got %d clients after shutdown, want 0", m.GetClientCount())
+	}
+}
+
+func TestShutdown_DeadlineAndNewCalls(t *testing.T) {
+	server := fakeServerConfig("slow", "sleep")
+	server.Env["FAKE_MCP_CALL_DELAY_MS"] = "2000"
+
+	m := newTestManager(server)
+	if err := m.StartServer(context.Background(), "slow"); err != nil {
+		t.Fatalf("StartServer: %v", err)
+	}
+	client := m.clients["slow"]
+
+	go m.CallTool(context.Background(), "sleep", json.RawMessage(`{}`))
+	for client.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.Drain(ctx); err == nil || !strings.Contains(err.Error(), "1 tool calls still in flight") {
+		t.Errorf("Drain past the deadline: got %v, want in-flight error", err)
+	}
+
+	// Draining clients reject new calls
+	if _, err := client.CallTool(context.Background(), "sleep", json.RawMessage(`{}`)); err == nil || !strings.Contains(err.Error(), "shutting down") {
+		t.Errorf("CallTool while draining: got %v, want shutting down error", err)
+	}
+
+	start := time.Now()
+	if err := m.Shutdown(ctx); err == nil {
+		t.Error("Shutdown past the deadline: got nil error, want error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %v, want it bounded by the deadline", elapsed)
+	}
+}
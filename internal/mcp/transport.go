@@ -10,10 +10,14 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/leeaandrob/claudex/internal/models"
 )
 
+// DefaultMaxMessageBytes is the default limit on a single stdout message.
+const DefaultMaxMessageBytes = 1024 * 1024
+
 // StdioTransport handles communication with an MCP server via stdio.
 // It implements JSON-RPC 2.0 over newline-delimited JSON (NDJSON).
 type StdioTransport struct {
@@ -23,8 +27,26 @@ type StdioTransport struct {
 	stderr    io.ReadCloser
 	mu        sync.Mutex
 	requestID int64
-	running   bool
+	running   atomic.Bool // written under mu, readable without it
 	serverEnv map[string]string
+
+	maxMessageBytes int
+	stderrLines     int
+	stderrTail      []string
+	stderrMu        sync.Mutex
+
+	// process is the running server, readable without mu so Kill can
+	// interrupt a Send that holds it.
+	process atomic.Pointer[os.Process]
+
+	// active is the request holding the transport, readable without mu.
+	active atomic.Pointer[activeRequest]
+}
+
+// activeRequest is a request being sent or awaiting its response.
+type activeRequest struct {
+	method string
+	since  time.Time
 }
 
 // NewStdioTransport creates a new stdio transport.
@@ -37,7 +59,7 @@ func (t *StdioTransport) Start(command string, args []string, env map[string]str
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if t.running {
+	if t.running.Load() {
 		return fmt.Errorf("transport already running")
 	}
 
@@ -66,7 +88,11 @@ func (t *StdioTransport) Start(command string, args []string, env map[string]str
 	}
 	t.stdout = bufio.NewScanner(stdout)
 	// Increase scanner buffer for large JSON responses
-	t.stdout.Buffer(make([]byte, 64*1024), 1024*1024)
+	maxMessageBytes := t.maxMessageBytes
+	if maxMessageBytes <= 0 {
+		maxMessageBytes = DefaultMaxMessageBytes
+	}
+	t.stdout.Buffer(make([]byte, 64*1024), maxMessageBytes)
 
 	stderr, err := t.cmd.StderrPipe()
 	if err != nil {
@@ -81,8 +107,13 @@ func (t *StdioTransport) Start(command string, args []string, env map[string]str
 		return fmt.Errorf("failed to start MCP server: %w", err)
 	}
 
-	t.running = true
+	t.running.Store(true)
 	t.requestID = 0
+	t.process.Store(t.cmd.Process)
+
+	t.stderrMu.Lock()
+	t.stderrTail = nil
+	t.stderrMu.Unlock()
 
 	// Drain stderr in background to prevent blocking
 	go t.drainStderr()
@@ -90,7 +121,22 @@ func (t *StdioTransport) Start(command string, args []string, env map[string]str
 	return nil
 }
 
-// drainStderr reads and discards stderr to prevent the process from blocking.
+// SetLimits sets the largest stdout message accepted and how many recent
+// stderr lines to keep. It must be called before Start.
+func (t *StdioTransport) SetLimits(maxMessageBytes, stderrLines int) {
+	t.maxMessageBytes = maxMessageBytes
+	t.stderrLines = stderrLines
+}
+
+// StderrTail returns the most recently captured stderr lines.
+func (t *StdioTransport) StderrTail() []string {
+	t.stderrMu.Lock()
+	defer t.stderrMu.Unlock()
+	return append([]string(nil), t.stderrTail...)
+}
+
+// drainStderr reads stderr to prevent the process from blocking, keeping the
+// last stderrLines lines for diagnostics.
 func (t *StdioTransport) drainStderr() {
 	if t.stderr == nil {
 		return
@@ -98,8 +144,15 @@ func (t *StdioTransport) drainStderr() {
 	scanner := bufio.NewScanner(t.stderr)
 	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
 	for scanner.Scan() {
-		// Could log stderr here if needed for debugging
-		_ = scanner.Text()
+		if t.stderrLines <= 0 {
+			continue
+		}
+		t.stderrMu.Lock()
+		t.stderrTail = append(t.stderrTail, scanner.Text())
+		if len(t.stderrTail) > t.stderrLines {
+			t.stderrTail = t.stderrTail[len(t.stderrTail)-t.stderrLines:]
+		}
+		t.stderrMu.Unlock()
 	}
 }
 
@@ -108,11 +161,11 @@ func (t *StdioTransport) Stop() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if !t.running {
+	if !t.running.Load() {
 		return nil
 	}
 
-	t.running = false
+	t.running.Store(false)
 
 	// Close stdin to signal the server to shut down
 	if t.stdin != nil {
@@ -135,19 +188,44 @@ func (t *StdioTransport) Stop() error {
 	return nil
 }
 
-// IsRunning returns whether the transport is running.
+// Kill terminates the server process immediately, failing any request in
+// progress. Stop must still be called to release the transport.
+func (t *StdioTransport) Kill() {
+	if p := t.process.Load(); p != nil {
+		p.Kill()
+	}
+}
+
+// current returns the request holding the transport, or nil when it is
+// idle.
+func (t *StdioTransport) current() *activeRequest {
+	return t.active.Load()
+}
+
+// IsRunning returns whether the transport is running. It does not wait for
+// a request in progress.
 func (t *StdioTransport) IsRunning() bool {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	return t.running
+	return t.running.Load()
 }
 
 // Send sends a JSON-RPC request and returns the response.
 func (t *StdioTransport) Send(method string, params interface{}) (*models.JSONRPCResponse, error) {
+	return t.send(method, params, nil)
+}
+
+// send is Send, closing acquired, if set, once the transport is free and the
+// request is about to be written. The server handles one request at a time,
+// so a request may first wait for earlier ones to be answered.
+func (t *StdioTransport) send(method string, params interface{}, acquired chan<- struct{}) (*models.JSONRPCResponse, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	t.active.Store(&activeRequest{method: method, since: time.Now()})
+	defer t.active.Store(nil)
+	if acquired != nil {
+		close(acquired)
+	}
 
-	if !t.running {
+	if !t.running.Load() {
 		return nil, fmt.Errorf("transport not running")
 	}
 
@@ -204,7 +282,7 @@ func (t *StdioTransport) SendNotification(method string, params interface{}) err
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if !t.running {
+	if !t.running.Load() {
 		return fmt.Errorf("transport not running")
 	}
 
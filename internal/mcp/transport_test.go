@@ -0,0 +1,85 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallTool_MaxMessageBytes(t *testing.T) {
+	// The echoed result is a little over 1.5MB, above the 1MB default
+	args := json.RawMessage(fmt.Sprintf(`{"blob":%q}`, strings.Repeat("x", 1536*1024)))
+
+	tests := []struct {
+		name            string
+		maxMessageBytes int
+		wantErr         bool
+	}{
+		{name: "default limit", maxMessageBytes: 0, wantErr: true},
+		{name: "raised limit", maxMessageBytes: 4 * 1024 * 1024, wantErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fakeServerConfig("big", "echo")
+			server.MaxMessageBytes = tt.maxMessageBytes
+			m := newTestManager(server)
+			defer m.StopAll()
+
+			if err := m.StartServer(context.Background(), "big"); err != nil {
+				t.Fatalf("StartServer: %v", err)
+			}
+
+			result, err := m.CallTool(context.Background(), "echo", args)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("got nil error, want message too long error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CallTool: %v", err)
+			}
+			if got := len(result.GetTextContent()); got != len(args) {
+				t.Errorf("got %d bytes of result, want %d", got, len(args))
+			}
+		})
+	}
+}
+
+func TestGetClients_StderrTail(t *testing.T) {
+	server := fakeServerConfig("noisy", "echo")
+	server.Env["FAKE_MCP_STDERR"] = "one|two|three|four"
+	server.StderrLines = 2
+
+	quiet := fakeServerConfig("quiet", "other")
+	quiet.Env["FAKE_MCP_STDERR"] = "ignored"
+
+	m := newTestManager(server, quiet)
+	defer m.StopAll()
+
+	if err := m.StartAll(context.Background()); err != nil {
+		t.Fatalf("StartAll: %v", err)
+	}
+
+	want := []string{"three", "four"}
+	deadline := time.Now().Add(5 * time.Second)
+	var got []string
+	for time.Now().Before(deadline) {
+		if got = m.GetClients()["noisy"].Stderr; reflect.DeepEqual(got, want) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got stderr %q, want %q", got, want)
+	}
+
+	if got := m.GetClients()["quiet"].Stderr; len(got) != 0 {
+		t.Errorf("got stderr %q for server without stderr_lines, want none", got)
+	}
+}
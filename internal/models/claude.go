@@ -2,11 +2,20 @@ package models
 
 // ClaudeJSONResponse represents a non-streaming Claude CLI JSON output.
 type ClaudeJSONResponse struct {
-	Type       string  `json:"type"`
-	Result     string  `json:"result"`
-	SessionID  string  `json:"session_id"`
-	CostUSD    float64 `json:"cost_usd"`
-	DurationMS int     `json:"duration_ms"`
+	Type       string             `json:"type"`
+	Subtype    string             `json:"subtype,omitempty"`
+	IsError    bool               `json:"is_error,omitempty"`
+	Result     string             `json:"result"`
+	SessionID  string             `json:"session_id"`
+	CostUSD    float64            `json:"cost_usd"`
+	DurationMS int                `json:"duration_ms"`
+	Error      *ClaudeErrorDetail `json:"error,omitempty"` // Set when Type is "error"
+}
+
+// ClaudeErrorDetail is the error object in a Claude CLI {"type":"error"} output.
+type ClaudeErrorDetail struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
 }
 
 // ClaudeStreamMessage represents a streaming Claude CLI output line (NDJSON).
@@ -16,13 +25,30 @@ type ClaudeStreamMessage struct {
 	Message   *ClaudeMessage      `json:"message,omitempty"`
 	Result    string              `json:"result,omitempty"`
 	Event     *ClaudeStreamEvent  `json:"event,omitempty"` // For stream_event type
+	Usage     *ClaudeUsage        `json:"usage,omitempty"` // For result type
+}
+
+// ClaudeUsage represents token usage reported by the Claude CLI.
+type ClaudeUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// ToOpenAIUsage converts Claude token usage to OpenAI format.
+func (u *ClaudeUsage) ToOpenAIUsage() Usage {
+	return Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
 }
 
 // ClaudeStreamEvent represents a streaming event from Claude CLI with --include-partial-messages.
 type ClaudeStreamEvent struct {
-	Type  string            `json:"type"`  // message_start, content_block_start, content_block_delta, content_block_stop, message_delta, message_stop
-	Index int               `json:"index,omitempty"`
-	Delta *ClaudeEventDelta `json:"delta,omitempty"`
+	Type    string            `json:"type"` // message_start, content_block_start, content_block_delta, content_block_stop, message_delta, message_stop
+	Index   int               `json:"index,omitempty"`
+	Delta   *ClaudeEventDelta `json:"delta,omitempty"`
+	Message *ClaudeMessage    `json:"message,omitempty"` // For message_start
 }
 
 // ClaudeEventDelta represents the delta in a content_block_delta event.
@@ -33,6 +59,7 @@ type ClaudeEventDelta struct {
 
 // ClaudeMessage represents a message in Claude streaming output.
 type ClaudeMessage struct {
+	ID      string               `json:"id,omitempty"`
 	Role    string               `json:"role"`
 	Content []ClaudeContentBlock `json:"content"`
 }
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"time"
 )
 
 // MCPConfig represents the complete MCP configuration.
@@ -17,19 +18,41 @@ type MCPSection struct {
 
 // MCPSettings contains global MCP configuration.
 type MCPSettings struct {
-	InitTimeout int  `yaml:"init_timeout" json:"init_timeout"` // Timeout for MCP server initialization (seconds)
-	CallTimeout int  `yaml:"call_timeout" json:"call_timeout"` // Timeout for tool calls (seconds)
-	AutoRestart bool `yaml:"auto_restart" json:"auto_restart"` // Restart failed servers automatically
-	MaxRestarts int  `yaml:"max_restarts" json:"max_restarts"` // Max restart attempts before giving up
+	InitTimeout        int  `yaml:"init_timeout" json:"init_timeout"`                 // Timeout for MCP server initialization (seconds)
+	CallTimeout        int  `yaml:"call_timeout" json:"call_timeout"`                 // Timeout for tool calls (seconds)
+	AutoRestart        bool `yaml:"auto_restart" json:"auto_restart"`                 // Restart failed servers automatically
+	MaxRestarts        int  `yaml:"max_restarts" json:"max_restarts"`                 // Max restarts within restart_window before giving up
+	RestartWindow      int  `yaml:"restart_window" json:"restart_window"`             // Sliding window for max_restarts (seconds)
+	RestartResetAfter  int  `yaml:"restart_reset_after" json:"restart_reset_after"`   // Healthy period after which the restart count resets (seconds)
+	DiscoveryRetries   int  `yaml:"discovery_retries" json:"discovery_retries"`       // Extra tools/list attempts when a tools-capable server lists none
+	DiscoveryDelayMS   int  `yaml:"discovery_delay_ms" json:"discovery_delay_ms"`     // Delay between tools/list attempts (milliseconds)
+	ProbeInterval      int  `yaml:"probe_interval" json:"probe_interval"`             // Interval between health probes of each server (seconds, 0 disables)
+	ProbeTimeout       int  `yaml:"probe_timeout" json:"probe_timeout"`               // Time a server has to answer a probe (seconds)
+	ProbeRestart       bool `yaml:"probe_restart" json:"probe_restart"`               // Restart servers that fail a probe
+	HideUnhealthyTools bool `yaml:"hide_unhealthy_tools" json:"hide_unhealthy_tools"` // Leave tools of down or unhealthy servers out of advertised tools
+	MaxServers         int  `yaml:"max_servers" json:"max_servers"`                   // Most servers started at startup (0 means unlimited)
+	MaxTools           int  `yaml:"max_tools" json:"max_tools"`                       // Most tools registered across all servers (0 means unlimited)
 }
 
 // MCPServerConfig represents a single MCP server configuration.
 type MCPServerConfig struct {
-	Name    string            `yaml:"name" json:"name"`
-	Command string            `yaml:"command" json:"command"`
-	Args    []string          `yaml:"args,omitempty" json:"args,omitempty"`
-	Env     map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
-	Enabled bool              `yaml:"enabled" json:"enabled"`
+	Name            string            `yaml:"name" json:"name"`
+	Command         string            `yaml:"command" json:"command"`
+	Args            []string          `yaml:"args,omitempty" json:"args,omitempty"`
+	Env             map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	Enabled         bool              `yaml:"enabled" json:"enabled"`
+	MaxMessageBytes int               `yaml:"max_message_bytes,omitempty" json:"max_message_bytes,omitempty"` // Largest stdout message accepted (default 1MB)
+	StderrLines     int               `yaml:"stderr_lines,omitempty" json:"stderr_lines,omitempty"`           // Recent stderr lines kept for diagnostics (0 discards stderr)
+	DependsOn       []string          `yaml:"depends_on,omitempty" json:"depends_on,omitempty"`               // Servers that must be running before this one starts
+}
+
+// MCPServerStatus describes a connected MCP server.
+type MCPServerStatus struct {
+	MCPImplementationInfo
+	Stderr     []string   `json:"stderr,omitempty"`      // Most recent stderr lines, if captured
+	LastProbe  *time.Time `json:"last_probe,omitempty"`  // When the server was last health-probed, if probing is enabled
+	Healthy    *bool      `json:"healthy,omitempty"`     // Whether the last probe succeeded
+	ProbeError string     `json:"probe_error,omitempty"` // Why the last probe failed
 }
 
 // MCPTool represents a tool discovered from an MCP server.
@@ -54,10 +77,20 @@ type MCPToolResult struct {
 
 // MCPContent represents content in a tool result.
 type MCPContent struct {
-	Type     string `json:"type"` // "text" | "image" | "resource"
-	Text     string `json:"text,omitempty"`
-	Data     string `json:"data,omitempty"`
+	Type     string               `json:"type"` // "text" | "image" | "resource"
+	Text     string               `json:"text,omitempty"`
+	Data     string               `json:"data,omitempty"` // Base64, for images
+	MimeType string               `json:"mimeType,omitempty"`
+	Resource *MCPResourceContents `json:"resource,omitempty"` // Set when Type is "resource"
+}
+
+// MCPResourceContents is an embedded resource in a tool result. It carries
+// either Text or base64 Blob data.
+type MCPResourceContents struct {
+	URI      string `json:"uri"`
 	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
 }
 
 // GetTextContent extracts all text content from the result.
@@ -2,16 +2,97 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
 )
 
 // ChatCompletionRequest represents an OpenAI-compatible chat completion request.
 type ChatCompletionRequest struct {
-	Model      string    `json:"model"`
-	Messages   []Message `json:"messages"`
-	Stream     bool      `json:"stream,omitempty"`
-	Tools      []Tool    `json:"tools,omitempty"`
-	ToolChoice any       `json:"tool_choice,omitempty"` // string | ToolChoiceObject
-	MaxTokens  int       `json:"max_tokens,omitempty"`
+	Model      string             `json:"model"`
+	Messages   []Message          `json:"messages"`
+	Stream     bool               `json:"stream,omitempty"`
+	Tools      []Tool             `json:"tools,omitempty"`
+	ToolChoice any                `json:"tool_choice,omitempty"` // string | ToolChoiceObject
+	MaxTokens  int                `json:"max_tokens,omitempty"`
+	N          int                `json:"n,omitempty"`          // Number of choices (streaming only)
+	LogitBias  map[string]float64 `json:"logit_bias,omitempty"` // Accepted for compatibility; not supported by the CLI
+	User       string             `json:"user,omitempty"`       // End-user identifier, recorded in audit logs
+
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+
+	// Stop holds up to four sequences at which the response is cut off.
+	// The CLI has no stop option, so the proxy cuts the text it returns.
+	Stop StopSequences `json:"stop,omitempty"`
+
+	// Store asks for the request/response pair to be persisted for retrieval
+	// via GET /v1/chat/completions/{id}. Metadata is stored alongside it.
+	Store    bool              `json:"store,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Temperature and TopP are range-checked but not passed to the CLI, which
+	// has no sampling options.
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+
+	// PromptCacheKey and SafetyIdentifier are sent by current OpenAI SDKs.
+	// Neither is passed to the CLI; SafetyIdentifier is recorded in audit and
+	// moderation logs.
+	PromptCacheKey   string `json:"prompt_cache_key,omitempty"`
+	SafetyIdentifier string `json:"safety_identifier,omitempty"`
+
+	// MCPTools holds the tool names that are executed via MCP for this
+	// request. It is set by the handler when merging MCP tools.
+	MCPTools map[string]bool `json:"-"`
+
+	// DisableMCP leaves the server's MCP tools out of this request. It is
+	// set by the handler from the X-Claudex-Disable-MCP header.
+	DisableMCP bool `json:"-"`
+
+	// AllowedMCPTools, when non-nil, limits the MCP tools merged into this
+	// request to those named. It is set by the handler from the API key's
+	// policy.
+	AllowedMCPTools map[string]bool `json:"-"`
+
+	// AllowedModels, when non-nil, limits the models this request may run
+	// on, including fallback models. It is set by the handler from the API
+	// key's policy.
+	AllowedModels []string `json:"-"`
+}
+
+// ResponseFormat represents the requested output format.
+type ResponseFormat struct {
+	Type       string            `json:"type"` // "text" | "json_object" | "json_schema"
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// StopSequences is the stop parameter, sent as a single string or an array
+// of strings.
+type StopSequences []string
+
+// UnmarshalJSON accepts a string, an array of strings, or null.
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*s = nil
+		return nil
+	}
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = StopSequences{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = many
+	return nil
+}
+
+// JSONSchemaFormat describes the schema for "json_schema" response formats.
+type JSONSchemaFormat struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
+	Strict      bool            `json:"strict,omitempty"`
 }
 
 // Tool represents an OpenAI function tool definition.
@@ -86,7 +167,8 @@ type messageAlias struct {
 	ToolCallID string          `json:"tool_call_id,omitempty"`
 }
 
-// UnmarshalJSON handles both string and array content formats.
+// UnmarshalJSON handles both string and array content formats, and a single
+// content part object.
 func (m *Message) UnmarshalJSON(data []byte) error {
 	var alias messageAlias
 	if err := json.Unmarshal(data, &alias); err != nil {
@@ -112,14 +194,20 @@ func (m *Message) UnmarshalJSON(data []byte) error {
 
 	// Try to unmarshal as array of content parts
 	var parts []ContentPart
-	if err := json.Unmarshal(alias.Content, &parts); err != nil {
-		// If both fail, store as raw for later processing
-		m.Content = string(alias.Content)
+	if err := json.Unmarshal(alias.Content, &parts); err == nil {
+		m.Content = parts
+		return nil
+	}
+
+	// Some clients send a lone content part instead of an array
+	var part ContentPart
+	if err := json.Unmarshal(alias.Content, &part); err == nil && part.Type != "" {
+		m.Content = []ContentPart{part}
 		return nil
 	}
 
-	// Store the content parts
-	m.Content = parts
+	// Otherwise store as raw for later processing (rejected by request validation)
+	m.Content = alias.Content
 	return nil
 }
 
@@ -201,6 +289,25 @@ type ChatCompletionResponse struct {
 	Model   string   `json:"model"`
 	Choices []Choice `json:"choices"`
 	Usage   Usage    `json:"usage"`
+
+	// Debug is a non-standard field set when the client asks for the
+	// assembled prompt and tools with the X-Claudex-Debug-Prompt header.
+	Debug *PromptDebug `json:"claudex_debug,omitempty"`
+}
+
+// PromptDebug holds the prompts claudex assembled for the CLI and the tools
+// advertised to the model, after client and MCP tools were merged.
+type PromptDebug struct {
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	Prompt       string `json:"prompt,omitempty"`
+	Tools        []Tool `json:"tools"`
+}
+
+// StoredCompletion is a completion persisted for a "store": true request.
+type StoredCompletion struct {
+	ChatCompletionResponse
+	Metadata map[string]string      `json:"metadata,omitempty"`
+	Request  *ChatCompletionRequest `json:"request,omitempty"`
 }
 
 // Choice represents a completion choice in a non-streaming response.
@@ -215,6 +322,10 @@ type Usage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+
+	// Estimated is a non-standard field set when the counts were estimated
+	// from the text because the CLI reported none.
+	Estimated bool `json:"claudex_estimated,omitempty"`
 }
 
 // ChatCompletionChunk represents a streaming chunk response.
@@ -224,6 +335,18 @@ type ChatCompletionChunk struct {
 	Created int64         `json:"created"`
 	Model   string        `json:"model"`
 	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"`
+}
+
+// StreamToolResult is a non-standard SSE event carrying the result of an MCP
+// tool call executed during a streamed tool loop.
+type StreamToolResult struct {
+	ID         string `json:"id"`
+	Object     string `json:"object"` // "claudex.tool_result"
+	Index      int    `json:"index"`
+	ToolCallID string `json:"tool_call_id"`
+	Name       string `json:"name"`
+	Content    string `json:"content"`
 }
 
 // ChunkChoice represents a choice in a streaming chunk.
@@ -263,5 +386,27 @@ type ErrorResponse struct {
 type ErrorDetail struct {
 	Message string `json:"message"`
 	Type    string `json:"type"`
+	Param   string `json:"param,omitempty"`
 	Code    string `json:"code,omitempty"`
 }
+
+// BatchCompletionRequest is a non-standard request carrying several
+// independent chat completion requests.
+type BatchCompletionRequest struct {
+	Requests []ChatCompletionRequest `json:"requests"`
+}
+
+// BatchCompletionResponse holds one result per batch item, in request order.
+type BatchCompletionResponse struct {
+	Object string                `json:"object"` // "batch.completion"
+	Data   []BatchCompletionItem `json:"data"`
+}
+
+// BatchCompletionItem is the result of a single batch item.
+// Exactly one of Response and Error is set.
+type BatchCompletionItem struct {
+	Index    int                     `json:"index"`
+	Status   int                     `json:"status"`
+	Response *ChatCompletionResponse `json:"response,omitempty"`
+	Error    *ErrorDetail            `json:"error,omitempty"`
+}
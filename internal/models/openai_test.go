@@ -244,3 +244,27 @@ func TestMessageUnmarshal_WithImageURL(t *testing.T) {
 		t.Fatalf("Failed to unmarshal: %v", err)
 	}
 }
+
+func TestMessageUnmarshal_ObjectContent(t *testing.T) {
+	input := `{"role": "user", "content": {"type": "text", "text": "Hello from a lone part"}}`
+
+	var msg Message
+	if err := json.Unmarshal([]byte(input), &msg); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if got := msg.GetTextContent(); got != "Hello from a lone part" {
+		t.Errorf("got text %q, want the object's text", got)
+	}
+	if parts, ok := msg.Content.([]ContentPart); !ok || len(parts) != 1 {
+		t.Errorf("got content %#v, want a one-element content part array", msg.Content)
+	}
+
+	// An object that is not a content part stays raw for validation to reject
+	msg = Message{}
+	if err := json.Unmarshal([]byte(`{"role": "user", "content": {"text": "no type"}}`), &msg); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if _, ok := msg.Content.(json.RawMessage); !ok {
+		t.Errorf("got content %#v, want raw content", msg.Content)
+	}
+}
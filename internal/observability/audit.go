@@ -0,0 +1,86 @@
+package observability
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// ToolAuditEntry records a single MCP tool execution.
+type ToolAuditEntry struct {
+	RequestID string
+	User      string // OpenAI "user" field, if sent
+	SafetyID  string // OpenAI "safety_identifier" field, if sent
+	APIKey    string // Masked caller API key, if sent
+	Tool      string
+	Arguments string
+	Status    string // "success" | "tool_error" | "error"
+	Error     string
+	Duration  time.Duration
+}
+
+// AuditLogger writes one structured record per tool execution. It is separate
+// from the request logger so it can be sent to durable storage. A nil
+// *AuditLogger discards entries.
+type AuditLogger struct {
+	logger     *slog.Logger
+	redactArgs bool
+}
+
+// NewAuditLogger creates an audit logger writing JSON lines to w. When
+// redactArgs is set, tool arguments are replaced with "[redacted]".
+func NewAuditLogger(w io.Writer, redactArgs bool) *AuditLogger {
+	return &AuditLogger{
+		logger:     slog.New(slog.NewJSONHandler(w, nil)),
+		redactArgs: redactArgs,
+	}
+}
+
+// OpenAuditLogger creates an audit logger for a destination: "stdout",
+// "stderr", or a file path that is appended to.
+func OpenAuditLogger(dest string, redactArgs bool) (*AuditLogger, error) {
+	switch dest {
+	case "stdout":
+		return NewAuditLogger(os.Stdout, redactArgs), nil
+	case "stderr":
+		return NewAuditLogger(os.Stderr, redactArgs), nil
+	}
+
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return NewAuditLogger(f, redactArgs), nil
+}
+
+// LogToolCall writes an audit record for a tool execution.
+func (a *AuditLogger) LogToolCall(entry ToolAuditEntry) {
+	if a == nil {
+		return
+	}
+
+	args := entry.Arguments
+	if a.redactArgs {
+		args = "[redacted]"
+	}
+
+	attrs := []any{
+		"event", "tool_call",
+		"request_id", entry.RequestID,
+		"user", entry.User,
+		"api_key", entry.APIKey,
+		"tool", entry.Tool,
+		"arguments", args,
+		"status", entry.Status,
+		"duration_ms", entry.Duration.Milliseconds(),
+	}
+	if entry.SafetyID != "" {
+		attrs = append(attrs, "safety_identifier", entry.SafetyID)
+	}
+	if entry.Error != "" {
+		attrs = append(attrs, "error", entry.Error)
+	}
+	a.logger.Info("tool audit", attrs...)
+}
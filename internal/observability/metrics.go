@@ -1,6 +1,11 @@
 package observability
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -11,66 +16,195 @@ type Metrics struct {
 	RequestDuration *prometheus.HistogramVec
 	ActiveRequests  prometheus.Gauge
 	ClaudeDuration  prometheus.Histogram
-	ErrorsTotal     *prometheus.CounterVec
+	// TTFT observes the time from a streaming request's start to its first
+	// content delta.
+	TTFT        prometheus.Histogram
+	ErrorsTotal *prometheus.CounterVec
+	// ClientDisconnects counts streams abandoned by the client mid-response.
+	ClientDisconnects prometheus.Counter
+	// MCPToolCallsQueued is the number of MCP tool calls waiting for a
+	// concurrency slot.
+	MCPToolCallsQueued prometheus.Gauge
+	// WarmUps counts startup CLI warm-ups by result.
+	WarmUps *prometheus.CounterVec
+	// ConversationCache counts conversation cache lookups by result.
+	ConversationCache *prometheus.CounterVec
+
+	// modelLabels are the models recorded by name in the model label;
+	// nil means the built-in model families.
+	modelLabels map[string]bool
 }
 
+// OtherModelLabel is the model label for models outside the allowlist.
+const OtherModelLabel = "other"
+
+// modelFamilies are the model labels used without an allowlist. A model is
+// recorded as the first family its name contains.
+var modelFamilies = []string{"opus", "sonnet", "haiku"}
+
 var (
 	// DefaultMetrics is the default metrics instance.
 	DefaultMetrics *Metrics
 )
 
-// InitMetrics initializes and registers all Prometheus metrics.
+// DefaultDurationBuckets are the duration histogram buckets, in seconds.
+// Claude calls often take minutes, so they extend to the default ten minute
+// request timeout rather than stopping at prometheus.DefBuckets' 10s.
+var DefaultDurationBuckets = []float64{0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600}
+
+// InitMetrics initializes and registers all Prometheus metrics, using
+// DefaultDurationBuckets for the duration histograms.
 func InitMetrics() *Metrics {
-	metrics := &Metrics{
-		RequestsTotal: promauto.NewCounterVec(
+	return InitMetricsWithBuckets(DefaultDurationBuckets)
+}
+
+// InitMetricsWithBuckets initializes and registers all Prometheus metrics,
+// using buckets for the duration histograms.
+func InitMetricsWithBuckets(buckets []float64) *Metrics {
+	metrics := newMetrics(prometheus.DefaultRegisterer, buckets)
+	DefaultMetrics = metrics
+	return metrics
+}
+
+// ParseBuckets parses a comma-separated list of histogram bucket upper
+// bounds in seconds, such as "1,10,60". An empty list returns
+// DefaultDurationBuckets.
+func ParseBuckets(list string) ([]float64, error) {
+	if strings.TrimSpace(list) == "" {
+		return DefaultDurationBuckets, nil
+	}
+
+	var buckets []float64
+	for _, field := range strings.Split(list, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || bound <= 0 {
+			return nil, fmt.Errorf("invalid histogram bucket %q", field)
+		}
+		buckets = append(buckets, bound)
+	}
+	sort.Float64s(buckets)
+	return buckets, nil
+}
+
+// newMetrics creates the metrics and registers them with reg.
+func newMetrics(reg prometheus.Registerer, buckets []float64) *Metrics {
+	factory := promauto.With(reg)
+	return &Metrics{
+		RequestsTotal: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "chat_completions_requests_total",
 				Help: "Total number of chat completion requests",
 			},
-			[]string{"status", "stream"},
+			[]string{"status", "stream", "model"},
 		),
-		RequestDuration: promauto.NewHistogramVec(
+		RequestDuration: factory.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Name:    "chat_completions_duration_seconds",
 				Help:    "Duration of chat completion requests in seconds",
-				Buckets: prometheus.DefBuckets,
+				Buckets: buckets,
 			},
-			[]string{"stream"},
+			[]string{"stream", "model"},
 		),
-		ActiveRequests: promauto.NewGauge(
+		ActiveRequests: factory.NewGauge(
 			prometheus.GaugeOpts{
 				Name: "chat_completions_active_requests",
 				Help: "Number of active chat completion requests",
 			},
 		),
-		ClaudeDuration: promauto.NewHistogram(
+		ClaudeDuration: factory.NewHistogram(
 			prometheus.HistogramOpts{
 				Name:    "claude_cli_duration_seconds",
 				Help:    "Duration of Claude CLI executions in seconds",
-				Buckets: prometheus.DefBuckets,
+				Buckets: buckets,
+			},
+		),
+		TTFT: factory.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "claude_ttft_seconds",
+				Help:    "Time from a streaming request's start to its first content delta in seconds",
+				Buckets: buckets,
 			},
 		),
-		ErrorsTotal: promauto.NewCounterVec(
+		ErrorsTotal: factory.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "chat_completions_errors_total",
 				Help: "Total number of errors in chat completions",
 			},
 			[]string{"type"},
 		),
+		ClientDisconnects: factory.NewCounter(
+			prometheus.CounterOpts{
+				Name: "chat_completions_client_disconnects_total",
+				Help: "Total number of streaming responses abandoned by the client",
+			},
+		),
+		MCPToolCallsQueued: factory.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "mcp_tool_calls_queued",
+				Help: "Number of MCP tool calls waiting for a concurrency slot",
+			},
+		),
+		WarmUps: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "claude_cli_warmups_total",
+				Help: "Total number of startup Claude CLI warm-ups",
+			},
+			[]string{"result"},
+		),
+		ConversationCache: factory.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "conversation_cache_lookups_total",
+				Help: "Total number of conversation cache lookups by result (hit, miss, diverged, bypass)",
+			},
+			[]string{"result"},
+		),
 	}
+}
 
-	DefaultMetrics = metrics
-	return metrics
+// SetModelAllowlist sets the models recorded by name in the request
+// metrics' model label; all others are recorded as OtherModelLabel. With an
+// empty allowlist, models are recorded by family (opus, sonnet, or haiku).
+// It must be called before the metrics are used.
+func (m *Metrics) SetModelAllowlist(models []string) {
+	if len(models) == 0 {
+		m.modelLabels = nil
+		return
+	}
+	m.modelLabels = make(map[string]bool, len(models))
+	for _, model := range models {
+		if model = strings.TrimSpace(model); model != "" {
+			m.modelLabels[model] = true
+		}
+	}
+}
+
+// modelLabel bounds a request's model to a label value, keeping the
+// metrics' cardinality fixed whatever models clients send.
+func (m *Metrics) modelLabel(model string) string {
+	if m.modelLabels != nil {
+		if m.modelLabels[model] {
+			return model
+		}
+		return OtherModelLabel
+	}
+	lower := strings.ToLower(model)
+	for _, family := range modelFamilies {
+		if strings.Contains(lower, family) {
+			return family
+		}
+	}
+	return OtherModelLabel
 }
 
 // RecordRequest records a completed request.
-func (m *Metrics) RecordRequest(status string, stream bool, duration float64) {
+func (m *Metrics) RecordRequest(status, model string, stream bool, duration float64) {
 	streamLabel := "false"
 	if stream {
 		streamLabel = "true"
 	}
-	m.RequestsTotal.WithLabelValues(status, streamLabel).Inc()
-	m.RequestDuration.WithLabelValues(streamLabel).Observe(duration)
+	modelLabel := m.modelLabel(model)
+	m.RequestsTotal.WithLabelValues(status, streamLabel, modelLabel).Inc()
+	m.RequestDuration.WithLabelValues(streamLabel, modelLabel).Observe(duration)
 }
 
 // RecordClaudeDuration records Claude CLI execution duration.
@@ -78,11 +212,35 @@ func (m *Metrics) RecordClaudeDuration(duration float64) {
 	m.ClaudeDuration.Observe(duration)
 }
 
+// RecordTTFT records a streaming request's time to first token.
+func (m *Metrics) RecordTTFT(duration float64) {
+	m.TTFT.Observe(duration)
+}
+
 // RecordError records an error.
 func (m *Metrics) RecordError(errorType string) {
 	m.ErrorsTotal.WithLabelValues(errorType).Inc()
 }
 
+// RecordClientDisconnect records a client that went away mid-stream.
+func (m *Metrics) RecordClientDisconnect() {
+	m.ClientDisconnects.Inc()
+}
+
+// RecordWarmUp records the result of a startup CLI warm-up.
+func (m *Metrics) RecordWarmUp(success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	m.WarmUps.WithLabelValues(result).Inc()
+}
+
+// RecordConversationCache records the result of a conversation cache lookup.
+func (m *Metrics) RecordConversationCache(result string) {
+	m.ConversationCache.WithLabelValues(result).Inc()
+}
+
 // IncrementActive increments the active requests gauge.
 func (m *Metrics) IncrementActive() {
 	m.ActiveRequests.Inc()
@@ -0,0 +1,95 @@
+package observability
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewMetrics_Buckets(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	buckets := []float64{1, 60, 900}
+	m := newMetrics(reg, buckets)
+	m.RecordRequest("success", "claude-sonnet-4-5", false, 120)
+	m.RecordClaudeDuration(120)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+
+	found := 0
+	for _, family := range families {
+		switch family.GetName() {
+		case "chat_completions_duration_seconds", "claude_cli_duration_seconds":
+			found++
+			var got []float64
+			for _, b := range family.GetMetric()[0].GetHistogram().GetBucket() {
+				got = append(got, b.GetUpperBound())
+			}
+			if !slices.Equal(got, buckets) {
+				t.Errorf("%s: got buckets %v, want %v", family.GetName(), got, buckets)
+			}
+		}
+	}
+	if found != 2 {
+		t.Errorf("got %d duration histograms, want 2", found)
+	}
+}
+
+func TestParseBuckets(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []float64
+		wantErr bool
+	}{
+		{list: "", want: DefaultDurationBuckets},
+		{list: "60, 1,10", want: []float64{1, 10, 60}},
+		{list: "1,fast", wantErr: true},
+		{list: "0,10", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.list, func(t *testing.T) {
+			got, err := ParseBuckets(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordRequest_ModelLabel(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+		model     string
+		want      string
+	}{
+		{name: "family", model: "claude-sonnet-4-5-20250929", want: "sonnet"},
+		{name: "alias", model: "opus", want: "opus"},
+		{name: "unknown", model: "gpt-4o-random-1234", want: OtherModelLabel},
+		{name: "allowlisted", allowlist: []string{"claude-sonnet-4-5"}, model: "claude-sonnet-4-5", want: "claude-sonnet-4-5"},
+		{name: "not allowlisted", allowlist: []string{"claude-sonnet-4-5"}, model: "claude-sonnet-4-5-x9", want: OtherModelLabel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMetrics(prometheus.NewRegistry(), DefaultDurationBuckets)
+			m.SetModelAllowlist(tt.allowlist)
+			m.RecordRequest("success", tt.model, true, 1)
+
+			if got := testutil.ToFloat64(m.RequestsTotal.WithLabelValues("success", "true", tt.want)); got != 1 {
+				t.Errorf("got %v requests with model label %q, want 1", got, tt.want)
+			}
+			if got := testutil.CollectAndCount(m.RequestsTotal); got != 1 {
+				t.Errorf("got %d request series, want 1", got)
+			}
+		})
+	}
+}